@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/paleotronic/dskalyzer/disk"
+)
+
+/*
+	Container (zip, gzip and tar.gz) support...
+
+	Images inside a container are read straight into memory. They are
+	addressed with a composite path made of the container path, "!" and the
+	member path, eg. /dumps/apple.zip!/Games/foo.dsk
+*/
+
+const containerSep = "!/"
+
+var containerRegex = regexp.MustCompile("(?i)[.](zip|gz|tgz)$")
+var tarGzRegex = regexp.MustCompile("(?i)[.](tar[.]gz|tgz)$")
+
+// splitContainerPath breaks a composite path into the container and the
+// member within it.
+func splitContainerPath(p string) (string, string, bool) {
+	i := strings.Index(p, containerSep)
+	if i < 0 {
+		return p, "", false
+	}
+	return p[:i], p[i+len(containerSep):], true
+}
+
+func containerPath(container, member string) string {
+	return container + containerSep + strings.TrimLeft(filepath.ToSlash(member), "/")
+}
+
+func isContainerPath(p string) bool {
+	_, _, ok := splitContainerPath(p)
+	return ok
+}
+
+// eachContainerMember calls fn with each regular file in the container,
+// stopping early if fn returns false.
+func eachContainerMember(filename string, fn func(name string, r io.Reader) (bool, error)) error {
+
+	if tarGzRegex.MatchString(filename) {
+
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		tr := tar.NewReader(gz)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+				continue
+			}
+			more, err := fn(h.Name, tr)
+			if err != nil || !more {
+				return err
+			}
+		}
+
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".zip":
+
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			more, err := fn(zf.Name, rc)
+			rc.Close()
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+
+	case ".gz":
+
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		// the stored name is optional, fall back on the file name
+		name := gz.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		}
+		_, err = fn(name, gz)
+		return err
+
+	}
+
+	return errors.New("Unrecognized container: " + filename)
+
+}
+
+// readDiskData reads an image from a plain file or from inside a container
+func readDiskData(filename string) ([]byte, error) {
+
+	container, member, ok := splitContainerPath(filename)
+	if !ok {
+		return ioutil.ReadFile(filename)
+	}
+
+	var data []byte
+	var found bool
+
+	err := eachContainerMember(container, func(name string, r io.Reader) (bool, error) {
+		name = strings.TrimLeft(filepath.ToSlash(name), "/")
+		// paths may have been lower cased along the way
+		if name != member && !strings.EqualFold(name, member) {
+			return true, nil
+		}
+		var err error
+		data, err = ioutil.ReadAll(r)
+		found = true
+		return name != member, err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Not found in container: " + member)
+	}
+
+	return data, nil
+
+}
+
+// loadDisk opens a disk image by path, images in containers can't be
+// written back so they are write protected.
+func loadDisk(filename string) (*disk.DSKWrapper, error) {
+
+	data, err := readDiskData(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dsk, err := disk.NewDSKWrapperBin(defNibbler, data, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if isContainerPath(filename) {
+		dsk.WriteProtected = true
+	}
+
+	return dsk, nil
+
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/paleotronic/dskalyzer/disk"
+)
+
+func TestSplitContainerPath(t *testing.T) {
+
+	tests := []struct {
+		path      string
+		container string
+		member    string
+		ok        bool
+	}{
+		{"/dumps/foo.dsk", "/dumps/foo.dsk", "", false},
+		{"/dumps/odd!name.dsk", "/dumps/odd!name.dsk", "", false},
+		{"/dumps/apple.zip!/foo.dsk", "/dumps/apple.zip", "foo.dsk", true},
+		{"/dumps/apple.zip!/Games/foo.dsk", "/dumps/apple.zip", "Games/foo.dsk", true},
+		{"/dumps/apple.zip!/", "/dumps/apple.zip", "", true},
+		// only the outer container is split off
+		{"/dumps/apple.zip!/more.tar.gz!/foo.dsk", "/dumps/apple.zip", "more.tar.gz!/foo.dsk", true},
+	}
+
+	for _, tt := range tests {
+		container, member, ok := splitContainerPath(tt.path)
+		if container != tt.container || member != tt.member || ok != tt.ok {
+			t.Errorf("splitContainerPath(%q) = %q, %q, %v, want %q, %q, %v", tt.path, container, member, ok, tt.container, tt.member, tt.ok)
+		}
+		if ok != isContainerPath(tt.path) {
+			t.Errorf("isContainerPath(%q) = %v", tt.path, !ok)
+		}
+	}
+
+	if p := containerPath("/dumps/apple.zip", "/Games/foo.dsk"); p != "/dumps/apple.zip!/Games/foo.dsk" {
+		t.Errorf("containerPath gave %q", p)
+	}
+
+}
+
+// testMember is a file to put in a test container
+type testMember struct {
+	name string
+	data []byte
+}
+
+func testZip(t *testing.T, members []testMember) []byte {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	if _, err := zw.Create("Games/"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members {
+		w, err := zw.Create(m.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(m.data)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+
+}
+
+func testTarGz(t *testing.T, members []testMember) []byte {
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{Name: "Games/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(m.data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(m.data)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+
+}
+
+// testIngest runs fn with the ingest queue swapped for one the test can
+// drain, and returns what was queued
+func testIngest(t *testing.T, fn func() error) []*ingestItem {
+
+	saved := incoming
+	incoming = make(chan *ingestItem, 16)
+	defer func() { incoming = saved }()
+
+	if err := fn(); err != nil {
+		t.Fatal(err)
+	}
+	close(incoming)
+
+	var items []*ingestItem
+	for item := range incoming {
+		items = append(items, item)
+	}
+	return items
+
+}
+
+func TestProcessContainer(t *testing.T) {
+
+	image := make([]byte, 143360)
+	rand.New(rand.NewSource(1)).Read(image)
+
+	// a 2MG header is enough to be found without an extension, noise
+	// only passes with a disk extension
+	sniffed := make([]byte, 0x40+512)
+	copy(sniffed, disk.MAGIC_2MG)
+
+	members := []testMember{
+		{"Games/foo.dsk", image},
+		{"readme.txt", []byte("not a disk")},
+		{"Disks/BAR", sniffed},
+		{"Games/noise", image},
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"test.zip", testZip(t, members)},
+		{"test.tar.gz", testTarGz(t, members)},
+	}
+
+	for _, tt := range tests {
+
+		path := filepath.Join(t.TempDir(), tt.name)
+		if err := ioutil.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		items := testIngest(t, func() error { return processContainer(path) })
+
+		want := []testMember{members[0], members[2]}
+		if len(items) != len(want) {
+			t.Fatalf("%s: %d items queued, want %d", tt.name, len(items), len(want))
+		}
+
+		for i, item := range items {
+
+			if item.Filename != containerPath(path, want[i].name) {
+				t.Errorf("%s: queued %s", tt.name, item.Filename)
+			}
+			if !bytes.Equal(item.Data, want[i].data) {
+				t.Errorf("%s: %s queued with %d bytes", tt.name, want[i].name, len(item.Data))
+			}
+
+			data, err := readDiskData(item.Filename)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !bytes.Equal(data, want[i].data) {
+				t.Errorf("%s: %s read back %d bytes", tt.name, want[i].name, len(data))
+			}
+
+		}
+
+		dsk, err := loadDisk(containerPath(path, "Games/foo.dsk"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !dsk.WriteProtected {
+			t.Errorf("%s: image from a container isn't write protected", tt.name)
+		}
+
+		if _, err := readDiskData(containerPath(path, "Games/missing.dsk")); err == nil {
+			t.Errorf("%s: missing member was found", tt.name)
+		}
+
+	}
+
+}
@@ -22,23 +22,29 @@ import (
 )
 
 type Disk struct {
-	FullPath                string
-	Filename                string
-	SHA256                  string // Sha of whole disk
-	SHA256Active            string // Sha of active sectors/blocks only
-	Format                  string
-	FormatID                disk.DiskFormat
-	Bitmap                  []bool
-	Tracks, Sectors, Blocks int
-	Files                   DiskCatalog
-	ActiveSectors           DiskSectors
-	//ActiveBlocks             DiskBlocks
-	InactiveSectors DiskSectors
-	//InactiveBlocks           DiskBlocks
+	FullPath                 string
+	Filename                 string
+	SHA256                   string // Sha of whole disk
+	SHA256Active             string // Sha of active sectors/blocks only
+	SHA256Canonical          string // Sha of whole disk in logical sector order
+	Format                   string
+	FormatID                 disk.DiskFormat
+	Bitmap                   []bool
+	Tracks, Sectors, Blocks  int
+	Files                    DiskCatalog
+	DeletedFiles             DiskCatalog // deleted files that could still be recovered
+	ActiveSectors            DiskSectors
+	ActiveBlocks             DiskBlocks // block level fingerprints for hard disk volumes
+	InactiveSectors          DiskSectors
+	InactiveBlocks           DiskBlocks
 	MatchFactor              float64
 	MatchFiles               map[*DiskFile]*DiskFile
 	MissingFiles, ExtraFiles []*DiskFile
 	IngestMode               int
+	Meta                     map[string]string       // container metadata (eg. WOZ META chunk)
+	Comment                  string                  // 2MG comment chunk
+	Protection               *disk.ProtectionProfile // nibble level oddities, nil for non 5.25" disks
+	OSVariant                string                  // operating system found by the boot signatures
 	source                   string
 }
 
@@ -76,6 +82,10 @@ type DiskFile struct {
 	Text        []byte
 	Data        []byte
 	Locked      bool
+	Access      disk.ProDOSAccessMode
+	Sparse      bool
+	Forked      bool   // GS/OS extended file
+	Resource    []byte // resource fork of a forked file
 	Created     time.Time
 	Modified    time.Time
 }
@@ -133,6 +143,8 @@ type DiskBlock struct {
 	Block int
 
 	SHA256 string
+
+	Data []byte
 }
 
 func (i Disk) LogBitmap(id int) {
@@ -181,12 +193,21 @@ func (i Disk) LogBitmap(id int) {
 }
 
 func (d Disk) GetFilename() string {
+	return d.fingerprintName(d.SHA256, d.SHA256Active, d.GetCanonicalSHA256())
+}
+
+// getLegacyFilename is the name used before the canonical hash was added
+func (d Disk) getLegacyFilename() string {
+	return d.fingerprintName(d.SHA256, d.SHA256Active)
+}
+
+func (d Disk) fingerprintName(sums ...string) string {
 
 	sum := md5.Sum([]byte(d.Filename))
 
 	//	fmt.Printf("checksum: [%s] -> [%s]\n", d.Filename, hex.EncodeToString(sum[:]))
 
-	ff := fmt.Sprintf("%s/%d", strings.Trim(filepath.Dir(d.FullPath), "/"), d.FormatID.ID) + "_" + d.SHA256 + "_" + d.SHA256Active + "_" + hex.EncodeToString(sum[:]) + ".fgp"
+	ff := fmt.Sprintf("%s/%d", strings.Trim(filepath.Dir(d.FullPath), "/"), d.FormatID.ID) + "_" + strings.Join(sums, "_") + "_" + hex.EncodeToString(sum[:]) + ".fgp"
 
 	if runtime.GOOS == "windows" {
 		ff = strings.Replace(ff, ":", "", -1)
@@ -197,6 +218,15 @@ func (d Disk) GetFilename() string {
 
 }
 
+// GetCanonicalSHA256 returns the sector order independent hash, falling
+// back on the whole disk hash for fingerprints made before it existed.
+func (d Disk) GetCanonicalSHA256() string {
+	if d.SHA256Canonical == "" {
+		return d.SHA256
+	}
+	return d.SHA256Canonical
+}
+
 func (d Disk) WriteToFile(filename string) error {
 
 	// b, err := yaml.Marshal(d)
@@ -218,6 +248,14 @@ func (d Disk) WriteToFile(filename string) error {
 
 	l.Logf("Created %s", filename)
 
+	// replace any fingerprint made before the canonical hash was added
+	if strings.HasSuffix(filename, d.GetFilename()) {
+		legacy := strings.TrimSuffix(filename, d.GetFilename()) + d.getLegacyFilename()
+		if os.Remove(legacy) == nil {
+			l.Logf("Removed %s", legacy)
+		}
+	}
+
 	return nil
 }
 
@@ -319,6 +357,10 @@ func (d *Disk) GetUtilizationMap() map[string]string {
 
 	}
 
+	for _, block := range d.ActiveBlocks {
+		out[fmt.Sprintf("B%d", block.Block)] = block.SHA256
+	}
+
 	return out
 
 }
@@ -350,6 +392,8 @@ func (d *Disk) CompareChunks(b *Disk) (float64, float64, float64, float64) {
 		return d.compareBlocksPositional(b)
 	case disk.DF_PRODOS_800KB:
 		return d.compareBlocksPositional(b)
+	case disk.DF_PRODOS_CUSTOM:
+		return d.compareBlocksPositional(b)
 	}
 
 	return 0, 0, 0, 0
@@ -1,7 +1,9 @@
 package disk
 
 import (
-	//"strings"
+	"bytes"
+	"math/rand"
+	"os"
 	"testing"
 )
 import "fmt"
@@ -14,18 +16,158 @@ func TestDisk(t *testing.T) {
 		t.Error(fmt.Sprintf("Wrong size got %d", STD_DISK_BYTES))
 	}
 
-	dsk, e := NewDSKWrapper("g19.dsk")
+	if _, err := os.Stat("g19.dsk"); err != nil {
+		t.Skip("g19.dsk not present")
+	}
+
+	dsk, e := NewDSKWrapper(nil, "g19.dsk")
 	if e != nil {
-		t.Error(e)
+		t.Fatal(e)
 	}
 
 	fmt.Printf("Disk format is %d\n", dsk.Format)
 
-	_, fdlist, e := dsk.GetCatalogProDOSPathed(2, "GAMES", "")
+	_, fdlist, e := dsk.PRODOSGetCatalogPathed(2, "GAMES", "")
 	for _, fd := range fdlist {
 		fmt.Printf("[%s]\n", fd.Name())
 	}
 
-	t.Fail()
+}
+
+// testData is repeatable filler for test files
+func testData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// testDOSDisk makes a DOS volume holding a single binary file
+func testDOSDisk(t *testing.T, format DiskFormat, layout SectorOrder, file []byte) *DSKWrapper {
+
+	t.Helper()
+
+	dsk, err := NewAppleDOSVolume(format, layout, 254, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dsk.AppleDOSWriteFile("HELLO", FileTypeBIN, file, 0x2000); err != nil {
+		t.Fatal(err)
+	}
+
+	return dsk
+
+}
+
+// testReadBack reads a file through the io/fs view and checks it
+func testReadBack(t *testing.T, dsk *DSKWrapper, name string, want []byte) {
+
+	t.Helper()
+
+	vfs, err := dsk.FS()
+	if err != nil {
+		t.Fatalf("%s: %v", dsk.Format, err)
+	}
+
+	got, err := vfs.ReadFile(name)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s: read back %d bytes, want %d", name, len(got), len(want))
+	}
+
+}
+
+// testProDOSDisk makes a ProDOS volume holding a single binary file
+func testProDOSDisk(t *testing.T, blocks int, layout SectorOrder, file []byte) *DSKWrapper {
+
+	t.Helper()
+
+	dsk, err := NewProDOSVolume("TEST", blocks, layout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dsk.PRODOSWriteFile("", "HELLO", FileType_PD_BIN, file, 0x2000); err != nil {
+		t.Fatal(err)
+	}
+
+	return dsk
+
+}
+
+// testDOSOrder copies a ProDOS volume block by block into DOS order
+func testDOSOrder(t *testing.T, src *DSKWrapper) []byte {
+
+	t.Helper()
+
+	dst := &DSKWrapper{Format: src.Format, Layout: SectorOrderDOS33, Data: make([]byte, len(src.Data))}
+
+	for b := 0; b < len(src.Data)/512; b++ {
+		data, err := src.PRODOSGetBlock(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		track, s1, s2 := dst.PRODOSGetBlockSectors(b)
+		dst.Seek(track, s1)
+		dst.Write(data[:256])
+		dst.Seek(track, s2)
+		dst.Write(data[256:])
+	}
+
+	return dst.Data
+
+}
+
+func TestChecksumCanonical(t *testing.T) {
+
+	dos := func(layout SectorOrder) []byte {
+		return testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), layout, testData(40, 3000)).Data
+	}
+	prodos := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, testData(41, 3000))
+
+	tests := []struct {
+		name        string
+		format      DiskFormatID
+		dosOrder    []byte
+		prodosOrder []byte
+		twoMG       []byte
+	}{
+		{"DOS 3.3", DF_DOS_SECTORS_16, dos(SectorOrderDOS33), dos(SectorOrderDOS33ProDOS),
+			NewHeader2MG(FORMAT_2MG_DOS, PRODOS_BLOCKS_PER_DISK).Bytes(dos(SectorOrderDOS33))},
+		{"ProDOS", DF_PRODOS, testDOSOrder(t, prodos), prodos.Data,
+			NewHeader2MG(FORMAT_2MG_PRODOS, PRODOS_BLOCKS_PER_DISK).Bytes(prodos.Data)},
+	}
+
+	for _, tt := range tests {
+
+		load := func(data []byte, filename string) *DSKWrapper {
+			w, err := NewDSKWrapperBin(nil, data, filename)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", tt.name, filename, err)
+			}
+			return w
+		}
+
+		do := load(tt.dosOrder, "test.do")
+		po := load(tt.prodosOrder, "test.po")
+		twoMG := load(tt.twoMG, "test.2mg")
+
+		if do.Format.ID != tt.format || po.Format.ID != tt.format {
+			t.Fatalf("%s: loaded as %s and %s", tt.name, do.Format, po.Format)
+		}
+		if do.ChecksumDisk() == po.ChecksumDisk() {
+			t.Errorf("%s: sector orders give the same ChecksumDisk", tt.name)
+		}
+		if po.ChecksumCanonical() != do.ChecksumCanonical() {
+			t.Errorf("%s: ProDOS order canonical checksum differs from DOS order", tt.name)
+		}
+		if twoMG.ChecksumCanonical() != do.ChecksumCanonical() {
+			t.Errorf("%s: 2MG canonical checksum differs from DOS order", tt.name)
+		}
+
+	}
 
 }
@@ -0,0 +1,732 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+	6502 family disassembler...
+
+	Decodes 6502, 65C02 and 65816 machine code. The opcode table is the
+	65816 one, each entry marked with the first CPU that has it, so the
+	6502 and 65C02 see the instructions they lack as data. On the 65816
+	REP and SEP are followed to get the width of immediate operands right,
+	starting out 8 bit as in emulation mode.
+
+	Output is either a monitor style listing or Merlin style source with
+	labels for branch targets, ROM entry points and soft switches.
+*/
+
+type CPU int
+
+const (
+	CPU6502 CPU = iota
+	CPU65C02
+	CPU65816
+)
+
+func (c CPU) String() string {
+	switch c {
+	case CPU6502:
+		return "6502"
+	case CPU65C02:
+		return "65C02"
+	case CPU65816:
+		return "65816"
+	}
+	return "Unknown"
+}
+
+// ParseCPU takes a CPU name like "65c02"
+func ParseCPU(s string) (CPU, bool) {
+	switch strings.ToUpper(strings.TrimPrefix(s, "-")) {
+	case "6502":
+		return CPU6502, true
+	case "65C02":
+		return CPU65C02, true
+	case "65816", "65C816":
+		return CPU65816, true
+	}
+	return CPU6502, false
+}
+
+type addrMode int
+
+const (
+	modeImp addrMode = iota
+	modeAcc
+	modeImm
+	modeImmM // 8 or 16 bits depending on the M flag
+	modeImmX // 8 or 16 bits depending on the X flag
+	modeDP
+	modeDPX
+	modeDPY
+	modeDPInd
+	modeDPIndX
+	modeDPIndY
+	modeDPIndLong
+	modeDPIndLongY
+	modeSR
+	modeSRIndY
+	modeAbs
+	modeAbsX
+	modeAbsY
+	modeAbsInd
+	modeAbsIndX
+	modeAbsIndLong
+	modeLong
+	modeLongX
+	modeRel
+	modeRelLong
+	modeMove
+)
+
+type opcode struct {
+	Mnemonic string
+	Mode     addrMode
+	CPU      CPU // first CPU to have it
+}
+
+var opcodes = [256]opcode{
+	{"BRK", modeImp, CPU6502}, {"ORA", modeDPIndX, CPU6502}, {"COP", modeImm, CPU65816}, {"ORA", modeSR, CPU65816},
+	{"TSB", modeDP, CPU65C02}, {"ORA", modeDP, CPU6502}, {"ASL", modeDP, CPU6502}, {"ORA", modeDPIndLong, CPU65816},
+	{"PHP", modeImp, CPU6502}, {"ORA", modeImmM, CPU6502}, {"ASL", modeAcc, CPU6502}, {"PHD", modeImp, CPU65816},
+	{"TSB", modeAbs, CPU65C02}, {"ORA", modeAbs, CPU6502}, {"ASL", modeAbs, CPU6502}, {"ORA", modeLong, CPU65816},
+	// $10
+	{"BPL", modeRel, CPU6502}, {"ORA", modeDPIndY, CPU6502}, {"ORA", modeDPInd, CPU65C02}, {"ORA", modeSRIndY, CPU65816},
+	{"TRB", modeDP, CPU65C02}, {"ORA", modeDPX, CPU6502}, {"ASL", modeDPX, CPU6502}, {"ORA", modeDPIndLongY, CPU65816},
+	{"CLC", modeImp, CPU6502}, {"ORA", modeAbsY, CPU6502}, {"INC", modeAcc, CPU65C02}, {"TCS", modeImp, CPU65816},
+	{"TRB", modeAbs, CPU65C02}, {"ORA", modeAbsX, CPU6502}, {"ASL", modeAbsX, CPU6502}, {"ORA", modeLongX, CPU65816},
+	// $20
+	{"JSR", modeAbs, CPU6502}, {"AND", modeDPIndX, CPU6502}, {"JSL", modeLong, CPU65816}, {"AND", modeSR, CPU65816},
+	{"BIT", modeDP, CPU6502}, {"AND", modeDP, CPU6502}, {"ROL", modeDP, CPU6502}, {"AND", modeDPIndLong, CPU65816},
+	{"PLP", modeImp, CPU6502}, {"AND", modeImmM, CPU6502}, {"ROL", modeAcc, CPU6502}, {"PLD", modeImp, CPU65816},
+	{"BIT", modeAbs, CPU6502}, {"AND", modeAbs, CPU6502}, {"ROL", modeAbs, CPU6502}, {"AND", modeLong, CPU65816},
+	// $30
+	{"BMI", modeRel, CPU6502}, {"AND", modeDPIndY, CPU6502}, {"AND", modeDPInd, CPU65C02}, {"AND", modeSRIndY, CPU65816},
+	{"BIT", modeDPX, CPU65C02}, {"AND", modeDPX, CPU6502}, {"ROL", modeDPX, CPU6502}, {"AND", modeDPIndLongY, CPU65816},
+	{"SEC", modeImp, CPU6502}, {"AND", modeAbsY, CPU6502}, {"DEC", modeAcc, CPU65C02}, {"TSC", modeImp, CPU65816},
+	{"BIT", modeAbsX, CPU65C02}, {"AND", modeAbsX, CPU6502}, {"ROL", modeAbsX, CPU6502}, {"AND", modeLongX, CPU65816},
+	// $40
+	{"RTI", modeImp, CPU6502}, {"EOR", modeDPIndX, CPU6502}, {"WDM", modeImm, CPU65816}, {"EOR", modeSR, CPU65816},
+	{"MVP", modeMove, CPU65816}, {"EOR", modeDP, CPU6502}, {"LSR", modeDP, CPU6502}, {"EOR", modeDPIndLong, CPU65816},
+	{"PHA", modeImp, CPU6502}, {"EOR", modeImmM, CPU6502}, {"LSR", modeAcc, CPU6502}, {"PHK", modeImp, CPU65816},
+	{"JMP", modeAbs, CPU6502}, {"EOR", modeAbs, CPU6502}, {"LSR", modeAbs, CPU6502}, {"EOR", modeLong, CPU65816},
+	// $50
+	{"BVC", modeRel, CPU6502}, {"EOR", modeDPIndY, CPU6502}, {"EOR", modeDPInd, CPU65C02}, {"EOR", modeSRIndY, CPU65816},
+	{"MVN", modeMove, CPU65816}, {"EOR", modeDPX, CPU6502}, {"LSR", modeDPX, CPU6502}, {"EOR", modeDPIndLongY, CPU65816},
+	{"CLI", modeImp, CPU6502}, {"EOR", modeAbsY, CPU6502}, {"PHY", modeImp, CPU65C02}, {"TCD", modeImp, CPU65816},
+	{"JML", modeLong, CPU65816}, {"EOR", modeAbsX, CPU6502}, {"LSR", modeAbsX, CPU6502}, {"EOR", modeLongX, CPU65816},
+	// $60
+	{"RTS", modeImp, CPU6502}, {"ADC", modeDPIndX, CPU6502}, {"PER", modeRelLong, CPU65816}, {"ADC", modeSR, CPU65816},
+	{"STZ", modeDP, CPU65C02}, {"ADC", modeDP, CPU6502}, {"ROR", modeDP, CPU6502}, {"ADC", modeDPIndLong, CPU65816},
+	{"PLA", modeImp, CPU6502}, {"ADC", modeImmM, CPU6502}, {"ROR", modeAcc, CPU6502}, {"RTL", modeImp, CPU65816},
+	{"JMP", modeAbsInd, CPU6502}, {"ADC", modeAbs, CPU6502}, {"ROR", modeAbs, CPU6502}, {"ADC", modeLong, CPU65816},
+	// $70
+	{"BVS", modeRel, CPU6502}, {"ADC", modeDPIndY, CPU6502}, {"ADC", modeDPInd, CPU65C02}, {"ADC", modeSRIndY, CPU65816},
+	{"STZ", modeDPX, CPU65C02}, {"ADC", modeDPX, CPU6502}, {"ROR", modeDPX, CPU6502}, {"ADC", modeDPIndLongY, CPU65816},
+	{"SEI", modeImp, CPU6502}, {"ADC", modeAbsY, CPU6502}, {"PLY", modeImp, CPU65C02}, {"TDC", modeImp, CPU65816},
+	{"JMP", modeAbsIndX, CPU65C02}, {"ADC", modeAbsX, CPU6502}, {"ROR", modeAbsX, CPU6502}, {"ADC", modeLongX, CPU65816},
+	// $80
+	{"BRA", modeRel, CPU65C02}, {"STA", modeDPIndX, CPU6502}, {"BRL", modeRelLong, CPU65816}, {"STA", modeSR, CPU65816},
+	{"STY", modeDP, CPU6502}, {"STA", modeDP, CPU6502}, {"STX", modeDP, CPU6502}, {"STA", modeDPIndLong, CPU65816},
+	{"DEY", modeImp, CPU6502}, {"BIT", modeImmM, CPU65C02}, {"TXA", modeImp, CPU6502}, {"PHB", modeImp, CPU65816},
+	{"STY", modeAbs, CPU6502}, {"STA", modeAbs, CPU6502}, {"STX", modeAbs, CPU6502}, {"STA", modeLong, CPU65816},
+	// $90
+	{"BCC", modeRel, CPU6502}, {"STA", modeDPIndY, CPU6502}, {"STA", modeDPInd, CPU65C02}, {"STA", modeSRIndY, CPU65816},
+	{"STY", modeDPX, CPU6502}, {"STA", modeDPX, CPU6502}, {"STX", modeDPY, CPU6502}, {"STA", modeDPIndLongY, CPU65816},
+	{"TYA", modeImp, CPU6502}, {"STA", modeAbsY, CPU6502}, {"TXS", modeImp, CPU6502}, {"TXY", modeImp, CPU65816},
+	{"STZ", modeAbs, CPU65C02}, {"STA", modeAbsX, CPU6502}, {"STZ", modeAbsX, CPU65C02}, {"STA", modeLongX, CPU65816},
+	// $A0
+	{"LDY", modeImmX, CPU6502}, {"LDA", modeDPIndX, CPU6502}, {"LDX", modeImmX, CPU6502}, {"LDA", modeSR, CPU65816},
+	{"LDY", modeDP, CPU6502}, {"LDA", modeDP, CPU6502}, {"LDX", modeDP, CPU6502}, {"LDA", modeDPIndLong, CPU65816},
+	{"TAY", modeImp, CPU6502}, {"LDA", modeImmM, CPU6502}, {"TAX", modeImp, CPU6502}, {"PLB", modeImp, CPU65816},
+	{"LDY", modeAbs, CPU6502}, {"LDA", modeAbs, CPU6502}, {"LDX", modeAbs, CPU6502}, {"LDA", modeLong, CPU65816},
+	// $B0
+	{"BCS", modeRel, CPU6502}, {"LDA", modeDPIndY, CPU6502}, {"LDA", modeDPInd, CPU65C02}, {"LDA", modeSRIndY, CPU65816},
+	{"LDY", modeDPX, CPU6502}, {"LDA", modeDPX, CPU6502}, {"LDX", modeDPY, CPU6502}, {"LDA", modeDPIndLongY, CPU65816},
+	{"CLV", modeImp, CPU6502}, {"LDA", modeAbsY, CPU6502}, {"TSX", modeImp, CPU6502}, {"TYX", modeImp, CPU65816},
+	{"LDY", modeAbsX, CPU6502}, {"LDA", modeAbsX, CPU6502}, {"LDX", modeAbsY, CPU6502}, {"LDA", modeLongX, CPU65816},
+	// $C0
+	{"CPY", modeImmX, CPU6502}, {"CMP", modeDPIndX, CPU6502}, {"REP", modeImm, CPU65816}, {"CMP", modeSR, CPU65816},
+	{"CPY", modeDP, CPU6502}, {"CMP", modeDP, CPU6502}, {"DEC", modeDP, CPU6502}, {"CMP", modeDPIndLong, CPU65816},
+	{"INY", modeImp, CPU6502}, {"CMP", modeImmM, CPU6502}, {"DEX", modeImp, CPU6502}, {"WAI", modeImp, CPU65816},
+	{"CPY", modeAbs, CPU6502}, {"CMP", modeAbs, CPU6502}, {"DEC", modeAbs, CPU6502}, {"CMP", modeLong, CPU65816},
+	// $D0
+	{"BNE", modeRel, CPU6502}, {"CMP", modeDPIndY, CPU6502}, {"CMP", modeDPInd, CPU65C02}, {"CMP", modeSRIndY, CPU65816},
+	{"PEI", modeDPInd, CPU65816}, {"CMP", modeDPX, CPU6502}, {"DEC", modeDPX, CPU6502}, {"CMP", modeDPIndLongY, CPU65816},
+	{"CLD", modeImp, CPU6502}, {"CMP", modeAbsY, CPU6502}, {"PHX", modeImp, CPU65C02}, {"STP", modeImp, CPU65816},
+	{"JML", modeAbsIndLong, CPU65816}, {"CMP", modeAbsX, CPU6502}, {"DEC", modeAbsX, CPU6502}, {"CMP", modeLongX, CPU65816},
+	// $E0
+	{"CPX", modeImmX, CPU6502}, {"SBC", modeDPIndX, CPU6502}, {"SEP", modeImm, CPU65816}, {"SBC", modeSR, CPU65816},
+	{"CPX", modeDP, CPU6502}, {"SBC", modeDP, CPU6502}, {"INC", modeDP, CPU6502}, {"SBC", modeDPIndLong, CPU65816},
+	{"INX", modeImp, CPU6502}, {"SBC", modeImmM, CPU6502}, {"NOP", modeImp, CPU6502}, {"XBA", modeImp, CPU65816},
+	{"CPX", modeAbs, CPU6502}, {"SBC", modeAbs, CPU6502}, {"INC", modeAbs, CPU6502}, {"SBC", modeLong, CPU65816},
+	// $F0
+	{"BEQ", modeRel, CPU6502}, {"SBC", modeDPIndY, CPU6502}, {"SBC", modeDPInd, CPU65C02}, {"SBC", modeSRIndY, CPU65816},
+	{"PEA", modeAbs, CPU65816}, {"SBC", modeDPX, CPU6502}, {"INC", modeDPX, CPU6502}, {"SBC", modeDPIndLongY, CPU65816},
+	{"SED", modeImp, CPU6502}, {"SBC", modeAbsY, CPU6502}, {"PLX", modeImp, CPU65C02}, {"XCE", modeImp, CPU65816},
+	{"JSR", modeAbsIndX, CPU65816}, {"SBC", modeAbsX, CPU6502}, {"INC", modeAbsX, CPU6502}, {"SBC", modeLongX, CPU65816},
+}
+
+// romLabels names the monitor and Applesoft entry points, the page 3
+// vectors and the soft switches
+var romLabels = map[int]string{
+	// page 3 vectors
+	0x03D0: "DOSWARM",
+	0x03D3: "DOSCOLD",
+	0x03D6: "FILEMGR",
+	0x03D9: "RWTS",
+	0x03E3: "GETIOB",
+	0x03EA: "CONNECT",
+	0x03F0: "BRKV",
+	0x03F2: "SOFTEV",
+	0x03F4: "PWREDUP",
+	0x03F5: "AMPERV",
+	0x03F8: "USRADR",
+	0x03FB: "NMI",
+	0x03FE: "IRQLOC",
+	// ProDOS
+	0xBF00: "MLI",
+	// keyboard, speaker and video
+	0xC000: "KBD",
+	0xC010: "KBDSTRB",
+	0xC011: "RDLCBNK2",
+	0xC012: "RDLCRAM",
+	0xC013: "RDRAMRD",
+	0xC014: "RDRAMWRT",
+	0xC018: "RD80STORE",
+	0xC019: "RDVBLBAR",
+	0xC01A: "RDTEXT",
+	0xC01C: "RDPAGE2",
+	0xC01E: "RDALTCHAR",
+	0xC01F: "RD80VID",
+	0xC020: "TAPEOUT",
+	0xC030: "SPKR",
+	0xC040: "STROBE",
+	0xC050: "TXTCLR",
+	0xC051: "TXTSET",
+	0xC052: "MIXCLR",
+	0xC053: "MIXSET",
+	0xC054: "LOWSCR",
+	0xC055: "HISCR",
+	0xC056: "LORES",
+	0xC057: "HIRES",
+	0xC058: "AN0OFF",
+	0xC059: "AN0ON",
+	0xC05A: "AN1OFF",
+	0xC05B: "AN1ON",
+	0xC05C: "AN2OFF",
+	0xC05D: "AN2ON",
+	0xC05E: "AN3OFF",
+	0xC05F: "AN3ON",
+	0xC060: "TAPEIN",
+	0xC061: "BUTN0",
+	0xC062: "BUTN1",
+	0xC063: "BUTN2",
+	0xC064: "PADDL0",
+	0xC065: "PADDL1",
+	0xC066: "PADDL2",
+	0xC067: "PADDL3",
+	0xC070: "PTRIG",
+	// language card
+	0xC081: "ROMIN",
+	0xC083: "LCBANK2",
+	0xC08B: "LCBANK1",
+	// Applesoft
+	0xD39E: "BLTU2",
+	0xD412: "ERROR",
+	0xD43C: "RESTART",
+	0xD566: "RUN",
+	0xD683: "STKINI",
+	0xDB3A: "STROUT",
+	0xDB5C: "OUTDO",
+	0xDD67: "FRMNUM",
+	0xDE60: "FRMEVL",
+	0xDEBE: "CHKCOM",
+	0xE6F8: "GETBYTE",
+	0xE752: "GETADR",
+	0xED24: "LINPRT",
+	0xF3E2: "HGR",
+	0xF3D8: "HGR2",
+	0xF3F2: "HCLR",
+	0xF411: "HPOSN",
+	0xF457: "HPLOT0",
+	0xF53A: "HGLIN",
+	0xF6E6: "HFNS",
+	// monitor
+	0xF800: "PLOT",
+	0xF819: "HLINE",
+	0xF828: "VLINE",
+	0xF832: "CLRSCR",
+	0xF836: "CLRTOP",
+	0xF847: "GBASCALC",
+	0xF85F: "NEXTCOL",
+	0xF864: "SETCOL",
+	0xF871: "SCRN",
+	0xF941: "PRNTAX",
+	0xF948: "PRBLNK",
+	0xF94A: "PRBL2",
+	0xFA62: "RESET",
+	0xFB1E: "PREAD",
+	0xFB2F: "INIT",
+	0xFB39: "SETTXT",
+	0xFB40: "SETGR",
+	0xFB4B: "SETWND",
+	0xFBC1: "BASCALC",
+	0xFBDD: "BELL1",
+	0xFC10: "BS",
+	0xFC1A: "UP",
+	0xFC22: "VTAB",
+	0xFC24: "VTABZ",
+	0xFC42: "CLREOP",
+	0xFC58: "HOME",
+	0xFC62: "CR",
+	0xFC66: "LF",
+	0xFC70: "SCROLL",
+	0xFC9C: "CLREOL",
+	0xFC9E: "CLREOLZ",
+	0xFCA8: "WAIT",
+	0xFD0C: "RDKEY",
+	0xFD1B: "KEYIN",
+	0xFD35: "RDCHAR",
+	0xFD67: "GETLNZ",
+	0xFD6A: "GETLN",
+	0xFD6F: "GETLN1",
+	0xFD8B: "CROUT1",
+	0xFD8E: "CROUT",
+	0xFDDA: "PRBYTE",
+	0xFDE3: "PRHEX",
+	0xFDED: "COUT",
+	0xFDF0: "COUT1",
+	0xFE2C: "MOVE",
+	0xFE36: "VERIFY",
+	0xFE80: "SETINV",
+	0xFE84: "SETNORM",
+	0xFE89: "SETKBD",
+	0xFE93: "SETVID",
+	0xFF2D: "PRERR",
+	0xFF3A: "BELL",
+	0xFF3F: "RESTORE",
+	0xFF4A: "SAVE",
+	0xFF58: "IORTS",
+	0xFF59: "OLDRST",
+	0xFF65: "MON",
+	0xFF69: "MONZ",
+}
+
+// writeLabels are the IIe switches that do something else when written
+var writeLabels = map[int]string{
+	0xC000: "CLR80STORE",
+	0xC001: "SET80STORE",
+	0xC002: "RDMAINRAM",
+	0xC003: "RDCARDRAM",
+	0xC004: "WRMAINRAM",
+	0xC005: "WRCARDRAM",
+	0xC008: "SETSTDZP",
+	0xC009: "SETALTZP",
+	0xC00C: "CLR80VID",
+	0xC00D: "SET80VID",
+	0xC00E: "CLRALTCHAR",
+	0xC00F: "SETALTCHAR",
+}
+
+// diskLabels are the Disk II switches, used slot relative as $C080,X
+var diskLabels = map[int]string{
+	0xC080: "PHASE0OFF",
+	0xC081: "PHASE0ON",
+	0xC082: "PHASE1OFF",
+	0xC083: "PHASE1ON",
+	0xC084: "PHASE2OFF",
+	0xC085: "PHASE2ON",
+	0xC086: "PHASE3OFF",
+	0xC087: "PHASE3ON",
+	0xC088: "MOTOROFF",
+	0xC089: "MOTORON",
+	0xC08A: "DRV0EN",
+	0xC08B: "DRV1EN",
+	0xC08C: "Q6L",
+	0xC08D: "Q6H",
+	0xC08E: "Q7L",
+	0xC08F: "Q7H",
+}
+
+// DisasmLine is one decoded instruction, or a byte that isn't one
+type DisasmLine struct {
+	Address  int
+	Bytes    []byte
+	Mnemonic string // "" for data
+	Mode     addrMode
+	Operand  int // operand value, branch target for relative modes
+	Target   int // address the instruction refers to, -1 if none
+	Label    string
+	M16, X16 bool // register widths after the instruction, 65816 only
+}
+
+// Disassembly is a decoded block of code
+type Disassembly struct {
+	CPU      CPU
+	Org      int
+	M16, X16 bool // register widths on entry, 65816 only
+	Lines    []*DisasmLine
+	labels   map[int]string
+}
+
+func isWrite(mnemonic string) bool {
+	switch mnemonic {
+	case "STA", "STX", "STY", "STZ":
+		return true
+	}
+	return false
+}
+
+// knownLabel names an address the ROM or hardware gives a meaning to
+func knownLabel(addr int, mnemonic string, mode addrMode) string {
+	if mode == modeAbsX && addr >= 0xC080 && addr <= 0xC08F {
+		return diskLabels[addr]
+	}
+	if isWrite(mnemonic) {
+		if l, ok := writeLabels[addr]; ok {
+			return l
+		}
+	}
+	return romLabels[addr]
+}
+
+// Disassemble decodes the data as loaded at org. The 65816 starts with 8
+// bit registers, as it does in emulation mode.
+func Disassemble(data []byte, org int, cpu CPU) *Disassembly {
+	return DisassembleWidth(data, org, cpu, false, false)
+}
+
+// DisassembleWidth is Disassemble for 65816 code entered with 16 bit
+// accumulator or index registers
+func DisassembleWidth(data []byte, org int, cpu CPU, m16, x16 bool) *Disassembly {
+
+	d := &Disassembly{CPU: cpu, Org: org, M16: m16, X16: x16}
+
+	lastSEC := false
+	for pos := 0; pos < len(data); {
+
+		addr := org + pos
+		op := opcodes[data[pos]]
+		line := &DisasmLine{Address: addr, Target: -1}
+
+		size := 1
+		switch op.Mode {
+		case modeImm, modeDP, modeDPX, modeDPY, modeDPInd, modeDPIndX, modeDPIndY,
+			modeDPIndLong, modeDPIndLongY, modeSR, modeSRIndY, modeRel:
+			size = 2
+		case modeImmM:
+			size = 2
+			if m16 {
+				size = 3
+			}
+		case modeImmX:
+			size = 2
+			if x16 {
+				size = 3
+			}
+		case modeAbs, modeAbsX, modeAbsY, modeAbsInd, modeAbsIndX, modeAbsIndLong, modeRelLong, modeMove:
+			size = 3
+		case modeLong, modeLongX:
+			size = 4
+		}
+
+		if op.CPU > cpu || pos+size > len(data) {
+			line.Bytes = data[pos : pos+1]
+			line.M16, line.X16 = m16, x16
+			d.Lines = append(d.Lines, line)
+			pos++
+			lastSEC = false
+			continue
+		}
+
+		line.Bytes = data[pos : pos+size]
+		line.Mnemonic = op.Mnemonic
+		line.Mode = op.Mode
+
+		switch size {
+		case 2:
+			line.Operand = int(data[pos+1])
+		case 3:
+			line.Operand = int(data[pos+1]) | int(data[pos+2])<<8
+		case 4:
+			line.Operand = int(data[pos+1]) | int(data[pos+2])<<8 | int(data[pos+3])<<16
+		}
+
+		switch op.Mode {
+		case modeRel:
+			line.Operand = (addr + 2 + int(int8(data[pos+1]))) & 0xffff
+			line.Target = line.Operand
+		case modeRelLong:
+			line.Operand = (addr + 3 + int(int16(line.Operand))) & 0xffff
+			line.Target = line.Operand
+		case modeAbs, modeAbsX, modeAbsY, modeAbsInd, modeAbsIndX, modeAbsIndLong:
+			if op.Mnemonic != "PEA" {
+				line.Target = line.Operand
+			}
+		case modeLong, modeLongX:
+			if line.Operand <= 0xffff {
+				line.Target = line.Operand
+			}
+		}
+
+		if cpu == CPU65816 {
+			switch op.Mnemonic {
+			case "REP":
+				m16 = m16 || line.Operand&0x20 != 0
+				x16 = x16 || line.Operand&0x10 != 0
+			case "SEP":
+				m16 = m16 && line.Operand&0x20 == 0
+				x16 = x16 && line.Operand&0x10 == 0
+			case "XCE":
+				// back to emulation mode, the registers are forced to 8 bits
+				if lastSEC {
+					m16, x16 = false, false
+				}
+			}
+		}
+		lastSEC = op.Mnemonic == "SEC"
+		line.M16, line.X16 = m16, x16
+
+		d.Lines = append(d.Lines, line)
+		pos += size
+	}
+
+	d.label()
+
+	return d
+}
+
+// label gives names to the addresses referred to, local targets get an
+// L<address> label on the line they point at.
+func (d *Disassembly) label() {
+
+	starts := make(map[int]*DisasmLine)
+	for _, line := range d.Lines {
+		if line.Mnemonic != "" {
+			starts[line.Address] = line
+		}
+	}
+
+	d.labels = make(map[int]string)
+	for _, line := range d.Lines {
+		if line.Target == -1 {
+			continue
+		}
+		if target, ok := starts[line.Target]; ok {
+			target.Label = fmt.Sprintf("L%.4X", line.Target)
+			d.labels[line.Target] = target.Label
+		}
+	}
+}
+
+// operandText formats the operand, using name for the address if given
+func (line *DisasmLine) operandText(name string) string {
+
+	hex := func(v, digits int) string {
+		if name != "" {
+			return name
+		}
+		return fmt.Sprintf("$%.*X", digits, v)
+	}
+
+	v := line.Operand
+	switch line.Mode {
+	case modeImp, modeAcc:
+		return ""
+	case modeImm:
+		return fmt.Sprintf("#$%.2X", v)
+	case modeImmM, modeImmX:
+		if len(line.Bytes) == 3 {
+			return fmt.Sprintf("#$%.4X", v)
+		}
+		return fmt.Sprintf("#$%.2X", v)
+	case modeDP:
+		return hex(v, 2)
+	case modeDPX:
+		return hex(v, 2) + ",X"
+	case modeDPY:
+		return hex(v, 2) + ",Y"
+	case modeDPInd:
+		return "(" + hex(v, 2) + ")"
+	case modeDPIndX:
+		return "(" + hex(v, 2) + ",X)"
+	case modeDPIndY:
+		return "(" + hex(v, 2) + "),Y"
+	case modeDPIndLong:
+		return "[" + hex(v, 2) + "]"
+	case modeDPIndLongY:
+		return "[" + hex(v, 2) + "],Y"
+	case modeSR:
+		return fmt.Sprintf("$%.2X,S", v)
+	case modeSRIndY:
+		return fmt.Sprintf("($%.2X,S),Y", v)
+	case modeAbs, modeRel, modeRelLong:
+		return hex(v, 4)
+	case modeAbsX:
+		return hex(v, 4) + ",X"
+	case modeAbsY:
+		return hex(v, 4) + ",Y"
+	case modeAbsInd:
+		return "(" + hex(v, 4) + ")"
+	case modeAbsIndX:
+		return "(" + hex(v, 4) + ",X)"
+	case modeAbsIndLong:
+		return "[" + hex(v, 4) + "]"
+	case modeLong:
+		return hex(v, 6)
+	case modeLongX:
+		return hex(v, 6) + ",X"
+	case modeMove:
+		// the destination bank comes first in the code
+		return fmt.Sprintf("$%.2X,$%.2X", v>>8, v&0xff)
+	}
+
+	return ""
+}
+
+// sourceMnemonic marks instructions that Merlin would otherwise assemble
+// to a shorter form, so the source gives back the same bytes.
+func (line *DisasmLine) sourceMnemonic() string {
+	switch line.Mode {
+	case modeAbs, modeAbsX, modeAbsY:
+		if line.Operand < 0x100 && line.Mnemonic != "JMP" && line.Mnemonic != "JSR" && line.Mnemonic != "PEA" {
+			return line.Mnemonic + ":"
+		}
+	case modeLong, modeLongX:
+		if line.Operand <= 0xffff && line.Mnemonic != "JSL" && line.Mnemonic != "JML" {
+			return line.Mnemonic + "L"
+		}
+	}
+	return line.Mnemonic
+}
+
+// Listing renders the code the way the monitor lists it, with the names
+// of known addresses alongside.
+func (d *Disassembly) Listing() string {
+
+	out := bytes.NewBuffer(nil)
+
+	for _, line := range d.Lines {
+
+		raw := make([]string, len(line.Bytes))
+		for i, b := range line.Bytes {
+			raw[i] = fmt.Sprintf("%.2X", b)
+		}
+		prefix := fmt.Sprintf("%.4X-   %-12s", line.Address, strings.Join(raw, " "))
+
+		if line.Mnemonic == "" {
+			out.WriteString(prefix + "???\n")
+			continue
+		}
+
+		text := fmt.Sprintf("%s   %s", line.Mnemonic, line.operandText(""))
+		if line.Target != -1 {
+			if name := knownLabel(line.Target, line.Mnemonic, line.Mode); name != "" {
+				text = fmt.Sprintf("%-20s; %s", text, name)
+			}
+		}
+		out.WriteString(prefix + strings.TrimRight(text, " ") + "\n")
+	}
+
+	return out.String()
+}
+
+// Source renders Merlin style source, with EQUs for the ROM entry points
+// and soft switches used.
+func (d *Disassembly) Source(title string) string {
+
+	out := bytes.NewBuffer(nil)
+
+	line := func(label, opcode, operand string) {
+		s := fmt.Sprintf("%-8s %-5s %s", label, opcode, operand)
+		out.WriteString(strings.TrimRight(s, " ") + "\n")
+	}
+
+	end := d.Org
+	if n := len(d.Lines); n > 0 {
+		last := d.Lines[n-1]
+		end = last.Address + len(last.Bytes) - 1
+	}
+
+	out.WriteString(fmt.Sprintf("* %s\n", title))
+	out.WriteString(fmt.Sprintf("* %s code from $%.4X-$%.4X\n", d.CPU, d.Org, end))
+	out.WriteString("\n")
+
+	equs := make(map[string]int)
+	for _, l := range d.Lines {
+		if l.Target == -1 || d.labels[l.Target] != "" {
+			continue
+		}
+		if name := knownLabel(l.Target, l.Mnemonic, l.Mode); name != "" {
+			equs[name] = l.Target
+		}
+	}
+	if len(equs) > 0 {
+		names := make([]string, 0, len(equs))
+		for name := range equs {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if equs[names[i]] != equs[names[j]] {
+				return equs[names[i]] < equs[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			line(name, "EQU", fmt.Sprintf("$%.4X", equs[name]))
+		}
+		out.WriteString("\n")
+	}
+
+	switch d.CPU {
+	case CPU65C02:
+		line("", "XC", "")
+	case CPU65816:
+		line("", "XC", "")
+		line("", "XC", "")
+	}
+	line("", "ORG", fmt.Sprintf("$%.4X", d.Org))
+
+	m16, x16 := d.M16, d.X16
+	mx := func(m, x bool) string {
+		bit := func(wide bool) string {
+			if wide {
+				return "0"
+			}
+			return "1"
+		}
+		return "%" + bit(m) + bit(x)
+	}
+	if d.CPU == CPU65816 {
+		line("", "MX", mx(m16, x16))
+	}
+
+	for _, l := range d.Lines {
+
+		if l.Mnemonic == "" {
+			line(l.Label, "DFB", fmt.Sprintf("$%.2X", l.Bytes[0]))
+			continue
+		}
+
+		name := ""
+		if l.Target != -1 {
+			if name = d.labels[l.Target]; name == "" {
+				name = knownLabel(l.Target, l.Mnemonic, l.Mode)
+			}
+		}
+		line(l.Label, l.sourceMnemonic(), l.operandText(name))
+
+		if d.CPU == CPU65816 && (l.M16 != m16 || l.X16 != x16) {
+			m16, x16 = l.M16, l.X16
+			line("", "MX", mx(m16, x16))
+		}
+	}
+
+	return out.String()
+}
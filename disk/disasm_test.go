@@ -0,0 +1,140 @@
+package disk
+
+import "testing"
+
+// testDisasmCode uses 65C02 opcodes after the 6502 ones
+var testDisasmCode = []byte{
+	0x20, 0x58, 0xfc, // JSR HOME
+	0xa2, 0x00,
+	0xbd, 0x8c, 0xc0,
+	0xf0, 0x06,
+	0x20, 0xed, 0xfd, // JSR COUT
+	0xe8,
+	0xd0, 0xf5,
+	0xad, 0x30, 0xc0,
+	0x80, 0x02, // BRA
+	0x1a,       // INC A
+	0x64, 0x00, // STZ
+	0x7c, 0x00, 0x10, // JMP (abs,X)
+	0xb2, 0x06, // LDA (dp)
+	0x60,
+}
+
+// testDisasm816 switches register widths and modes, and moves a block
+var testDisasm816 = []byte{
+	0x18, 0xfb, // CLC XCE, native mode
+	0xc2, 0x30, // REP #$30
+	0xa9, 0x34, 0x12,
+	0xa2, 0x00, 0x10,
+	0xe2, 0x20, // SEP #$20, the index registers stay 16 bit
+	0xa9, 0x12,
+	0xa0, 0x00, 0x20,
+	0x54, 0x01, 0x02, // MVN from bank 2 to bank 1
+	0x38, 0xfb, // SEC XCE, emulation mode
+	0xa9, 0x00,
+	0xa2, 0x00,
+	0x22, 0x00, 0x00, 0xe1,
+	0x6b,
+}
+
+func TestDisasmListing(t *testing.T) {
+
+	tests := []struct {
+		cpu  CPU
+		code []byte
+		org  int
+		want string
+	}{
+		{CPU6502, testDisasmCode, 0x300, `0300-   20 58 FC    JSR   $FC58         ; HOME
+0303-   A2 00       LDX   #$00
+0305-   BD 8C C0    LDA   $C08C,X       ; Q6L
+0308-   F0 06       BEQ   $0310
+030A-   20 ED FD    JSR   $FDED         ; COUT
+030D-   E8          INX
+030E-   D0 F5       BNE   $0305
+0310-   AD 30 C0    LDA   $C030         ; SPKR
+0313-   80          ???
+0314-   02          ???
+0315-   1A          ???
+0316-   64          ???
+0317-   00          BRK
+0318-   7C          ???
+0319-   00          BRK
+031A-   10 B2       BPL   $02CE
+031C-   06 60       ASL   $60
+`},
+		{CPU65C02, testDisasmCode, 0x300, `0300-   20 58 FC    JSR   $FC58         ; HOME
+0303-   A2 00       LDX   #$00
+0305-   BD 8C C0    LDA   $C08C,X       ; Q6L
+0308-   F0 06       BEQ   $0310
+030A-   20 ED FD    JSR   $FDED         ; COUT
+030D-   E8          INX
+030E-   D0 F5       BNE   $0305
+0310-   AD 30 C0    LDA   $C030         ; SPKR
+0313-   80 02       BRA   $0317
+0315-   1A          INC
+0316-   64 00       STZ   $00
+0318-   7C 00 10    JMP   ($1000,X)
+031B-   B2 06       LDA   ($06)
+031D-   60          RTS
+`},
+		{CPU65816, testDisasm816, 0x2000, `2000-   18          CLC
+2001-   FB          XCE
+2002-   C2 30       REP   #$30
+2004-   A9 34 12    LDA   #$1234
+2007-   A2 00 10    LDX   #$1000
+200A-   E2 20       SEP   #$20
+200C-   A9 12       LDA   #$12
+200E-   A0 00 20    LDY   #$2000
+2011-   54 01 02    MVN   $02,$01
+2014-   38          SEC
+2015-   FB          XCE
+2016-   A9 00       LDA   #$00
+2018-   A2 00       LDX   #$00
+201A-   22 00 00 E1 JSL   $E10000
+201E-   6B          RTL
+`},
+	}
+
+	for _, tt := range tests {
+		if got := Disassemble(tt.code, tt.org, tt.cpu).Listing(); got != tt.want {
+			t.Errorf("%s listing:\n%s\nwant:\n%s", tt.cpu, got, tt.want)
+		}
+	}
+
+}
+
+func TestDisasmSource816(t *testing.T) {
+
+	want := `* TEST
+* 65816 code from $2000-$201E
+
+         XC
+         XC
+         ORG   $2000
+         MX    %11
+         CLC
+         XCE
+         REP   #$30
+         MX    %00
+         LDA   #$1234
+         LDX   #$1000
+         SEP   #$20
+         MX    %10
+         LDA   #$12
+         LDY   #$2000
+         MVN   $02,$01
+         SEC
+         XCE
+         MX    %11
+         LDA   #$00
+         LDX   #$00
+         JSL   $E10000
+         RTL
+`
+
+	if got := Disassemble(testDisasm816, 0x2000, CPU65816).Source("TEST"); got != want {
+		t.Errorf("source:\n%s\nwant:\n%s", got, want)
+	}
+
+}
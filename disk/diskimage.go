@@ -86,18 +86,13 @@ var NIBBLE_53 = []byte{
 	0xf5, 0xf6, 0xf7, 0xfa, 0xfb, 0xfd, 0xfe, 0xff,
 }
 
-var identity = map[string]DiskFormat{
-	"99b900080a0a0a990008c8d0f4a62ba9098527adcc03854184408a4a4a4a4a09": GetDiskFormat(DF_DOS_SECTORS_13),
-	"01a527c909d018a52b4a4a4a4a09c0853fa95c853e18adfe086dff088dfe08ae": GetDiskFormat(DF_DOS_SECTORS_16),
-	"0138b0034c32a18643c903088a29704a4a4a4a09c08549a0ff844828c8b148d0": GetDiskFormat(DF_PRODOS),
-}
-
 const (
 	SectorOrderDOS33 SectorOrder = iota
 	SectorOrderDOS32
 	SectorOrderDOS33Alt
 	SectorOrderProDOS
 	SectorOrderProDOSLinear
+	SectorOrderDOS33ProDOS // DOS 3.3 sectors in a ProDOS ordered (.po) image
 )
 
 func (so SectorOrder) String() string {
@@ -112,6 +107,8 @@ func (so SectorOrder) String() string {
 		return "ProDOS"
 	case SectorOrderProDOSLinear:
 		return "Linear"
+	case SectorOrderDOS33ProDOS:
+		return "DOS (ProDOS order)"
 	}
 
 	return "Linear"
@@ -145,13 +142,15 @@ func GetDiskFormat(id DiskFormatID) DiskFormat {
 	return DiskFormat{ID: id}
 }
 
+// GetPDDiskFormat describes a ProDOS block device of any size. The blocks
+// are laid out as 16 sector "tracks" so the usual block mapping works.
 func GetPDDiskFormat(id DiskFormatID, blocks int) DiskFormat {
 	return DiskFormat{
 		ID:   id,
 		bpd:  blocks,
-		tpd:  80,
-		spt:  blocks / 80,
-		uspt: blocks / 80,
+		tpd:  (blocks + PRODOS_BLOCKS_PER_TRACK - 1) / PRODOS_BLOCKS_PER_TRACK,
+		spt:  STD_SECTORS_PER_TRACK,
+		uspt: STD_SECTORS_PER_TRACK,
 	}
 }
 
@@ -178,7 +177,7 @@ func (f DiskFormat) String() string {
 	case DF_RDOS_33:
 		return "SSI RDOS 32 (16/16/PD)"
 	case DF_PRODOS_CUSTOM:
-		return fmt.Sprintf("ProDOS Custom (%d SPT, %d TPD)", f.SPT(), f.TPD())
+		return fmt.Sprintf("ProDOS Custom (%d blocks)", f.BPD())
 	}
 	return "Unrecognized"
 }
@@ -306,6 +305,14 @@ type DSKWrapper struct {
 	CurrentSectorOrder []int
 	WriteProtected     bool
 	NibblesChanged     bool
+	WOZ                *WOZImage
+	NIB                bool // loaded from a .nib image, Data holds the decoded sectors
+	NibbleEncoding     NibbleEncoding
+	nibData            []byte      // the .nib image as loaded, for protection analysis
+	nibSectors         []byte      // the sectors as first decoded from nibData
+	Header2MG          *Header2MG  // set when the image came from a 2MG container
+	HeaderDC42         *HeaderDC42 // set when the image came from a DiskCopy 4.2 container
+	detected           DetectCandidates
 }
 
 // SectoreMapperDOS33 handles the interleaving for dos sectors
@@ -433,6 +440,19 @@ func SectorMapperDOS33bad(wanted int) int {
 	return -1 // invalid sector
 }
 
+// SectorMapperDOS33ProDOS finds a DOS sector in a ProDOS ordered image,
+// where each block holds a pair of sectors counting down the track.
+func SectorMapperDOS33ProDOS(wanted int) int {
+	switch wanted {
+	case 0, 15:
+		return wanted
+	}
+	if wanted > 0 && wanted < 15 {
+		return 15 - wanted
+	}
+	return -1 // invalid sector
+}
+
 // SectoreMapperProDOS handles the interleaving for dos sectors
 func SectorMapperProDOS(wanted int) int {
 	switch wanted {
@@ -522,6 +542,8 @@ func (d *DSKWrapper) SetSectorPointer() {
 		isector = SectorMapperDOS33(sector)
 	case SectorOrderProDOS:
 		isector = SectorMapperProDOS(sector)
+	case SectorOrderDOS33ProDOS:
+		isector = SectorMapperDOS33ProDOS(sector)
 	}
 
 	d.SectorPointer = (track * d.Format.SPT() * STD_BYTES_PER_SECTOR) + (STD_BYTES_PER_SECTOR * isector)
@@ -541,6 +563,41 @@ func (d *DSKWrapper) ChecksumSector(t, s int) string {
 	return Checksum(d.Data[d.SectorPointer : d.SectorPointer+256])
 }
 
+// ChecksumCanonical hashes the disk in logical order, so copies stored
+// with a different sector order (.dsk, .do, .po) or held in a container
+// match. ProDOS and Pascal volumes go by block, the rest by sector.
+// Unrecognized disks can only be taken as they are.
+func (d *DSKWrapper) ChecksumCanonical() string {
+
+	h := sha256.New()
+
+	switch d.Format.ID {
+	case DF_NONE, DF_PRODOS_400KB, DF_PRODOS_800KB, DF_PRODOS_CUSTOM:
+		// already in block order
+		return d.ChecksumDisk()
+	case DF_PRODOS, DF_PASCAL:
+		for b := 0; b < len(d.Data)/PRODOS_BLOCK_BYTES; b++ {
+			data, err := d.PRODOSGetBlock(b)
+			if err != nil {
+				return d.ChecksumDisk()
+			}
+			h.Write(data)
+		}
+	default:
+		for t := 0; t < d.Format.TPD(); t++ {
+			for s := 0; s < d.Format.USPT(); s++ {
+				if d.Seek(t, s) != nil {
+					return d.ChecksumDisk()
+				}
+				h.Write(d.Read())
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+
+}
+
 func (d *DSKWrapper) IsChanged() bool {
 	return d.NibblesChanged
 }
@@ -610,8 +667,34 @@ func NewDSKWrapper(nibbler Nibbler, filename string) (*DSKWrapper, error) {
 }
 
 func NewDSKWrapperBin(nibbler Nibbler, data []byte, filename string) (*DSKWrapper, error) {
+	return NewDSKWrapperDetected(nibbler, data, filename, nil)
+}
 
-	if len(data) != 232960 &&
+// NewDSKWrapperDetected is NewDSKWrapperBin for data that has already been
+// through Detect, so the sector order it ranked highest is used rather
+// than probing again.
+func NewDSKWrapperDetected(nibbler Nibbler, data []byte, filename string, dc DetectCandidates) (*DSKWrapper, error) {
+
+	if isWOZ, _ := IsWOZ(data); isWOZ {
+		return NewDSKWrapperWOZ(nibbler, data, filename)
+	}
+
+	if len(data) == DISK_NIBBLE_LENGTH {
+		return NewDSKWrapperNIB(nibbler, data, filename)
+	}
+
+	if IsDC42(data) {
+		return NewDSKWrapperDC42(nibbler, data, filename)
+	}
+
+	if isNuFX, _ := IsNuFX(data); isNuFX {
+		return NewDSKWrapperNuFX(nibbler, data, filename)
+	}
+
+	is2MG := len(data) > PREAMBLE_2MG_SIZE && matchAt(data, 0, MAGIC_2MG)
+	isHDV, _ := IsProDOSHDV(data)
+
+	if !is2MG && !isHDV &&
 		len(data) != STD_DISK_BYTES &&
 		len(data) != STD_DISK_BYTES_OLD &&
 		len(data) != PRODOS_400KB_DISK_BYTES &&
@@ -630,6 +713,9 @@ func NewDSKWrapperBin(nibbler Nibbler, data []byte, filename string) (*DSKWrappe
 	this.CurrentSectorOrder = DOS_33_SECTOR_ORDER
 	this.Nibbles = nibbler
 	this.WriteProtected = false
+	if best := dc.Best(); best != nil && best.Container == "" {
+		this.detected = dc
+	}
 
 	this.Identify()
 
@@ -637,6 +723,70 @@ func NewDSKWrapperBin(nibbler Nibbler, data []byte, filename string) (*DSKWrappe
 
 }
 
+// detectCandidates gives what Detect makes of the sectors, worked out
+// once per image
+func (dsk *DSKWrapper) detectCandidates() DetectCandidates {
+	if dsk.detected == nil {
+		dsk.detected = Detect(dsk.Data, dsk.Filename)
+	}
+	return dsk.detected
+}
+
+// rankedLayouts orders the sector layouts to try for a format, putting
+// those Detect found most likely first. The others follow in case the
+// contents fooled it.
+func (dsk *DSKWrapper) rankedLayouts(id DiskFormatID, layouts []SectorOrder) []SectorOrder {
+
+	out := make([]SectorOrder, 0, len(layouts))
+	seen := make(map[SectorOrder]bool)
+
+	for _, c := range dsk.detectCandidates() {
+		if c.Format.ID != id || c.Container != "" || seen[c.Layout] {
+			continue
+		}
+		for _, l := range layouts {
+			if l == c.Layout {
+				out = append(out, l)
+				seen[l] = true
+			}
+		}
+	}
+
+	for _, l := range layouts {
+		if !seen[l] {
+			out = append(out, l)
+		}
+	}
+
+	return out
+
+}
+
+// isFloppySize is true for the sizes handled by the regular floppy checks.
+// 400K disks only ever hold ProDOS in block order so they are left to the
+// block device check.
+func (dsk *DSKWrapper) isFloppySize() bool {
+	switch len(dsk.Data) {
+	case STD_DISK_BYTES, STD_DISK_BYTES_OLD, PRODOS_800KB_DISK_BYTES:
+		return true
+	}
+	return false
+}
+
+// Bytes returns the image as it should be written back to disk
+func (dsk *DSKWrapper) Bytes() []byte {
+	if dsk.NIB {
+		return dsk.nibBytes()
+	}
+	if dsk.Header2MG != nil {
+		return dsk.Header2MG.Bytes(dsk.Data)
+	}
+	if dsk.HeaderDC42 != nil {
+		return dsk.HeaderDC42.Bytes(dsk.Data)
+	}
+	return dsk.Data
+}
+
 func (dsk *DSKWrapper) GetNibbles() []byte {
 
 	n := make([]byte, DISK_NIBBLE_LENGTH)
@@ -665,21 +815,24 @@ func (dsk *DSKWrapper) Identify() {
 
 	dsk.Filename = strings.ToLower(dsk.Filename)
 
-	switch {
-	case strings.HasSuffix(dsk.Filename, ".po"):
-		hint = GetDiskFormat(DF_PRODOS)
-	case strings.HasSuffix(dsk.Filename, ".do"):
-		hint = GetDiskFormat(DF_DOS_SECTORS_16)
-	default:
-		hint = GetDiskFormat(DF_DOS_SECTORS_16)
-	}
-
 	is2MG, Format, Layout, zdsk := dsk.Is2MG()
 	if is2MG {
 		////fmt.Println("repacked", len(zdsk.Data))
 		dsk.SetData(zdsk.Data)
 		dsk.Layout = Layout
 		dsk.Format = Format
+		dsk.WriteProtected = dsk.Header2MG.IsLocked()
+		return
+	}
+
+	if isHDV, blocks := IsProDOSHDV(dsk.Data); isHDV && !dsk.isFloppySize() {
+		dsk.Format = GetPDDiskFormat(DF_PRODOS_CUSTOM, blocks)
+		if blocks == PRODOS_400KB_BLOCKS && len(dsk.Data) == PRODOS_400KB_DISK_BYTES {
+			dsk.Format = GetDiskFormat(DF_PRODOS_400KB)
+		}
+		dsk.Layout = SectorOrderProDOSLinear
+		dsk.CurrentSectorOrder = PRODOS_SECTOR_ORDER
+		dsk.SetNibbles(make([]byte, 232960))
 		return
 	}
 
@@ -727,7 +880,7 @@ func (dsk *DSKWrapper) Identify() {
 			dsk.Format = GetDiskFormat(DF_RDOS_32)
 			dsk.Layout = SectorOrderDOS33Alt
 			dsk.CurrentSectorOrder = DOS_33_SECTOR_ORDER
-			dsk.SetNibbles(make([]byte, 232960)) // FIXME: fix nibbles
+			dsk.SetNibbles(dsk.Nibblize())
 		case RDOS_33:
 			dsk.Format = GetDiskFormat(DF_RDOS_33)
 			dsk.Layout = SectorOrderProDOS
@@ -758,13 +911,15 @@ func (dsk *DSKWrapper) Identify() {
 		case SectorOrderDOS33Alt:
 			////fmt.Println("Sector Order: Alt Linear")
 			dsk.CurrentSectorOrder = LINEAR_SECTOR_ORDER
+
+		case SectorOrderDOS33ProDOS:
+			dsk.CurrentSectorOrder = PRODOS_SECTOR_ORDER
 		}
 		dsk.SetNibbles(dsk.Nibblize())
 		return
 	}
 
-	fp := hex.EncodeToString(dsk.Data[:32])
-	if dfmt, ok := identity[fp]; ok {
+	if dfmt, ok := bootFormat(dsk.Data); ok {
 		dsk.Format = dfmt
 		//fmt.Println(dsk.Format.String())
 	}
@@ -806,7 +961,7 @@ func (dsk *DSKWrapper) Identify() {
 			dsk.Layout = SectorOrderDOS32
 			dsk.CurrentSectorOrder = DOS_32_SECTOR_ORDER
 			dsk.Format = GetDiskFormat(DF_DOS_SECTORS_13)
-			dsk.SetNibbles(make([]byte, 232960))
+			dsk.SetNibbles(dsk.Nibblize())
 		}
 		return
 	}
@@ -880,6 +1035,14 @@ func (dsk *DSKWrapper) Identify() {
 
 	}
 
+	// nothing checked out, go with whatever the contents look most like
+	hint = GetDiskFormat(DF_DOS_SECTORS_16)
+	if c := dsk.detectCandidates().BestOf(DF_PRODOS, DF_DOS_SECTORS_16); c != nil {
+		hint = c.Format
+	} else if strings.HasSuffix(dsk.Filename, ".po") {
+		hint = GetDiskFormat(DF_PRODOS)
+	}
+
 	switch hint.ID {
 	case DF_PRODOS:
 		dsk.Format = GetDiskFormat(DF_PRODOS)
@@ -975,6 +1138,10 @@ func PokeToAscii(v uint, usealt bool) int {
 
 func (d *DSKWrapper) Nibblize() []byte {
 
+	if len(d.Data) == STD_DISK_BYTES_OLD {
+		return d.nibblize53()
+	}
+
 	if len(d.Data) != STD_DISK_BYTES {
 		return make([]byte, 232960)
 	}
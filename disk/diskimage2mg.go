@@ -10,8 +10,43 @@ const PREAMBLE_2MG_SIZE = 0x40
 
 var MAGIC_2MG = []byte{byte('2'), byte('I'), byte('M'), byte('G')}
 
+const VERSION_2MG = 1
+
+const FORMAT_2MG_DOS = 0x00
+const FORMAT_2MG_PRODOS = 0x01
+const FORMAT_2MG_NIB = 0x02
+
+const FLAG_2MG_LOCKED = 0x80000000
+const FLAG_2MG_VOLUME_VALID = 0x00000100
+
 type Header2MG struct {
-	Data [64]byte
+	Data        [64]byte
+	Comment     string
+	CreatorData []byte
+}
+
+// NewHeader2MG starts the header for a new image, the data and chunk
+// positions are filled in by Bytes
+func NewHeader2MG(format int, blocks int) *Header2MG {
+	h := &Header2MG{}
+	copy(h.Data[0x00:], MAGIC_2MG)
+	h.SetCreatorID("DSKA")
+	h.SetHeaderSize(PREAMBLE_2MG_SIZE)
+	h.SetVersion(VERSION_2MG)
+	h.SetImageFormat(format)
+	h.SetProDOSBlocks(blocks)
+	return h
+}
+
+func get2MGInt(b []byte) int {
+	return int(b[0]) + 256*int(b[1]) + 65536*int(b[2]) + 16777216*int(b[3])
+}
+
+func set2MGInt(b []byte, v int) {
+	b[0] = byte(v & 0xff)
+	b[1] = byte((v >> 8) & 0xff)
+	b[2] = byte((v >> 16) & 0xff)
+	b[3] = byte((v >> 24) & 0xff)
 }
 
 func (h *Header2MG) SetData(data []byte) {
@@ -30,36 +65,165 @@ func (h *Header2MG) GetCreatorID() string {
 	return string(h.Data[0x04:0x08])
 }
 
+func (h *Header2MG) SetCreatorID(id string) {
+	for i := 0; i < 4; i++ {
+		if i < len(id) {
+			h.Data[0x04+i] = id[i]
+		} else {
+			h.Data[0x04+i] = ' '
+		}
+	}
+}
+
 func (h *Header2MG) GetHeaderSize() int {
 	return int(h.Data[0x08]) + 256*int(h.Data[0x09])
 }
 
+func (h *Header2MG) SetHeaderSize(v int) {
+	h.Data[0x08] = byte(v & 0xff)
+	h.Data[0x09] = byte(v >> 8)
+}
+
 func (h *Header2MG) GetVersion() int {
 	return int(h.Data[0x0A]) + 256*int(h.Data[0x0B])
 }
 
+func (h *Header2MG) SetVersion(v int) {
+	h.Data[0x0A] = byte(v & 0xff)
+	h.Data[0x0B] = byte(v >> 8)
+}
+
 func (h *Header2MG) GetImageFormat() int {
-	return int(h.Data[0x0C]) + 256*int(h.Data[0x0D]) + 65336*int(h.Data[0x0E]) + 16777216*int(h.Data[0x0F])
+	return get2MGInt(h.Data[0x0C:])
+}
+
+func (h *Header2MG) SetImageFormat(v int) {
+	set2MGInt(h.Data[0x0C:], v)
 }
 
 func (h *Header2MG) GetDOSFlags() int {
-	return int(h.Data[0x10]) + 256*int(h.Data[0x11]) + 65336*int(h.Data[0x12]) + 16777216*int(h.Data[0x13])
+	return get2MGInt(h.Data[0x10:])
+}
+
+func (h *Header2MG) SetDOSFlags(v int) {
+	set2MGInt(h.Data[0x10:], v)
+}
+
+// IsLocked reports the write protect flag
+func (h *Header2MG) IsLocked() bool {
+	return h.GetDOSFlags()&FLAG_2MG_LOCKED != 0
+}
+
+func (h *Header2MG) SetLocked(b bool) {
+	f := h.GetDOSFlags() &^ FLAG_2MG_LOCKED
+	if b {
+		f |= FLAG_2MG_LOCKED
+	}
+	h.SetDOSFlags(f)
+}
+
+// GetVolume returns the DOS 3.3 volume number, if one is set
+func (h *Header2MG) GetVolume() (int, bool) {
+	f := h.GetDOSFlags()
+	return f & 0xff, f&FLAG_2MG_VOLUME_VALID != 0
+}
+
+func (h *Header2MG) SetVolume(v int) {
+	f := h.GetDOSFlags() &^ 0xff
+	h.SetDOSFlags(f | FLAG_2MG_VOLUME_VALID | (v & 0xff))
 }
 
 func (h *Header2MG) GetProDOSBlocks() int {
-	return int(h.Data[0x14]) + 256*int(h.Data[0x15]) + 65336*int(h.Data[0x16]) + 16777216*int(h.Data[0x17])
+	return get2MGInt(h.Data[0x14:])
+}
+
+func (h *Header2MG) SetProDOSBlocks(v int) {
+	set2MGInt(h.Data[0x14:], v)
 }
 
 func (h *Header2MG) GetDiskDataStart() int {
-	return int(h.Data[0x18]) + 256*int(h.Data[0x19]) + 65336*int(h.Data[0x1A]) + 16777216*int(h.Data[0x1B])
+	return get2MGInt(h.Data[0x18:])
+}
+
+func (h *Header2MG) SetDiskDataStart(v int) {
+	set2MGInt(h.Data[0x18:], v)
 }
 
 func (h *Header2MG) GetDiskDataLength() int {
-	return int(h.Data[0x1C]) + 256*int(h.Data[0x1D]) + 65336*int(h.Data[0x1E]) + 16777216*int(h.Data[0x1F])
+	return get2MGInt(h.Data[0x1C:])
+}
+
+func (h *Header2MG) SetDiskDataLength(v int) {
+	set2MGInt(h.Data[0x1C:], v)
+}
+
+func (h *Header2MG) GetCommentStart() int {
+	return get2MGInt(h.Data[0x20:])
+}
+
+func (h *Header2MG) GetCommentLength() int {
+	return get2MGInt(h.Data[0x24:])
+}
+
+func (h *Header2MG) GetCreatorDataStart() int {
+	return get2MGInt(h.Data[0x28:])
+}
+
+func (h *Header2MG) GetCreatorDataLength() int {
+	return get2MGInt(h.Data[0x2C:])
+}
+
+// readChunks pulls the comment and creator data out of the full image
+func (h *Header2MG) readChunks(data []byte) {
+	if start, size := h.GetCommentStart(), h.GetCommentLength(); start > 0 && size > 0 && start+size <= len(data) {
+		h.Comment = string(data[start : start+size])
+	}
+	if start, size := h.GetCreatorDataStart(), h.GetCreatorDataLength(); start > 0 && size > 0 && start+size <= len(data) {
+		h.CreatorData = append([]byte(nil), data[start:start+size]...)
+	}
+}
+
+// Bytes builds a complete 2MG image around the disk data, placing the
+// comment and creator data chunks after it.
+func (h *Header2MG) Bytes(data []byte) []byte {
+
+	h.SetHeaderSize(PREAMBLE_2MG_SIZE)
+	h.SetDiskDataStart(PREAMBLE_2MG_SIZE)
+	h.SetDiskDataLength(len(data))
+
+	offset := PREAMBLE_2MG_SIZE + len(data)
+
+	set2MGInt(h.Data[0x20:], 0)
+	set2MGInt(h.Data[0x24:], 0)
+	if h.Comment != "" {
+		set2MGInt(h.Data[0x20:], offset)
+		set2MGInt(h.Data[0x24:], len(h.Comment))
+		offset += len(h.Comment)
+	}
+
+	set2MGInt(h.Data[0x28:], 0)
+	set2MGInt(h.Data[0x2C:], 0)
+	if len(h.CreatorData) > 0 {
+		set2MGInt(h.Data[0x28:], offset)
+		set2MGInt(h.Data[0x2C:], len(h.CreatorData))
+		offset += len(h.CreatorData)
+	}
+
+	out := make([]byte, 0, offset)
+	out = append(out, h.Data[:]...)
+	out = append(out, data...)
+	out = append(out, []byte(h.Comment)...)
+	out = append(out, h.CreatorData...)
+
+	return out
 }
 
 func (dsk *DSKWrapper) Is2MG() (bool, DiskFormat, SectorOrder, *DSKWrapper) {
 
+	if len(dsk.Data) < PREAMBLE_2MG_SIZE {
+		return false, GetDiskFormat(DF_NONE), SectorOrderDOS33, nil
+	}
+
 	h := &Header2MG{}
 	h.SetData(dsk.Data[:0x40])
 
@@ -73,30 +237,46 @@ func (dsk *DSKWrapper) Is2MG() (bool, DiskFormat, SectorOrder, *DSKWrapper) {
 	start := h.GetDiskDataStart()
 	size := h.GetDiskDataLength()
 
-	if size < len(dsk.Data)-start {
-		size = len(dsk.Data) - start
+	if size == 0 && h.GetProDOSBlocks() > 0 {
+		size = h.GetProDOSBlocks() * 512
 	}
 
-	if size != STD_DISK_BYTES && size != PRODOS_800KB_DISK_BYTES && size != PRODOS_400KB_DISK_BYTES {
+	isBlocks := h.GetImageFormat() == FORMAT_2MG_PRODOS && size%PRODOS_BLOCK_BYTES == 0
+
+	if size != STD_DISK_BYTES && size != PRODOS_800KB_DISK_BYTES && size != PRODOS_400KB_DISK_BYTES && !isBlocks {
 		fmt.Printf("Bad size %d bytes @ start %d\n", size, start)
 		return false, GetDiskFormat(DF_NONE), SectorOrderDOS33, nil
 	}
 
+	if start+size > len(dsk.Data) {
+		return false, GetDiskFormat(DF_NONE), SectorOrderDOS33, nil
+	}
+
+	h.readChunks(dsk.Data)
+
 	data := dsk.Data[start : start+size]
 	format := h.GetImageFormat()
 	switch format {
-	case 0x00: /* DOS sector order */
+	case FORMAT_2MG_DOS: /* DOS sector order */
 		zdsk, _ := NewDSKWrapperBin(dsk.Nibbles, data, dsk.Filename)
+		dsk.Header2MG = h
 		return true, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, zdsk
-	case 0x01: /* ProDOS sector order */
+	case FORMAT_2MG_PRODOS: /* ProDOS sector order */
 		zdsk, _ := NewDSKWrapperBin(dsk.Nibbles, data, dsk.Filename)
+		if zdsk == nil {
+			// odd sized and not formatted, keep the blocks as they are
+			zdsk = &DSKWrapper{Data: data}
+		}
+		dsk.Header2MG = h
 
 		if h.GetProDOSBlocks() == 1600 {
 			return true, GetDiskFormat(DF_PRODOS_800KB), SectorOrderProDOSLinear, zdsk
+		} else if h.GetProDOSBlocks() == PRODOS_BLOCKS_PER_DISK && size == STD_DISK_BYTES {
+			return true, GetDiskFormat(DF_PRODOS), SectorOrderProDOSLinear, zdsk
 		} else if h.GetProDOSBlocks() == 800 {
 			return true, GetDiskFormat(DF_PRODOS_400KB), SectorOrderProDOSLinear, zdsk
 		} else {
-			return true, GetPDDiskFormat(DF_PRODOS_CUSTOM, h.GetProDOSBlocks()), SectorOrderProDOSLinear, zdsk
+			return true, GetPDDiskFormat(DF_PRODOS_CUSTOM, size/PRODOS_BLOCK_BYTES), SectorOrderProDOSLinear, zdsk
 		}
 
 	}
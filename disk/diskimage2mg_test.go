@@ -0,0 +1,79 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test2MGRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format int
+		dsk    func([]byte) *DSKWrapper
+		want   DiskFormatID
+	}{
+		{"DOS", FORMAT_2MG_DOS, func(file []byte) *DSKWrapper {
+			return testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, file)
+		}, DF_DOS_SECTORS_16},
+		{"ProDOS 800K", FORMAT_2MG_PRODOS, func(file []byte) *DSKWrapper {
+			return testProDOSDisk(t, PRODOS_800KB_BLOCKS, SectorOrderProDOSLinear, file)
+		}, DF_PRODOS_800KB},
+	}
+
+	for i, tt := range tests {
+
+		file := testData(int64(10+i), 7000)
+		dsk := tt.dsk(file)
+
+		h := NewHeader2MG(tt.format, len(dsk.Data)/PRODOS_BLOCK_BYTES)
+		h.Comment = "made by the tests"
+		h.CreatorData = []byte{1, 2, 3, 4}
+		h.SetVolume(17)
+		image := h.Bytes(dsk.Data)
+
+		w, err := NewDSKWrapperBin(nil, image, "test.2mg")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if w.Format.ID != tt.want || w.Header2MG == nil || w.WriteProtected {
+			t.Fatalf("%s: loaded as %s, write protected %v", tt.name, w.Format, w.WriteProtected)
+		}
+		if w.Header2MG.Comment != h.Comment || !bytes.Equal(w.Header2MG.CreatorData, h.CreatorData) {
+			t.Errorf("%s: comment %q, creator data %v", tt.name, w.Header2MG.Comment, w.Header2MG.CreatorData)
+		}
+		if v, ok := w.Header2MG.GetVolume(); !ok || v != 17 {
+			t.Errorf("%s: volume %d (valid %v), want 17", tt.name, v, ok)
+		}
+		if !bytes.Equal(w.Data, dsk.Data) {
+			t.Errorf("%s: sectors don't match the disk", tt.name)
+		}
+
+		if !bytes.Equal(w.Bytes(), image) {
+			t.Errorf("%s: image didn't save as loaded", tt.name)
+		}
+
+		h.SetLocked(true)
+		w, err = NewDSKWrapperBin(nil, h.Bytes(dsk.Data), "test.2mg")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !w.WriteProtected {
+			t.Errorf("%s: locked image isn't write protected", tt.name)
+		}
+
+	}
+
+}
+
+func Test2MGTruncated(t *testing.T) {
+
+	dsk := testProDOSDisk(t, PRODOS_800KB_BLOCKS, SectorOrderProDOSLinear, testData(12, 100))
+	image := NewHeader2MG(FORMAT_2MG_PRODOS, PRODOS_800KB_BLOCKS).Bytes(dsk.Data)
+
+	w := &DSKWrapper{Data: image[:len(image)-1000]}
+	if ok, _, _, _ := w.Is2MG(); ok {
+		t.Error("truncated image was taken as 2MG")
+	}
+
+}
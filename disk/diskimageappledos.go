@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type FileType byte
@@ -50,6 +51,35 @@ func AppleDOSFileTypeFromExt(ext string) FileType {
 	return 0x04
 }
 
+// AppleDOSProDOSTypeMap gives the ProDOS equivalent of each DOS 3.3 type,
+// as used when files are moved between the two.
+var AppleDOSProDOSTypeMap = map[FileType]ProDOSFileType{
+	FileTypeTXT: FileType_PD_TXT,
+	FileTypeINT: FileType_PD_INT,
+	FileTypeAPP: FileType_PD_APP,
+	FileTypeBIN: FileType_PD_BIN,
+	FileTypeS:   0xf2,
+	FileTypeREL: FileType_PD_Reloc,
+	FileTypeA:   0xf3,
+	FileTypeB:   0xf4,
+}
+
+func (ft FileType) ProDOSType() ProDOSFileType {
+	if t, ok := AppleDOSProDOSTypeMap[ft]; ok {
+		return t
+	}
+	return FileType_PD_BIN
+}
+
+func AppleDOSFileTypeFromProDOS(t ProDOSFileType) FileType {
+	for ft, pt := range AppleDOSProDOSTypeMap {
+		if pt == t {
+			return ft
+		}
+	}
+	return FileTypeBIN
+}
+
 func (ft FileType) Ext() string {
 
 	info, ok := AppleDOSTypeMap[ft]
@@ -103,6 +133,13 @@ func (fd *FileDescriptor) IsUnused() bool {
 	return fd.Data[0] == 0xff || fd.Type().String() == "Unknown" || fd.TotalSectors() == 0
 }
 
+// Delete marks the entry deleted the way DOS does, keeping the track of the
+// T/S list in the last byte of the name so the file can be recovered
+func (fd *FileDescriptor) Delete() {
+	fd.Data[0x20] = fd.Data[0x00]
+	fd.Data[0x00] = 0xff
+}
+
 func (fd *FileDescriptor) GetTrackSectorListStart() (int, int) {
 	return int(fd.Data[0]), int(fd.Data[1])
 }
@@ -154,7 +191,7 @@ func (fd *FileDescriptor) Name() string {
 	s := ""
 	for _, v := range r {
 		ch := PokeToAscii(uint(v), false)
-		s = s + string(ch)
+		s = s + string(rune(ch))
 	}
 
 	s = strings.ToLower(strings.Trim(s, " "))
@@ -178,7 +215,7 @@ func (fd *FileDescriptor) NameUnadorned() string {
 	s := ""
 	for _, v := range r {
 		ch := PokeToAscii(uint(v), false)
-		s = s + string(ch)
+		s = s + string(rune(ch))
 	}
 
 	s = strings.ToLower(strings.Trim(s, " "))
@@ -214,6 +251,11 @@ func (fd *FileDescriptor) SetTrackSectorListStart(t, s int) {
 	fd.Data[1] = byte(s)
 }
 
+const APPLEDOS_CATALOG_TRACK = 17
+const APPLEDOS_BOOT_TRACKS = 3
+const APPLEDOS_TS_PAIRS = 122
+const APPLEDOS_DEFAULT_VOLUME = 254
+
 type VTOC struct {
 	Data [256]byte
 	t, s int
@@ -258,6 +300,15 @@ func (fd *VTOC) BytesPerSector() int {
 	return int(fd.Data[0x36]) + 256*int(fd.Data[0x37])
 }
 
+// IsInitialized is true for a VTOC laid out the way INIT leaves it, so a
+// disk with nothing in the catalog yet can still be recognized.
+func (fd *VTOC) IsInitialized() bool {
+	ct, cs := fd.GetCatalogStart()
+	return ct == 17 && cs == fd.GetSectors()-1 &&
+		fd.GetMaxTSPairsPerSector() == APPLEDOS_TS_PAIRS &&
+		fd.BytesPerSector() == STD_BYTES_PER_SECTOR
+}
+
 func (fd *VTOC) IsTSFree(t, s int) bool {
 	offset := 0x38 + t*4
 	if s < 8 {
@@ -334,7 +385,7 @@ func (dsk *DSKWrapper) IsAppleDOS() (bool, DiskFormat, SectorOrder) {
 
 	if len(dsk.Data) == STD_DISK_BYTES {
 
-		layouts := []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear}
+		layouts := dsk.rankedLayouts(DF_DOS_SECTORS_16, []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear, SectorOrderDOS33ProDOS})
 
 		for _, l := range layouts {
 
@@ -354,7 +405,7 @@ func (dsk *DSKWrapper) IsAppleDOS() (bool, DiskFormat, SectorOrder) {
 				continue
 			}
 
-			if len(files) > 0 {
+			if len(files) > 0 || vtoc.IsInitialized() {
 				return true, GetDiskFormat(DF_DOS_SECTORS_16), l
 			}
 
@@ -362,7 +413,7 @@ func (dsk *DSKWrapper) IsAppleDOS() (bool, DiskFormat, SectorOrder) {
 
 	} else if len(dsk.Data) == STD_DISK_BYTES_OLD {
 
-		layouts := []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear}
+		layouts := dsk.rankedLayouts(DF_DOS_SECTORS_13, []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear})
 
 		dsk.Format = GetDiskFormat(DF_DOS_SECTORS_13)
 
@@ -383,7 +434,7 @@ func (dsk *DSKWrapper) IsAppleDOS() (bool, DiskFormat, SectorOrder) {
 				continue
 			}
 
-			if len(files) > 0 {
+			if len(files) > 0 || vtoc.IsInitialized() {
 				return true, GetDiskFormat(DF_DOS_SECTORS_13), l
 			}
 
@@ -870,7 +921,7 @@ func (d *DSKWrapper) AppleDOSNamedCatalogEntry(name string) (*FileDescriptor, er
 
 		//fmt.Printf("FILE NAME CHECK [%s] vs [%s]\n", strings.ToLower(fd.NameUnadorned()), strings.ToLower(name))
 
-		if name != "" && strings.ToLower(fd.NameUnadorned()) == strings.ToLower(name) {
+		if name != "" && fd.Data[0] != 0xff && strings.ToLower(fd.NameUnadorned()) == strings.ToLower(name) {
 			return &fd, nil
 		}
 		count++
@@ -901,8 +952,15 @@ func (dsk *DSKWrapper) AppleDOSWriteFile(name string, kind FileType, data []byte
 		return err
 	}
 
-	if kind != FileTypeTXT {
-		header := []byte{byte(loadAddr % 256), byte(loadAddr / 256)}
+	// binary files start with the load address and length, the rest
+	// (bar text) with just the length, as AppleDOSReadFileRaw expects
+	switch kind {
+	case FileTypeTXT:
+	case FileTypeBIN:
+		header := []byte{byte(loadAddr % 256), byte(loadAddr / 256), byte(len(data) % 256), byte(len(data) / 256)}
+		data = append(header, data...)
+	default:
+		header := []byte{byte(len(data) % 256), byte(len(data) / 256)}
 		data = append(header, data...)
 	}
 
@@ -1010,7 +1068,7 @@ func (dsk *DSKWrapper) AppleDOSWriteFile(name string, kind FileType, data []byte
 	fd.SetName(name)
 	fd.SetTrackSectorListStart(tsBlocks[0][0], tsBlocks[0][1])
 	fd.SetType(kind)
-	fd.SetTotalSectors(len(dataBlocks))
+	fd.SetTotalSectors(len(tsBlocks) + len(dataBlocks))
 
 	return nil
 
@@ -1045,8 +1103,7 @@ func (d *DSKWrapper) AppleDOSRemoveFile(fd *FileDescriptor) error {
 		vtoc.SetTSFree(pair[0], pair[1], true)
 	}
 
-	fd.Data[0x00] = 0xff
-	fd.SetName("")
+	fd.Delete()
 	return fd.Publish(d)
 
 }
@@ -1093,8 +1150,7 @@ func (dsk *DSKWrapper) AppleDOSDeleteFile(name string) error {
 		return err
 	}
 
-	fd.Data[0x00] = 0xff
-	fd.SetName("")
+	fd.Delete()
 	return fd.Publish(dsk)
 
 }
@@ -1134,3 +1190,263 @@ func (dsk *DSKWrapper) AppleDOSRenameFile(name, newname string) error {
 	return fd.Publish(dsk)
 
 }
+
+// NewAppleDOSVolume makes an empty DOS volume, with a fresh VTOC and
+// catalog on track 17. If boot is given its DOS tracks are copied so the
+// new disk will start up, otherwise only track 0 is kept back as DOS can't
+// use it for files. 13 sector disks are always in DOS order.
+func NewAppleDOSVolume(format DiskFormat, layout SectorOrder, volume int, boot *DSKWrapper) (*DSKWrapper, error) {
+
+	dsk := &DSKWrapper{Format: format, Layout: layout}
+
+	var version byte
+	switch {
+	case format.ID == DF_DOS_SECTORS_16 && layout == SectorOrderDOS33:
+		dsk.Data = make([]byte, STD_DISK_BYTES)
+		dsk.CurrentSectorOrder = DOS_33_SECTOR_ORDER
+		version = 3
+	case format.ID == DF_DOS_SECTORS_16 && layout == SectorOrderDOS33ProDOS:
+		dsk.Data = make([]byte, STD_DISK_BYTES)
+		dsk.CurrentSectorOrder = PRODOS_SECTOR_ORDER
+		version = 3
+	case format.ID == DF_DOS_SECTORS_13 && layout == SectorOrderDOS32:
+		dsk.Data = make([]byte, STD_DISK_BYTES_OLD)
+		dsk.CurrentSectorOrder = DOS_32_SECTOR_ORDER
+		version = 2
+	default:
+		return nil, errors.New("Can't make " + format.String() + " disk with " + layout.String() + " sectors")
+	}
+
+	if volume < 1 || volume > 254 {
+		return nil, errors.New("Volume number must be 1 to 254")
+	}
+
+	if boot != nil && boot.Format.ID != format.ID {
+		return nil, errors.New("Boot disk is not " + format.String())
+	}
+
+	tracks := format.TPD()
+	spt := format.SPT()
+
+	if boot != nil {
+		for t := 0; t < APPLEDOS_BOOT_TRACKS; t++ {
+			for s := 0; s < spt; s++ {
+				err := boot.Seek(t, s)
+				if err != nil {
+					return nil, err
+				}
+				data := boot.Read()
+				err = dsk.Seek(t, s)
+				if err != nil {
+					return nil, err
+				}
+				dsk.Write(data)
+			}
+		}
+	}
+
+	vtoc := &VTOC{t: APPLEDOS_CATALOG_TRACK, s: 0}
+	vtoc.Data[0x00] = 0x04
+	vtoc.Data[0x01] = APPLEDOS_CATALOG_TRACK
+	vtoc.Data[0x02] = byte(spt - 1)
+	vtoc.Data[0x03] = version
+	vtoc.Data[0x06] = byte(volume)
+	vtoc.Data[0x27] = APPLEDOS_TS_PAIRS
+	vtoc.Data[0x30] = APPLEDOS_CATALOG_TRACK // last track allocated
+	vtoc.Data[0x31] = 1                      // direction of allocation
+	vtoc.Data[0x34] = byte(tracks)
+	vtoc.Data[0x35] = byte(spt)
+	vtoc.Data[0x36] = STD_BYTES_PER_SECTOR & 0xff
+	vtoc.Data[0x37] = STD_BYTES_PER_SECTOR >> 8
+
+	reserved := 1
+	if boot != nil {
+		reserved = APPLEDOS_BOOT_TRACKS
+	}
+	for t := 0; t < tracks; t++ {
+		for s := 0; s < spt; s++ {
+			vtoc.SetTSFree(t, s, t >= reserved && t != APPLEDOS_CATALOG_TRACK)
+		}
+	}
+
+	err := vtoc.Publish(dsk)
+	if err != nil {
+		return nil, err
+	}
+
+	// catalog sectors are chained from the end of the track down to sector 1
+	for s := spt - 1; s > 0; s-- {
+		data := make([]byte, STD_BYTES_PER_SECTOR)
+		if s > 1 {
+			data[0x01] = APPLEDOS_CATALOG_TRACK
+			data[0x02] = byte(s - 1)
+		}
+		err = dsk.Seek(APPLEDOS_CATALOG_TRACK, s)
+		if err != nil {
+			return nil, err
+		}
+		dsk.Write(data)
+	}
+
+	return dsk, nil
+
+}
+
+// appleDOSDriver exposes DOS 3.2 and 3.3 volumes through the Driver
+// interface.
+type appleDOSDriver struct{}
+
+func init() {
+	RegisterDriver(appleDOSDriver{}, DF_DOS_SECTORS_13, DF_DOS_SECTORS_16)
+}
+
+func (appleDOSDriver) Name() string {
+	return "AppleDOS"
+}
+
+func (appleDOSDriver) Caps() DriverCaps {
+	return CapWrite
+}
+
+func (appleDOSDriver) BlockSize() int {
+	return 256
+}
+
+func (appleDOSDriver) MaxNameLength() int {
+	return 30
+}
+
+func (appleDOSDriver) Catalog(dsk *DSKWrapper, path string, pattern string) ([]*CatalogEntry, error) {
+
+	_, files, err := dsk.AppleDOSGetCatalog(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*CatalogEntry, 0, len(files))
+	for _, fd := range files {
+		out = append(out, appleDOSEntry(fd))
+	}
+
+	return out, nil
+
+}
+
+func appleDOSEntry(fd FileDescriptor) *CatalogEntry {
+
+	e := &CatalogEntry{
+		Name:   fd.NameUnadorned(),
+		Kind:   int(fd.Type()),
+		Type:   fd.Type().String(),
+		Ext:    fd.Type().Ext(),
+		Class:  appleDOSDriver{}.Class(int(fd.Type())),
+		Locked: fd.IsLocked(),
+		fd:     fd,
+	}
+	switch fd.Type() {
+	case FileTypeAPP:
+		e.LoadAddress = 0x801
+	case FileTypeINT:
+		e.LoadAddress = 0x1000
+	}
+
+	return e
+
+}
+
+func (appleDOSDriver) ReadFile(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error) {
+	fd, ok := e.fd.(FileDescriptor)
+	if !ok {
+		return nil, errors.New("Not an AppleDOS catalog entry")
+	}
+	_, addr, data, err := dsk.AppleDOSReadFileRaw(fd)
+	if err == nil && fd.Type() == FileTypeBIN {
+		e.LoadAddress = addr
+	}
+	return data, err
+}
+
+func (appleDOSDriver) WriteFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error {
+	return dsk.AppleDOSWriteFile(name, FileType(kind), data, loadAddr)
+}
+
+func (appleDOSDriver) DeleteFile(dsk *DSKWrapper, path string, name string) error {
+	return dsk.AppleDOSDeleteFile(name)
+}
+
+func (appleDOSDriver) RenameFile(dsk *DSKWrapper, path string, name string, newname string) error {
+	return dsk.AppleDOSRenameFile(name, newname)
+}
+
+func (appleDOSDriver) SetLocked(dsk *DSKWrapper, path string, name string, lock bool) error {
+	return dsk.AppleDOSSetLocked(name, lock)
+}
+
+// SetFileInfo can only carry over write protection, DOS keeps no dates.
+func (appleDOSDriver) SetFileInfo(dsk *DSKWrapper, path string, name string, access ProDOSAccessMode, created, modified time.Time) error {
+	if access&AccessType_Writable == 0 {
+		return dsk.AppleDOSSetLocked(strings.ToUpper(name), true)
+	}
+	return nil
+}
+
+func (appleDOSDriver) Mkdir(dsk *DSKWrapper, path string, name string) error {
+	return ErrNotSupported
+}
+
+func (appleDOSDriver) UsedBitmap(dsk *DSKWrapper) ([]bool, error) {
+
+	vtoc, err := dsk.AppleDOSGetVTOC()
+	if err != nil {
+		return nil, err
+	}
+
+	// no VTOC to speak of, so work it out from the files
+	if vtoc.IsTSFree(17, 0) {
+		return dsk.AppleDOSUsedBitmap()
+	}
+
+	out := make([]bool, dsk.Format.TPD()*dsk.Format.SPT())
+	for t := 0; t < dsk.Format.TPD(); t++ {
+		for s := 0; s < dsk.Format.SPT(); s++ {
+			out[t*dsk.Format.SPT()+s] = !vtoc.IsTSFree(t, s)
+		}
+	}
+
+	return out, nil
+
+}
+
+func (appleDOSDriver) Check(dsk *DSKWrapper, repair bool) ([]*CheckProblem, error) {
+	return dsk.AppleDOSCheck(repair)
+}
+
+func (appleDOSDriver) Deleted(dsk *DSKWrapper) ([]*DeletedEntry, error) {
+	return dsk.AppleDOSGetDeleted()
+}
+
+func (appleDOSDriver) Undelete(dsk *DSKWrapper, d *DeletedEntry) error {
+	return dsk.AppleDOSUndelete(d)
+}
+
+func (appleDOSDriver) TypeFromExt(ext string) int {
+	return int(AppleDOSFileTypeFromExt(ext))
+}
+
+func (appleDOSDriver) TypeFromProDOS(t ProDOSFileType) int {
+	return int(AppleDOSFileTypeFromProDOS(t))
+}
+
+func (appleDOSDriver) Class(kind int) CatalogEntryType {
+	switch FileType(kind) {
+	case FileTypeTXT:
+		return CETText
+	case FileTypeINT:
+		return CETBasicInteger
+	case FileTypeAPP:
+		return CETBasicApplesoft
+	case FileTypeBIN:
+		return CETBinary
+	}
+	return CETUnknown
+}
@@ -0,0 +1,112 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppleDOSDriverRoundTrip(t *testing.T) {
+
+	// text files come back padded to the sector, so no zeros in the text
+	text := bytes.Repeat([]byte("PRINT \"HELLO\"\x8d"), 40)
+
+	tests := []struct {
+		name     string
+		kind     FileType
+		data     []byte
+		loadAddr int
+		want     int // load address listed after reading
+	}{
+		{"TEXT", FileTypeTXT, text, 0, 0},
+		{"INTEGER", FileTypeINT, testData(60, 700), 0, 0x1000},
+		{"APPLESOFT", FileTypeAPP, testData(61, 1500), 0, 0x801},
+		{"BINARY", FileTypeBIN, testData(62, 2000), 0x4000, 0x4000},
+	}
+
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(63, 100))
+	d, err := dsk.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		if err := d.WriteFile(dsk, "", tt.name, int(tt.kind), tt.data, tt.loadAddr); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+	}
+
+	for _, tt := range tests {
+
+		e := testEntry(t, dsk, "", tt.name)
+		if e == nil {
+			t.Fatalf("%s isn't in the catalog", tt.name)
+		}
+		if FileType(e.Kind) != tt.kind {
+			t.Errorf("%s listed as %s", tt.name, e.Type)
+		}
+
+		data, err := d.ReadFile(dsk, e)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.kind == FileTypeTXT {
+			data = bytes.TrimRight(data, "\x00")
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: read back %d bytes, wrote %d", tt.name, len(data), len(tt.data))
+		}
+		if e.LoadAddress != tt.want {
+			t.Errorf("%s: load address %.4x, want %.4x", tt.name, e.LoadAddress, tt.want)
+		}
+
+	}
+
+}
+
+func TestAppleDOSBlankVolumeReload(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format DiskFormatID
+		layout SectorOrder
+	}{
+		{"blank.do", DF_DOS_SECTORS_16, SectorOrderDOS33},
+		{"blank.dsk", DF_DOS_SECTORS_16, SectorOrderDOS33},
+		{"blank.po", DF_DOS_SECTORS_16, SectorOrderDOS33ProDOS},
+		{"blank.d13", DF_DOS_SECTORS_13, SectorOrderDOS32},
+	}
+
+	file := testData(80, 3000)
+
+	for _, tt := range tests {
+
+		dsk, err := NewAppleDOSVolume(GetDiskFormat(tt.format), tt.layout, 254, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		// as init leaves it, then as put leaves it
+		w, err := NewDSKWrapperBin(nil, dsk.Bytes(), tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if w.Format.ID != tt.format {
+			t.Fatalf("%s: blank volume reloaded as %s", tt.name, w.Format)
+		}
+		if err := w.AppleDOSWriteFile("HELLO", FileTypeBIN, file, 0x2000); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		w, err = NewDSKWrapperBin(nil, w.Bytes(), tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// 13 sector images are read the one way whatever the layout says
+		if w.Format.ID != tt.format || (tt.format == DF_DOS_SECTORS_16 && w.Layout != tt.layout) {
+			t.Fatalf("%s: reloaded as %s, %s order", tt.name, w.Format, w.Layout)
+		}
+		testReadBack(t, w, "HELLO", file)
+
+	}
+
+}
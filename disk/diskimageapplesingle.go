@@ -0,0 +1,234 @@
+package disk
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+	AppleSingle / AppleDouble (version 2) support...
+
+	A 26 byte big endian header followed by a list of entries, each giving
+	an id, offset and length. AppleSingle holds the data fork as an entry,
+	AppleDouble leaves it in a separate file and only carries the rest.
+	We write the real name, ProDOS file info, dates and the forks.
+*/
+
+const AS_HEADER_SIZE = 26
+const AS_ENTRY_SIZE = 12
+const AS_VERSION = 0x00020000
+
+const AS_MAGIC_SINGLE = 0x00051600
+const AS_MAGIC_DOUBLE = 0x00051607
+
+const (
+	AS_ENTRY_DATA_FORK     = 1
+	AS_ENTRY_RESOURCE_FORK = 2
+	AS_ENTRY_REAL_NAME     = 3
+	AS_ENTRY_FILE_DATES    = 8
+	AS_ENTRY_FINDER_INFO   = 9
+	AS_ENTRY_PRODOS_INFO   = 11
+)
+
+// dates are signed seconds from this point, 0x80000000 meaning unknown
+var asEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const asUnknownDate = -0x80000000
+
+type AppleSingle struct {
+	Double   bool // AppleDouble header, data fork lives elsewhere
+	Name     string
+	HasInfo  bool // ProDOS file info was present
+	Access   ProDOSAccessMode
+	FileType ProDOSFileType
+	AuxType  int
+	Created  time.Time
+	Modified time.Time
+	Data     []byte
+	Resource []byte
+}
+
+func getASInt(b []byte) int {
+	return 16777216*int(b[0]) + 65536*int(b[1]) + 256*int(b[2]) + int(b[3])
+}
+
+func setASInt(b []byte, v int) {
+	b[0] = byte((v >> 24) & 0xff)
+	b[1] = byte((v >> 16) & 0xff)
+	b[2] = byte((v >> 8) & 0xff)
+	b[3] = byte(v & 0xff)
+}
+
+func asDateToTime(b []byte) time.Time {
+	v := int32(getASInt(b))
+	if v == asUnknownDate {
+		return time.Time{}
+	}
+	return asEpoch.Add(time.Duration(v) * time.Second).Local()
+}
+
+func timeToASDate(b []byte, t time.Time) {
+	if t.IsZero() {
+		setASInt(b, asUnknownDate)
+		return
+	}
+	setASInt(b, int(t.Sub(asEpoch)/time.Second))
+}
+
+func IsAppleSingle(data []byte) bool {
+	return len(data) >= AS_HEADER_SIZE && getASInt(data) == AS_MAGIC_SINGLE
+}
+
+func IsAppleDouble(data []byte) bool {
+	return len(data) >= AS_HEADER_SIZE && getASInt(data) == AS_MAGIC_DOUBLE
+}
+
+// NewAppleSingle describes a ProDOS file ready for writing out
+func NewAppleSingle(name string, access ProDOSAccessMode, kind ProDOSFileType, auxtype int, created, modified time.Time, data []byte) *AppleSingle {
+	return &AppleSingle{
+		Name:     name,
+		HasInfo:  true,
+		Access:   access,
+		FileType: kind,
+		AuxType:  auxtype,
+		Created:  created,
+		Modified: modified,
+		Data:     data,
+	}
+}
+
+// ParseAppleSingle reads either an AppleSingle or AppleDouble header file.
+// Unknown entries are skipped.
+func ParseAppleSingle(data []byte) (*AppleSingle, error) {
+
+	as := &AppleSingle{}
+
+	switch {
+	case IsAppleSingle(data):
+	case IsAppleDouble(data):
+		as.Double = true
+	default:
+		return nil, errors.New("Not an AppleSingle or AppleDouble file")
+	}
+
+	count := 256*int(data[24]) + int(data[25])
+	if AS_HEADER_SIZE+count*AS_ENTRY_SIZE > len(data) {
+		return nil, errors.New("AppleSingle entry list is truncated")
+	}
+
+	var finder []byte
+
+	for i := 0; i < count; i++ {
+
+		e := data[AS_HEADER_SIZE+i*AS_ENTRY_SIZE:]
+		id := getASInt(e[0:])
+		offset := getASInt(e[4:])
+		length := getASInt(e[8:])
+
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errors.New("AppleSingle entry is truncated")
+		}
+		entry := data[offset : offset+length]
+
+		switch id {
+		case AS_ENTRY_DATA_FORK:
+			as.Data = entry
+		case AS_ENTRY_RESOURCE_FORK:
+			as.Resource = entry
+		case AS_ENTRY_REAL_NAME:
+			as.Name = string(entry)
+		case AS_ENTRY_FILE_DATES:
+			if length >= 8 {
+				as.Created = asDateToTime(entry[0:4])
+				as.Modified = asDateToTime(entry[4:8])
+			}
+		case AS_ENTRY_FINDER_INFO:
+			finder = entry
+		case AS_ENTRY_PRODOS_INFO:
+			if length >= 8 {
+				as.HasInfo = true
+				as.Access = ProDOSAccessMode(256*int(entry[0]) + int(entry[1]))
+				as.FileType = ProDOSFileType(256*int(entry[2]) + int(entry[3]))
+				as.AuxType = getASInt(entry[4:])
+			}
+		}
+	}
+
+	// Files from a Mac may only carry the ProDOS type in the finder info
+	if !as.HasInfo && len(finder) >= 8 && finder[0] == 'p' && string(finder[4:8]) == "pdos" {
+		as.HasInfo = true
+		as.Access = AccessType_Default
+		as.FileType = ProDOSFileType(finder[1])
+		as.AuxType = 256*int(finder[2]) + int(finder[3])
+	}
+
+	return as, nil
+
+}
+
+// Bytes builds the file, leaving out the data fork for AppleDouble
+func (as *AppleSingle) Bytes() []byte {
+
+	type asEntry struct {
+		id   int
+		data []byte
+	}
+
+	var entries []asEntry
+
+	if as.Name != "" {
+		entries = append(entries, asEntry{AS_ENTRY_REAL_NAME, []byte(as.Name)})
+	}
+
+	if as.HasInfo {
+		info := make([]byte, 8)
+		info[1] = byte(as.Access)
+		info[3] = byte(as.FileType)
+		setASInt(info[4:], as.AuxType)
+		entries = append(entries, asEntry{AS_ENTRY_PRODOS_INFO, info})
+
+		finder := make([]byte, 32)
+		copy(finder, []byte{'p', byte(as.FileType), byte(as.AuxType >> 8), byte(as.AuxType)})
+		copy(finder[4:], []byte("pdos"))
+		entries = append(entries, asEntry{AS_ENTRY_FINDER_INFO, finder})
+	}
+
+	if !as.Created.IsZero() || !as.Modified.IsZero() {
+		dates := make([]byte, 16)
+		timeToASDate(dates[0:], as.Created)
+		timeToASDate(dates[4:], as.Modified)
+		timeToASDate(dates[8:], time.Time{})
+		timeToASDate(dates[12:], time.Time{})
+		entries = append(entries, asEntry{AS_ENTRY_FILE_DATES, dates})
+	}
+
+	if len(as.Resource) > 0 {
+		entries = append(entries, asEntry{AS_ENTRY_RESOURCE_FORK, as.Resource})
+	}
+
+	if !as.Double {
+		entries = append(entries, asEntry{AS_ENTRY_DATA_FORK, as.Data})
+	}
+
+	out := make([]byte, AS_HEADER_SIZE+len(entries)*AS_ENTRY_SIZE)
+
+	if as.Double {
+		setASInt(out[0:], AS_MAGIC_DOUBLE)
+	} else {
+		setASInt(out[0:], AS_MAGIC_SINGLE)
+	}
+	setASInt(out[4:], AS_VERSION)
+	out[24] = byte(len(entries) >> 8)
+	out[25] = byte(len(entries))
+
+	for i, e := range entries {
+		h := out[AS_HEADER_SIZE+i*AS_ENTRY_SIZE:]
+		setASInt(h[0:], e.id)
+		setASInt(h[4:], len(out))
+		setASInt(h[8:], len(e.data))
+		out = append(out, e.data...)
+	}
+
+	return out
+
+}
@@ -0,0 +1,88 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestAppleSingleRoundTrip(t *testing.T) {
+
+	created := time.Date(1988, 9, 12, 10, 30, 0, 0, time.UTC)
+	modified := time.Date(2017, 3, 1, 23, 59, 58, 0, time.UTC)
+
+	for _, double := range []bool{false, true} {
+
+		as := NewAppleSingle("HELLO.SYSTEM", AccessType_Default, FileType_PD_SYS, 0x2000, created, modified, testData(60, 3000))
+		as.Resource = testData(61, 700)
+		as.Double = double
+
+		data := as.Bytes()
+		if IsAppleSingle(data) == double || IsAppleDouble(data) != double {
+			t.Fatalf("AppleDouble %v: wrong magic", double)
+		}
+
+		got, err := ParseAppleSingle(data)
+		if err != nil {
+			t.Fatalf("AppleDouble %v: %v", double, err)
+		}
+
+		if got.Name != as.Name || !got.HasInfo || got.Access != as.Access || got.FileType != as.FileType || got.AuxType != as.AuxType {
+			t.Errorf("AppleDouble %v: read back %q type %02x aux %04x access %02x", double, got.Name, got.FileType, got.AuxType, got.Access)
+		}
+		if !got.Created.Equal(created) || !got.Modified.Equal(modified) {
+			t.Errorf("AppleDouble %v: dates %v, %v", double, got.Created, got.Modified)
+		}
+		if !bytes.Equal(got.Resource, as.Resource) {
+			t.Errorf("AppleDouble %v: resource fork doesn't match", double)
+		}
+
+		wantData := as.Data
+		if double {
+			wantData = nil
+		}
+		if !bytes.Equal(got.Data, wantData) {
+			t.Errorf("AppleDouble %v: data fork %d bytes, want %d", double, len(got.Data), len(wantData))
+		}
+
+	}
+
+}
+
+func TestAppleSingleFinderInfo(t *testing.T) {
+
+	// as written by a Mac, with the ProDOS type only in the finder info
+	finder := make([]byte, 32)
+	copy(finder, []byte{'p', byte(FileType_PD_TXT), 0x12, 0x34, 'p', 'd', 'o', 's'})
+
+	data := make([]byte, AS_HEADER_SIZE+2*AS_ENTRY_SIZE)
+	setASInt(data[0:], AS_MAGIC_SINGLE)
+	setASInt(data[4:], AS_VERSION)
+	data[25] = 2
+	for i, e := range [][]byte{finder, []byte("TEXT")} {
+		id := AS_ENTRY_FINDER_INFO
+		if i == 1 {
+			id = AS_ENTRY_DATA_FORK
+		}
+		h := data[AS_HEADER_SIZE+i*AS_ENTRY_SIZE:]
+		setASInt(h[0:], id)
+		setASInt(h[4:], len(data))
+		setASInt(h[8:], len(e))
+		data = append(data, e...)
+	}
+
+	as, err := ParseAppleSingle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !as.HasInfo || as.FileType != FileType_PD_TXT || as.AuxType != 0x1234 || string(as.Data) != "TEXT" {
+		t.Errorf("read back type %02x aux %04x data %q", as.FileType, as.AuxType, as.Data)
+	}
+
+	// an entry running past the end is refused
+	setASInt(data[AS_HEADER_SIZE+AS_ENTRY_SIZE+8:], 100)
+	if _, err := ParseAppleSingle(data); err == nil {
+		t.Error("truncated entry was accepted")
+	}
+
+}
@@ -0,0 +1,384 @@
+package disk
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+/*
+	Binary II archive support...
+
+	Each file is a 128 byte header holding the ProDOS directory
+	information, followed by the file data padded out to 128 bytes.
+	Squeezed files (.BQY) are unsqueezed on the way in.
+*/
+
+const BNY_HEADER_SIZE = 128
+const BNY_ID_BYTE = 0x02
+const BNY_VERSION = 1
+
+const BNY_FLAG_SQUEEZED = 0x80
+const BNY_FLAG_ENCRYPTED = 0x40
+const BNY_FLAG_SPARSE = 0x01
+
+var MAGIC_BNY = []byte{0x0a, 0x47, 0x4c}
+
+type BinaryIIHeader struct {
+	Data [BNY_HEADER_SIZE]byte
+}
+
+func (h *BinaryIIHeader) SetData(data []byte) {
+	for i, v := range data {
+		if i < BNY_HEADER_SIZE {
+			h.Data[i] = v
+		}
+	}
+}
+
+func (h *BinaryIIHeader) GetAccess() ProDOSAccessMode {
+	return ProDOSAccessMode(h.Data[0x03])
+}
+
+func (h *BinaryIIHeader) SetAccess(a ProDOSAccessMode) {
+	h.Data[0x03] = byte(a)
+}
+
+func (h *BinaryIIHeader) GetFileType() ProDOSFileType {
+	return ProDOSFileType(h.Data[0x04])
+}
+
+func (h *BinaryIIHeader) SetFileType(t ProDOSFileType) {
+	h.Data[0x04] = byte(t)
+}
+
+func (h *BinaryIIHeader) GetAuxType() int {
+	return int(h.Data[0x05]) + 256*int(h.Data[0x06])
+}
+
+func (h *BinaryIIHeader) SetAuxType(v int) {
+	h.Data[0x05] = byte(v & 0xff)
+	h.Data[0x06] = byte(v >> 8)
+}
+
+func (h *BinaryIIHeader) GetStorageType() ProDOSStorageType {
+	return ProDOSStorageType(h.Data[0x07])
+}
+
+func (h *BinaryIIHeader) SetStorageType(t ProDOSStorageType) {
+	h.Data[0x07] = byte(t)
+}
+
+func (h *BinaryIIHeader) GetTotalBlocks() int {
+	return int(h.Data[0x08]) + 256*int(h.Data[0x09])
+}
+
+func (h *BinaryIIHeader) SetTotalBlocks(v int) {
+	h.Data[0x08] = byte(v & 0xff)
+	h.Data[0x09] = byte(v >> 8)
+}
+
+func (h *BinaryIIHeader) ModTime() time.Time {
+	return prodosStampBytesToTime(h.Data[0x0a:0x0e])
+}
+
+func (h *BinaryIIHeader) SetModTime(t time.Time) {
+	copy(h.Data[0x0a:0x0e], timeToProdosStampBytes(t))
+}
+
+func (h *BinaryIIHeader) CreateTime() time.Time {
+	return prodosStampBytesToTime(h.Data[0x0e:0x12])
+}
+
+func (h *BinaryIIHeader) SetCreateTime(t time.Time) {
+	copy(h.Data[0x0e:0x12], timeToProdosStampBytes(t))
+}
+
+// GetEOF returns the length of the stored data, the high byte lives in
+// the GS/OS extension area.
+func (h *BinaryIIHeader) GetEOF() int {
+	return int(h.Data[0x14]) + 256*int(h.Data[0x15]) + 65536*int(h.Data[0x16]) + 16777216*int(h.Data[0x74])
+}
+
+func (h *BinaryIIHeader) SetEOF(v int) {
+	h.Data[0x14] = byte(v & 0xff)
+	h.Data[0x15] = byte((v >> 8) & 0xff)
+	h.Data[0x16] = byte((v >> 16) & 0xff)
+	h.Data[0x74] = byte((v >> 24) & 0xff)
+}
+
+// GetFilename returns the partial ProDOS pathname of the file
+func (h *BinaryIIHeader) GetFilename() string {
+	l := int(h.Data[0x17])
+	if l > 64 {
+		l = 64
+	}
+	return string(h.Data[0x18 : 0x18+l])
+}
+
+func (h *BinaryIIHeader) SetFilename(name string) {
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	for i := 0x18; i < 0x58; i++ {
+		h.Data[i] = 0
+	}
+	h.Data[0x17] = byte(len(name))
+	copy(h.Data[0x18:], []byte(strings.ToUpper(name)))
+}
+
+func (h *BinaryIIHeader) GetDiskSpace() int {
+	return int(h.Data[0x75]) + 256*int(h.Data[0x76]) + 65536*int(h.Data[0x77]) + 16777216*int(h.Data[0x78])
+}
+
+func (h *BinaryIIHeader) SetDiskSpace(v int) {
+	h.Data[0x75] = byte(v & 0xff)
+	h.Data[0x76] = byte((v >> 8) & 0xff)
+	h.Data[0x77] = byte((v >> 16) & 0xff)
+	h.Data[0x78] = byte((v >> 24) & 0xff)
+}
+
+func (h *BinaryIIHeader) IsPhantom() bool {
+	return h.Data[0x7c] != 0
+}
+
+func (h *BinaryIIHeader) GetDataFlags() int {
+	return int(h.Data[0x7d])
+}
+
+func (h *BinaryIIHeader) IsSqueezed() bool {
+	return h.GetDataFlags()&BNY_FLAG_SQUEEZED != 0
+}
+
+func (h *BinaryIIHeader) GetVersion() int {
+	return int(h.Data[0x7e])
+}
+
+func (h *BinaryIIHeader) GetFilesToFollow() int {
+	return int(h.Data[0x7f])
+}
+
+func (h *BinaryIIHeader) SetFilesToFollow(v int) {
+	h.Data[0x7f] = byte(v)
+}
+
+type BinaryIIFile struct {
+	Header BinaryIIHeader
+	Data   []byte
+}
+
+func (f *BinaryIIFile) IsDirectory() bool {
+	return f.Header.GetFileType() == FileType_PD_Directory
+}
+
+// NewBinaryIIFile creates an archive entry from a ProDOS directory entry.
+// Path is the partial pathname to store, eg. "DIR/FILE".
+func NewBinaryIIFile(fd ProDOSFileDescriptor, path string, data []byte) *BinaryIIFile {
+
+	f := &BinaryIIFile{Data: data}
+	h := &f.Header
+
+	copy(h.Data[0x00:], MAGIC_BNY)
+	h.SetAccess(fd.AccessMode())
+	h.SetFileType(fd.Type())
+	h.SetAuxType(fd.AuxType())
+	h.SetStorageType(fd.GetStorageType())
+	h.SetTotalBlocks(fd.TotalBlocks())
+	h.SetModTime(fd.ModTime())
+	h.SetCreateTime(fd.CreateTime())
+	h.Data[0x12] = BNY_ID_BYTE
+	h.SetEOF(len(data))
+	h.SetFilename(path)
+	h.SetDiskSpace(fd.TotalBlocks())
+	h.Data[0x7e] = BNY_VERSION
+
+	return f
+
+}
+
+func IsBinaryII(data []byte) bool {
+	return len(data) >= BNY_HEADER_SIZE && matchAt(data, 0, MAGIC_BNY) && data[0x12] == BNY_ID_BYTE
+}
+
+// ParseBinaryII returns the files held in a Binary II archive. Phantom
+// entries (used to carry data for other programs) are skipped.
+func ParseBinaryII(data []byte) ([]*BinaryIIFile, error) {
+
+	if !IsBinaryII(data) {
+		return nil, errors.New("Not a Binary II archive")
+	}
+
+	var files []*BinaryIIFile
+
+	ptr := 0
+	for ptr+BNY_HEADER_SIZE <= len(data) && matchAt(data, ptr, MAGIC_BNY) {
+
+		f := &BinaryIIFile{}
+		f.Header.SetData(data[ptr : ptr+BNY_HEADER_SIZE])
+		ptr += BNY_HEADER_SIZE
+
+		size := f.Header.GetEOF()
+		if f.IsDirectory() {
+			size = 0
+		}
+		if ptr+size > len(data) {
+			return files, errors.New("Binary II file data is truncated")
+		}
+		raw := data[ptr : ptr+size]
+
+		// data is padded to a multiple of 128 bytes
+		ptr += ((size + BNY_HEADER_SIZE - 1) / BNY_HEADER_SIZE) * BNY_HEADER_SIZE
+
+		if f.Header.IsPhantom() {
+			continue
+		}
+
+		if f.Header.IsSqueezed() {
+			out, err := Unsqueeze(raw)
+			if err != nil {
+				return files, err
+			}
+			raw = out
+		}
+
+		f.Data = raw
+		files = append(files, f)
+
+		if f.Header.GetFilesToFollow() == 0 {
+			break
+		}
+	}
+
+	return files, nil
+
+}
+
+// BinaryIIBytes builds an archive from a list of files
+func BinaryIIBytes(files []*BinaryIIFile) []byte {
+
+	out := make([]byte, 0)
+
+	for i, f := range files {
+		f.Header.SetFilesToFollow(len(files) - i - 1)
+		f.Header.SetEOF(len(f.Data))
+		if f.IsDirectory() {
+			f.Header.SetEOF(0)
+		}
+		out = append(out, f.Header.Data[:]...)
+		if f.IsDirectory() {
+			continue
+		}
+		out = append(out, f.Data...)
+		if pad := len(f.Data) % BNY_HEADER_SIZE; pad != 0 {
+			out = append(out, make([]byte, BNY_HEADER_SIZE-pad)...)
+		}
+	}
+
+	return out
+
+}
+
+/*
+	Squeeze (SQ) decompression, Huffman coding on top of RLE with 0x90 as
+	the repeat marker.
+*/
+
+const SQ_MAGIC = 0xff76
+const SQ_SPEOF = 256
+const SQ_RLE_MARKER = 0x90
+
+func Unsqueeze(data []byte) ([]byte, error) {
+
+	if len(data) < 5 || int(data[0])+256*int(data[1]) != SQ_MAGIC {
+		return nil, errors.New("Not a squeezed file")
+	}
+
+	checksum := int(data[2]) + 256*int(data[3])
+
+	// skip the original filename
+	ptr := 4
+	for ptr < len(data) && data[ptr] != 0 {
+		ptr++
+	}
+	ptr++
+
+	if ptr+2 > len(data) {
+		return nil, errors.New("Squeezed file is truncated")
+	}
+	count := int(data[ptr]) + 256*int(data[ptr+1])
+	ptr += 2
+
+	if count > SQ_SPEOF || ptr+count*4 > len(data) {
+		return nil, errors.New("Bad squeeze tree")
+	}
+
+	nodes := make([][2]int, count)
+	for i := range nodes {
+		nodes[i][0] = int(int16(uint16(data[ptr]) | uint16(data[ptr+1])<<8))
+		nodes[i][1] = int(int16(uint16(data[ptr+2]) | uint16(data[ptr+3])<<8))
+		ptr += 4
+	}
+
+	out := make([]byte, 0, len(data)*2)
+	var last byte
+	repeat := false
+
+	emit := func(c byte) {
+		switch {
+		case repeat:
+			repeat = false
+			if c == 0 {
+				out = append(out, SQ_RLE_MARKER)
+				last = SQ_RLE_MARKER
+				return
+			}
+			for i := 1; i < int(c); i++ {
+				out = append(out, last)
+			}
+		case c == SQ_RLE_MARKER:
+			repeat = true
+		default:
+			out = append(out, c)
+			last = c
+		}
+	}
+
+	if count > 0 {
+		node := 0
+		bit := uint(0)
+	decode:
+		for ptr < len(data) {
+			b := (data[ptr] >> bit) & 0x01
+			bit++
+			if bit == 8 {
+				bit = 0
+				ptr++
+			}
+			next := nodes[node][b]
+			if next >= 0 {
+				if next >= count {
+					return nil, errors.New("Bad squeeze tree")
+				}
+				node = next
+				continue
+			}
+			value := -(next + 1)
+			if value == SQ_SPEOF {
+				break decode
+			}
+			emit(byte(value))
+			node = 0
+		}
+	}
+
+	sum := 0
+	for _, v := range out {
+		sum += int(v)
+	}
+	if sum&0xffff != checksum {
+		return nil, errors.New("Squeezed file checksum mismatch")
+	}
+
+	return out, nil
+
+}
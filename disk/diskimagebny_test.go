@@ -0,0 +1,105 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBinaryIIRoundTrip(t *testing.T) {
+
+	file := testData(50, 1000) // not a multiple of 128, so padded
+	dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, file)
+	if err := dsk.PRODOSCreateDirectory("", "DIR"); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := dsk.PRODOSGetNamedEntry("", "HELLO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := dsk.PRODOSGetNamedEntry("", "DIR")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []*BinaryIIFile{
+		NewBinaryIIFile(*dir, "DIR", nil),
+		NewBinaryIIFile(*fd, "DIR/HELLO", file),
+		NewBinaryIIFile(*fd, "EMPTY", []byte{}),
+	}
+
+	archive := BinaryIIBytes(in)
+	if len(archive)%BNY_HEADER_SIZE != 0 || !IsBinaryII(archive) {
+		t.Fatalf("archive of %d bytes isn't Binary II", len(archive))
+	}
+
+	out, err := ParseBinaryII(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("read back %d files, want %d", len(out), len(in))
+	}
+
+	for i, f := range out {
+		want := in[i]
+		if f.Header.GetFilename() != want.Header.GetFilename() {
+			t.Errorf("file %d: name %q, want %q", i, f.Header.GetFilename(), want.Header.GetFilename())
+		}
+		if f.IsDirectory() != want.IsDirectory() || f.Header.GetFileType() != want.Header.GetFileType() ||
+			f.Header.GetAuxType() != want.Header.GetAuxType() || f.Header.GetAccess() != want.Header.GetAccess() {
+			t.Errorf("%s: file info doesn't match", f.Header.GetFilename())
+		}
+		if !f.Header.ModTime().Equal(want.Header.ModTime()) || !f.Header.CreateTime().Equal(want.Header.CreateTime()) {
+			t.Errorf("%s: dates don't match", f.Header.GetFilename())
+		}
+		if !bytes.Equal(f.Data, want.Data) {
+			t.Errorf("%s: %d bytes, want %d", f.Header.GetFilename(), len(f.Data), len(want.Data))
+		}
+	}
+
+	if out[1].Header.GetAuxType() != 0x2000 || out[1].Header.GetFileType() != FileType_PD_BIN {
+		t.Errorf("HELLO read back as type %02x aux %04x", out[1].Header.GetFileType(), out[1].Header.GetAuxType())
+	}
+
+}
+
+func TestBinaryIISqueezed(t *testing.T) {
+
+	// codes A=0, 0x90=10, 0x04=110, EOF=111 giving A, repeat 4
+	sq := []byte{
+		0x76, 0xff, // magic
+		0x04, 0x01, // checksum of "AAAA"
+		'X', 0x00,
+		0x03, 0x00, // nodes
+		0xbe, 0xff, 0x01, 0x00,
+		0x6f, 0xff, 0x02, 0x00,
+		0xfb, 0xff, 0xff, 0xfe,
+		0xda, 0x01,
+	}
+
+	if out, err := Unsqueeze(sq); err != nil || string(out) != "AAAA" {
+		t.Fatalf("unsqueezed to %q, %v", out, err)
+	}
+
+	f := &BinaryIIFile{Data: sq}
+	copy(f.Header.Data[0x00:], MAGIC_BNY)
+	f.Header.Data[0x12] = BNY_ID_BYTE
+	f.Header.Data[0x7d] = BNY_FLAG_SQUEEZED
+	f.Header.SetFileType(FileType_PD_TXT)
+	f.Header.SetFilename("SQ")
+
+	out, err := ParseBinaryII(BinaryIIBytes([]*BinaryIIFile{f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || string(out[0].Data) != "AAAA" {
+		t.Fatalf("squeezed file read back as %v", out)
+	}
+
+	sq[2] ^= 0xff
+	if _, err := Unsqueeze(sq); err == nil {
+		t.Error("squeeze checksum mismatch wasn't caught")
+	}
+
+}
@@ -0,0 +1,638 @@
+package disk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+	Filesystem checks...
+
+	Each check walks the whole catalog, following every T/S list, index
+	block or extent, and notes who owns each sector or block. Anything
+	claimed twice is cross-linked, anything pointing off the disk is a bad
+	pointer, and the owners are then compared with the free space bitmap.
+	Header counts are checked against what the directory holds.
+
+	With repair set the bitmap is rebuilt from what the files use and the
+	counts are put right. Cross-links and bad pointers are only reported,
+	there is no telling which file is in the wrong.
+*/
+
+type CheckProblemKind int
+
+const (
+	CheckCrossLinked CheckProblemKind = iota
+	CheckOrphaned
+	CheckBadPointer
+	CheckBitmap
+	CheckBadName
+	CheckCount
+	CheckOrder
+)
+
+func (k CheckProblemKind) String() string {
+	switch k {
+	case CheckCrossLinked:
+		return "Cross-linked"
+	case CheckOrphaned:
+		return "Orphaned"
+	case CheckBadPointer:
+		return "Bad pointer"
+	case CheckBitmap:
+		return "Bitmap"
+	case CheckBadName:
+		return "Bad name"
+	case CheckCount:
+		return "Count"
+	case CheckOrder:
+		return "Order"
+	}
+	return "Unknown"
+}
+
+// CheckProblem is one thing wrong with a volume
+type CheckProblem struct {
+	Kind     CheckProblemKind
+	File     string // the file or structure at fault, "" for the volume
+	Message  string
+	Repaired bool
+}
+
+func (p *CheckProblem) String() string {
+	s := p.Kind.String() + ": "
+	if p.File != "" {
+		s += p.File + ": "
+	}
+	s += p.Message
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// checkOwners records which file uses each sector or block
+type checkOwners struct {
+	owner    []string
+	problems []*CheckProblem
+	label    func(i int) string
+}
+
+func newCheckOwners(size int, label func(i int) string) *checkOwners {
+	return &checkOwners{owner: make([]string, size), label: label}
+}
+
+func (c *checkOwners) report(kind CheckProblemKind, file string, format string, args ...interface{}) *CheckProblem {
+	p := &CheckProblem{Kind: kind, File: file, Message: fmt.Sprintf(format, args...)}
+	c.problems = append(c.problems, p)
+	return p
+}
+
+// claim marks i as used by file, it is false if i is off the disk or
+// already in use
+func (c *checkOwners) claim(file string, what string, i int) bool {
+	if i < 0 || i >= len(c.owner) {
+		c.report(CheckBadPointer, file, "%s %s is off the disk", what, c.label(i))
+		return false
+	}
+	if c.owner[i] != "" {
+		c.report(CheckCrossLinked, file, "%s %s is also used by %s", what, c.label(i), c.owner[i])
+		return false
+	}
+	c.owner[i] = file
+	return true
+}
+
+// damaged is true if a cross-link or bad pointer has been found since the
+// problem count was at mark, the counts of a damaged file can't be trusted
+func (c *checkOwners) damaged(mark int) bool {
+	for _, p := range c.problems[mark:] {
+		if p.Kind == CheckCrossLinked || p.Kind == CheckBadPointer {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checkOwners) used(i int) bool {
+	return c.owner[i] != ""
+}
+
+// checkRanges writes a list of numbers as runs, eg. 1-4, 9
+func checkRanges(list []int) string {
+
+	sort.Ints(list)
+
+	var parts []string
+	for i := 0; i < len(list); {
+		j := i
+		for j+1 < len(list) && list[j+1] == list[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", list[i], list[j]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d", list[i]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(parts, ", ")
+
+}
+
+// AppleDOSCheck checks the catalog and T/S lists against the VTOC
+func (dsk *DSKWrapper) AppleDOSCheck(repair bool) ([]*CheckProblem, error) {
+
+	vtoc, err := dsk.AppleDOSGetVTOC()
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := dsk.Format.TPD()
+	spt := dsk.Format.SPT()
+
+	c := newCheckOwners(tracks*spt, func(i int) string {
+		if i < 0 {
+			return "?"
+		}
+		return fmt.Sprintf("T%d S%d", i/spt, i%spt)
+	})
+	ts := func(t, s int) int {
+		if t >= tracks || s >= spt {
+			return -1
+		}
+		return t*spt + s
+	}
+
+	if vtoc.GetTracks() != tracks || vtoc.GetSectors() != spt {
+		c.report(CheckCount, "VTOC", "says %d tracks of %d sectors, the disk has %d of %d", vtoc.GetTracks(), vtoc.GetSectors(), tracks, spt)
+	}
+
+	c.claim("VTOC", "VTOC", ts(APPLEDOS_CATALOG_TRACK, 0))
+
+	// catalog chain, the entries are copied so they can be put right later
+	var entries []*FileDescriptor
+	ct, cs := vtoc.GetCatalogStart()
+	for ct != 0 {
+		if !c.claim("catalog", "Catalog sector", ts(ct, cs)) {
+			break
+		}
+		err = dsk.Seek(ct, cs)
+		if err != nil {
+			return c.problems, err
+		}
+		data := append([]byte(nil), dsk.Read()...)
+		for slot := 0; slot < 7; slot++ {
+			pos := 0x0b + 35*slot
+			if data[pos] == 0x00 || data[pos] == 0xff {
+				continue
+			}
+			fd := &FileDescriptor{}
+			fd.SetData(data[pos:pos+35], ct, cs, pos)
+			entries = append(entries, fd)
+		}
+		ct, cs = int(data[1]), int(data[2])
+	}
+
+	for _, fd := range entries {
+
+		name := fd.NameUnadorned()
+		if !fd.NameOK() {
+			c.report(CheckBadName, name, "name has control characters")
+		}
+
+		mark := len(c.problems)
+		count := 0
+		lt, ls := fd.GetTrackSectorListStart()
+		for lt != 0 {
+			if !c.claim(name, "T/S list", ts(lt, ls)) {
+				break
+			}
+			count++
+			err = dsk.Seek(lt, ls)
+			if err != nil {
+				return c.problems, err
+			}
+			list := append([]byte(nil), dsk.Read()...)
+			for i := 0; i < APPLEDOS_TS_PAIRS; i++ {
+				t, s := int(list[0x0c+i*2]), int(list[0x0d+i*2])
+				if t == 0 {
+					continue // not allocated
+				}
+				if c.claim(name, "Sector", ts(t, s)) {
+					count++
+				}
+			}
+			lt, ls = int(list[1]), int(list[2])
+		}
+
+		if count != fd.TotalSectors() {
+			p := c.report(CheckCount, name, "catalog says %d sectors, the file has %d", fd.TotalSectors(), count)
+			if repair && !c.damaged(mark) {
+				fd.SetTotalSectors(count)
+				err = fd.Publish(dsk)
+				if err != nil {
+					return c.problems, err
+				}
+				p.Repaired = true
+			}
+		}
+
+	}
+
+	// DOS never gives out track 0 or the catalog track. DOS itself lives
+	// in tracks 0-2 of a bootable disk, and INIT marks them in use even when
+	// the boot sector has been replaced, so leave them as they are unless
+	// the disk boots.
+	err = dsk.Seek(0, 0)
+	if err != nil {
+		return c.problems, err
+	}
+	bootable := dsk.Read()[0] == 0x01
+
+	var bitmap []*CheckProblem
+	for t := 0; t < tracks; t++ {
+
+		var marked, unmarked []int
+		for s := 0; s < spt; s++ {
+			used := c.used(t*spt + s)
+			free := vtoc.IsTSFree(t, s)
+			system := t == 0 || t == APPLEDOS_CATALOG_TRACK
+			if t < APPLEDOS_BOOT_TRACKS && (bootable || !free) {
+				system = true
+			}
+			switch {
+			case used && free:
+				unmarked = append(unmarked, s)
+			case !used && !free && !system:
+				marked = append(marked, s)
+			}
+			if repair {
+				vtoc.SetTSFree(t, s, !used && !system)
+			}
+		}
+
+		if len(unmarked) > 0 {
+			bitmap = append(bitmap, c.report(CheckBitmap, "", "T%d S%s in use but marked free", t, checkRanges(unmarked)))
+		}
+		if len(marked) > 0 {
+			bitmap = append(bitmap, c.report(CheckOrphaned, "", "T%d S%s marked in use but not used by any file", t, checkRanges(marked)))
+		}
+
+	}
+
+	if repair && len(bitmap) > 0 {
+		err = vtoc.Publish(dsk)
+		if err != nil {
+			return c.problems, err
+		}
+		for _, p := range bitmap {
+			p.Repaired = true
+		}
+	}
+
+	return c.problems, nil
+
+}
+
+// PRODOSCheck checks every directory and file against the volume bitmap
+func (dsk *DSKWrapper) PRODOSCheck(repair bool) ([]*CheckProblem, error) {
+
+	vdh, err := dsk.PRODOSGetVDH(2)
+	if err != nil {
+		return nil, err
+	}
+
+	total := vdh.GetTotalBlocks()
+
+	c := newCheckOwners(dsk.Format.BPD(), func(i int) string {
+		return fmt.Sprintf("%d", i)
+	})
+
+	if total > dsk.Format.BPD() || total < 3 {
+		c.report(CheckCount, "Volume header", "says %d blocks, the disk has %d", total, dsk.Format.BPD())
+		total = dsk.Format.BPD()
+	}
+	c.owner = c.owner[:total]
+
+	c.claim("boot blocks", "Block", 0)
+	c.claim("boot blocks", "Block", 1)
+
+	bitmapStart := vdh.GetBitmapPointer()
+	for i := 0; i < PRODOSBitmapBlocks(total); i++ {
+		if !c.claim("volume bitmap", "Block", bitmapStart+i) {
+			return c.problems, nil // can't compare or rebuild it
+		}
+	}
+
+	// block pointers are split into a low and a high half
+	pointer := func(data []byte, i int) int {
+		return int(data[i]) + 256*int(data[i+256])
+	}
+
+	// index walks the blocks under an index block, depth 1 for a sapling
+	// and 2 for a tree, counting the blocks used
+	var index func(name string, key int, depth int) int
+	index = func(name string, key int, depth int) int {
+		if !c.claim(name, "Index block", key) {
+			return 0
+		}
+		count := 1
+		data, err := dsk.PRODOSGetBlock(key)
+		if err != nil {
+			return count
+		}
+		entries := 256
+		if depth > 1 {
+			entries = PRODOS_MAX_INDEX_BLOCKS
+		}
+		for i := 0; i < entries; i++ {
+			b := pointer(data, i)
+			switch {
+			case b == 0:
+				// a hole in a sparse file
+			case depth > 1:
+				count += index(name, b, depth-1)
+			case c.claim(name, "Block", b):
+				count++
+			}
+		}
+		return count
+	}
+
+	// fork walks the blocks of a file or one fork of one
+	fork := func(name string, st ProDOSStorageType, key int) int {
+		switch st {
+		case StorageType_Seedling:
+			if c.claim(name, "Block", key) {
+				return 1
+			}
+		case StorageType_Sapling:
+			return index(name, key, 1)
+		case StorageType_Tree:
+			return index(name, key, 2)
+		default:
+			c.report(CheckBadPointer, name, "unknown storage type $%x", int(st))
+		}
+		return 0
+	}
+
+	// dir walks a directory, path is "" for the volume directory and parent
+	// is the block holding the directory's entry
+	var dir func(path string, key int, parent int) int
+	dir = func(path string, key int, parent int) int {
+
+		name := path
+		if name == "" {
+			name = "volume directory"
+		}
+
+		var header *VDH
+		var files []*ProDOSFileDescriptor
+		count := 0
+
+		for b, prev := key, 0; b != 0; {
+
+			if !c.claim(name, "Directory block", b) {
+				break
+			}
+			count++
+
+			data, err := dsk.PRODOSGetBlock(b)
+			if err != nil {
+				break
+			}
+			if back := int(data[0]) + 256*int(data[1]); back != prev {
+				c.report(CheckBadPointer, name, "directory block %d links back to %d, not %d", b, back, prev)
+			}
+
+			first := 4
+			if b == key {
+				header = &VDH{}
+				header.SetData(data[4:4+PRODOS_ENTRY_SIZE], b, 4)
+				first += PRODOS_ENTRY_SIZE
+			}
+
+			for pos := first; pos+PRODOS_ENTRY_SIZE <= PRODOS_BLOCK_BYTES; pos += PRODOS_ENTRY_SIZE {
+				if data[pos]>>4 == byte(StorageType_Inactive) {
+					continue
+				}
+				fd := &ProDOSFileDescriptor{}
+				fd.SetData(data[pos:pos+PRODOS_ENTRY_SIZE], b, pos)
+				files = append(files, fd)
+			}
+
+			prev, b = b, int(data[2])+256*int(data[3])
+		}
+
+		if header == nil {
+			return count
+		}
+
+		if header.GetFileCount() != len(files) {
+			p := c.report(CheckCount, name, "header says %d files, the directory has %d", header.GetFileCount(), len(files))
+			if repair {
+				header.SetFileCount(len(files))
+				if header.Publish(dsk) == nil {
+					p.Repaired = true
+				}
+			}
+		}
+
+		for _, fd := range files {
+
+			fname := strings.TrimPrefix(path+"/"+fd.NameUnadorned(), "/")
+
+			if !fd.NameOK() {
+				c.report(CheckBadName, fname, "name is not a valid ProDOS name")
+			}
+
+			if fd.HeaderPointer() != key {
+				p := c.report(CheckBadPointer, fname, "header pointer is %d, the directory starts at %d", fd.HeaderPointer(), key)
+				if repair {
+					fd.SetHeaderPointer(key)
+					if fd.Publish(dsk) == nil {
+						p.Repaired = true
+					}
+				}
+			}
+
+			mark := len(c.problems)
+			used := 0
+			switch st := fd.GetStorageType(); st {
+			case StorageType_SubDir_File:
+				used = dir(fname, fd.IndexBlock(), fd.blockid)
+			case StorageType_Extended:
+				if !c.claim(fname, "Extended key block", fd.IndexBlock()) {
+					break
+				}
+				used = 1
+				kb, err := dsk.PRODOSGetBlock(fd.IndexBlock())
+				if err != nil {
+					break
+				}
+				for _, off := range []int{0, PRODOS_FORK_ENTRY_OFFSET} {
+					mst := ProDOSStorageType(kb[off] & 0x0f)
+					if mst == StorageType_Inactive {
+						continue
+					}
+					used += fork(fname, mst, int(kb[off+1])+256*int(kb[off+2]))
+				}
+			default:
+				used = fork(fname, st, fd.IndexBlock())
+			}
+
+			if used != fd.TotalBlocks() {
+				p := c.report(CheckCount, fname, "entry says %d blocks, the file has %d", fd.TotalBlocks(), used)
+				if repair && !c.damaged(mark) {
+					fd.SetTotalBlocks(used)
+					if fd.Publish(dsk) == nil {
+						p.Repaired = true
+					}
+				}
+			}
+
+		}
+
+		// a subdirectory points back at the block holding its entry
+		if parent != 0 && header.GetDirParentPointer() != parent {
+			c.report(CheckBadPointer, name, "parent pointer is %d, the entry is in block %d", header.GetDirParentPointer(), parent)
+		}
+
+		return count
+
+	}
+
+	dir("", 2, 0)
+
+	vbm, err := dsk.PRODOSGetVolumeBitmap()
+	if err != nil {
+		return c.problems, err
+	}
+
+	var marked, unmarked []int
+	for b := 0; b < total; b++ {
+		used := c.used(b)
+		free := vbm.IsBlockFree(b)
+		switch {
+		case used && free:
+			unmarked = append(unmarked, b)
+		case !used && !free:
+			marked = append(marked, b)
+		}
+	}
+
+	var bitmap []*CheckProblem
+	if len(unmarked) > 0 {
+		bitmap = append(bitmap, c.report(CheckBitmap, "", "blocks %s in use but marked free", checkRanges(unmarked)))
+	}
+	if len(marked) > 0 {
+		bitmap = append(bitmap, c.report(CheckOrphaned, "", "blocks %s marked in use but not used by any file", checkRanges(marked)))
+	}
+
+	if repair && len(bitmap) > 0 {
+		for b := 0; b < total; b++ {
+			vbm.SetBlockFree(b, !c.used(b))
+		}
+		err = dsk.PRODOSWriteVolumeBitmap(vbm)
+		if err != nil {
+			return c.problems, err
+		}
+		for _, p := range bitmap {
+			p.Repaired = true
+		}
+	}
+
+	return c.problems, nil
+
+}
+
+// PascalCheck checks the extents in the directory. Pascal keeps no bitmap,
+// so all that can be put right is the order of the entries.
+func (dsk *DSKWrapper) PascalCheck(repair bool) ([]*CheckProblem, error) {
+
+	pvh, files, err := dsk.pascalGetDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	total := dsk.pascalVolumeBlocks(pvh)
+
+	c := newCheckOwners(total, func(i int) string {
+		return fmt.Sprintf("%d", i)
+	})
+
+	if pvh.GetTotalBlocks() != total {
+		c.report(CheckCount, "Volume header", "says %d blocks, the disk has %d", pvh.GetTotalBlocks(), total)
+	}
+
+	if len(files) > pascalMaxFiles(pvh) {
+		c.report(CheckCount, "Volume header", "says %d files, the directory only has room for %d", len(files), pascalMaxFiles(pvh))
+	}
+
+	for b := 0; b < pvh.GetNextBlock() && b < total; b++ {
+		c.claim("directory", "Block", b)
+	}
+
+	// the header count is all that marks the end of the directory, so
+	// blank entries at the end mean it is too high
+	count := len(files)
+	for len(files) > 0 && files[len(files)-1].GetName() == "" && files[len(files)-1].GetNextBlock() == 0 {
+		files = files[:len(files)-1]
+	}
+	var header *CheckProblem
+	if len(files) != count {
+		header = c.report(CheckCount, "Volume header", "says %d files, the directory has %d", count, len(files))
+	}
+
+	ordered := true
+	for i, fd := range files {
+
+		name := fd.GetName()
+		if _, err := PascalFileName(name); err != nil || name != strings.ToUpper(name) {
+			c.report(CheckBadName, name, "name is not a valid Pascal name")
+		}
+
+		start, next := fd.GetStartBlock(), fd.GetNextBlock()
+		if next <= start || next > total {
+			c.report(CheckBadPointer, name, "extent %d-%d is not on the disk", start, next-1)
+			continue
+		}
+		for b := start; b < next; b++ {
+			if !c.claim(name, "Block", b) {
+				break
+			}
+		}
+
+		if fd.GetBytesRemaining() > PASCAL_BLOCK_SIZE {
+			c.report(CheckCount, name, "last block holds %d bytes", fd.GetBytesRemaining())
+		}
+
+		if i > 0 && start < files[i-1].GetStartBlock() {
+			ordered = false
+		}
+
+	}
+
+	var order *CheckProblem
+	if !ordered {
+		order = c.report(CheckOrder, "", "directory entries are not in block order")
+	}
+
+	if repair && (order != nil || header != nil) && len(files) <= pascalMaxFiles(pvh) {
+		err = dsk.pascalPutDirectory(pvh, files)
+		if err != nil {
+			return c.problems, err
+		}
+		for _, p := range []*CheckProblem{order, header} {
+			if p != nil {
+				p.Repaired = true
+			}
+		}
+	}
+
+	return c.problems, nil
+
+}
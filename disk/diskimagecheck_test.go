@@ -0,0 +1,330 @@
+package disk
+
+import (
+	"testing"
+)
+
+// testCheckDamage runs a check with and without repair. A repairable
+// problem must be gone on a second check, anything else must still be
+// reported.
+func testCheckDamage(t *testing.T, name string, check func(repair bool) ([]*CheckProblem, error), kind CheckProblemKind, fixed bool) {
+
+	t.Helper()
+
+	find := func(problems []*CheckProblem) *CheckProblem {
+		for _, p := range problems {
+			if p.Kind == kind {
+				return p
+			}
+		}
+		return nil
+	}
+
+	problems, err := check(false)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if find(problems) == nil {
+		t.Fatalf("%s: no %s problem in %v", name, kind, problems)
+	}
+
+	problems, err = check(true)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if p := find(problems); p == nil || p.Repaired != fixed {
+		t.Fatalf("%s: repair gave %v", name, problems)
+	}
+
+	problems, err = check(false)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if fixed && len(problems) != 0 {
+		t.Errorf("%s: still damaged after repair: %v", name, problems)
+	}
+	if !fixed && find(problems) == nil {
+		t.Errorf("%s: %s problem went away without a repair", name, kind)
+	}
+
+}
+
+// testCheckClean fails if a check finds anything
+func testCheckClean(t *testing.T, name string, check func(repair bool) ([]*CheckProblem, error)) {
+
+	t.Helper()
+
+	problems, err := check(false)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("%s: fresh volume has problems: %v", name, problems)
+	}
+
+}
+
+func TestAppleDOSCheck(t *testing.T) {
+
+	entry := func(t *testing.T, dsk *DSKWrapper, name string) *FileDescriptor {
+		fd, err := dsk.AppleDOSNamedCatalogEntry(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+	sectors := func(t *testing.T, dsk *DSKWrapper, name string) [][2]int {
+		list, err := dsk.AppleDOSGetFileSectors(*entry(t, dsk, name), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+	// tsList points at the first T/S list sector of a file
+	tsList := func(t *testing.T, dsk *DSKWrapper, name string) []byte {
+		lt, ls := entry(t, dsk, name).GetTrackSectorListStart()
+		if err := dsk.Seek(lt, ls); err != nil {
+			t.Fatal(err)
+		}
+		return dsk.Read()
+	}
+	vtoc := func(t *testing.T, dsk *DSKWrapper, f func(vtoc *VTOC)) {
+		vtoc, err := dsk.AppleDOSGetVTOC()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(vtoc)
+		if err := vtoc.Publish(dsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, dsk *DSKWrapper)
+		kind   CheckProblemKind
+		fixed  bool
+	}{
+		{"wrong sector count", func(t *testing.T, dsk *DSKWrapper) {
+			fd := entry(t, dsk, "HELLO")
+			fd.SetTotalSectors(fd.TotalSectors() + 3)
+			if err := fd.Publish(dsk); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckCount, true},
+		{"used sector marked free", func(t *testing.T, dsk *DSKWrapper) {
+			used := sectors(t, dsk, "HELLO")[0]
+			vtoc(t, dsk, func(vtoc *VTOC) { vtoc.SetTSFree(used[0], used[1], true) })
+		}, CheckBitmap, true},
+		{"free sector marked used", func(t *testing.T, dsk *DSKWrapper) {
+			vtoc(t, dsk, func(vtoc *VTOC) {
+				if !vtoc.IsTSFree(30, 5) {
+					t.Fatal("T30 S5 is in use")
+				}
+				vtoc.SetTSFree(30, 5, false)
+			})
+		}, CheckOrphaned, true},
+		{"cross-linked sector", func(t *testing.T, dsk *DSKWrapper) {
+			used := sectors(t, dsk, "HELLO")[0]
+			list := tsList(t, dsk, "OTHER")
+			list[0x0c], list[0x0d] = byte(used[0]), byte(used[1])
+		}, CheckCrossLinked, false},
+		{"T/S list off the disk", func(t *testing.T, dsk *DSKWrapper) {
+			list := tsList(t, dsk, "OTHER")
+			list[0x0c], list[0x0d] = 60, 0
+		}, CheckBadPointer, false},
+		{"T/S list start off the disk", func(t *testing.T, dsk *DSKWrapper) {
+			fd := entry(t, dsk, "OTHER")
+			fd.SetTrackSectorListStart(60, 0)
+			if err := fd.Publish(dsk); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckBadPointer, false},
+	}
+
+	fresh := func(t *testing.T) *DSKWrapper {
+		dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(140, 3000))
+		if err := dsk.AppleDOSWriteFile("OTHER", FileTypeBIN, testData(141, 2000), 0x4000); err != nil {
+			t.Fatal(err)
+		}
+		return dsk
+	}
+
+	testCheckClean(t, "fresh volume", fresh(t).AppleDOSCheck)
+
+	for _, tt := range tests {
+		dsk := fresh(t)
+		tt.damage(t, dsk)
+		testCheckDamage(t, tt.name, dsk.AppleDOSCheck, tt.kind, tt.fixed)
+	}
+
+}
+
+func TestPRODOSCheck(t *testing.T) {
+
+	entry := func(t *testing.T, dsk *DSKWrapper, name string) *ProDOSFileDescriptor {
+		fd, err := dsk.PRODOSGetNamedEntry("", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+	bitmap := func(t *testing.T, dsk *DSKWrapper, f func(vbm ProDOSVolumeBitmap)) {
+		vbm, err := dsk.PRODOSGetVolumeBitmap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(vbm)
+		if err := dsk.PRODOSWriteVolumeBitmap(vbm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, dsk *DSKWrapper)
+		kind   CheckProblemKind
+		fixed  bool
+	}{
+		{"wrong file count", func(t *testing.T, dsk *DSKWrapper) {
+			vdh, err := dsk.PRODOSGetVDH(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vdh.SetFileCount(5)
+			if err := vdh.Publish(dsk); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckCount, true},
+		{"wrong block count", func(t *testing.T, dsk *DSKWrapper) {
+			fd := entry(t, dsk, "HELLO")
+			fd.SetTotalBlocks(fd.TotalBlocks() + 2)
+			if err := fd.Publish(dsk); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckCount, true},
+		{"used block marked free", func(t *testing.T, dsk *DSKWrapper) {
+			key := entry(t, dsk, "HELLO").IndexBlock()
+			bitmap(t, dsk, func(vbm ProDOSVolumeBitmap) { vbm.SetBlockFree(key, true) })
+		}, CheckBitmap, true},
+		{"free block marked used", func(t *testing.T, dsk *DSKWrapper) {
+			bitmap(t, dsk, func(vbm ProDOSVolumeBitmap) {
+				if !vbm.IsBlockFree(PRODOS_BLOCKS_PER_DISK - 1) {
+					t.Fatal("last block is in use")
+				}
+				vbm.SetBlockFree(PRODOS_BLOCKS_PER_DISK-1, false)
+			})
+		}, CheckOrphaned, true},
+		{"cross-linked block", func(t *testing.T, dsk *DSKWrapper) {
+			other := entry(t, dsk, "OTHER")
+			other.SetIndexBlock(entry(t, dsk, "HELLO").IndexBlock())
+			if err := other.Publish(dsk); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckCrossLinked, false},
+		{"index block off the disk", func(t *testing.T, dsk *DSKWrapper) {
+			key := entry(t, dsk, "HELLO").IndexBlock()
+			data, err := dsk.PRODOSGetBlock(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[0], data[256] = 0xff, 0x7f
+			if err := dsk.PRODOSWrite(key, data); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckBadPointer, false},
+	}
+
+	fresh := func(t *testing.T) *DSKWrapper {
+		dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, testData(142, 3000))
+		if err := dsk.PRODOSWriteFile("", "OTHER", FileType_PD_BIN, testData(143, 2000), 0x4000); err != nil {
+			t.Fatal(err)
+		}
+		return dsk
+	}
+
+	testCheckClean(t, "fresh volume", fresh(t).PRODOSCheck)
+
+	// holes, trees and forks are walked as well
+	dsk := testProDOSDisk(t, PRODOS_800KB_BLOCKS, SectorOrderProDOSLinear, testData(146, 200000))
+	if err := dsk.PRODOSWriteFileSparse("", "SPARSE", FileType_PD_BIN, testSparseData(147, 1000000), 0x2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsk.PRODOSWriteForkedFile("", "FORKED", FileType_PD_BIN, testData(148, 3000), testData(149, 20000), 0x2000); err != nil {
+		t.Fatal(err)
+	}
+	testCheckClean(t, "sparse, tree and forked files", dsk.PRODOSCheck)
+
+	for _, tt := range tests {
+		dsk := fresh(t)
+		tt.damage(t, dsk)
+		testCheckDamage(t, tt.name, dsk.PRODOSCheck, tt.kind, tt.fixed)
+	}
+
+}
+
+func TestPascalCheck(t *testing.T) {
+
+	// entry is the offset of a directory entry in the first directory block
+	entry := func(i int) int {
+		return (i + 1) * PASCAL_DIRECTORY_ENTRY_LENGTH
+	}
+	directory := func(t *testing.T, dsk *DSKWrapper, f func(data []byte)) {
+		data, err := dsk.PRODOSGetBlock(PASCAL_VOLUME_BLOCK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(data)
+		if err := dsk.PRODOSWrite(PASCAL_VOLUME_BLOCK, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, dsk *DSKWrapper)
+		kind   CheckProblemKind
+		fixed  bool
+	}{
+		{"entries out of order", func(t *testing.T, dsk *DSKWrapper) {
+			directory(t, dsk, func(data []byte) {
+				a := append([]byte(nil), data[entry(0):entry(1)]...)
+				copy(data[entry(0):], data[entry(1):entry(2)])
+				copy(data[entry(1):], a)
+			})
+		}, CheckOrder, true},
+		{"wrong file count", func(t *testing.T, dsk *DSKWrapper) {
+			directory(t, dsk, func(data []byte) { data[0x10] = 3 })
+		}, CheckCount, true},
+		{"cross-linked block", func(t *testing.T, dsk *DSKWrapper) {
+			directory(t, dsk, func(data []byte) {
+				// B starts inside A
+				copy(data[entry(1):entry(1)+2], data[entry(0):entry(0)+2])
+			})
+		}, CheckCrossLinked, false},
+		{"extent off the disk", func(t *testing.T, dsk *DSKWrapper) {
+			directory(t, dsk, func(data []byte) {
+				data[entry(1)+2], data[entry(1)+3] = 0x00, 0x10
+			})
+		}, CheckBadPointer, false},
+	}
+
+	fresh := func(t *testing.T) *DSKWrapper {
+		dsk := testPascalDisk(t)
+		for i, name := range []string{"A.DATA", "B.DATA"} {
+			if err := dsk.PascalWriteFile(name, FileType_PAS_DATA, testData(int64(144+i), 3*PASCAL_BLOCK_SIZE)); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		return dsk
+	}
+
+	testCheckClean(t, "fresh volume", fresh(t).PascalCheck)
+
+	for _, tt := range tests {
+		dsk := fresh(t)
+		tt.damage(t, dsk)
+		testCheckDamage(t, tt.name, dsk.PascalCheck, tt.kind, tt.fixed)
+	}
+
+}
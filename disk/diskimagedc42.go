@@ -0,0 +1,199 @@
+package disk
+
+import (
+	"errors"
+)
+
+/*
+	DiskCopy 4.2 format loader...
+
+	An 84 byte header (big endian) followed by the disk data and then the
+	tag data, if any. Both data and tags carry a checksum.
+*/
+
+const PREAMBLE_DC42_SIZE = 0x54
+const PRIVATE_DC42 = 0x0100
+
+const DISK_DC42_400K = 0x00
+const DISK_DC42_800K = 0x01
+
+const FORMAT_DC42_400K = 0x12
+const FORMAT_DC42_800K = 0x22
+
+type HeaderDC42 struct {
+	Data [PREAMBLE_DC42_SIZE]byte
+	Tags []byte
+}
+
+func getDC42Int(b []byte) int {
+	return 16777216*int(b[0]) + 65536*int(b[1]) + 256*int(b[2]) + int(b[3])
+}
+
+func setDC42Int(b []byte, v int) {
+	b[0] = byte((v >> 24) & 0xff)
+	b[1] = byte((v >> 16) & 0xff)
+	b[2] = byte((v >> 8) & 0xff)
+	b[3] = byte(v & 0xff)
+}
+
+func (h *HeaderDC42) SetData(data []byte) {
+	for i, v := range data {
+		if i < PREAMBLE_DC42_SIZE {
+			h.Data[i] = v
+		}
+	}
+}
+
+func (h *HeaderDC42) GetName() string {
+	l := int(h.Data[0x00])
+	if l > 63 {
+		l = 63
+	}
+	return string(h.Data[0x01 : 0x01+l])
+}
+
+func (h *HeaderDC42) SetName(name string) {
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	for i := 1; i < 64; i++ {
+		h.Data[i] = 0
+	}
+	h.Data[0x00] = byte(len(name))
+	copy(h.Data[0x01:], []byte(name))
+}
+
+func (h *HeaderDC42) GetDataSize() int {
+	return getDC42Int(h.Data[0x40:])
+}
+
+func (h *HeaderDC42) SetDataSize(v int) {
+	setDC42Int(h.Data[0x40:], v)
+}
+
+func (h *HeaderDC42) GetTagSize() int {
+	return getDC42Int(h.Data[0x44:])
+}
+
+func (h *HeaderDC42) SetTagSize(v int) {
+	setDC42Int(h.Data[0x44:], v)
+}
+
+func (h *HeaderDC42) GetDataChecksum() uint32 {
+	return uint32(getDC42Int(h.Data[0x48:]))
+}
+
+func (h *HeaderDC42) SetDataChecksum(v uint32) {
+	setDC42Int(h.Data[0x48:], int(v))
+}
+
+func (h *HeaderDC42) GetTagChecksum() uint32 {
+	return uint32(getDC42Int(h.Data[0x4C:]))
+}
+
+func (h *HeaderDC42) SetTagChecksum(v uint32) {
+	setDC42Int(h.Data[0x4C:], int(v))
+}
+
+// GetDiskFormat returns 0 for 400K and 1 for 800K media
+func (h *HeaderDC42) GetDiskFormat() int {
+	return int(h.Data[0x50])
+}
+
+func (h *HeaderDC42) GetFormatByte() int {
+	return int(h.Data[0x51])
+}
+
+func (h *HeaderDC42) GetPrivate() int {
+	return 256*int(h.Data[0x52]) + int(h.Data[0x53])
+}
+
+// DC42Checksum adds each big endian word then rotates the total right
+func DC42Checksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+		sum = (sum >> 1) | (sum << 31)
+	}
+	return sum
+}
+
+// dc42TagChecksum skips the first 12 bytes of tag data, as DiskCopy does
+func dc42TagChecksum(tags []byte) uint32 {
+	if len(tags) <= 12 {
+		return 0
+	}
+	return DC42Checksum(tags[12:])
+}
+
+func IsDC42(data []byte) bool {
+	if len(data) < PREAMBLE_DC42_SIZE {
+		return false
+	}
+	h := &HeaderDC42{}
+	h.SetData(data)
+	if h.GetPrivate() != PRIVATE_DC42 || h.Data[0x00] > 63 {
+		return false
+	}
+	size := h.GetDataSize()
+	if size != PRODOS_400KB_DISK_BYTES && size != PRODOS_800KB_DISK_BYTES {
+		return false
+	}
+	return len(data) == PREAMBLE_DC42_SIZE+size+h.GetTagSize()
+}
+
+// Bytes builds a complete DiskCopy image around the disk data, updating
+// the checksums.
+func (h *HeaderDC42) Bytes(data []byte) []byte {
+
+	h.SetDataSize(len(data))
+	h.SetTagSize(len(h.Tags))
+	h.SetDataChecksum(DC42Checksum(data))
+	h.SetTagChecksum(dc42TagChecksum(h.Tags))
+
+	out := make([]byte, 0, PREAMBLE_DC42_SIZE+len(data)+len(h.Tags))
+	out = append(out, h.Data[:]...)
+	out = append(out, data...)
+	out = append(out, h.Tags...)
+
+	return out
+}
+
+func NewDSKWrapperDC42(nibbler Nibbler, data []byte, filename string) (*DSKWrapper, error) {
+
+	if !IsDC42(data) {
+		return nil, errors.New("Not a DiskCopy 4.2 image")
+	}
+
+	h := &HeaderDC42{}
+	h.SetData(data)
+
+	size := h.GetDataSize()
+	payload := data[PREAMBLE_DC42_SIZE : PREAMBLE_DC42_SIZE+size]
+	h.Tags = append([]byte(nil), data[PREAMBLE_DC42_SIZE+size:]...)
+
+	if DC42Checksum(payload) != h.GetDataChecksum() {
+		return nil, errors.New("DiskCopy data checksum mismatch")
+	}
+
+	if dc42TagChecksum(h.Tags) != h.GetTagChecksum() {
+		return nil, errors.New("DiskCopy tag checksum mismatch")
+	}
+
+	this, err := NewDSKWrapperBin(nibbler, append([]byte(nil), payload...), filename)
+	if err != nil {
+		return nil, err
+	}
+
+	this.HeaderDC42 = h
+
+	// 3.5" disks are always in block order. If the filesystem was not
+	// recognised the format is left for Detect or the caller to decide.
+	if this.Format.ID == DF_NONE {
+		this.Layout = SectorOrderProDOSLinear
+		this.CurrentSectorOrder = PRODOS_SECTOR_ORDER
+	}
+
+	return this, nil
+
+}
@@ -0,0 +1,119 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDC42Checksum(t *testing.T) {
+
+	tests := []struct {
+		data []byte
+		want uint32
+	}{
+		{[]byte{}, 0},
+		{[]byte{0x00, 0x01}, 0x80000000},
+		{[]byte{0x00, 0x01, 0x00, 0x01}, 0xc0000000},
+		{[]byte{0x12, 0x34, 0x00}, 0x0000091a}, // odd byte is ignored
+	}
+
+	for _, tt := range tests {
+		if got := DC42Checksum(tt.data); got != tt.want {
+			t.Errorf("DC42Checksum(% x) = %08x, want %08x", tt.data, got, tt.want)
+		}
+	}
+
+	// the first 12 bytes of tags are left out
+	tags := append(make([]byte, 12), 0x00, 0x01)
+	if got := dc42TagChecksum(tags); got != 0x80000000 {
+		t.Errorf("tag checksum %08x, want 80000000", got)
+	}
+
+}
+
+func TestDC42RoundTrip(t *testing.T) {
+
+	for i, blocks := range []int{PRODOS_400KB_BLOCKS, PRODOS_800KB_BLOCKS} {
+
+		file := testData(int64(20+i), 20000)
+		dsk := testProDOSDisk(t, blocks, SectorOrderProDOSLinear, file)
+
+		h := &HeaderDC42{Tags: testData(int64(30+i), blocks*12)}
+		h.SetName("Test Disk")
+		h.Data[0x52], h.Data[0x53] = PRIVATE_DC42>>8, PRIVATE_DC42&0xff
+		image := h.Bytes(dsk.Data)
+
+		if !IsDC42(image) {
+			t.Fatalf("%d blocks: not seen as DiskCopy 4.2", blocks)
+		}
+
+		w, err := NewDSKWrapperBin(nil, image, "test.dc")
+		if err != nil {
+			t.Fatalf("%d blocks: %v", blocks, err)
+		}
+		if w.HeaderDC42 == nil || w.HeaderDC42.GetName() != "Test Disk" {
+			t.Fatalf("%d blocks: loaded as %s", blocks, w.Format)
+		}
+		if !bytes.Equal(w.Data, dsk.Data) {
+			t.Errorf("%d blocks: sectors don't match the disk", blocks)
+		}
+
+		if !bytes.Equal(w.Bytes(), image) {
+			t.Errorf("%d blocks: image didn't save as loaded", blocks)
+		}
+
+		// a change to the disk has to be carried into the checksum
+		w.Data[len(w.Data)-1] ^= 0xff
+		if _, err := NewDSKWrapperBin(nil, w.Bytes(), "test.dc"); err != nil {
+			t.Errorf("%d blocks: saved image: %v", blocks, err)
+		}
+
+		bad := append([]byte(nil), image...)
+		bad[PREAMBLE_DC42_SIZE+1000] ^= 0xff
+		if _, err := NewDSKWrapperBin(nil, bad, "test.dc"); err == nil {
+			t.Errorf("%d blocks: bad data checksum was accepted", blocks)
+		}
+
+		bad = append([]byte(nil), image...)
+		bad[len(bad)-1] ^= 0xff
+		if _, err := NewDSKWrapperBin(nil, bad, "test.dc"); err == nil {
+			t.Errorf("%d blocks: bad tag checksum was accepted", blocks)
+		}
+
+	}
+
+}
+
+func TestDC42Format(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+		want DiskFormatID
+	}{
+		{"ProDOS 800K", testProDOSDisk(t, PRODOS_800KB_BLOCKS, SectorOrderProDOSLinear, testData(40, 100)).Data, DF_PRODOS_800KB},
+		{"ProDOS 400K", testProDOSDisk(t, PRODOS_400KB_BLOCKS, SectorOrderProDOSLinear, testData(43, 100)).Data, DF_PRODOS_400KB},
+		{"unknown 400K", make([]byte, PRODOS_400KB_DISK_BYTES), DF_NONE},
+	}
+
+	for _, tt := range tests {
+
+		h := &HeaderDC42{Tags: make([]byte, len(tt.data)/512*12)}
+		h.SetName(tt.name)
+		h.Data[0x52], h.Data[0x53] = PRIVATE_DC42>>8, PRIVATE_DC42&0xff
+		w, err := NewDSKWrapperBin(nil, h.Bytes(tt.data), "test.dc")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if w.Format.ID != tt.want {
+			t.Errorf("%s: loaded as %s", tt.name, w.Format)
+		}
+		// unknown filesystems aren't guessed at, but the blocks are in order
+		if tt.want == DF_NONE && w.Layout != SectorOrderProDOSLinear {
+			t.Errorf("%s: loaded in %s order", tt.name, w.Layout)
+		}
+
+	}
+
+}
@@ -0,0 +1,669 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+	Image detection...
+
+	Rather than going by the file extension we look at what the file holds:
+	container headers, boot sector signatures and how plausible the DOS VTOC,
+	ProDOS volume directory and Pascal volume look in each sector order.
+	Every probe gives a candidate with a confidence from 0 to 1 and the most
+	likely comes first. The extension only breaks ties.
+*/
+
+// DETECT_MAX_BYTES is the largest file worth probing, a full size ProDOS
+// volume with room for a header.
+const DETECT_MAX_BYTES = (PRODOS_MAX_BLOCKS+1)*PRODOS_BLOCK_BYTES + 0x10000
+
+const (
+	ContainerWOZ      = "WOZ"
+	ContainerNIB      = "NIB"
+	Container2MG      = "2MG"
+	ContainerDC42     = "DiskCopy 4.2"
+	ContainerNuFX     = "NuFX"
+	ContainerBinaryII = "Binary II"
+)
+
+// sector orders tried for 140K and 113K images, in order of preference.
+// Linear only differs from DOS order when reading blocks.
+var detectLayouts = []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear}
+var detectDOSLayouts = []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS}
+
+// 16 sector DOS can also be stored in ProDOS order
+var detectDOS16Layouts = append(detectDOSLayouts, SectorOrderDOS33ProDOS)
+
+type DetectCandidate struct {
+	Format     DiskFormat
+	Layout     SectorOrder
+	Container  string // header or encoding around the sectors, if any
+	Archive    bool   // holds files rather than a disk
+	Confidence float64
+	Reasons    []string
+}
+
+func (c *DetectCandidate) add(score float64, reason string) {
+	c.Confidence += score
+	if c.Confidence > 1 {
+		c.Confidence = 1
+	}
+	c.Reasons = append(c.Reasons, reason)
+}
+
+func (c *DetectCandidate) Describe() string {
+	switch {
+	case c.Archive:
+		return c.Container + " archive"
+	case c.Container != "" && c.Format.ID == DF_NONE:
+		return c.Container + " image"
+	case c.Container != "":
+		return c.Format.String() + " in " + c.Container
+	}
+	return c.Format.String() + ", " + c.Layout.String() + " order"
+}
+
+func (c *DetectCandidate) String() string {
+	return fmt.Sprintf("%s (%.2f): %s", c.Describe(), c.Confidence, strings.Join(c.Reasons, ", "))
+}
+
+// DetectCandidates is ranked most likely first
+type DetectCandidates []*DetectCandidate
+
+func (dc DetectCandidates) Len() int {
+	return len(dc)
+}
+
+func (dc DetectCandidates) Swap(i, j int) {
+	dc[i], dc[j] = dc[j], dc[i]
+}
+
+func (dc DetectCandidates) Less(i, j int) bool {
+	return dc[i].Confidence > dc[j].Confidence
+}
+
+// Best returns the most likely candidate, or nil if nothing matched
+func (dc DetectCandidates) Best() *DetectCandidate {
+	if len(dc) == 0 {
+		return nil
+	}
+	return dc[0]
+}
+
+// BestOf returns the most likely candidate of the given formats
+func (dc DetectCandidates) BestOf(ids ...DiskFormatID) *DetectCandidate {
+	for _, c := range dc {
+		for _, id := range ids {
+			if c.Format.ID == id {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Detect probes the contents of a file to work out what it holds. The
+// filename is only used as a hint.
+func Detect(data []byte, filename string) DetectCandidates {
+
+	dc := detectContainers(data)
+
+	if len(dc) == 0 {
+		dc = detectSectors(data)
+		detectBootSector(data, &dc)
+	}
+
+	detectExtension(dc, filename)
+
+	sort.Stable(dc)
+
+	return dc
+
+}
+
+func detectContainers(data []byte) DetectCandidates {
+
+	var dc DetectCandidates
+
+	if isWOZ, version := IsWOZ(data); isWOZ {
+		c := &DetectCandidate{Container: ContainerWOZ, Format: GetDiskFormat(DF_NONE)}
+		c.add(1, fmt.Sprintf("WOZ%d header", version))
+		dc = append(dc, c)
+	}
+
+	if isNuFX, _ := IsNuFX(data); isNuFX {
+		c := &DetectCandidate{Container: ContainerNuFX, Format: GetDiskFormat(DF_NONE), Archive: true}
+		c.add(1, "NuFX master header")
+		dc = append(dc, c)
+	} else if IsBinaryII(data) {
+		c := &DetectCandidate{Container: ContainerBinaryII, Format: GetDiskFormat(DF_NONE), Archive: true}
+		c.add(0.95, "Binary II header")
+		dc = append(dc, c)
+	}
+
+	if IsDC42(data) {
+		h := &HeaderDC42{}
+		h.SetData(data)
+		c := &DetectCandidate{Container: ContainerDC42, Format: GetDiskFormat(DF_PRODOS_800KB), Layout: SectorOrderProDOSLinear}
+		if h.GetDataSize() == PRODOS_400KB_DISK_BYTES {
+			c.Format = GetDiskFormat(DF_PRODOS_400KB)
+		}
+		c.add(0.95, "DiskCopy 4.2 header")
+		dc = append(dc, c)
+	}
+
+	if len(data) > PREAMBLE_2MG_SIZE && matchAt(data, 0, MAGIC_2MG) {
+		dc = append(dc, detect2MG(data))
+	}
+
+	if len(data) == DISK_NIBBLE_LENGTH {
+		dc = append(dc, detectNibbles(data))
+	}
+
+	return dc
+
+}
+
+func detect2MG(data []byte) *DetectCandidate {
+
+	h := &Header2MG{}
+	h.SetData(data[:PREAMBLE_2MG_SIZE])
+
+	c := &DetectCandidate{Container: Container2MG, Format: GetDiskFormat(DF_NONE)}
+	c.add(0.8, "2MG header")
+
+	size := h.GetDiskDataLength()
+	if size == 0 {
+		size = h.GetProDOSBlocks() * PRODOS_BLOCK_BYTES
+	}
+
+	switch h.GetImageFormat() {
+	case FORMAT_2MG_DOS:
+		c.Format = GetDiskFormat(DF_DOS_SECTORS_16)
+		c.Layout = SectorOrderDOS33
+	case FORMAT_2MG_PRODOS:
+		c.Layout = SectorOrderProDOSLinear
+		switch size {
+		case STD_DISK_BYTES:
+			c.Format = GetDiskFormat(DF_PRODOS)
+		case PRODOS_400KB_DISK_BYTES:
+			c.Format = GetDiskFormat(DF_PRODOS_400KB)
+		case PRODOS_800KB_DISK_BYTES:
+			c.Format = GetDiskFormat(DF_PRODOS_800KB)
+		default:
+			c.Format = GetPDDiskFormat(DF_PRODOS_CUSTOM, size/PRODOS_BLOCK_BYTES)
+		}
+	}
+
+	if size > 0 && h.GetDiskDataStart()+size <= len(data) {
+		c.add(0.2, "data fits the file")
+	}
+
+	return c
+
+}
+
+// detectNibbles counts the address field prologues in a nibble image
+func detectNibbles(data []byte) *DetectCandidate {
+
+	c := &DetectCandidate{Container: ContainerNIB, Format: GetDiskFormat(DF_NONE)}
+	c.add(0.5, "nibble image size")
+
+	var count16, count13 int
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0xd5 || data[i+1] != 0xaa {
+			continue
+		}
+		switch data[i+2] {
+		case 0x96:
+			count16++
+		case 0xb5:
+			count13++
+		}
+	}
+
+	expected := STD_TRACKS_PER_DISK * STD_SECTORS_PER_TRACK
+	count := count16
+	c.Format = GetDiskFormat(DF_DOS_SECTORS_16)
+	if count13 > count16 {
+		expected = STD_TRACKS_PER_DISK * STD_SECTORS_PER_TRACK_OLD
+		count = count13
+		c.Format = GetDiskFormat(DF_DOS_SECTORS_13)
+	}
+
+	if count > 0 {
+		c.add(0.45*detectRatio(count, expected), fmt.Sprintf("%d address fields", count))
+	}
+
+	return c
+
+}
+
+// detectSectors probes a plain run of sectors or blocks
+func detectSectors(data []byte) DetectCandidates {
+
+	var dc DetectCandidates
+
+	raw := func(score float64, reason string) {
+		c := &DetectCandidate{Format: GetDiskFormat(DF_NONE), Layout: SectorOrderDOS33}
+		c.add(score, reason)
+		dc = append(dc, c)
+	}
+
+	probe := func(id DiskFormatID, l SectorOrder, score float64, reasons []string) {
+		if score <= 0 {
+			return
+		}
+		c := &DetectCandidate{Format: GetDiskFormat(id), Layout: l, Confidence: score, Reasons: reasons}
+		if c.Confidence > 1 {
+			c.Confidence = 1
+		}
+		dc = append(dc, c)
+	}
+
+	switch len(data) {
+	case STD_DISK_BYTES:
+
+		raw(0.3, "140K image size")
+
+		for _, l := range detectDOS16Layouts {
+			dsk := detectWrapper(data, DF_DOS_SECTORS_16, l)
+			score, reasons := probeAppleDOS(dsk, STD_SECTORS_PER_TRACK)
+			probe(DF_DOS_SECTORS_16, l, score, reasons)
+		}
+
+		for _, l := range detectLayouts {
+			dsk := detectWrapper(data, DF_PRODOS, l)
+			score, reasons := probeProDOS(dsk.PRODOSGetBlock, PRODOS_BLOCKS_PER_DISK)
+			probe(DF_PRODOS, l, score, reasons)
+		}
+
+		for _, l := range detectLayouts {
+			dsk := detectWrapper(data, DF_PRODOS, l)
+			score, reasons := probePascal(dsk.PRODOSGetBlock, PRODOS_BLOCKS_PER_DISK)
+			probe(DF_PASCAL, l, score, reasons)
+		}
+
+		dc = append(dc, detectRDOS(data)...)
+
+	case STD_DISK_BYTES_OLD:
+
+		raw(0.3, "113K image size")
+
+		for _, l := range detectDOSLayouts {
+			dsk := detectWrapper(data, DF_DOS_SECTORS_13, l)
+			score, reasons := probeAppleDOS(dsk, STD_SECTORS_PER_TRACK_OLD)
+			probe(DF_DOS_SECTORS_13, l, score, reasons)
+		}
+
+		dc = append(dc, detectRDOS(data)...)
+
+	case PRODOS_400KB_DISK_BYTES, PRODOS_800KB_DISK_BYTES:
+
+		id, name := DF_PRODOS_800KB, "800K"
+		if len(data) == PRODOS_400KB_DISK_BYTES {
+			id, name = DF_PRODOS_400KB, "400K"
+		}
+		blocks := len(data) / PRODOS_BLOCK_BYTES
+
+		raw(0.3, name+" image size")
+
+		score, reasons := probeProDOS(linearBlocks(data), blocks)
+		probe(id, SectorOrderProDOSLinear, score, reasons)
+
+		score, reasons = probePascal(linearBlocks(data), blocks)
+		probe(DF_PASCAL, SectorOrderProDOSLinear, score, reasons)
+
+	default:
+
+		if isHDV, blocks := IsProDOSHDV(data); isHDV {
+			score, reasons := probeProDOS(linearBlocks(data), len(data)/PRODOS_BLOCK_BYTES)
+			c := &DetectCandidate{Format: GetPDDiskFormat(DF_PRODOS_CUSTOM, blocks), Layout: SectorOrderProDOSLinear, Confidence: score, Reasons: reasons}
+			dc = append(dc, c)
+		}
+
+	}
+
+	return dc
+
+}
+
+// detectRatio is n out of the expected count, capped at 1
+func detectRatio(n, expected int) float64 {
+	if n >= expected {
+		return 1
+	}
+	return float64(n) / float64(expected)
+}
+
+func detectWrapper(data []byte, id DiskFormatID, l SectorOrder) *DSKWrapper {
+	return &DSKWrapper{Data: data, Format: GetDiskFormat(id), Layout: l}
+}
+
+// linearBlocks reads blocks from an image that is stored in block order
+func linearBlocks(data []byte) func(int) ([]byte, error) {
+	return func(b int) ([]byte, error) {
+		if b < 0 || (b+1)*PRODOS_BLOCK_BYTES > len(data) {
+			return nil, errors.New("Invalid block")
+		}
+		return data[b*PRODOS_BLOCK_BYTES : (b+1)*PRODOS_BLOCK_BYTES], nil
+	}
+}
+
+// probeAppleDOS scores the VTOC and catalog chain. The VTOC sits in sector
+// 0 which is the same in every order, so it is the catalog sectors running
+// down the track that tell the orders apart.
+func probeAppleDOS(dsk *DSKWrapper, sectors int) (float64, []string) {
+
+	var score float64
+	var reasons []string
+
+	vtoc, err := dsk.AppleDOSGetVTOC()
+	if err != nil || vtoc.GetTracks() != STD_TRACKS_PER_DISK {
+		return 0, nil
+	}
+	score += 0.2
+	reasons = append(reasons, "VTOC track count")
+
+	if vtoc.GetSectors() != sectors {
+		return score, reasons
+	}
+	score += 0.15
+	reasons = append(reasons, "VTOC sector count")
+
+	if vtoc.BytesPerSector() == STD_BYTES_PER_SECTOR && vtoc.GetMaxTSPairsPerSector() == 122 {
+		score += 0.05
+		reasons = append(reasons, "VTOC sector size")
+	}
+
+	ct, cs := vtoc.GetCatalogStart()
+
+	var links, ordered, files, bad int
+	seen := make(map[int]bool)
+
+	for ct != 0 {
+
+		if ct >= STD_TRACKS_PER_DISK || cs >= sectors || seen[ct*sectors+cs] {
+			bad++
+			break
+		}
+		seen[ct*sectors+cs] = true
+
+		if dsk.Seek(ct, cs) != nil {
+			bad++
+			break
+		}
+		data := dsk.Read()
+
+		for slot := 0; slot < 7; slot++ {
+			entry := data[0x0b+35*slot:]
+			switch {
+			case entry[0] == 0x00 || entry[0] == 0xff:
+			case int(entry[0]) < STD_TRACKS_PER_DISK && int(entry[1]) < sectors:
+				files++
+			default:
+				bad++
+			}
+		}
+
+		nt, ns := int(data[1]), int(data[2])
+		if nt == ct && ns == cs-1 {
+			ordered++
+		}
+		links++
+		ct, cs = nt, ns
+
+	}
+
+	// INIT leaves the whole track as catalog, each sector pointing at the
+	// one below it
+	if links > 0 {
+		score += 0.05 + 0.05*detectRatio(links, sectors-1)
+		reasons = append(reasons, fmt.Sprintf("%d catalog sectors", links))
+	}
+
+	if ordered > 0 {
+		score += 0.15 * detectRatio(ordered, sectors-2)
+		reasons = append(reasons, fmt.Sprintf("%d in sequence", ordered))
+	}
+
+	if bad == 0 && links > 0 {
+		score += 0.1
+		reasons = append(reasons, "catalog chain is sound")
+	}
+
+	if files > 0 && files > bad {
+		score += 0.1
+		reasons = append(reasons, fmt.Sprintf("%d catalog entries", files))
+	}
+
+	return score, reasons
+
+}
+
+// probeProDOS scores the volume directory header and the chain of
+// directory blocks following it.
+func probeProDOS(read func(int) ([]byte, error), blocks int) (float64, []string) {
+
+	var score float64
+	var reasons []string
+
+	data, err := read(2)
+	if err != nil {
+		return 0, nil
+	}
+
+	vdh := &VDH{}
+	vdh.SetData(data[4:43], 2, 4)
+
+	if vdh.GetStorageType() != 0xf {
+		return 0, nil
+	}
+	score += 0.25
+	reasons = append(reasons, "volume directory header")
+
+	if isProDOSName(data[5 : 5+vdh.GetNameLength()]) {
+		score += 0.1
+		reasons = append(reasons, "volume name")
+	}
+
+	if data[0x23] == PRODOS_ENTRY_SIZE && data[0x24] == 0x0d {
+		score += 0.15
+		reasons = append(reasons, "entry size")
+	}
+
+	total := vdh.GetTotalBlocks()
+	switch {
+	case total == blocks:
+		score += 0.15
+		reasons = append(reasons, "block count matches")
+	case total > 0 && total < blocks:
+		score += 0.05
+		reasons = append(reasons, "block count fits")
+	}
+
+	if vdh.GetBitmapPointer() >= 3 && vdh.GetBitmapPointer() < blocks {
+		score += 0.05
+		reasons = append(reasons, "bitmap pointer")
+	}
+
+	if data[0] != 0 || data[1] != 0 {
+		return score, reasons
+	}
+
+	// follow the directory blocks, each should point back at the last
+	var links, good int
+	prev, next := 2, int(data[2])+256*int(data[3])
+	for next != 0 && links < 64 {
+		links++
+		if next >= blocks {
+			break
+		}
+		block, err := read(next)
+		if err != nil {
+			break
+		}
+		if int(block[0])+256*int(block[1]) != prev {
+			break
+		}
+		good++
+		prev, next = next, int(block[2])+256*int(block[3])
+	}
+
+	if links > 0 {
+		score += 0.2 * float64(good) / float64(links)
+		if good > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d directory blocks linked", good+1))
+		}
+	}
+
+	return score, reasons
+
+}
+
+func isProDOSName(name []byte) bool {
+	if len(name) == 0 || name[0] < 'A' || name[0] > 'Z' {
+		return false
+	}
+	for _, ch := range name {
+		if !(ch >= 'A' && ch <= 'Z') && !(ch >= '0' && ch <= '9') && ch != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// probePascal scores the Pascal volume header in block 2
+func probePascal(read func(int) ([]byte, error), blocks int) (float64, []string) {
+
+	var score float64
+	var reasons []string
+
+	data, err := read(PASCAL_VOLUME_BLOCK)
+	if err != nil {
+		return 0, nil
+	}
+
+	if data[0x00] != 0 || data[0x01] != 0 || data[0x04] != 0 || data[0x05] != 0 {
+		return 0, nil
+	}
+	score += 0.15
+	reasons = append(reasons, "volume header")
+
+	l := int(data[0x06])
+	if l == 0 || l > PASCAL_MAX_VOLUME_NAME {
+		return 0, nil
+	}
+	for _, ch := range data[0x07 : 0x07+l] {
+		if ch <= 0x20 || ch >= 0x7f || strings.Contains("$=?,[#:", string(ch)) {
+			return 0, nil
+		}
+	}
+	score += 0.2
+	reasons = append(reasons, "volume name")
+
+	last := int(data[0x02]) + 256*int(data[0x03])
+	if last > PASCAL_VOLUME_BLOCK && last <= PASCAL_VOLUME_BLOCK+PASCAL_OVERSIZE_DIR {
+		score += 0.1
+		reasons = append(reasons, "directory size")
+	}
+
+	if int(data[0x0e])+256*int(data[0x0f]) == blocks {
+		score += 0.25
+		reasons = append(reasons, "block count matches")
+	}
+
+	if int(data[0x10])+256*int(data[0x11]) <= 77 {
+		score += 0.05
+		reasons = append(reasons, "file count")
+	}
+
+	return score, reasons
+
+}
+
+func detectRDOS(data []byte) DetectCandidates {
+
+	dsk := &DSKWrapper{Data: data}
+
+	isRDOS, version := dsk.IsRDOS()
+	if !isRDOS {
+		return nil
+	}
+
+	c := &DetectCandidate{}
+	switch version {
+	case RDOS_3:
+		c.Format, c.Layout = GetDiskFormat(DF_RDOS_3), SectorOrderDOS33Alt
+	case RDOS_32:
+		c.Format, c.Layout = GetDiskFormat(DF_RDOS_32), SectorOrderDOS33Alt
+	case RDOS_33:
+		c.Format, c.Layout = GetDiskFormat(DF_RDOS_33), SectorOrderProDOS
+	}
+	c.add(0.9, "RDOS signature")
+
+	return DetectCandidates{c}
+
+}
+
+// detectBootSector adds weight to candidates with a known boot sector.
+// Sector 0 doesn't move between sector orders so this is the same for all.
+func detectBootSector(data []byte, dc *DetectCandidates) {
+
+	if len(data) < 32 {
+		return
+	}
+
+	bf, ok := bootFormat(data)
+	if !ok {
+		return
+	}
+
+	found := false
+	for _, c := range *dc {
+		if c.Format.ID == bf.ID {
+			c.add(0.1, "boot sector")
+			found = true
+		}
+	}
+
+	if !found && len(*dc) > 0 {
+		c := &DetectCandidate{Format: bf, Layout: SectorOrderDOS33}
+		c.add(0.4, "boot sector")
+		*dc = append(*dc, c)
+	}
+
+}
+
+// detectExtension nudges the sector order towards the one the extension
+// suggests, a .po holds DOS sectors out of order.
+func detectExtension(dc DetectCandidates, filename string) {
+
+	ext := strings.ToLower(path.Ext(filename))
+
+	for _, c := range dc {
+		if c.Container != "" || c.Format.ID == DF_NONE {
+			continue
+		}
+		switch ext {
+		case ".po":
+			if c.Layout != SectorOrderDOS33 {
+				c.add(0.05, "file extension")
+			}
+		case ".do", ".dsk":
+			if c.Layout == SectorOrderDOS33 {
+				c.add(0.05, "file extension")
+			}
+		case ".d13":
+			if c.Format.USPT() == STD_SECTORS_PER_TRACK_OLD {
+				c.add(0.05, "file extension")
+			}
+		}
+	}
+
+}
@@ -0,0 +1,108 @@
+package disk
+
+import (
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+
+	blank, err := NewProDOSVolume("TEST", PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header2MG := NewHeader2MG(FORMAT_2MG_PRODOS, PRODOS_BLOCKS_PER_DISK).Bytes(blank.Data)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		format    DiskFormatID
+		container string
+		sniffed   bool // confident enough without a known extension
+	}{
+		{"dos33.dsk", testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(1, 1000)).Data, DF_DOS_SECTORS_16, "", true},
+		{"dos32.d13", testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_13), SectorOrderDOS32, testData(1, 1000)).Data, DF_DOS_SECTORS_13, "", true},
+		{"prodos.po", testProDOSDisk(t, PRODOS_800KB_BLOCKS, SectorOrderProDOSLinear, testData(1, 1000)).Data, DF_PRODOS_800KB, "", true},
+		{"prodos.2mg", header2MG, DF_PRODOS, Container2MG, true},
+		{"noise.dsk", testData(1, STD_DISK_BYTES), DF_NONE, "", false},
+	}
+
+	for _, tt := range tests {
+
+		best := Detect(tt.data, tt.name).Best()
+		if best == nil {
+			t.Fatalf("%s: nothing detected", tt.name)
+		}
+		if best.Format.ID != tt.format || best.Container != tt.container {
+			t.Errorf("%s: detected %s", tt.name, best)
+		}
+		if sniffed := best.Confidence >= 0.5; sniffed != tt.sniffed {
+			t.Errorf("%s: confidence %.2f", tt.name, best.Confidence)
+		}
+
+	}
+
+	if dc := Detect(testData(1, 1000), "junk"); len(dc) != 0 {
+		t.Errorf("junk detected as %s", dc.Best())
+	}
+
+}
+
+func TestDetectDrivesLayout(t *testing.T) {
+
+	file := testData(70, 6000)
+
+	for _, layout := range []SectorOrder{SectorOrderDOS33, SectorOrderDOS33ProDOS} {
+
+		dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), layout, file)
+
+		// the name shouldn't matter, the catalog gives the order away
+		for _, name := range []string{"x.dsk", "x.do", "x.po", "x"} {
+
+			data := append([]byte(nil), dsk.Data...)
+
+			dc := Detect(data, name)
+			if best := dc.Best(); best == nil || best.Format.ID != DF_DOS_SECTORS_16 || best.Layout != layout {
+				t.Fatalf("%s in %s order: detected as %v", name, layout, best)
+			}
+
+			for _, w := range []func() (*DSKWrapper, error){
+				func() (*DSKWrapper, error) { return NewDSKWrapperBin(nil, data, name) },
+				func() (*DSKWrapper, error) { return NewDSKWrapperDetected(nil, data, name, dc) },
+			} {
+				w, err := w()
+				if err != nil {
+					t.Fatalf("%s in %s order: %v", name, layout, err)
+				}
+				if w.Format.ID != DF_DOS_SECTORS_16 || w.Layout != layout {
+					t.Fatalf("%s in %s order: loaded as %s, %s order", name, layout, w.Format, w.Layout)
+				}
+				if _, files, err := w.AppleDOSGetCatalog("*"); err != nil || len(files) != 1 {
+					t.Fatalf("%s in %s order: catalog has %d files, %v", name, layout, len(files), err)
+				}
+			}
+
+		}
+
+	}
+
+}
+
+func TestDetectIgnoresContainer(t *testing.T) {
+
+	file := testData(71, 2000)
+	dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, file)
+	nib := dsk.Nibblize()
+
+	// candidates for the nibbles say nothing about the decoded sectors
+	w, err := NewDSKWrapperDetected(nil, nib, "test.nib", Detect(nib, "test.nib"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.NIB || w.Format.ID != DF_PRODOS {
+		t.Fatalf("loaded as %s", w.Format)
+	}
+	if _, err := w.PRODOSGetNamedEntry("", "HELLO"); err != nil {
+		t.Fatal(err)
+	}
+
+}
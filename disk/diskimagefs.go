@@ -0,0 +1,330 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	io/fs view of a disk...
+
+	The files on any volume with a driver can be walked with the standard
+	library, eg.
+
+		vfs, err := dsk.FS()
+		fs.WalkDir(vfs, ".", func(p string, d fs.DirEntry, err error) error { ... })
+		data, err := fs.ReadFile(vfs, "SYSTEM/BASIC.SYSTEM")
+
+	Names are matched without regard to case. The view is read only and
+	reflects the disk at the time of each call.
+*/
+
+var errNotDir = errors.New("Not a directory")
+var errNotFile = errors.New("Is a directory")
+
+// VolumeFS implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS
+// over the filesystem on a disk.
+type VolumeFS struct {
+	dsk *DSKWrapper
+	drv Driver
+}
+
+// FS returns an io/fs view of the files on the disk
+func (dsk *DSKWrapper) FS() (*VolumeFS, error) {
+	drv, err := dsk.Driver()
+	if err != nil {
+		return nil, err
+	}
+	return &VolumeFS{dsk: dsk, drv: drv}, nil
+}
+
+func (v *VolumeFS) Open(name string) (fs.File, error) {
+
+	e, err := v.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info := v.newInfo(e)
+
+	if info.IsDir() {
+		entries, err := v.readDir("open", name)
+		if err != nil {
+			return nil, err
+		}
+		return &volumeDir{info: info, entries: entries}, nil
+	}
+
+	data, err := info.data()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &volumeFile{info: info, r: bytes.NewReader(data)}, nil
+
+}
+
+func (v *VolumeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return v.readDir("readdir", name)
+}
+
+func (v *VolumeFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := v.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return v.newInfo(e), nil
+}
+
+func (v *VolumeFS) ReadFile(name string) ([]byte, error) {
+
+	e, err := v.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil || e.Dir {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errNotFile}
+	}
+
+	data, err := v.drv.ReadFile(v.dsk, e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return data, nil
+
+}
+
+// lookup finds the catalog entry for a name, the root has none
+func (v *VolumeFS) lookup(op string, name string) (*CatalogEntry, error) {
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, nil
+	}
+
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+
+	// make sure the whole path exists, the flat filesystems pay no
+	// attention to it
+	dirpath := ""
+	if dir != "" {
+		d, err := v.lookup(op, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !d.Dir {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		dirpath = d.FullName()
+	}
+
+	entries, err := v.drv.Catalog(v.dsk, dirpath, "*")
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	var found *CatalogEntry
+	for _, e := range entries {
+		if e.Name == base {
+			return e, nil
+		}
+		if found == nil && strings.EqualFold(e.Name, base) {
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return found, nil
+
+}
+
+func (v *VolumeFS) readDir(op string, name string) ([]fs.DirEntry, error) {
+
+	e, err := v.lookup(op, name)
+	if err != nil {
+		return nil, err
+	}
+
+	dirpath := ""
+	if e != nil {
+		if !e.Dir {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errNotDir}
+		}
+		dirpath = e.FullName()
+	}
+
+	entries, err := v.drv.Catalog(v.dsk, dirpath, "*")
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	out := make(volumeDirEntries, 0, len(entries))
+	for _, e := range entries {
+		if !fs.ValidPath(e.Name) || strings.Contains(e.Name, "/") {
+			continue // can't be addressed, so leave it out
+		}
+		out = append(out, v.newInfo(e))
+	}
+	sort.Sort(out)
+
+	return out, nil
+
+}
+
+func (v *VolumeFS) newInfo(e *CatalogEntry) *volumeInfo {
+	return &volumeInfo{fs: v, entry: e}
+}
+
+// volumeInfo is both the fs.FileInfo and fs.DirEntry for a file, the
+// size is only found by reading the file so it is put off until asked.
+type volumeInfo struct {
+	fs      *VolumeFS
+	entry   *CatalogEntry
+	content []byte
+	read    bool
+	err     error
+}
+
+func (i *volumeInfo) data() ([]byte, error) {
+	if !i.read {
+		i.content, i.err = i.fs.drv.ReadFile(i.fs.dsk, i.entry)
+		i.read = true
+	}
+	return i.content, i.err
+}
+
+func (i *volumeInfo) Name() string {
+	if i.entry == nil {
+		return "."
+	}
+	return i.entry.Name
+}
+
+func (i *volumeInfo) Size() int64 {
+	if i.IsDir() {
+		return 0
+	}
+	data, _ := i.data()
+	return int64(len(data))
+}
+
+func (i *volumeInfo) Mode() fs.FileMode {
+	if i.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	if i.entry.Locked {
+		return 0444
+	}
+	return 0644
+}
+
+func (i *volumeInfo) ModTime() time.Time {
+	if i.entry == nil {
+		return time.Time{}
+	}
+	return i.entry.Modified
+}
+
+func (i *volumeInfo) IsDir() bool {
+	return i.entry == nil || i.entry.Dir
+}
+
+// Sys gives the *CatalogEntry for the file, or nil for the root. Its
+// Descriptor() is the filesystem's own entry.
+func (i *volumeInfo) Sys() interface{} {
+	return i.entry
+}
+
+func (i *volumeInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i *volumeInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
+
+type volumeDirEntries []fs.DirEntry
+
+func (d volumeDirEntries) Len() int {
+	return len(d)
+}
+
+func (d volumeDirEntries) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+}
+
+func (d volumeDirEntries) Less(i, j int) bool {
+	return d[i].Name() < d[j].Name()
+}
+
+type volumeFile struct {
+	info *volumeInfo
+	r    *bytes.Reader
+}
+
+func (f *volumeFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *volumeFile) Read(b []byte) (int, error) {
+	return f.r.Read(b)
+}
+
+func (f *volumeFile) ReadAt(b []byte, off int64) (int, error) {
+	return f.r.ReadAt(b, off)
+}
+
+func (f *volumeFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *volumeFile) Close() error {
+	return nil
+}
+
+type volumeDir struct {
+	info    *volumeInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *volumeDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *volumeDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errNotFile}
+}
+
+func (d *volumeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+
+	left := d.entries[d.offset:]
+	if n > 0 {
+		if len(left) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(left) {
+			left = left[:n]
+		}
+	}
+	d.offset += len(left)
+
+	return left, nil
+
+}
+
+func (d *volumeDir) Close() error {
+	return nil
+}
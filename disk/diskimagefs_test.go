@@ -0,0 +1,104 @@
+package disk
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestVolumeFS(t *testing.T) {
+
+	file := testData(80, 1000)
+
+	tests := []struct {
+		name string
+		dsk  *DSKWrapper
+		path string
+	}{
+		{"DOS 3.3", testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, file), "hello"},
+		{"ProDOS", testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, file), "hello"},
+	}
+
+	for _, tt := range tests {
+
+		testReadBack(t, tt.dsk, tt.path, file)
+
+		vfs, err := tt.dsk.FS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(vfs, tt.path); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+
+		if _, err := vfs.Open("MISSING"); err == nil {
+			t.Errorf("%s: opened a missing file", tt.name)
+		}
+
+	}
+
+}
+
+func TestProDOSCatalogAfterDelete(t *testing.T) {
+
+	dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, testData(90, 100))
+
+	// enough files to run into the second directory block
+	names := []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"}
+	for i, name := range names {
+		if err := dsk.PRODOSWriteFile("", name, FileType_PD_BIN, testData(int64(91+i), 300), 0x2000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"HELLO", "A", "E"} {
+		if err := dsk.PRODOSDeleteFile("", name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = name != "A" && name != "E"
+	}
+
+	_, files, err := dsk.PRODOSGetCatalog(2, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 8 {
+		t.Errorf("catalog lists %d files, want 8", len(files))
+	}
+	for _, fd := range files {
+		if name := strings.ToUpper(fd.NameUnadorned()); !want[name] {
+			t.Errorf("catalog lists %s", name)
+		}
+	}
+
+	vfs, err := dsk.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	err = fs.WalkDir(vfs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			found[strings.ToUpper(path)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, live := range want {
+		if found[name] != live {
+			t.Errorf("%s: walked %v, want %v", name, found[name], live)
+		}
+	}
+
+	testReadBack(t, dsk, "j", testData(100, 300))
+
+}
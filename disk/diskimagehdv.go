@@ -0,0 +1,59 @@
+package disk
+
+/*
+	ProDOS hard disk images (.HDV / .PO)...
+
+	A plain run of 512 byte blocks in ProDOS order, anything up to 65535
+	blocks (32MB). There is no header, so we go by the volume directory
+	header in block 2.
+*/
+
+const PRODOS_BLOCK_BYTES = STD_BYTES_PER_SECTOR * PRODOS_SECTORS_PER_BLOCK
+const PRODOS_MAX_BLOCKS = 65535
+
+// PRODOS_MAX_INDEX_BLOCKS is the number of index blocks a tree file's master
+// index can point at, which caps files at 16MB
+const PRODOS_MAX_INDEX_BLOCKS = 128
+const PRODOS_MAX_FILE_BYTES = 0xffffff
+
+// PRODOS_BITMAP_BLOCK_BLOCKS is the number of blocks one bitmap block covers
+const PRODOS_BITMAP_BLOCK_BLOCKS = PRODOS_BLOCK_BYTES * 8
+
+// IsProDOSHDV checks for a ProDOS volume in block order, returning the
+// number of blocks it holds.
+func IsProDOSHDV(data []byte) (bool, int) {
+
+	if len(data)%PRODOS_BLOCK_BYTES != 0 || len(data) < 3*PRODOS_BLOCK_BYTES {
+		return false, 0
+	}
+
+	// some tools make 32MB images, one block more than ProDOS can use
+	if len(data) > (PRODOS_MAX_BLOCKS+1)*PRODOS_BLOCK_BYTES {
+		return false, 0
+	}
+
+	vdh := &VDH{}
+	vdh.SetData(data[2*PRODOS_BLOCK_BYTES+4:2*PRODOS_BLOCK_BYTES+43], 2, 4)
+
+	blocks := vdh.GetTotalBlocks()
+
+	if vdh.GetStorageType() != 0xf || vdh.GetNameLength() == 0 {
+		return false, 0
+	}
+
+	if blocks < 3 || blocks*PRODOS_BLOCK_BYTES > len(data) {
+		return false, 0
+	}
+
+	if vdh.GetBitmapPointer() < 3 || vdh.GetBitmapPointer() >= blocks {
+		return false, 0
+	}
+
+	return true, blocks
+
+}
+
+// PRODOSBitmapBlocks is the number of blocks used by the volume bitmap
+func PRODOSBitmapBlocks(totalBlocks int) int {
+	return (totalBlocks + PRODOS_BITMAP_BLOCK_BLOCKS - 1) / PRODOS_BITMAP_BLOCK_BLOCKS
+}
@@ -0,0 +1,40 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProDOSBlockImageReload(t *testing.T) {
+
+	tests := []struct {
+		blocks int
+		want   DiskFormatID
+	}{
+		{PRODOS_400KB_BLOCKS, DF_PRODOS_400KB},
+		{PRODOS_800KB_BLOCKS, DF_PRODOS_800KB},
+		{4000, DF_PRODOS_CUSTOM},
+		{PRODOS_MAX_BLOCKS, DF_PRODOS_CUSTOM},
+	}
+
+	for i, tt := range tests {
+
+		file := testData(int64(70+i), 20000)
+		dsk := testProDOSDisk(t, tt.blocks, SectorOrderProDOSLinear, file)
+
+		for _, name := range []string{"test.po", "test.hdv", "test"} {
+			w, err := NewDSKWrapperBin(nil, dsk.Bytes(), name)
+			if err != nil {
+				t.Fatalf("%d blocks as %s: %v", tt.blocks, name, err)
+			}
+			if w.Format.ID != tt.want || w.Format.BPD() != tt.blocks {
+				t.Fatalf("%d blocks as %s: loaded as %s", tt.blocks, name, w.Format)
+			}
+			if !bytes.Equal(w.Data, dsk.Data) {
+				t.Fatalf("%d blocks as %s: blocks don't match the volume", tt.blocks, name)
+			}
+		}
+
+	}
+
+}
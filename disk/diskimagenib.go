@@ -0,0 +1,478 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+/*
+	Nibble level decoding...
+
+	Takes a stream of disk bytes (as read by the disk controller) and
+	recovers the logical sectors from it. Both the 16 sector 6&2 scheme
+	(DOS 3.3, ProDOS) and the 13 sector 5&3 scheme (DOS 3.1/3.2) are
+	handled.
+*/
+
+const NIBBLE_62_DATA_LENGTH = 342
+const NIBBLE_53_DATA_LENGTH = 410
+const NIBBLE_53_CHUNK = 0x33
+
+var ADDRESS_PROLOGUE_62 = []byte{0xd5, 0xaa, 0x96}
+var ADDRESS_PROLOGUE_53 = []byte{0xd5, 0xaa, 0xb5}
+var DATA_PROLOGUE = []byte{0xd5, 0xaa, 0xad}
+
+var NIBBLE_62_DECODE = nibbleDecodeTable(NIBBLE_62)
+var NIBBLE_53_DECODE = nibbleDecodeTable(NIBBLE_53)
+
+func nibbleDecodeTable(table []byte) [256]int {
+	var out [256]int
+	for i := range out {
+		out[i] = -1
+	}
+	for i, v := range table {
+		out[v] = i
+	}
+	return out
+}
+
+type NibbleEncoding int
+
+const (
+	NibbleEncoding62 NibbleEncoding = iota
+	NibbleEncoding53
+)
+
+func (ne NibbleEncoding) String() string {
+	switch ne {
+	case NibbleEncoding62:
+		return "6&2"
+	case NibbleEncoding53:
+		return "5&3"
+	}
+	return "Unknown"
+}
+
+// NibbleSector is a sector recovered from a nibble stream
+type NibbleSector struct {
+	Volume   int
+	Track    int
+	Sector   int
+	Data     []byte
+	Encoding NibbleEncoding
+}
+
+func decode44(a, b byte) int {
+	return int(((a << 1) | 0x01) & b)
+}
+
+func matchAt(nibbles []byte, pos int, pattern []byte) bool {
+	if pos+len(pattern) > len(nibbles) {
+		return false
+	}
+	for i, v := range pattern {
+		if nibbles[pos+i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// findData locates the data prologue following an address field, giving up
+// if another address field turns up first.
+func findData(nibbles []byte, pos int, addrPrologue []byte) int {
+	limit := pos + 64
+	if limit > len(nibbles) {
+		limit = len(nibbles)
+	}
+	for i := pos; i < limit; i++ {
+		if matchAt(nibbles, i, DATA_PROLOGUE) {
+			return i + len(DATA_PROLOGUE)
+		}
+		if matchAt(nibbles, i, addrPrologue) {
+			return -1
+		}
+	}
+	return -1
+}
+
+// readAddressField decodes the 4&4 volume, track, sector and checksum bytes
+func readAddressField(nibbles []byte, pos int) (int, int, int, bool) {
+	if pos+8 > len(nibbles) {
+		return 0, 0, 0, false
+	}
+	vol := decode44(nibbles[pos+0], nibbles[pos+1])
+	trk := decode44(nibbles[pos+2], nibbles[pos+3])
+	sec := decode44(nibbles[pos+4], nibbles[pos+5])
+	chk := decode44(nibbles[pos+6], nibbles[pos+7])
+	return vol, trk, sec, (vol ^ trk ^ sec) == chk
+}
+
+// denibblize62 reverses the encoding performed by nibblizeBlock
+func denibblize62(nibbles []byte) ([]byte, error) {
+
+	if len(nibbles) < NIBBLE_62_DATA_LENGTH+1 {
+		return nil, errors.New("Short data field")
+	}
+
+	temp := make([]int, NIBBLE_62_DATA_LENGTH)
+
+	last := 0
+	idx := 0
+	for i := len(temp) - 1; i > 255; i-- {
+		v := NIBBLE_62_DECODE[nibbles[idx]]
+		if v == -1 {
+			return nil, errors.New("Invalid disk byte in data field")
+		}
+		last ^= v
+		temp[i] = last
+		idx++
+	}
+	for i := 0; i < 256; i++ {
+		v := NIBBLE_62_DECODE[nibbles[idx]]
+		if v == -1 {
+			return nil, errors.New("Invalid disk byte in data field")
+		}
+		last ^= v
+		temp[i] = last
+		idx++
+	}
+
+	if NIBBLE_62_DECODE[nibbles[idx]] != last {
+		return nil, errors.New("Data field checksum mismatch")
+	}
+
+	out := make([]byte, 256)
+	posn := 0x55
+	shift := uint(0)
+	for i := 0; i < 256; i++ {
+		bits := (temp[256+posn] >> shift) & 0x03
+		out[i] = byte(temp[i]<<2) | byte((bits&0x01)<<1) | byte((bits&0x02)>>1)
+		if posn == 0 {
+			posn = 0x56
+			shift += 2
+		}
+		posn--
+	}
+
+	return out, nil
+}
+
+// nibblize53 builds a 13 sector nibble image. 13 sector images are stored
+// in physical sector order so no interleave is applied.
+func (d *DSKWrapper) nibblize53() []byte {
+
+	output := bytes.NewBuffer([]byte(nil))
+
+	for track := 0; track < STD_TRACKS_PER_DISK; track++ {
+		for sector := 0; sector < STD_SECTORS_PER_TRACK_OLD; sector++ {
+			// 13 sectors of 512 bytes fill a standard nibble track
+			d.writeJunkBytes(output, 40)
+			d.writeAddressBlock53(output, track, sector, 254)
+			d.writeJunkBytes(output, 6)
+			offset := ((track * STD_SECTORS_PER_TRACK_OLD) + sector) * STD_BYTES_PER_SECTOR
+			d.nibblizeBlock53(output, d.Data[offset:offset+STD_BYTES_PER_SECTOR])
+			d.writeJunkBytes(output, 35)
+		}
+	}
+
+	return output.Bytes()
+
+}
+
+func (d *DSKWrapper) writeAddressBlock53(output io.Writer, track, sector int, volumeNumber int) {
+	output.Write(ADDRESS_PROLOGUE_53)
+	output.Write(d.getOddEven(volumeNumber))
+	output.Write(d.getOddEven(track))
+	output.Write(d.getOddEven(sector))
+	output.Write(d.getOddEven((volumeNumber ^ track ^ sector) & 0x0ff))
+	output.Write([]byte{0xde, 0xaa, 0xeb})
+}
+
+// nibblizeBlock53 is the inverse of denibblize53. The low three bits of
+// each group of five bytes are spread across three "threes" values while
+// the top five bits of each byte are written separately.
+func (d *DSKWrapper) nibblizeBlock53(output io.Writer, data []byte) {
+
+	threes := make([]int, 3*NIBBLE_53_CHUNK+1)
+	top := make([]int, 256)
+
+	i := 0
+	for chunk := NIBBLE_53_CHUNK - 1; chunk >= 0; chunk-- {
+		b0, b1, b2, b3, b4 := int(data[i]), int(data[i+1]), int(data[i+2]), int(data[i+3]), int(data[i+4])
+		top[chunk] = b0 >> 3
+		top[chunk+NIBBLE_53_CHUNK] = b1 >> 3
+		top[chunk+2*NIBBLE_53_CHUNK] = b2 >> 3
+		top[chunk+3*NIBBLE_53_CHUNK] = b3 >> 3
+		top[chunk+4*NIBBLE_53_CHUNK] = b4 >> 3
+		threes[chunk] = (b0&0x07)<<2 | (b3&0x04)>>1 | (b4&0x04)>>2
+		threes[chunk+NIBBLE_53_CHUNK] = (b1&0x07)<<2 | (b3 & 0x02) | (b4&0x02)>>1
+		threes[chunk+2*NIBBLE_53_CHUNK] = (b2&0x07)<<2 | (b3&0x01)<<1 | (b4 & 0x01)
+		i += 5
+	}
+	top[5*NIBBLE_53_CHUNK] = int(data[255]) >> 3
+	threes[3*NIBBLE_53_CHUNK] = int(data[255]) & 0x07
+
+	output.Write(DATA_PROLOGUE)
+
+	last := 0
+	for i := len(threes) - 1; i >= 0; i-- {
+		output.Write([]byte{NIBBLE_53[threes[i]^last]})
+		last = threes[i]
+	}
+	for i := 0; i < len(top); i++ {
+		output.Write([]byte{NIBBLE_53[top[i]^last]})
+		last = top[i]
+	}
+	// Last data byte used as checksum
+	output.Write([]byte{NIBBLE_53[last]})
+	output.Write([]byte{0xde, 0xaa, 0xeb})
+
+}
+
+// denibblize53 decodes a 13 sector (DOS 3.2) data field
+func denibblize53(nibbles []byte) ([]byte, error) {
+
+	if len(nibbles) < NIBBLE_53_DATA_LENGTH+1 {
+		return nil, errors.New("Short data field")
+	}
+
+	threes := make([]int, 3*NIBBLE_53_CHUNK+1)
+	top := make([]int, 256)
+
+	last := 0
+	idx := 0
+	for i := len(threes) - 1; i >= 0; i-- {
+		v := NIBBLE_53_DECODE[nibbles[idx]]
+		if v == -1 {
+			return nil, errors.New("Invalid disk byte in data field")
+		}
+		last ^= v
+		threes[i] = last
+		idx++
+	}
+	for i := 0; i < len(top); i++ {
+		v := NIBBLE_53_DECODE[nibbles[idx]]
+		if v == -1 {
+			return nil, errors.New("Invalid disk byte in data field")
+		}
+		last ^= v
+		top[i] = last
+		idx++
+	}
+
+	if NIBBLE_53_DECODE[nibbles[idx]] != last {
+		return nil, errors.New("Data field checksum mismatch")
+	}
+
+	out := make([]byte, 0, 256)
+	for chunk := NIBBLE_53_CHUNK - 1; chunk >= 0; chunk-- {
+		t1 := threes[chunk]
+		t2 := threes[chunk+NIBBLE_53_CHUNK]
+		t3 := threes[chunk+2*NIBBLE_53_CHUNK]
+		out = append(out,
+			byte(top[chunk]<<3|(t1>>2)&0x07),
+			byte(top[chunk+NIBBLE_53_CHUNK]<<3|(t2>>2)&0x07),
+			byte(top[chunk+2*NIBBLE_53_CHUNK]<<3|(t3>>2)&0x07),
+			byte(top[chunk+3*NIBBLE_53_CHUNK]<<3|(t1&0x02)<<1|(t2&0x02)|(t3&0x02)>>1),
+			byte(top[chunk+4*NIBBLE_53_CHUNK]<<3|(t1&0x01)<<2|(t2&0x01)<<1|(t3&0x01)),
+		)
+	}
+	out = append(out, byte(top[5*NIBBLE_53_CHUNK]<<3|threes[3*NIBBLE_53_CHUNK]&0x07))
+
+	return out, nil
+}
+
+// DecodeNibbleTrack scans a stream of disk bytes and returns every sector
+// that could be recovered with valid address and data checksums. The
+// stream should contain at least one full revolution of the track; passing
+// two revolutions catches a sector straddling the index.
+func DecodeNibbleTrack(nibbles []byte, encoding NibbleEncoding) []*NibbleSector {
+
+	addrPrologue := ADDRESS_PROLOGUE_62
+	dataLength := NIBBLE_62_DATA_LENGTH
+	decoder := denibblize62
+	if encoding == NibbleEncoding53 {
+		addrPrologue = ADDRESS_PROLOGUE_53
+		dataLength = NIBBLE_53_DATA_LENGTH
+		decoder = denibblize53
+	}
+
+	var sectors []*NibbleSector
+	seen := make(map[int]bool)
+
+	for pos := 0; pos < len(nibbles)-len(addrPrologue); pos++ {
+
+		if !matchAt(nibbles, pos, addrPrologue) {
+			continue
+		}
+
+		vol, trk, sec, ok := readAddressField(nibbles, pos+len(addrPrologue))
+		if !ok {
+			continue
+		}
+
+		dpos := findData(nibbles, pos+len(addrPrologue)+8, addrPrologue)
+		if dpos == -1 || dpos+dataLength+1 > len(nibbles) {
+			continue
+		}
+
+		data, err := decoder(nibbles[dpos : dpos+dataLength+1])
+		if err != nil {
+			continue
+		}
+
+		if !seen[sec] {
+			seen[sec] = true
+			sectors = append(sectors, &NibbleSector{
+				Volume:   vol,
+				Track:    trk,
+				Sector:   sec,
+				Data:     data,
+				Encoding: encoding,
+			})
+		}
+
+		pos = dpos + dataLength
+	}
+
+	return sectors
+}
+
+// DetectNibbleEncoding guesses the encoding from the address prologues present
+func DetectNibbleEncoding(nibbles []byte) NibbleEncoding {
+	c62, c53 := 0, 0
+	for pos := 0; pos < len(nibbles)-3; pos++ {
+		if matchAt(nibbles, pos, ADDRESS_PROLOGUE_62) {
+			c62++
+		} else if matchAt(nibbles, pos, ADDRESS_PROLOGUE_53) {
+			c53++
+		}
+	}
+	if c53 > c62 {
+		return NibbleEncoding53
+	}
+	return NibbleEncoding62
+}
+
+// SectorsFromNibbleTracks assembles a sector image from per track nibble
+// streams. 16 sector disks are returned in DOS order, 13 sector disks in
+// physical order (as used by .d13 images). The number of sectors that
+// could not be recovered is also returned.
+func SectorsFromNibbleTracks(tracks [][]byte) ([]byte, NibbleEncoding, int) {
+
+	var all []byte
+	for _, t := range tracks {
+		all = append(all, t...)
+	}
+	encoding := DetectNibbleEncoding(all)
+
+	spt := STD_SECTORS_PER_TRACK
+	if encoding == NibbleEncoding53 {
+		spt = STD_SECTORS_PER_TRACK_OLD
+	}
+
+	out := make([]byte, STD_TRACKS_PER_DISK*spt*STD_BYTES_PER_SECTOR)
+	missing := 0
+
+	for track := 0; track < STD_TRACKS_PER_DISK; track++ {
+
+		found := make(map[int]bool)
+
+		if track < len(tracks) {
+			for _, s := range DecodeNibbleTrack(tracks[track], encoding) {
+				if s.Sector >= spt {
+					continue
+				}
+				logical := s.Sector
+				if encoding == NibbleEncoding62 {
+					logical = DOS_33_SECTOR_ORDER[s.Sector]
+				}
+				offset := (track*spt + logical) * STD_BYTES_PER_SECTOR
+				copy(out[offset:offset+STD_BYTES_PER_SECTOR], s.Data)
+				found[s.Sector] = true
+			}
+		}
+
+		missing += spt - len(found)
+	}
+
+	return out, encoding, missing
+}
+
+// NibbleTracks splits a .nib image into its tracks. Each track is doubled
+// up so a sector wrapping around the end of the track can still be read.
+func NibbleTracks(data []byte) [][]byte {
+	tracks := make([][]byte, 0, STD_TRACKS_PER_DISK)
+	for off := 0; off+TRACK_NIBBLE_LENGTH <= len(data); off += TRACK_NIBBLE_LENGTH {
+		trk := data[off : off+TRACK_NIBBLE_LENGTH]
+		double := make([]byte, 0, 2*TRACK_NIBBLE_LENGTH)
+		double = append(double, trk...)
+		double = append(double, trk...)
+		tracks = append(tracks, double)
+	}
+	return tracks
+}
+
+// nibBytes rebuilds the .nib image. Only tracks whose sectors have changed
+// are nibblized again, the rest keep the nibbles they were loaded with.
+func (d *DSKWrapper) nibBytes() []byte {
+
+	out := append([]byte(nil), d.nibData...)
+	size := len(d.Data) / STD_TRACKS_PER_DISK
+
+	var fresh []byte
+	for track := 0; track < STD_TRACKS_PER_DISK; track++ {
+		start, end := track*size, (track+1)*size
+		if bytes.Equal(d.Data[start:end], d.nibSectors[start:end]) {
+			continue
+		}
+		if fresh == nil {
+			fresh = d.Nibblize()
+		}
+		offset := track * TRACK_NIBBLE_LENGTH
+		copy(out[offset:offset+TRACK_NIBBLE_LENGTH], fresh[offset:])
+	}
+
+	return out
+
+}
+
+// NewDSKWrapperNIB decodes a .nib image into a sector image. The original
+// nibbles are kept for the nibble view. If nothing at all can be decoded
+// (heavily protected disks) we fall back to storing the raw nibbles, and if
+// only some of it can be the image is write protected.
+func NewDSKWrapperNIB(nibbler Nibbler, data []byte, filename string) (*DSKWrapper, error) {
+
+	this := &DSKWrapper{}
+
+	this.Filename = filename
+	this.Layout = SectorOrderDOS33
+	this.CurrentSectorOrder = DOS_33_SECTOR_ORDER
+	this.Nibbles = nibbler
+	this.WriteProtected = false
+
+	sectors, encoding, missing := SectorsFromNibbleTracks(NibbleTracks(data))
+	if missing == len(sectors)/STD_BYTES_PER_SECTOR {
+		this.SetData(data)
+		this.Identify()
+		return this, nil
+	}
+
+	this.SetData(sectors)
+	this.NIB = true
+	this.NibbleEncoding = encoding
+	this.nibData = data
+	this.nibSectors = append([]byte(nil), sectors...)
+	// sectors we couldn't read would be lost when their track is rebuilt
+	this.WriteProtected = missing > 0
+
+	this.Identify()
+
+	// Identify re-nibblizes from the sectors, put the real thing back
+	this.SetNibbles(data)
+
+	return this, nil
+
+}
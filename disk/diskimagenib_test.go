@@ -0,0 +1,144 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNIBRoundTrip(t *testing.T) {
+
+	file := testData(3, 5000)
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, file)
+	nib := dsk.Nibblize()
+	if len(nib) != DISK_NIBBLE_LENGTH {
+		t.Fatalf("nibblized to %d bytes, want %d", len(nib), DISK_NIBBLE_LENGTH)
+	}
+
+	// a change in the gap before track 5 that decoding never sees
+	nib[5*TRACK_NIBBLE_LENGTH] = 0xfe
+
+	w, err := NewDSKWrapperBin(nil, nib, "test.nib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.NIB || w.NibbleEncoding != NibbleEncoding62 || w.Format.ID != DF_DOS_SECTORS_16 {
+		t.Fatalf("loaded as %s, NIB %v, %s encoding", w.Format, w.NIB, w.NibbleEncoding)
+	}
+	if w.WriteProtected {
+		t.Error("fully decoded image is write protected")
+	}
+	if !bytes.Equal(w.Data, dsk.Data) {
+		t.Fatal("decoded sectors don't match the disk")
+	}
+
+	if !bytes.Equal(w.Bytes(), nib) {
+		t.Error("unchanged image didn't save as loaded")
+	}
+
+	more := testData(4, 3000)
+	if err := w.AppleDOSWriteFile("MORE", FileTypeBIN, more, 0x4000); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := w.Bytes()
+	if !bytes.Equal(saved[5*TRACK_NIBBLE_LENGTH:6*TRACK_NIBBLE_LENGTH], nib[5*TRACK_NIBBLE_LENGTH:6*TRACK_NIBBLE_LENGTH]) {
+		t.Error("untouched track 5 was rebuilt")
+	}
+	// the catalog lives on track 17
+	if bytes.Equal(saved[17*TRACK_NIBBLE_LENGTH:18*TRACK_NIBBLE_LENGTH], nib[17*TRACK_NIBBLE_LENGTH:18*TRACK_NIBBLE_LENGTH]) {
+		t.Error("catalog track wasn't rebuilt")
+	}
+
+	reloaded, err := NewDSKWrapperBin(nil, saved, "test.nib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reloaded.Data, w.Data) {
+		t.Error("saved image doesn't decode to the changed sectors")
+	}
+
+}
+
+func TestNIBUndecodable(t *testing.T) {
+
+	// nothing but sync, so there's nothing to decode
+	nib := bytes.Repeat([]byte{0xff}, DISK_NIBBLE_LENGTH)
+
+	w, err := NewDSKWrapperBin(nil, nib, "test.nib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.NIB || !bytes.Equal(w.Data, nib) {
+		t.Errorf("undecodable image loaded with NIB %v, %d bytes", w.NIB, len(w.Data))
+	}
+
+}
+
+func TestNIBPartlyDecoded(t *testing.T) {
+
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(5, 100))
+	nib := dsk.Nibblize()
+
+	// a track with no sectors on it, as protected disks often have
+	for i := 3 * TRACK_NIBBLE_LENGTH; i < 4*TRACK_NIBBLE_LENGTH; i++ {
+		nib[i] = 0xff
+	}
+
+	w, err := NewDSKWrapperBin(nil, nib, "test.nib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.NIB || !w.WriteProtected {
+		t.Errorf("partly decoded image loaded with NIB %v, write protected %v", w.NIB, w.WriteProtected)
+	}
+
+}
+
+func TestNibble53Block(t *testing.T) {
+
+	d := &DSKWrapper{}
+
+	for seed := int64(0); seed < 8; seed++ {
+		data := testData(seed, STD_BYTES_PER_SECTOR)
+		var buf bytes.Buffer
+		d.nibblizeBlock53(&buf, data)
+		out, err := denibblize53(buf.Bytes()[len(DATA_PROLOGUE):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("seed %d: 5&3 block didn't decode to what was encoded", seed)
+		}
+	}
+
+}
+
+func TestNIB53RoundTrip(t *testing.T) {
+
+	file := testData(6, 4000)
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_13), SectorOrderDOS32, file)
+	nib := dsk.Nibblize()
+	if len(nib) != DISK_NIBBLE_LENGTH {
+		t.Fatalf("nibblized to %d bytes, want %d", len(nib), DISK_NIBBLE_LENGTH)
+	}
+
+	if DetectNibbleEncoding(nib) != NibbleEncoding53 {
+		t.Fatal("13 sector nibbles not detected as 5&3")
+	}
+
+	w, err := NewDSKWrapperBin(nil, nib, "test.nib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.NibbleEncoding != NibbleEncoding53 || w.Format.ID != DF_DOS_SECTORS_13 || w.WriteProtected {
+		t.Fatalf("loaded as %s, %s, write protected %v", w.Format, w.NibbleEncoding, w.WriteProtected)
+	}
+	if !bytes.Equal(w.Data, dsk.Data) {
+		t.Fatal("decoded sectors don't match the disk")
+	}
+
+	if !bytes.Equal(w.Bytes(), nib) {
+		t.Error("unchanged image didn't save as loaded")
+	}
+
+}
@@ -0,0 +1,624 @@
+package disk
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+/*
+	NuFX (ShrinkIt) archive reader...
+
+	An archive is a master header followed by records. Each record has a
+	header with the ProDOS attributes and a list of threads (data fork,
+	resource fork, disk image, filename, comments). Thread data may be
+	stored uncompressed or with LZW/1 or LZW/2.
+*/
+
+const NUFX_MASTER_HEADER_SIZE = 48
+const NUFX_THREAD_HEADER_SIZE = 16
+const NUFX_BXY_OFFSET = 128
+const NUFX_LZW_BLOCK = 4096
+
+var MAGIC_NUFX_MASTER = []byte{0x4e, 0xf5, 0x46, 0xe9, 0x6c, 0xe5}
+var MAGIC_NUFX_RECORD = []byte{0x4e, 0xf5, 0x46, 0xd8}
+
+type NuFXThreadClass int
+
+const (
+	NuFXThreadClass_Message NuFXThreadClass = 0x0000
+	NuFXThreadClass_Control NuFXThreadClass = 0x0001
+	NuFXThreadClass_Data    NuFXThreadClass = 0x0002
+	NuFXThreadClass_Name    NuFXThreadClass = 0x0003
+)
+
+const (
+	NuFXThreadKind_DataFork     = 0x0000
+	NuFXThreadKind_DiskImage    = 0x0001
+	NuFXThreadKind_ResourceFork = 0x0002
+)
+
+type NuFXThreadFormat int
+
+const (
+	NuFXFormat_Uncompressed NuFXThreadFormat = 0x0000
+	NuFXFormat_Squeeze      NuFXThreadFormat = 0x0001
+	NuFXFormat_LZW1         NuFXThreadFormat = 0x0002
+	NuFXFormat_LZW2         NuFXThreadFormat = 0x0003
+	NuFXFormat_LZC12        NuFXThreadFormat = 0x0004
+	NuFXFormat_LZC16        NuFXThreadFormat = 0x0005
+)
+
+func (f NuFXThreadFormat) String() string {
+	switch f {
+	case NuFXFormat_Uncompressed:
+		return "Uncompressed"
+	case NuFXFormat_Squeeze:
+		return "Squeeze"
+	case NuFXFormat_LZW1:
+		return "LZW/1"
+	case NuFXFormat_LZW2:
+		return "LZW/2"
+	case NuFXFormat_LZC12:
+		return "LZC 12 bit"
+	case NuFXFormat_LZC16:
+		return "LZC 16 bit"
+	}
+	return "Unknown"
+}
+
+type NuFXThread struct {
+	Class   NuFXThreadClass
+	Format  NuFXThreadFormat
+	Kind    int
+	CRC     int
+	EOF     int
+	CompEOF int
+	Raw     []byte // thread data as stored in the archive
+}
+
+type NuFXRecord struct {
+	Filename    string
+	FileSysID   int
+	Separator   byte
+	Access      int
+	FileType    int
+	AuxType     int
+	StorageType int
+	Created     time.Time
+	Modified    time.Time
+	Archived    time.Time
+	Threads     []*NuFXThread
+}
+
+type NuFXArchive struct {
+	Records []*NuFXRecord
+}
+
+func nufxUint16(b []byte) int {
+	return int(b[0]) + 256*int(b[1])
+}
+
+func nufxUint32(b []byte) int {
+	return int(b[0]) + 256*int(b[1]) + 65536*int(b[2]) + 16777216*int(b[3])
+}
+
+// nufxTime decodes the 8 byte date/time used in NuFX headers
+func nufxTime(b []byte) time.Time {
+	second, minute, hour := int(b[0]), int(b[1]), int(b[2])
+	year, day, month := int(b[3]), int(b[4]), int(b[5])
+	if month > 11 || day > 30 || (year == 0 && month == 0 && day == 0) {
+		return time.Time{}
+	}
+	if year < 40 {
+		year += 100
+	}
+	return time.Date(1900+year, time.Month(month+1), day+1, hour, minute, second, 0, time.Local)
+}
+
+// crc16 is the CCITT CRC (as used by XMODEM) that ShrinkIt uses
+func crc16(crc uint16, data []byte) uint16 {
+	for _, v := range data {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// IsNuFX checks for a NuFX archive, either bare or wrapped in Binary II
+// (.BXY). The offset of the master header is returned.
+func IsNuFX(data []byte) (bool, int) {
+	if matchAt(data, 0, MAGIC_NUFX_MASTER) {
+		return true, 0
+	}
+	if len(data) > NUFX_BXY_OFFSET && data[0] == 0x0a && data[1] == 0x47 && data[2] == 0x4c &&
+		matchAt(data, NUFX_BXY_OFFSET, MAGIC_NUFX_MASTER) {
+		return true, NUFX_BXY_OFFSET
+	}
+	return false, 0
+}
+
+func ParseNuFX(data []byte) (*NuFXArchive, error) {
+
+	ok, offset := IsNuFX(data)
+	if !ok {
+		return nil, errors.New("Not a NuFX archive")
+	}
+	data = data[offset:]
+
+	if len(data) < NUFX_MASTER_HEADER_SIZE {
+		return nil, errors.New("NuFX master header is truncated")
+	}
+
+	count := nufxUint32(data[0x08:])
+	archive := &NuFXArchive{}
+
+	ptr := NUFX_MASTER_HEADER_SIZE
+	for i := 0; i < count; i++ {
+
+		if !matchAt(data, ptr, MAGIC_NUFX_RECORD) {
+			return archive, errors.New("NuFX record header not found")
+		}
+
+		if ptr+0x3a > len(data) {
+			return archive, errors.New("NuFX record header is truncated")
+		}
+
+		h := data[ptr:]
+		attribCount := nufxUint16(h[0x06:])
+		threadCount := nufxUint32(h[0x0a:])
+
+		r := &NuFXRecord{
+			FileSysID:   nufxUint16(h[0x0e:]),
+			Separator:   h[0x10],
+			Access:      nufxUint32(h[0x12:]),
+			FileType:    nufxUint32(h[0x16:]),
+			AuxType:     nufxUint32(h[0x1a:]),
+			StorageType: nufxUint16(h[0x1e:]),
+			Created:     nufxTime(h[0x20:]),
+			Modified:    nufxTime(h[0x28:]),
+			Archived:    nufxTime(h[0x30:]),
+		}
+
+		if attribCount < 0x3a || ptr+attribCount > len(data) {
+			return archive, errors.New("NuFX record header is truncated")
+		}
+
+		// old style filename sits at the end of the attributes
+		nameLen := nufxUint16(h[attribCount-2:])
+		if ptr+attribCount+nameLen > len(data) {
+			return archive, errors.New("NuFX record filename is truncated")
+		}
+		r.Filename = string(h[attribCount : attribCount+nameLen])
+
+		tptr := ptr + attribCount + nameLen
+		dptr := tptr + threadCount*NUFX_THREAD_HEADER_SIZE
+		if dptr > len(data) {
+			return archive, errors.New("NuFX thread headers are truncated")
+		}
+
+		for t := 0; t < threadCount; t++ {
+			th := data[tptr+t*NUFX_THREAD_HEADER_SIZE:]
+			thread := &NuFXThread{
+				Class:   NuFXThreadClass(nufxUint16(th[0x00:])),
+				Format:  NuFXThreadFormat(nufxUint16(th[0x02:])),
+				Kind:    nufxUint16(th[0x04:]),
+				CRC:     nufxUint16(th[0x06:]),
+				EOF:     nufxUint32(th[0x08:]),
+				CompEOF: nufxUint32(th[0x0c:]),
+			}
+			if dptr+thread.CompEOF > len(data) {
+				return archive, errors.New("NuFX thread data is truncated")
+			}
+			thread.Raw = data[dptr : dptr+thread.CompEOF]
+			dptr += thread.CompEOF
+
+			if thread.Class == NuFXThreadClass_Name && thread.Kind == 0 {
+				name := thread.Raw
+				if thread.EOF < len(name) {
+					name = name[:thread.EOF]
+				}
+				r.Filename = strings.TrimRight(string(name), "\x00")
+			}
+
+			r.Threads = append(r.Threads, thread)
+		}
+
+		archive.Records = append(archive.Records, r)
+		ptr = dptr
+	}
+
+	return archive, nil
+
+}
+
+func (r *NuFXRecord) findThread(kind int) *NuFXThread {
+	for _, t := range r.Threads {
+		if t.Class == NuFXThreadClass_Data && t.Kind == kind {
+			return t
+		}
+	}
+	return nil
+}
+
+// IsDisk is true if the record holds a disk image
+func (r *NuFXRecord) IsDisk() bool {
+	return r.findThread(NuFXThreadKind_DiskImage) != nil
+}
+
+// HasResourceFork is true if the record holds a resource fork
+func (r *NuFXRecord) HasResourceFork() bool {
+	return r.findThread(NuFXThreadKind_ResourceFork) != nil
+}
+
+// GetPathParts splits the stored filename on the record's separator
+func (r *NuFXRecord) GetPathParts() []string {
+	sep := string([]byte{r.Separator})
+	if r.Separator == 0 {
+		sep = "/"
+	}
+	return strings.Split(strings.Trim(r.Filename, sep), sep)
+}
+
+// GetBaseName returns the last part of the stored filename
+func (r *NuFXRecord) GetBaseName() string {
+	parts := r.GetPathParts()
+	return parts[len(parts)-1]
+}
+
+func (r *NuFXRecord) DataFork() ([]byte, error) {
+	t := r.findThread(NuFXThreadKind_DataFork)
+	if t == nil {
+		return []byte(nil), nil
+	}
+	return t.Expand(t.EOF)
+}
+
+func (r *NuFXRecord) ResourceFork() ([]byte, error) {
+	t := r.findThread(NuFXThreadKind_ResourceFork)
+	if t == nil {
+		return []byte(nil), nil
+	}
+	return t.Expand(t.EOF)
+}
+
+// DiskImage returns the image in ProDOS block order. Some archivers leave
+// the thread EOF as zero, in which case the size comes from the record
+// (storage type is the block size, aux type the block count).
+func (r *NuFXRecord) DiskImage() ([]byte, error) {
+	t := r.findThread(NuFXThreadKind_DiskImage)
+	if t == nil {
+		return nil, errors.New("Record is not a disk image")
+	}
+	size := t.EOF
+	if size == 0 {
+		size = r.StorageType * r.AuxType
+	}
+	return t.Expand(size)
+}
+
+// Expand decompresses the thread data to size bytes
+func (t *NuFXThread) Expand(size int) ([]byte, error) {
+	switch t.Format {
+	case NuFXFormat_Uncompressed:
+		if size > len(t.Raw) {
+			return nil, errors.New("Thread data is truncated")
+		}
+		return t.Raw[:size], nil
+	case NuFXFormat_LZW1:
+		return nufxExpandLZW(t.Raw, size, false)
+	case NuFXFormat_LZW2:
+		return nufxExpandLZW(t.Raw, size, true)
+	}
+	return nil, errors.New("Unsupported NuFX compression: " + t.Format.String())
+}
+
+// nufxLZW holds the decoder table, which carries across chunks in LZW/2
+type nufxLZW struct {
+	prefix  [4096]int
+	suffix  [4096]byte
+	entry   int
+	oldcode int
+	finalc  byte
+	fresh   bool // next code is a literal that starts the table
+	stack   []byte
+}
+
+const nufxLZWClear = 0x100
+const nufxLZWFirst = 0x101
+const nufxLZWMax = 0x1000
+
+func (z *nufxLZW) reset() {
+	z.entry = nufxLZWFirst
+	z.fresh = true
+}
+
+type nufxBitReader struct {
+	data []byte
+	pos  int
+	bit  uint
+}
+
+// code reads an LSB first code. The width switches one entry earlier than
+// a strict reading of the table size would suggest, as ShrinkIt does.
+func (br *nufxBitReader) code(entry int) (int, error) {
+	width := uint(9)
+	switch {
+	case entry+1 >= 0x800:
+		width = 12
+	case entry+1 >= 0x400:
+		width = 11
+	case entry+1 >= 0x200:
+		width = 10
+	}
+	v := 0
+	for i := uint(0); i < width; i++ {
+		if br.pos >= len(br.data) {
+			return 0, errors.New("LZW data is truncated")
+		}
+		if br.data[br.pos]&(1<<br.bit) != 0 {
+			v |= 1 << i
+		}
+		br.bit++
+		if br.bit == 8 {
+			br.bit = 0
+			br.pos++
+		}
+	}
+	return v, nil
+}
+
+// expand decodes codes until length bytes have been produced
+func (z *nufxLZW) expand(br *nufxBitReader, length int) ([]byte, error) {
+
+	out := make([]byte, 0, length)
+
+	for len(out) < length {
+
+		code, err := br.code(z.entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if z.fresh {
+			if code > 0xff {
+				return nil, errors.New("Bad LZW code")
+			}
+			z.oldcode = code
+			z.finalc = byte(code)
+			z.fresh = false
+			out = append(out, z.finalc)
+			continue
+		}
+
+		if code == nufxLZWClear {
+			z.reset()
+			continue
+		}
+
+		incode := code
+		z.stack = z.stack[:0]
+
+		if code >= z.entry {
+			if code > z.entry {
+				return nil, errors.New("Bad LZW code")
+			}
+			z.stack = append(z.stack, z.finalc)
+			code = z.oldcode
+		}
+
+		for code > 0xff {
+			z.stack = append(z.stack, z.suffix[code])
+			code = z.prefix[code]
+		}
+		z.finalc = byte(code)
+		z.stack = append(z.stack, z.finalc)
+
+		for i := len(z.stack) - 1; i >= 0; i-- {
+			out = append(out, z.stack[i])
+		}
+
+		if z.entry < nufxLZWMax {
+			z.prefix[z.entry] = z.oldcode
+			z.suffix[z.entry] = z.finalc
+			z.entry++
+		}
+		z.oldcode = incode
+	}
+
+	if len(out) > length {
+		out = out[:length]
+	}
+
+	return out, nil
+}
+
+// nufxUnRLE expands the ShrinkIt run length encoding of a chunk
+func nufxUnRLE(data []byte, escape byte) []byte {
+	out := make([]byte, 0, NUFX_LZW_BLOCK)
+	for i := 0; i < len(data) && len(out) < NUFX_LZW_BLOCK; i++ {
+		if data[i] == escape && i+2 < len(data) {
+			for c := 0; c <= int(data[i+2]); c++ {
+				out = append(out, data[i+1])
+			}
+			i += 2
+			continue
+		}
+		out = append(out, data[i])
+	}
+	for len(out) < NUFX_LZW_BLOCK {
+		out = append(out, 0)
+	}
+	return out[:NUFX_LZW_BLOCK]
+}
+
+// nufxExpandLZW handles both LZW/1 and LZW/2 threads. Data is split into
+// 4K chunks, each optionally RLE'd and then optionally LZW'd.
+func nufxExpandLZW(data []byte, size int, lzw2 bool) ([]byte, error) {
+
+	var crc int
+	ptr := 0
+
+	if !lzw2 {
+		if len(data) < 4 {
+			return nil, errors.New("LZW/1 header is truncated")
+		}
+		crc = nufxUint16(data)
+		ptr = 2
+	}
+
+	if len(data) < ptr+2 {
+		return nil, errors.New("LZW header is truncated")
+	}
+	// skip the volume number
+	escape := data[ptr+1]
+	ptr += 2
+
+	z := &nufxLZW{}
+	z.reset()
+
+	out := make([]byte, 0, size+NUFX_LZW_BLOCK)
+	var chunkCRC uint16
+
+	for len(out) < size {
+
+		var rleLen, compLen int
+		var isLZW bool
+
+		if lzw2 {
+			if ptr+2 > len(data) {
+				return nil, errors.New("LZW/2 chunk header is truncated")
+			}
+			rleLen = nufxUint16(data[ptr:])
+			isLZW = rleLen&0x8000 != 0
+			rleLen &= 0x1fff
+			ptr += 2
+			if isLZW {
+				if ptr+2 > len(data) {
+					return nil, errors.New("LZW/2 chunk header is truncated")
+				}
+				// length includes the 4 byte chunk header
+				compLen = nufxUint16(data[ptr:]) - 4
+				ptr += 2
+			}
+		} else {
+			if ptr+3 > len(data) {
+				return nil, errors.New("LZW/1 chunk header is truncated")
+			}
+			rleLen = nufxUint16(data[ptr:])
+			isLZW = data[ptr+2] != 0
+			ptr += 3
+		}
+
+		if rleLen > NUFX_LZW_BLOCK {
+			return nil, errors.New("Bad LZW chunk length")
+		}
+
+		var chunk []byte
+
+		if isLZW {
+			if !lzw2 {
+				z.reset()
+			}
+			br := &nufxBitReader{data: data, pos: ptr}
+			c, err := z.expand(br, rleLen)
+			if err != nil {
+				return nil, err
+			}
+			chunk = c
+			if lzw2 {
+				ptr += compLen
+			} else {
+				ptr = br.pos
+				if br.bit != 0 {
+					ptr++
+				}
+			}
+		} else {
+			if lzw2 {
+				// the table does not survive a stored chunk
+				z.reset()
+			}
+			if ptr+rleLen > len(data) {
+				return nil, errors.New("LZW chunk is truncated")
+			}
+			chunk = data[ptr : ptr+rleLen]
+			ptr += rleLen
+		}
+
+		if rleLen != NUFX_LZW_BLOCK {
+			chunk = nufxUnRLE(chunk, escape)
+		}
+
+		if !lzw2 {
+			chunkCRC = crc16(chunkCRC, chunk)
+		}
+
+		out = append(out, chunk...)
+	}
+
+	if !lzw2 && int(chunkCRC) != crc {
+		return nil, errors.New("LZW/1 checksum mismatch")
+	}
+
+	return out[:size], nil
+}
+
+// DiskRecords returns the records that hold disk images
+func (a *NuFXArchive) DiskRecords() []*NuFXRecord {
+	var out []*NuFXRecord
+	for _, r := range a.Records {
+		if r.IsDisk() {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// FileRecords returns the records that hold files
+func (a *NuFXArchive) FileRecords() []*NuFXRecord {
+	var out []*NuFXRecord
+	for _, r := range a.Records {
+		if r.IsDisk() {
+			continue
+		}
+		if r.findThread(NuFXThreadKind_DataFork) != nil || r.HasResourceFork() {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// NewDSKWrapperNuFX opens the first disk image held in a NuFX archive.
+// Changes can't be written back into the archive.
+func NewDSKWrapperNuFX(nibbler Nibbler, data []byte, filename string) (*DSKWrapper, error) {
+
+	archive, err := ParseNuFX(data)
+	if err != nil {
+		return nil, err
+	}
+
+	disks := archive.DiskRecords()
+	if len(disks) == 0 {
+		return nil, errors.New("No disk images in archive")
+	}
+
+	img, err := disks[0].DiskImage()
+	if err != nil {
+		return nil, err
+	}
+
+	this, err := NewDSKWrapperBin(nibbler, img, filename)
+	if err != nil {
+		return nil, err
+	}
+	this.WriteProtected = true
+
+	return this, nil
+}
@@ -0,0 +1,406 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testNuFXEscape = 0xdb
+
+// testRLE is the ShrinkIt run length encoding nufxUnRLE undoes
+func testRLE(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && data[i+run] == data[i] && run < 256 {
+			run++
+		}
+		if run > 3 || data[i] == testNuFXEscape {
+			out = append(out, testNuFXEscape, data[i], byte(run-1))
+		} else {
+			out = append(out, data[i:i+run]...)
+		}
+		i += run
+	}
+	return out
+}
+
+// testLZW packs codes the way nufxLZW reads them back. The table carries
+// on from chunk to chunk until reset, as in LZW/2.
+type testLZW struct {
+	table map[[2]int]int
+	entry int // next entry the encoder adds
+	read  int // next entry the decoder adds, which sets the code width
+	fresh bool
+	last  int // last code written, -1 after a reset
+	out   []byte
+	bits  uint
+}
+
+func newTestLZW() *testLZW {
+	z := &testLZW{}
+	z.reset()
+	return z
+}
+
+func (z *testLZW) reset() {
+	z.table = make(map[[2]int]int)
+	z.entry = nufxLZWFirst
+	z.read = nufxLZWFirst
+	z.fresh = true
+	z.last = -1
+}
+
+func (z *testLZW) add(prefix int, c byte) {
+	if z.entry < nufxLZWMax {
+		z.table[[2]int{prefix, int(c)}] = z.entry
+		z.entry++
+	}
+}
+
+func (z *testLZW) emit(code int) {
+	width := uint(9)
+	switch {
+	case z.read+1 >= 0x800:
+		width = 12
+	case z.read+1 >= 0x400:
+		width = 11
+	case z.read+1 >= 0x200:
+		width = 10
+	}
+	for i := uint(0); i < width; i++ {
+		if z.bits%8 == 0 {
+			z.out = append(z.out, 0)
+		}
+		if code&(1<<i) != 0 {
+			z.out[len(z.out)-1] |= 1 << (z.bits % 8)
+		}
+		z.bits++
+	}
+	if !z.fresh && z.read < nufxLZWMax {
+		z.read++
+	}
+	z.fresh = false
+	z.last = code
+}
+
+// chunk compresses one chunk, returning the bytes written for it
+func (z *testLZW) chunk(data []byte) []byte {
+
+	z.out, z.bits = nil, 0
+
+	w := int(data[0])
+	if z.last >= 0 {
+		// the decoder finishes the entry started by the last chunk
+		z.add(z.last, data[0])
+	}
+
+	for _, c := range data[1:] {
+		if code, ok := z.table[[2]int{w, int(c)}]; ok {
+			w = code
+			continue
+		}
+		z.emit(w)
+		z.add(w, c)
+		w = int(c)
+	}
+	z.emit(w)
+
+	return z.out
+
+}
+
+func (z *testLZW) clone() *testLZW {
+	c := *z
+	c.table = make(map[[2]int]int, len(z.table))
+	for k, v := range z.table {
+		c.table[k] = v
+	}
+	return &c
+}
+
+// testNuFXCompress builds an LZW/1 or LZW/2 thread
+func testNuFXCompress(data []byte, lzw2 bool) []byte {
+
+	padded := append([]byte(nil), data...)
+	for len(padded)%NUFX_LZW_BLOCK != 0 {
+		padded = append(padded, 0)
+	}
+
+	var out []byte
+	if !lzw2 {
+		crc := crc16(0, padded)
+		out = append(out, byte(crc), byte(crc>>8))
+	}
+	out = append(out, 0x00, testNuFXEscape)
+
+	z := newTestLZW()
+
+	for off := 0; off < len(padded); off += NUFX_LZW_BLOCK {
+
+		chunk := padded[off : off+NUFX_LZW_BLOCK]
+		if rle := testRLE(chunk); len(rle) < NUFX_LZW_BLOCK {
+			chunk = rle
+		}
+
+		if !lzw2 {
+			z.reset()
+		}
+		saved := z.clone()
+		lzw := z.chunk(chunk)
+		stored := len(lzw) >= len(chunk)
+		if stored {
+			// the decoder starts a fresh table after a stored chunk
+			z = saved
+			z.reset()
+		}
+
+		n := len(chunk)
+		switch {
+		case lzw2 && stored:
+			out = append(out, byte(n), byte(n>>8))
+			out = append(out, chunk...)
+		case lzw2:
+			total := len(lzw) + 4
+			out = append(out, byte(n), byte(n>>8)|0x80, byte(total), byte(total>>8))
+			out = append(out, lzw...)
+		case stored:
+			out = append(out, byte(n), byte(n>>8), 0)
+			out = append(out, chunk...)
+		default:
+			out = append(out, byte(n), byte(n>>8), 1)
+			out = append(out, lzw...)
+		}
+
+	}
+
+	return out
+
+}
+
+type testNuFXThread struct {
+	class  NuFXThreadClass
+	format NuFXThreadFormat
+	kind   int
+	eof    int
+	data   []byte
+}
+
+// testNuFX makes an archive of one record per list of threads
+func testNuFX(records ...[]testNuFXThread) []byte {
+
+	out := make([]byte, NUFX_MASTER_HEADER_SIZE)
+	copy(out, MAGIC_NUFX_MASTER)
+	out[0x08] = byte(len(records))
+
+	for _, threads := range records {
+
+		h := make([]byte, 0x3a)
+		copy(h, MAGIC_NUFX_RECORD)
+		h[0x06] = 0x3a
+		h[0x0a] = byte(len(threads))
+		h[0x0e] = 0x01 // ProDOS
+		h[0x10] = '/'
+		h[0x12] = 0xc3
+		h[0x16] = byte(FileType_PD_BIN)
+		h[0x1a], h[0x1b] = 0x00, 0x20
+		out = append(out, h...)
+
+		for _, t := range threads {
+			th := make([]byte, NUFX_THREAD_HEADER_SIZE)
+			th[0x00] = byte(t.class)
+			th[0x02] = byte(t.format)
+			th[0x04] = byte(t.kind)
+			th[0x08], th[0x09], th[0x0a] = byte(t.eof), byte(t.eof>>8), byte(t.eof>>16)
+			n := len(t.data)
+			th[0x0c], th[0x0d], th[0x0e] = byte(n), byte(n>>8), byte(n>>16)
+			out = append(out, th...)
+		}
+		for _, t := range threads {
+			out = append(out, t.data...)
+		}
+
+	}
+
+	return out
+
+}
+
+func testNuFXName(name string) testNuFXThread {
+	return testNuFXThread{class: NuFXThreadClass_Name, eof: len(name), data: []byte(name)}
+}
+
+func TestNuFXDiskImage(t *testing.T) {
+
+	file := testData(40, 30000)
+	dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, file)
+
+	for _, format := range []NuFXThreadFormat{NuFXFormat_Uncompressed, NuFXFormat_LZW1, NuFXFormat_LZW2} {
+
+		data := dsk.Data
+		switch format {
+		case NuFXFormat_LZW1:
+			data = testNuFXCompress(dsk.Data, false)
+		case NuFXFormat_LZW2:
+			data = testNuFXCompress(dsk.Data, true)
+		}
+		if format != NuFXFormat_Uncompressed && len(data) >= len(dsk.Data) {
+			t.Errorf("%s: %d bytes didn't compress", format, len(data))
+		}
+
+		archive := testNuFX([]testNuFXThread{
+			testNuFXName("TEST"),
+			{class: NuFXThreadClass_Data, format: format, kind: NuFXThreadKind_DiskImage, eof: len(dsk.Data), data: data},
+		})
+
+		w, err := NewDSKWrapperBin(nil, archive, "test.shk")
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !bytes.Equal(w.Data, dsk.Data) {
+			t.Fatalf("%s: disk image doesn't match", format)
+		}
+		if w.Format.ID != DF_PRODOS || !w.WriteProtected {
+			t.Errorf("%s: loaded as %s, write protected %v", format, w.Format, w.WriteProtected)
+		}
+
+	}
+
+}
+
+func TestNuFXFiles(t *testing.T) {
+
+	// runs, escape bytes, text and noise, so some chunks are stored
+	var data []byte
+	data = append(data, bytes.Repeat([]byte{0xdb}, 700)...)
+	data = append(data, bytes.Repeat([]byte("THE QUICK BROWN FOX "), 500)...)
+	data = append(data, testData(41, 9000)...)
+	data = append(data, make([]byte, 5000)...)
+	data = append(data, bytes.Repeat([]byte{1, 2, 3, 0xdb}, 2000)...)
+
+	rsrc := testData(42, 1500)
+
+	for _, lzw2 := range []bool{false, true} {
+
+		format := NuFXFormat_LZW1
+		if lzw2 {
+			format = NuFXFormat_LZW2
+		}
+
+		archive := testNuFX(
+			[]testNuFXThread{
+				testNuFXName("DIR/DATA"),
+				{class: NuFXThreadClass_Data, format: format, kind: NuFXThreadKind_DataFork, eof: len(data), data: testNuFXCompress(data, lzw2)},
+				{class: NuFXThreadClass_Data, format: format, kind: NuFXThreadKind_ResourceFork, eof: len(rsrc), data: testNuFXCompress(rsrc, lzw2)},
+			},
+			[]testNuFXThread{
+				testNuFXName("SMALL"),
+				{class: NuFXThreadClass_Data, format: format, kind: NuFXThreadKind_DataFork, eof: 5, data: testNuFXCompress([]byte("HELLO"), lzw2)},
+			},
+		)
+
+		a, err := ParseNuFX(archive)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		files := a.FileRecords()
+		if len(files) != 2 || files[0].GetBaseName() != "DATA" || !files[0].HasResourceFork() {
+			t.Fatalf("%s: %d file records", format, len(files))
+		}
+
+		got, err := files[0].DataFork()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: data fork %d bytes, %v", format, len(got), err)
+		}
+		got, err = files[0].ResourceFork()
+		if err != nil || !bytes.Equal(got, rsrc) {
+			t.Errorf("%s: resource fork %d bytes, %v", format, len(got), err)
+		}
+		got, err = files[1].DataFork()
+		if err != nil || string(got) != "HELLO" {
+			t.Errorf("%s: small file %q, %v", format, got, err)
+		}
+
+	}
+
+}
+
+func TestNuFXLZW1Checksum(t *testing.T) {
+
+	data := bytes.Repeat([]byte("ABCD"), 3000)
+	thread := &NuFXThread{Format: NuFXFormat_LZW1, EOF: len(data), Raw: testNuFXCompress(data, false)}
+
+	if got, err := thread.Expand(len(data)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("LZW/1 expanded to %d bytes, %v", len(got), err)
+	}
+
+	thread.Raw[0] ^= 0xff
+	if _, err := thread.Expand(len(data)); err == nil {
+		t.Error("LZW/1 checksum mismatch wasn't caught")
+	}
+
+}
+
+// TestNuFXFixtures checks archives made by ShrinkIt or NuLib2, rather than
+// by testNuFXCompress, against the files that went into them. Each
+// testdata/nufx/NAME.shk has a directory testdata/nufx/NAME beside it
+// holding the original data forks, laid out by their paths in the archive.
+// ShrinkIt 1.x wrote LZW/1, GS/ShrinkIt and NuLib2 write LZW/2.
+func TestNuFXFixtures(t *testing.T) {
+
+	archives, err := filepath.Glob(filepath.Join("testdata", "nufx", "*.shk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) == 0 {
+		t.Skip("no ShrinkIt or NuLib2 archives in testdata/nufx")
+	}
+
+	formats := map[NuFXThreadFormat]int{}
+
+	for _, name := range archives {
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := ParseNuFX(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		dir := strings.TrimSuffix(name, ".shk")
+		for _, r := range a.FileRecords() {
+
+			want, err := os.ReadFile(filepath.Join(append([]string{dir}, r.GetPathParts()...)...))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			got, err := r.DataFork()
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, r.Filename, err)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: %s expanded to %d bytes that don't match the original %d", name, r.Filename, len(got), len(want))
+			}
+
+			for _, thread := range r.Threads {
+				if thread.Class == NuFXThreadClass_Data {
+					formats[thread.Format]++
+				}
+			}
+
+		}
+
+	}
+
+	for format, n := range formats {
+		t.Logf("%d %s threads", n, format)
+	}
+
+}
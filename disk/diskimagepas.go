@@ -1,16 +1,23 @@
 package disk
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 const PASCAL_BLOCK_SIZE = 512
 const PASCAL_VOLUME_BLOCK = 2
 const PASCAL_MAX_VOLUME_NAME = 7
+const PASCAL_MAX_FILE_NAME = 15
 const PASCAL_DIRECTORY_ENTRY_LENGTH = 26
 const PASCAL_OVERSIZE_DIR = 32
+const PASCAL_TEXT_PAGE = 1024
+const PASCAL_TEXT_DLE = 0x10
 
 func (dsk *DSKWrapper) IsPascal() (bool, string) {
 
@@ -118,12 +125,30 @@ func (ft PascalFileType) Ext() string {
 
 }
 
+// PascalSuffixMap gives the name suffix the p-System uses for each type
+var PascalSuffixMap = map[PascalFileType]string{
+	FileType_PAS_CODE: "CODE",
+	FileType_PAS_TEXT: "TEXT",
+	FileType_PAS_INFO: "INFO",
+	FileType_PAS_DATA: "DATA",
+	FileType_PAS_GRAF: "GRAF",
+	FileType_PAS_FOTO: "FOTO",
+}
+
 func PascalFileTypeFromExt(ext string) PascalFileType {
 	for ft, info := range PascalTypeMap {
 		if strings.ToUpper(ext) == info[0] {
 			return ft
 		}
 	}
+	for ft, suffix := range PascalSuffixMap {
+		if strings.ToUpper(ext) == suffix {
+			return ft
+		}
+	}
+	if strings.ToUpper(ext) == "TXT" {
+		return FileType_PAS_TEXT
+	}
 	return 0x00
 }
 
@@ -148,6 +173,11 @@ func (pvh *PascalVolumeHeader) GetNumFiles() int {
 	return int(pvh.data[0x10]) + 256*int(pvh.data[0x11])
 }
 
+func (pvh *PascalVolumeHeader) SetNumFiles(n int) {
+	pvh.data[0x10] = byte(n & 0xff)
+	pvh.data[0x11] = byte(n / 0x100)
+}
+
 type PascalFileEntry struct {
 	data [PASCAL_DIRECTORY_ENTRY_LENGTH]byte
 }
@@ -160,8 +190,9 @@ func (pfe *PascalFileEntry) SetData(data []byte) {
 	}
 }
 
+// IsLocked is always false, Pascal has no notion of locked files
 func (pvh *PascalFileEntry) IsLocked() bool {
-	return true
+	return false
 }
 
 func (pvh *PascalFileEntry) GetStartBlock() int {
@@ -193,6 +224,74 @@ func (pvh *PascalFileEntry) GetFileSize() int {
 	return pvh.GetBytesRemaining() + (pvh.GetNextBlock()-pvh.GetStartBlock()-1)*PASCAL_BLOCK_SIZE
 }
 
+func (pvh *PascalFileEntry) SetStartBlock(b int) {
+	pvh.data[0x00] = byte(b & 0xff)
+	pvh.data[0x01] = byte(b / 0x100)
+}
+
+func (pvh *PascalFileEntry) SetNextBlock(b int) {
+	pvh.data[0x02] = byte(b & 0xff)
+	pvh.data[0x03] = byte(b / 0x100)
+}
+
+func (pvh *PascalFileEntry) SetType(t PascalFileType) {
+	pvh.data[0x04] = byte(t & 0xff)
+	pvh.data[0x05] = byte(t / 0x100)
+}
+
+func (pvh *PascalFileEntry) SetName(name string) {
+	if len(name) > PASCAL_MAX_FILE_NAME {
+		name = name[:PASCAL_MAX_FILE_NAME]
+	}
+	pvh.data[0x06] = byte(len(name))
+	for i := 0; i < PASCAL_MAX_FILE_NAME; i++ {
+		if i < len(name) {
+			pvh.data[0x07+i] = name[i]
+		} else {
+			pvh.data[0x07+i] = 0x00
+		}
+	}
+}
+
+// SetBytesRemaining sets the number of bytes used in the last block
+func (pvh *PascalFileEntry) SetBytesRemaining(b int) {
+	pvh.data[0x16] = byte(b & 0xff)
+	pvh.data[0x17] = byte(b / 0x100)
+}
+
+func (pvh *PascalFileEntry) GetDate() time.Time {
+	return pascalDateToTime(int(pvh.data[0x18]) + 256*int(pvh.data[0x19]))
+}
+
+func (pvh *PascalFileEntry) SetDate(t time.Time) {
+	v := timeToPascalDate(t)
+	pvh.data[0x18] = byte(v & 0xff)
+	pvh.data[0x19] = byte(v / 0x100)
+}
+
+func (pvh *PascalFileEntry) GetBlocks() int {
+	return pvh.GetNextBlock() - pvh.GetStartBlock()
+}
+
+// Pascal dates pack the month into bits 0-3, the day into bits 4-8 and
+// the year less 1900 into bits 9-15.
+func pascalDateToTime(v int) time.Time {
+	month := v & 0x0f
+	day := (v >> 4) & 0x1f
+	year := (v >> 9) & 0x7f
+	if month < 1 || month > 12 || day < 1 {
+		return time.Time{}
+	}
+	return time.Date(1900+year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+}
+
+func timeToPascalDate(t time.Time) int {
+	if t.IsZero() {
+		return 0
+	}
+	return int(t.Month()) | t.Day()<<4 | ((t.Year()-1900)&0x7f)<<9
+}
+
 func (dsk *DSKWrapper) PascalGetCatalog(pattern string) ([]*PascalFileEntry, error) {
 
 	pattern = strings.Replace(pattern, ".", "[.]", -1)
@@ -312,3 +411,539 @@ func (dsk *DSKWrapper) PascalReadFile(file *PascalFileEntry) ([]byte, error) {
 	return data, nil
 
 }
+
+// PascalTextEncode lays text out as a Pascal TEXT file: an empty editor
+// header page, then pages of whole lines padded out with NULs. Leading
+// spaces are packed as DLE and a count. Lines may end with CR, LF or
+// both, and are stored with CR.
+func PascalTextEncode(text []byte) []byte {
+
+	out := make([]byte, PASCAL_TEXT_PAGE)
+	page := make([]byte, 0, PASCAL_TEXT_PAGE)
+
+	flush := func() {
+		out = append(out, page...)
+		out = append(out, make([]byte, PASCAL_TEXT_PAGE-len(page))...)
+		page = page[:0]
+	}
+
+	text = StripText(text)
+	text = bytes.Replace(text, []byte("\r\n"), []byte("\r"), -1)
+	text = bytes.Replace(text, []byte("\n"), []byte("\r"), -1)
+	text = bytes.TrimSuffix(text, []byte("\r"))
+
+	for _, line := range bytes.Split(text, []byte("\r")) {
+
+		indent := 0
+		for indent < len(line) && indent < 0xff-32 && line[indent] == ' ' {
+			indent++
+		}
+
+		var l []byte
+		if indent > 0 {
+			l = append(l, PASCAL_TEXT_DLE, byte(32+indent))
+		}
+		l = append(l, line[indent:]...)
+		l = append(l, '\r')
+
+		// a line never straddles pages, unless it is longer than one
+		for len(l) > 0 {
+			if len(page)+len(l) >= PASCAL_TEXT_PAGE && len(page) > 0 {
+				flush()
+			}
+			n := len(l)
+			if n > PASCAL_TEXT_PAGE-1 {
+				n = PASCAL_TEXT_PAGE - 1
+			}
+			page = append(page, l[:n]...)
+			l = l[n:]
+		}
+
+	}
+
+	if len(page) > 0 {
+		flush()
+	}
+
+	return out
+
+}
+
+// PascalTextDecode turns a Pascal TEXT file back into plain text with CR
+// line endings, dropping the header page and page padding.
+func PascalTextDecode(data []byte) []byte {
+
+	if len(data) < PASCAL_TEXT_PAGE {
+		return StripText(data)
+	}
+
+	var out []byte
+	data = data[PASCAL_TEXT_PAGE:]
+
+	for i := 0; i < len(data); i++ {
+		switch ch := data[i] & 0x7f; {
+		case ch == 0:
+		case ch == PASCAL_TEXT_DLE && i+1 < len(data):
+			i++
+			if n := int(data[i]) - 32; n > 0 {
+				out = append(out, bytes.Repeat([]byte{' '}, n)...)
+			}
+		default:
+			out = append(out, ch)
+		}
+	}
+
+	return out
+
+}
+
+// pascalGetDirectory reads the volume header and every file entry, in
+// the order they sit in the directory.
+func (dsk *DSKWrapper) pascalGetDirectory() (*PascalVolumeHeader, []*PascalFileEntry, error) {
+
+	files, err := dsk.PascalGetCatalog("*")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := dsk.PRODOSGetBlock(PASCAL_VOLUME_BLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pvh := &PascalVolumeHeader{}
+	pvh.SetData(d)
+
+	return pvh, files, nil
+
+}
+
+// pascalPutDirectory writes the directory back, the entries are kept in
+// block order with no gaps as the p-System expects.
+func (dsk *DSKWrapper) pascalPutDirectory(pvh *PascalVolumeHeader, files []*PascalFileEntry) error {
+
+	numBlocks := pvh.GetNextBlock() - PASCAL_VOLUME_BLOCK
+	if len(files) > pascalMaxFiles(pvh) {
+		return errors.New("Directory full")
+	}
+
+	sort.Sort(pascalFilesByBlock(files))
+
+	pvh.SetNumFiles(len(files))
+
+	catdata := make([]byte, numBlocks*PASCAL_BLOCK_SIZE)
+	copy(catdata, pvh.data[:])
+	for i, fd := range files {
+		copy(catdata[(i+1)*PASCAL_DIRECTORY_ENTRY_LENGTH:], fd.data[:])
+	}
+
+	for i := 0; i < numBlocks; i++ {
+		err := dsk.PRODOSWrite(PASCAL_VOLUME_BLOCK+i, catdata[i*PASCAL_BLOCK_SIZE:(i+1)*PASCAL_BLOCK_SIZE])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func pascalMaxFiles(pvh *PascalVolumeHeader) int {
+	return (pvh.GetNextBlock()-PASCAL_VOLUME_BLOCK)*PASCAL_BLOCK_SIZE/PASCAL_DIRECTORY_ENTRY_LENGTH - 1
+}
+
+type pascalFilesByBlock []*PascalFileEntry
+
+func (f pascalFilesByBlock) Len() int {
+	return len(f)
+}
+
+func (f pascalFilesByBlock) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (f pascalFilesByBlock) Less(i, j int) bool {
+	return f[i].GetStartBlock() < f[j].GetStartBlock()
+}
+
+// pascalVolumeBlocks is the last usable block on the volume
+func (dsk *DSKWrapper) pascalVolumeBlocks(pvh *PascalVolumeHeader) int {
+	total := pvh.GetTotalBlocks()
+	if total <= 0 || total > dsk.Format.BPD() {
+		total = dsk.Format.BPD()
+	}
+	return total
+}
+
+func pascalFindEntry(files []*PascalFileEntry, name string) int {
+	for i, fd := range files {
+		if strings.EqualFold(fd.GetName(), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PascalFileName checks a name is fit for a Pascal volume and returns it
+// in the upper case the p-System uses.
+func PascalFileName(name string) (string, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" || len(name) > PASCAL_MAX_FILE_NAME {
+		return name, errors.New("Pascal file names must be 1 to 15 characters")
+	}
+	for _, ch := range name {
+		if ch <= 0x20 || ch >= 0x7f || strings.ContainsRune("$=?,[#:", ch) {
+			return name, errors.New("Invalid character in file name: " + string(ch))
+		}
+	}
+	return name, nil
+}
+
+// PascalWriteFile stores a file in the first free extent large enough to
+// hold it, replacing any file of the same name. TEXT data is stored as
+// given, see PascalTextEncode. As Pascal files have to
+// be contiguous, a volume with enough free space may still need krunching
+// before a file will fit.
+func (dsk *DSKWrapper) PascalWriteFile(name string, kind PascalFileType, data []byte) error {
+
+	name, err := PascalFileName(name)
+	if err != nil {
+		return err
+	}
+
+	pvh, files, err := dsk.pascalGetDirectory()
+	if err != nil {
+		return err
+	}
+
+	if i := pascalFindEntry(files, name); i >= 0 {
+		files = append(files[:i], files[i+1:]...)
+	}
+
+	if len(files) >= pascalMaxFiles(pvh) {
+		return errors.New("Directory full")
+	}
+
+	needed := (len(data) + PASCAL_BLOCK_SIZE - 1) / PASCAL_BLOCK_SIZE
+	if needed == 0 {
+		needed = 1
+	}
+
+	start, largest, free := dsk.pascalFindExtent(pvh, files, needed)
+	if start < 0 {
+		if free >= needed {
+			return fmt.Errorf("No contiguous space for %d blocks (largest free area is %d blocks), krunch the volume", needed, largest)
+		}
+		return errors.New("Disk full")
+	}
+
+	for i := 0; i < needed; i++ {
+		chunk := make([]byte, PASCAL_BLOCK_SIZE)
+		if i*PASCAL_BLOCK_SIZE < len(data) {
+			copy(chunk, data[i*PASCAL_BLOCK_SIZE:])
+		}
+		err = dsk.PRODOSWrite(start+i, chunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	last := len(data) - (needed-1)*PASCAL_BLOCK_SIZE
+
+	fd := &PascalFileEntry{}
+	fd.SetStartBlock(start)
+	fd.SetNextBlock(start + needed)
+	fd.SetType(kind)
+	fd.SetName(name)
+	fd.SetBytesRemaining(last)
+	fd.SetDate(time.Now())
+
+	return dsk.pascalPutDirectory(pvh, append(files, fd))
+
+}
+
+// pascalFindExtent finds the first gap of at least the needed number of
+// blocks, along with the largest gap and the total free.
+func (dsk *DSKWrapper) pascalFindExtent(pvh *PascalVolumeHeader, files []*PascalFileEntry, needed int) (int, int, int) {
+
+	sorted := make([]*PascalFileEntry, len(files))
+	copy(sorted, files)
+	sort.Sort(pascalFilesByBlock(sorted))
+
+	start := -1
+	largest := 0
+	free := 0
+
+	next := pvh.GetNextBlock()
+	gap := func(from, to int) {
+		size := to - from
+		if size <= 0 {
+			return
+		}
+		free += size
+		if size > largest {
+			largest = size
+		}
+		if start < 0 && size >= needed {
+			start = from
+		}
+	}
+
+	for _, fd := range sorted {
+		gap(next, fd.GetStartBlock())
+		if fd.GetNextBlock() > next {
+			next = fd.GetNextBlock()
+		}
+	}
+	gap(next, dsk.pascalVolumeBlocks(pvh))
+
+	return start, largest, free
+
+}
+
+func (dsk *DSKWrapper) PascalDeleteFile(name string) error {
+
+	pvh, files, err := dsk.pascalGetDirectory()
+	if err != nil {
+		return err
+	}
+
+	i := pascalFindEntry(files, name)
+	if i < 0 {
+		return errors.New("Not found")
+	}
+
+	return dsk.pascalPutDirectory(pvh, append(files[:i], files[i+1:]...))
+
+}
+
+func (dsk *DSKWrapper) PascalRenameFile(name, newname string) error {
+
+	newname, err := PascalFileName(newname)
+	if err != nil {
+		return err
+	}
+
+	pvh, files, err := dsk.pascalGetDirectory()
+	if err != nil {
+		return err
+	}
+
+	i := pascalFindEntry(files, name)
+	if i < 0 {
+		return errors.New("Not found")
+	}
+
+	if j := pascalFindEntry(files, newname); j >= 0 && j != i {
+		return errors.New("A file with the new name already exists")
+	}
+
+	files[i].SetName(newname)
+
+	return dsk.pascalPutDirectory(pvh, files)
+
+}
+
+// PascalSetDate changes the date on a file
+func (dsk *DSKWrapper) PascalSetDate(name string, t time.Time) error {
+
+	pvh, files, err := dsk.pascalGetDirectory()
+	if err != nil {
+		return err
+	}
+
+	i := pascalFindEntry(files, name)
+	if i < 0 {
+		return errors.New("Not found")
+	}
+
+	files[i].SetDate(t)
+
+	return dsk.pascalPutDirectory(pvh, files)
+
+}
+
+// PascalKrunch slides every file down towards the directory so that all
+// the free space ends up in one area at the end of the volume. It returns
+// the number of files moved.
+func (dsk *DSKWrapper) PascalKrunch() (int, error) {
+
+	pvh, files, err := dsk.pascalGetDirectory()
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Sort(pascalFilesByBlock(files))
+
+	moved := 0
+	next := pvh.GetNextBlock()
+	for _, fd := range files {
+
+		start := fd.GetStartBlock()
+		length := fd.GetBlocks()
+		if length < 0 || start+length > dsk.pascalVolumeBlocks(pvh) {
+			return moved, errors.New("Bad extent for " + fd.GetName() + ", not krunching")
+		}
+
+		if start > next {
+			// moving down, so copying from the front is safe
+			for i := 0; i < length; i++ {
+				chunk, err := dsk.PRODOSGetBlock(start + i)
+				if err != nil {
+					return moved, err
+				}
+				err = dsk.PRODOSWrite(next+i, chunk)
+				if err != nil {
+					return moved, err
+				}
+			}
+			fd.SetStartBlock(next)
+			fd.SetNextBlock(next + length)
+			moved++
+		}
+
+		next = fd.GetNextBlock()
+
+	}
+
+	return moved, dsk.pascalPutDirectory(pvh, files)
+
+}
+
+// pascalDriver exposes Apple Pascal volumes through the Driver interface.
+type pascalDriver struct{}
+
+func init() {
+	RegisterDriver(pascalDriver{}, DF_PASCAL)
+}
+
+func (pascalDriver) Name() string {
+	return "Pascal"
+}
+
+func (pascalDriver) Caps() DriverCaps {
+	return CapWrite
+}
+
+func (pascalDriver) BlockSize() int {
+	return PASCAL_BLOCK_SIZE
+}
+
+func (pascalDriver) MaxNameLength() int {
+	return PASCAL_MAX_FILE_NAME
+}
+
+func (pascalDriver) Catalog(dsk *DSKWrapper, path string, pattern string) ([]*CatalogEntry, error) {
+
+	files, err := dsk.PascalGetCatalog(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*CatalogEntry, 0, len(files))
+	for _, fd := range files {
+		out = append(out, &CatalogEntry{
+			Name:     fd.GetName(),
+			Kind:     int(fd.GetType()),
+			Type:     fd.GetType().String(),
+			Ext:      fd.GetType().Ext(),
+			Class:    pascalDriver{}.Class(int(fd.GetType())),
+			Locked:   fd.IsLocked(),
+			Created:  fd.GetDate(),
+			Modified: fd.GetDate(),
+			fd:       fd,
+		})
+	}
+
+	return out, nil
+
+}
+
+// ReadFile gives TEXT files as plain text, as other text files are given
+func (pascalDriver) ReadFile(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error) {
+	fd, ok := e.fd.(*PascalFileEntry)
+	if !ok {
+		return nil, errors.New("Not a Pascal catalog entry")
+	}
+	data, err := dsk.PascalReadFile(fd)
+	if err == nil && fd.GetType() == FileType_PAS_TEXT {
+		data = PascalTextDecode(data)
+	}
+	return data, err
+}
+
+// WriteFile gives the name the usual suffix for the type if it has none,
+// and lays out TEXT files in pages the p-System editor can read
+func (pascalDriver) WriteFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error {
+	if !strings.Contains(name, ".") {
+		if suffix, ok := PascalSuffixMap[PascalFileType(kind)]; ok {
+			name += "." + suffix
+		}
+	}
+	if PascalFileType(kind) == FileType_PAS_TEXT {
+		data = PascalTextEncode(data)
+	}
+	return dsk.PascalWriteFile(name, PascalFileType(kind), data)
+}
+
+func (pascalDriver) DeleteFile(dsk *DSKWrapper, path string, name string) error {
+	return dsk.PascalDeleteFile(name)
+}
+
+func (pascalDriver) RenameFile(dsk *DSKWrapper, path string, name string, newname string) error {
+	return dsk.PascalRenameFile(name, newname)
+}
+
+func (pascalDriver) SetLocked(dsk *DSKWrapper, path string, name string, lock bool) error {
+	return ErrNotSupported
+}
+
+// SetFileInfo keeps the modified date, the only one Pascal has room for
+func (pascalDriver) SetFileInfo(dsk *DSKWrapper, path string, name string, access ProDOSAccessMode, created, modified time.Time) error {
+	if modified.IsZero() {
+		return nil
+	}
+	return dsk.PascalSetDate(name, modified)
+}
+
+func (pascalDriver) Mkdir(dsk *DSKWrapper, path string, name string) error {
+	return ErrNotSupported
+}
+
+func (pascalDriver) UsedBitmap(dsk *DSKWrapper) ([]bool, error) {
+	return dsk.PascalUsedBitmap()
+}
+
+func (pascalDriver) Check(dsk *DSKWrapper, repair bool) ([]*CheckProblem, error) {
+	return dsk.PascalCheck(repair)
+}
+
+func (pascalDriver) TypeFromExt(ext string) int {
+	return int(PascalFileTypeFromExt(ext))
+}
+
+func (pascalDriver) TypeFromProDOS(t ProDOSFileType) int {
+	switch t {
+	case FileType_PD_TXT, 0x03:
+		return int(FileType_PAS_TEXT)
+	case 0x02:
+		return int(FileType_PAS_CODE)
+	case 0x08:
+		return int(FileType_PAS_FOTO)
+	}
+	return int(FileType_PAS_DATA)
+}
+
+func (pascalDriver) Class(kind int) CatalogEntryType {
+	switch PascalFileType(kind) {
+	case FileType_PAS_TEXT:
+		return CETText
+	case FileType_PAS_CODE:
+		return CETPascal
+	case FileType_PAS_DATA:
+		return CETData
+	case FileType_PAS_GRAF, FileType_PAS_FOTO:
+		return CETGraphics
+	}
+	return CETUnknown
+}
@@ -0,0 +1,178 @@
+package disk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testPascalDisk makes an empty 140K Pascal volume
+func testPascalDisk(t *testing.T) *DSKWrapper {
+
+	t.Helper()
+
+	blank := &DSKWrapper{Data: make([]byte, STD_DISK_BYTES), Format: GetDiskFormat(DF_PASCAL), Layout: SectorOrderDOS33}
+
+	vh := make([]byte, PASCAL_BLOCK_SIZE)
+	vh[0x02] = 6
+	vh[0x06] = 4
+	copy(vh[0x07:], "TEST")
+	vh[0x0e], vh[0x0f] = PRODOS_BLOCKS_PER_DISK%256, PRODOS_BLOCKS_PER_DISK/256
+	if err := blank.PRODOSWrite(PASCAL_VOLUME_BLOCK, vh); err != nil {
+		t.Fatal(err)
+	}
+
+	dsk, err := NewDSKWrapperBin(nil, blank.Data, "test.dsk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsk.Format.ID != DF_PASCAL {
+		t.Fatalf("loaded as %s", dsk.Format)
+	}
+
+	return dsk
+
+}
+
+// testPascalFile reads a file back by name
+func testPascalFile(t *testing.T, dsk *DSKWrapper, name string) []byte {
+
+	t.Helper()
+
+	files, err := dsk.PascalGetCatalog(name)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("%s: %d files, %v", name, len(files), err)
+	}
+	data, err := dsk.PascalReadFile(files[0])
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return data
+
+}
+
+// testPascalCatalog lists every file on the volume
+func testPascalCatalog(t *testing.T, dsk *DSKWrapper) []*PascalFileEntry {
+
+	t.Helper()
+
+	files, err := dsk.PascalGetCatalog("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+
+}
+
+func TestPascalKrunch(t *testing.T) {
+
+	dsk := testPascalDisk(t)
+
+	// 274 blocks free after the directory
+	files := map[string][]byte{
+		"A.DATA": testData(70, 100*PASCAL_BLOCK_SIZE),
+		"B.DATA": testData(71, 100*PASCAL_BLOCK_SIZE),
+		"C.DATA": testData(72, 60*PASCAL_BLOCK_SIZE-100),
+	}
+	for _, name := range []string{"A.DATA", "B.DATA", "C.DATA"} {
+		if err := dsk.PascalWriteFile(name, FileType_PAS_DATA, files[name]); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	if err := dsk.PascalDeleteFile("A.DATA"); err != nil {
+		t.Fatal(err)
+	}
+	if files := testPascalCatalog(t, dsk); len(files) != 2 {
+		t.Fatalf("%d files after the delete", len(files))
+	}
+
+	// 114 blocks free, but not in one piece
+	big := testData(73, 110*PASCAL_BLOCK_SIZE)
+	if err := dsk.PascalWriteFile("D.DATA", FileType_PAS_DATA, big); err == nil {
+		t.Fatal("wrote a file larger than any free area")
+	}
+
+	moved, err := dsk.PascalKrunch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 2 {
+		t.Errorf("krunch moved %d files, want 2", moved)
+	}
+
+	if err := dsk.PascalWriteFile("D.DATA", FileType_PAS_DATA, big); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsk.PascalRenameFile("D.DATA", "E.DATA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsk.PascalRenameFile("E.DATA", "B.DATA"); err == nil {
+		t.Error("renamed over an existing file")
+	}
+
+	files["E.DATA"] = big
+	for _, name := range []string{"B.DATA", "C.DATA", "E.DATA"} {
+		if data := testPascalFile(t, dsk, name); !bytes.Equal(data, files[name]) {
+			t.Errorf("%s: read back %d bytes, wrote %d", name, len(data), len(files[name]))
+		}
+	}
+
+}
+
+func TestPascalText(t *testing.T) {
+
+	dsk := testPascalDisk(t)
+	drv, err := dsk.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text []byte
+	text = append(text, "PROGRAM HELLO;\nBEGIN\n    WRITELN('HI')\nEND.\n"...)
+	for len(text) < 3000 {
+		text = append(text, "  { padding out to more than one page }\n"...)
+	}
+
+	if err := drv.WriteFile(dsk, "", "HELLO", int(FileType_PAS_TEXT), text, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := dsk.PascalGetCatalog("HELLO.TEXT")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("%d files, %v", len(files), err)
+	}
+	raw, err := dsk.PascalReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header page, then pages ending in NULs, with indents packed
+	if len(raw)%PASCAL_TEXT_PAGE != 0 || len(raw) < 4*PASCAL_TEXT_PAGE {
+		t.Fatalf("%d bytes isn't header and pages", len(raw))
+	}
+	if !bytes.Equal(raw[:PASCAL_TEXT_PAGE], make([]byte, PASCAL_TEXT_PAGE)) {
+		t.Error("header page isn't blank")
+	}
+	if !bytes.HasPrefix(raw[PASCAL_TEXT_PAGE:], []byte("PROGRAM HELLO;\rBEGIN\r\x10\x24WRITELN('HI')\rEND.\r")) {
+		t.Errorf("text stored as %q", raw[PASCAL_TEXT_PAGE:PASCAL_TEXT_PAGE+40])
+	}
+	for p := 2 * PASCAL_TEXT_PAGE; p < len(raw); p += PASCAL_TEXT_PAGE {
+		if raw[p-1] != 0 || raw[p-2] != 0 && raw[p-2] != '\r' {
+			t.Errorf("page ending at %d splits a line", p)
+		}
+	}
+
+	entries, err := drv.Catalog(dsk, "", "HELLO.TEXT")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("%d entries, %v", len(entries), err)
+	}
+	got, err := drv.ReadFile(dsk, entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(string(text), "\n", "\r", -1); string(got) != want {
+		t.Errorf("read back %q", got)
+	}
+
+}
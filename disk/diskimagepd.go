@@ -16,7 +16,7 @@ type VDH struct {
 
 func (fd *VDH) CreateTime() time.Time {
 
-	b := fd.Data[0x1C:0x20]
+	b := fd.Data[0x18:0x1C]
 
 	return prodosStampBytesToTime(b)
 
@@ -26,7 +26,7 @@ func (fd *VDH) SetCreateTime(t time.Time) {
 
 	b := timeToProdosStampBytes(t)
 	for i, v := range b {
-		fd.Data[0x1C+i] = v
+		fd.Data[0x18+i] = v
 	}
 
 }
@@ -151,6 +151,11 @@ func (fd *VDH) GetBitmapPointer() int {
 	return int(fd.Data[35]) + 256*int(fd.Data[36])
 }
 
+func (fd *VDH) SetBitmapPointer(b int) {
+	fd.Data[35] = byte(b & 0xff)
+	fd.Data[36] = byte(b / 0x100)
+}
+
 func (fd *VDH) GetTotalBlocks() int {
 	return int(fd.Data[37]) + 256*int(fd.Data[38])
 }
@@ -161,7 +166,7 @@ func (fd *VDH) SetTotalBlocks(b int) {
 }
 
 func (fd *VDH) GetDirParentPointer() int {
-	return int(fd.Data[35]) + 256*int(fd.Data[35])
+	return int(fd.Data[35]) + 256*int(fd.Data[36])
 }
 
 func (fd *VDH) SetDirParentPointer(b int) {
@@ -212,7 +217,7 @@ func (dsk *DSKWrapper) IsProDOS() (bool, DiskFormat, SectorOrder) {
 
 	if len(dsk.Data) == STD_DISK_BYTES {
 
-		layouts := []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear}
+		layouts := dsk.rankedLayouts(DF_PRODOS, []SectorOrder{SectorOrderDOS33, SectorOrderDOS33Alt, SectorOrderProDOS, SectorOrderProDOSLinear})
 
 		for _, l := range layouts {
 
@@ -291,9 +296,19 @@ func (dsk *DSKWrapper) PRODOSGetVolumeBitmap() (ProDOSVolumeBitmap, error) {
 
 	b := vdh.GetBitmapPointer()
 
-	data, err := dsk.PRODOSGetBlock(b)
-	if err != nil {
-		return vb, err
+	// each bitmap block covers 4096 blocks, larger volumes use a run of them
+	count := PRODOSBitmapBlocks(vdh.GetTotalBlocks())
+	if count < 1 {
+		count = 1
+	}
+
+	data := make([]byte, 0, count*PRODOS_BLOCK_BYTES)
+	for i := 0; i < count; i++ {
+		chunk, err := dsk.PRODOSGetBlock(b + i)
+		if err != nil {
+			return vb, err
+		}
+		data = append(data, chunk...)
 	}
 
 	//copy(vb[:], data)
@@ -319,6 +334,10 @@ func (vb ProDOSVolumeBitmap) IsBlockFree(b int) bool {
 	bit := 7 - (b % 8)
 	mask := byte(1 << uint(bit))
 
+	if bidx >= len(vb.Data) {
+		return false
+	}
+
 	return (vb.Data[bidx] & mask) == mask
 
 }
@@ -329,6 +348,10 @@ func (vb ProDOSVolumeBitmap) SetBlockFree(b int, free bool) {
 	setmask := byte(1 << uint(bit))
 	clrmask := 0xff ^ setmask
 
+	if bidx >= len(vb.Data) {
+		return
+	}
+
 	if free {
 		vb.Data[bidx] = vb.Data[bidx] | setmask
 	} else {
@@ -414,6 +437,28 @@ func (fd *ProDOSFileDescriptor) NameUnadorned() string {
 
 }
 
+// NameOK checks the name is one ProDOS would make: a letter, then letters,
+// digits or periods
+func (fd *ProDOSFileDescriptor) NameOK() bool {
+
+	l := fd.GetNameLength()
+	if l == 0 {
+		return false
+	}
+
+	for i, v := range fd.Data[1 : 1+l] {
+		switch {
+		case v >= 'A' && v <= 'Z':
+		case i > 0 && ((v >= '0' && v <= '9') || v == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+
+}
+
 func (fd *ProDOSFileDescriptor) SetName(name string) {
 
 	name = strings.ToUpper(name)
@@ -568,11 +613,17 @@ const (
 	StorageType_Seedling      ProDOSStorageType = 0x1
 	StorageType_Sapling       ProDOSStorageType = 0x2
 	StorageType_Tree          ProDOSStorageType = 0x3
+	StorageType_Extended      ProDOSStorageType = 0x5
 	StorageType_SubDir_File   ProDOSStorageType = 0xd
 	StorageType_SubDir_Header ProDOSStorageType = 0xe
 	StorageType_Volume_Header ProDOSStorageType = 0xf
 )
 
+// The extended key block of a forked file has a mini entry for the data
+// fork at the start and one for the resource fork half way
+const PRODOS_FORK_ENTRY_SIZE = 8
+const PRODOS_FORK_ENTRY_OFFSET = 0x100
+
 type ProDOSFileType byte
 
 const (
@@ -779,6 +830,17 @@ func (fd *ProDOSFileDescriptor) SetTotalBlocks(b int) {
 	fd.Data[20] = byte(b / 256)
 }
 
+// IsSparse is true when a file has fewer blocks than its length needs, so
+// some of them are holes
+func (fd *ProDOSFileDescriptor) IsSparse() bool {
+	switch fd.GetStorageType() {
+	case StorageType_Sapling, StorageType_Tree:
+		_, blocks, err := PRODOSStorageForSize(fd.Size())
+		return err == nil && fd.TotalBlocks() < blocks
+	}
+	return false
+}
+
 func (fd *ProDOSFileDescriptor) AuxType() int {
 	return int(fd.Data[31]) + 256*int(fd.Data[32])
 }
@@ -951,11 +1013,10 @@ func (d *DSKWrapper) PRODOSGetCatalog(startblock int, pattern string) (*VDH, []P
 		return vtoc, files, e
 	}
 
-	activeentries := 0
-	filecount := vtoc.GetFileCount()
 	blockentries := 2
 	entriesperblock := vtoc.GetEntriesPerBlock()
 	refnum := startblock
+	seen := map[int]bool{refnum: true}
 
 	var data []byte
 	if d.Format.ID == DF_PRODOS_800KB {
@@ -966,13 +1027,14 @@ func (d *DSKWrapper) PRODOSGetCatalog(startblock int, pattern string) (*VDH, []P
 
 	nextblock := int(data[2]) + 256*int(data[3])
 
-	//fmt.Printf("ActiveCount = %d\n", filecount)
-
 	entrypointer := 4 + PRODOS_ENTRY_SIZE
 
-	for activeentries < filecount {
+	// deleted entries keep their names, and can sit anywhere in the
+	// directory, so every slot is looked at rather than stopping after
+	// the file count
+	for {
 
-		if data[entrypointer] != 0x00 {
+		if ProDOSStorageType(data[entrypointer]>>4) != StorageType_Inactive {
 			// Valid entry
 			chunk := data[entrypointer : entrypointer+PRODOS_ENTRY_SIZE]
 			fd := ProDOSFileDescriptor{}
@@ -986,33 +1048,33 @@ func (d *DSKWrapper) PRODOSGetCatalog(startblock int, pattern string) (*VDH, []P
 					skipname = !re.MatchString(fd.Name())
 				}
 
-				if fd.GetStorageType() != StorageType_Inactive && !skipname {
+				if !skipname {
 					files = append(files, fd)
 				}
 
 			}
-
-			activeentries++
 		}
 
-		if activeentries < filecount {
-			if blockentries == entriesperblock {
-				refnum = nextblock
-				if d.Format.ID == DF_PRODOS_800KB {
-					data, err = d.PRODOS800GetBlock(refnum)
-				} else {
-					data, err = d.PRODOSGetBlock(refnum)
-				}
-				if err != nil {
-					break
-				}
-				nextblock = int(data[2]) + 256*int(data[3])
-				blockentries = 0x01
-				entrypointer = 0x04
+		if blockentries == entriesperblock || entrypointer+2*PRODOS_ENTRY_SIZE > len(data) {
+			if nextblock == 0 || seen[nextblock] {
+				break
+			}
+			refnum = nextblock
+			seen[refnum] = true
+			if d.Format.ID == DF_PRODOS_800KB {
+				data, err = d.PRODOS800GetBlock(refnum)
 			} else {
-				entrypointer += PRODOS_ENTRY_SIZE
-				blockentries++
+				data, err = d.PRODOSGetBlock(refnum)
 			}
+			if err != nil {
+				break
+			}
+			nextblock = int(data[2]) + 256*int(data[3])
+			blockentries = 0x01
+			entrypointer = 0x04
+		} else {
+			entrypointer += PRODOS_ENTRY_SIZE
+			blockentries++
 		}
 
 	}
@@ -1052,7 +1114,10 @@ func (d *DSKWrapper) PRODOSReadFileSectors(fd ProDOSFileDescriptor, maxblocks in
 
 			//fmt.Printf("File block %d (%d %d)\n", blocknum, int(index[bptr]), int(index[bptr+1]))
 
-			if d.Format.ID == DF_PRODOS_800KB {
+			if blocknum == 0 {
+				// hole in a sparse file
+				chunk = make([]byte, PRODOS_BLOCK_BYTES)
+			} else if d.Format.ID == DF_PRODOS_800KB {
 				chunk, e = d.PRODOS800GetBlock(blocknum)
 			} else {
 				chunk, e = d.PRODOSGetBlock(blocknum)
@@ -1072,8 +1137,55 @@ func (d *DSKWrapper) PRODOSReadFileSectors(fd ProDOSFileDescriptor, maxblocks in
 			remaining = fd.Size() - len(data)
 		}
 		return data, e
+	case StorageType_Extended:
+		/* GS/OS forked file, the data fork is the file */
+		dfd, _, e := d.PRODOSGetForks(fd)
+		if e != nil {
+			return []byte(nil), e
+		}
+		return d.PRODOSReadFileSectors(dfd, maxblocks)
 	case StorageType_Tree:
-		return []byte(nil), errors.New("Unimplemented yet... soz :(")
+		/* master index points to up to 128 index blocks */
+		var master []byte
+		if d.Format.ID == DF_PRODOS_800KB {
+			master, _ = d.PRODOS800GetBlock(fd.IndexBlock())
+		} else {
+			master, _ = d.PRODOSGetBlock(fd.IndexBlock())
+		}
+		data := make([]byte, 0)
+		for mptr := 0; len(data) < fd.Size() && mptr < 128; mptr++ {
+			indexnum := int(master[mptr]) + 256*int(master[mptr+256])
+			if indexnum == 0 {
+				// the whole 128K run is a hole
+				index = make([]byte, PRODOS_BLOCK_BYTES)
+			} else if d.Format.ID == DF_PRODOS_800KB {
+				index, e = d.PRODOS800GetBlock(indexnum)
+			} else {
+				index, e = d.PRODOSGetBlock(indexnum)
+			}
+			if e != nil {
+				return data, e
+			}
+			for bptr := 0; len(data) < fd.Size() && bptr < 256; bptr++ {
+				blocknum := int(index[bptr]) + 256*int(index[bptr+256])
+				if blocknum == 0 {
+					chunk = make([]byte, PRODOS_BLOCK_BYTES)
+				} else if d.Format.ID == DF_PRODOS_800KB {
+					chunk, e = d.PRODOS800GetBlock(blocknum)
+				} else {
+					chunk, e = d.PRODOSGetBlock(blocknum)
+				}
+				if e != nil {
+					return data, e
+				}
+				count := 512
+				if remaining := fd.Size() - len(data); remaining < count {
+					count = remaining
+				}
+				data = append(data, chunk[:count]...)
+			}
+		}
+		return data, e
 	}
 
 	return []byte(nil), nil
@@ -1137,6 +1249,12 @@ func (d *DSKWrapper) PRODOS800ChecksumBlock(b int) string {
 
 func (d *DSKWrapper) PRODOSGetBlockSectors(block int) (int, int, int) {
 
+	// 3.5" volumes don't use the 5.25" track layout
+	switch d.Format.ID {
+	case DF_PRODOS_800KB, DF_PRODOS_400KB:
+		return d.PRODOS800GetBlockSectors(block)
+	}
+
 	track := block / PRODOS_BLOCKS_PER_TRACK
 
 	bo := block % PRODOS_BLOCKS_PER_TRACK
@@ -1172,7 +1290,7 @@ func (d *DSKWrapper) PRODOS800GetBlockSectors(block int) (int, int, int) {
 
 	dblBlock := block * 2
 
-	spt := 40
+	spt := d.Format.SPT()
 
 	track := dblBlock / spt
 	s1 := dblBlock % spt
@@ -1289,16 +1407,58 @@ func (dsk *DSKWrapper) PRODOSGetFirstFreeEntry(path string, name string, grow bo
 		return nil, errors.New("No free slot: told not to grow directory")
 	}
 
+	// the volume directory is a fixed size
+	if vdh.GetStorageType() == StorageType_Volume_Header {
+		return nil, errors.New("Directory full")
+	}
+
 	// If we got here, we need to create a new block
 	freeBlocks, err := dsk.PRODOSGetFreeBlocks(1, vdh.GetTotalBlocks())
 	if err != nil {
 		return nil, errors.New("Could not extend directory")
 	}
 
-	data, err := dsk.PRODOSGetBlock(freeBlocks[0])
+	// link the new block onto the end of the directory
+	data := make([]byte, PRODOS_BLOCK_BYTES)
 	prevBlock := blockList[len(blockList)-1]
 	data[0x00] = byte(prevBlock & 0xff)
 	data[0x01] = byte(prevBlock / 0x100)
+	err = dsk.PRODOSWrite(freeBlocks[0], data)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := blockData[len(blockData)-1]
+	prev[0x02] = byte(freeBlocks[0] & 0xff)
+	prev[0x03] = byte(freeBlocks[0] / 0x100)
+	err = dsk.PRODOSWrite(prevBlock, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dsk.PRODOSMarkBlocks(freeBlocks, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// a subdirectory's entry in its parent counts its blocks too
+	path = strings.Trim(path, "/")
+	if path != "" {
+		parent, dirname := "", path
+		if i := strings.LastIndex(path, "/"); i != -1 {
+			parent, dirname = path[:i], path[i+1:]
+		}
+		dfd, err := dsk.PRODOSGetNamedEntry(parent, dirname)
+		if err != nil {
+			return nil, err
+		}
+		dfd.SetTotalBlocks(len(blockList) + 1)
+		dfd.SetSize((len(blockList) + 1) * PRODOS_BLOCK_BYTES)
+		err = dfd.Publish(dsk)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	offset := 4
 	chunk := data[offset : offset+PRODOS_ENTRY_SIZE]
@@ -1306,8 +1466,6 @@ func (dsk *DSKWrapper) PRODOSGetFirstFreeEntry(path string, name string, grow bo
 	fd.entryNum = entries
 	fd.SetData(chunk, freeBlocks[0], offset)
 
-	dsk.PRODOSMarkBlocks(freeBlocks, false)
-
 	return fd, nil
 }
 
@@ -1321,9 +1479,22 @@ func (dsk *DSKWrapper) PRODOSMarkBlocks(list []int, free bool) error {
 		vbm.SetBlockFree(b, free)
 	}
 
+	return dsk.PRODOSWriteVolumeBitmap(vbm)
+}
+
+// PRODOSWriteVolumeBitmap writes the bitmap back over as many blocks as it spans
+func (dsk *DSKWrapper) PRODOSWriteVolumeBitmap(vbm ProDOSVolumeBitmap) error {
+
 	//fmt.Printf("Writing Volume bitmap to block %d\n", vbm.blockid)
 
-	return dsk.PRODOSWrite(vbm.blockid, vbm.Data)
+	for i := 0; i*PRODOS_BLOCK_BYTES < len(vbm.Data); i++ {
+		err := dsk.PRODOSWrite(vbm.blockid+i, vbm.Data[i*PRODOS_BLOCK_BYTES:(i+1)*PRODOS_BLOCK_BYTES])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (dsk *DSKWrapper) PRODOSGetFreeBlocks(count int, totalBlocks int) ([]int, error) {
@@ -1371,31 +1542,17 @@ func (dsk *DSKWrapper) PRODOSDeleteFile(path string, name string) error {
 		return errors.New("Read-only file")
 	}
 
-	// Make sure its either a sapling or a seedling
+	// Make sure its a seedling, sapling, tree or forked file
 	st := fd.GetStorageType()
-	if st != StorageType_Sapling && st != StorageType_Seedling && st != StorageType_SubDir_File {
+	if st != StorageType_Sapling && st != StorageType_Seedling && st != StorageType_Tree && st != StorageType_Extended && st != StorageType_SubDir_File {
 		return errors.New("Special file deletion not implemented: yet.")
 	} else if st == StorageType_SubDir_File {
 		return dsk.PRODOSDeleteDirectory(path, name)
 	}
 
-	var removeBlocks []int
-	switch st {
-	case StorageType_Seedling:
-		removeBlocks = append(removeBlocks, fd.IndexBlock())
-	case StorageType_Sapling:
-		removeBlocks = append(removeBlocks, fd.IndexBlock())
-		ib, err := dsk.PRODOSGetBlock(removeBlocks[0])
-		if err != nil {
-			return err
-		}
-
-		i := 0
-		b := int(ib[2*i+0]) + 256*int(ib[2*i+1])
-		for i < 256 && b != 0 {
-			b = int(ib[2*i+0]) + 256*int(ib[2*i+1])
-			i++
-		}
+	removeBlocks, err := dsk.PRODOSFileBlocks(fd)
+	if err != nil {
+		return err
 	}
 
 	err = dsk.PRODOSMarkBlocks(removeBlocks, true)
@@ -1424,17 +1581,32 @@ func (dsk *DSKWrapper) PRODOSDeleteFile(path string, name string) error {
 }
 
 func (dsk *DSKWrapper) PRODOSWriteFile(path string, name string, kind ProDOSFileType, data []byte, auxtype int) error {
+	return dsk.prodosWriteFile(path, name, kind, auxtype, false, [][]byte{data})
+}
+
+// PRODOSWriteFileSparse writes a file leaving any blocks of zeros after the
+// first unallocated, as ProDOS does for random access files
+func (dsk *DSKWrapper) PRODOSWriteFileSparse(path string, name string, kind ProDOSFileType, data []byte, auxtype int) error {
+	return dsk.prodosWriteFile(path, name, kind, auxtype, true, [][]byte{data})
+}
+
+// PRODOSWriteForkedFile writes a GS/OS extended file with both a data and
+// a resource fork
+func (dsk *DSKWrapper) PRODOSWriteForkedFile(path string, name string, kind ProDOSFileType, data []byte, rsrc []byte, auxtype int) error {
+	return dsk.prodosWriteFile(path, name, kind, auxtype, false, [][]byte{data, rsrc})
+}
+
+// prodosWriteFile writes a plain file given one fork, or an extended file
+// given the data and resource forks
+func (dsk *DSKWrapper) prodosWriteFile(path string, name string, kind ProDOSFileType, auxtype int, sparse bool, forks [][]byte) error {
 
 	name = strings.ToUpper(name)
 
-	nst := StorageType_Seedling
-	blocksNeeded := len(data)/512 + 1
-	totalBlocks := blocksNeeded
-	if blocksNeeded > 1 {
-		nst = StorageType_Sapling
-		totalBlocks++ // extra block for blocklist
-	} else if blocksNeeded > 256 {
-		return errors.New("Not implemented: Tree write")
+	for _, data := range forks {
+		_, _, err := PRODOSStorageForSize(len(data))
+		if err != nil {
+			return err
+		}
 	}
 
 	var origTime time.Time
@@ -1457,28 +1629,57 @@ func (dsk *DSKWrapper) PRODOSWriteFile(path string, name string, kind ProDOSFile
 
 	}
 
-	vdh, err := dsk.PRODOSGetVDH(2)
-	if err != nil {
-		return err
-	}
-	freeBlocks, err := dsk.PRODOSGetFreeBlocks(totalBlocks, vdh.GetTotalBlocks())
-	if err != nil {
-		return err
-	}
+	var nst ProDOSStorageType
+	var keyBlock, totalBlocks, size int
 
-	// Okay got enough blocks
-	switch nst {
-	case StorageType_Sapling:
-		err = dsk.PRODOSWriteSaplingBlocks(freeBlocks[0], freeBlocks[1:], data)
+	if len(forks) == 1 {
+		nst, keyBlock, totalBlocks, err = dsk.prodosWriteFork(forks[0], sparse)
 		if err != nil {
 			return err
 		}
-	case StorageType_Seedling:
-		//fmt.Printf("Write Seedling %d bytes data to block %d\n", len(data), freeBlocks[0])
-		err = dsk.PRODOSWrite(freeBlocks[0], data)
+		size = len(forks[0])
+	} else {
+		// the extended key block holds a mini entry for each fork
+		vdh, err := dsk.PRODOSGetVDH(2)
+		if err != nil {
+			return err
+		}
+		freeBlocks, err := dsk.PRODOSGetFreeBlocks(1, vdh.GetTotalBlocks())
+		if err != nil {
+			return err
+		}
+		err = dsk.PRODOSMarkBlocks(freeBlocks, false)
+		if err != nil {
+			return err
+		}
+
+		kb := make([]byte, PRODOS_BLOCK_BYTES)
+		totalBlocks = 1
+		for i, data := range forks {
+			fst, key, blocks, err := dsk.prodosWriteFork(data, sparse)
+			if err != nil {
+				return err
+			}
+			mini := kb[i*PRODOS_FORK_ENTRY_OFFSET:]
+			mini[0] = byte(fst)
+			mini[1] = byte(key & 0xff)
+			mini[2] = byte(key / 0x100)
+			mini[3] = byte(blocks & 0xff)
+			mini[4] = byte(blocks / 0x100)
+			mini[5] = byte(len(data) & 0xff)
+			mini[6] = byte((len(data) >> 8) & 0xff)
+			mini[7] = byte((len(data) >> 16) & 0xff)
+			totalBlocks += blocks
+		}
+
+		err = dsk.PRODOSWrite(freeBlocks[0], kb)
 		if err != nil {
 			return err
 		}
+
+		nst = StorageType_Extended
+		keyBlock = freeBlocks[0]
+		size = PRODOS_BLOCK_BYTES
 	}
 
 	// Get the current directories directory header
@@ -1492,8 +1693,8 @@ func (dsk *DSKWrapper) PRODOSWriteFile(path string, name string, kind ProDOSFile
 	fd.SetName(name)
 	fd.SetType(kind)
 	fd.SetTotalBlocks(totalBlocks)
-	fd.SetIndexBlock(freeBlocks[0])
-	fd.SetSize(len(data))
+	fd.SetIndexBlock(keyBlock)
+	fd.SetSize(size)
 	fd.SetStorageType(nst)
 	if origAccess == 0x00 {
 		fd.SetAccessMode(AccessType_Default)
@@ -1510,15 +1711,75 @@ func (dsk *DSKWrapper) PRODOSWriteFile(path string, name string, kind ProDOSFile
 
 	fd.Publish(dsk)
 
-	dvdh.SetFileCount(vdh.GetFileCount() + 1)
-	dvdh.Publish(dsk)
+	dvdh.SetFileCount(dvdh.GetFileCount() + 1)
+	return dvdh.Publish(dsk)
+}
+
+// prodosWriteFork allocates and writes the blocks for one fork of a file,
+// giving its storage type, key block and the number of blocks used
+func (dsk *DSKWrapper) prodosWriteFork(data []byte, sparse bool) (ProDOSStorageType, int, int, error) {
+
+	nst, _, err := PRODOSStorageForSize(len(data))
+	if err != nil {
+		return nst, 0, 0, err
+	}
+
+	needed := make([]bool, PRODOSDataBlocks(len(data)))
+	for i := range needed {
+		needed[i] = !sparse || i == 0 || !prodosZeroBlock(data, i)
+	}
+	totalBlocks := prodosBlocksNeeded(nst, needed)
+
+	vdh, err := dsk.PRODOSGetVDH(2)
+	if err != nil {
+		return nst, 0, 0, err
+	}
+	freeBlocks, err := dsk.PRODOSGetFreeBlocks(totalBlocks, vdh.GetTotalBlocks())
+	if err != nil {
+		return nst, 0, 0, err
+	}
+
+	// Okay got enough blocks, hand them out with holes left as zero
+	next := 1
+	take := func(want bool) int {
+		if !want {
+			return 0
+		}
+		next++
+		return freeBlocks[next-1]
+	}
+
+	switch nst {
+	case StorageType_Tree:
+		indexBlocks := make([]int, PRODOSIndexBlocks(len(needed)))
+		for i := range indexBlocks {
+			indexBlocks[i] = take(prodosAnyNeeded(needed, i))
+		}
+		dataBlocks := make([]int, len(needed))
+		for i := range dataBlocks {
+			dataBlocks[i] = take(needed[i])
+		}
+		err = dsk.PRODOSWriteTreeBlocks(freeBlocks[0], indexBlocks, dataBlocks, data)
+	case StorageType_Sapling:
+		dataBlocks := make([]int, len(needed))
+		for i := range dataBlocks {
+			dataBlocks[i] = take(needed[i])
+		}
+		err = dsk.PRODOSWriteSaplingBlocks(freeBlocks[0], dataBlocks, data)
+	case StorageType_Seedling:
+		//fmt.Printf("Write Seedling %d bytes data to block %d\n", len(data), freeBlocks[0])
+		err = dsk.PRODOSWrite(freeBlocks[0], data)
+	}
+	if err != nil {
+		return nst, 0, 0, err
+	}
 
 	err = dsk.PRODOSMarkBlocks(freeBlocks, false)
 	if err != nil {
-		return err
+		return nst, 0, 0, err
 	}
 
-	return nil
+	return nst, freeBlocks[0], totalBlocks, nil
 }
 
 func (fd *ProDOSFileDescriptor) Publish(dsk *DSKWrapper) error {
@@ -1572,9 +1833,13 @@ func (dsk *DSKWrapper) PRODOSWriteSaplingBlocks(indexBlock int, dataBlocks []int
 
 	ib := make([]byte, 512)
 	for i, blocknum := range dataBlocks {
-		// index the block
-		ib[i*2+0] = byte(blocknum & 0xff)
-		ib[i*2+1] = byte(blocknum / 0x100)
+		if blocknum == 0 {
+			continue // left as a hole
+		}
+
+		// index the block, low bytes in the first half, high in the second
+		ib[i] = byte(blocknum & 0xff)
+		ib[i+256] = byte(blocknum / 0x100)
 
 		// data offset...
 		ptr := 512 * i
@@ -1582,10 +1847,8 @@ func (dsk *DSKWrapper) PRODOSWriteSaplingBlocks(indexBlock int, dataBlocks []int
 		if end > len(data) {
 			end = len(data)
 		}
-		chunk := data[ptr:end]
-		for len(chunk) < 512 {
-			chunk = append(chunk, 0x00)
-		}
+		chunk := make([]byte, 512)
+		copy(chunk, data[ptr:end])
 		err := dsk.PRODOSWrite(blocknum, chunk)
 		if err != nil {
 			return err
@@ -1595,6 +1858,250 @@ func (dsk *DSKWrapper) PRODOSWriteSaplingBlocks(indexBlock int, dataBlocks []int
 	return dsk.PRODOSWrite(indexBlock, ib)
 }
 
+// PRODOSWriteTreeBlocks writes a tree file, the master index at masterBlock points
+// at each of the index blocks, which in turn point at 256 data blocks each
+func (dsk *DSKWrapper) PRODOSWriteTreeBlocks(masterBlock int, indexBlocks []int, dataBlocks []int, data []byte) error {
+
+	if len(indexBlocks) > PRODOS_MAX_INDEX_BLOCKS || len(indexBlocks) != PRODOSIndexBlocks(len(dataBlocks)) {
+		return errors.New("Too many data blocks")
+	}
+
+	mb := make([]byte, 512)
+	for i, indexnum := range indexBlocks {
+		if indexnum == 0 {
+			continue // nothing but holes under this one
+		}
+
+		mb[i] = byte(indexnum & 0xff)
+		mb[i+256] = byte(indexnum / 0x100)
+
+		first := i * 256
+		last := first + 256
+		if last > len(dataBlocks) {
+			last = len(dataBlocks)
+		}
+
+		ptr := first * 512
+		end := last * 512
+		if end > len(data) {
+			end = len(data)
+		}
+
+		err := dsk.PRODOSWriteSaplingBlocks(indexnum, dataBlocks[first:last], data[ptr:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return dsk.PRODOSWrite(masterBlock, mb)
+}
+
+// PRODOSIndexBlocks is the number of index blocks needed to point at a run
+// of data blocks
+func PRODOSIndexBlocks(dataBlocks int) int {
+	return (dataBlocks + 255) / 256
+}
+
+// PRODOSDataBlocks is the number of data blocks a file of size bytes spans,
+// a key block is always needed so it is at least one
+func PRODOSDataBlocks(size int) int {
+	n := (size + PRODOS_BLOCK_BYTES - 1) / PRODOS_BLOCK_BYTES
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// prodosZeroBlock checks if data block i of a file holds nothing but zeros
+func prodosZeroBlock(data []byte, i int) bool {
+	end := (i + 1) * PRODOS_BLOCK_BYTES
+	if end > len(data) {
+		end = len(data)
+	}
+	for _, v := range data[i*PRODOS_BLOCK_BYTES : end] {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// prodosAnyNeeded checks if any data block under index block i is allocated
+func prodosAnyNeeded(needed []bool, i int) bool {
+	for j := i * 256; j < len(needed) && j < (i+1)*256; j++ {
+		if needed[j] {
+			return true
+		}
+	}
+	return false
+}
+
+// prodosBlocksNeeded counts the blocks to allocate for a file, including
+// index blocks, given which data blocks are not holes
+func prodosBlocksNeeded(nst ProDOSStorageType, needed []bool) int {
+
+	if nst == StorageType_Seedling {
+		return 1
+	}
+
+	count := 1 // key block
+	for _, n := range needed {
+		if n {
+			count++
+		}
+	}
+	if nst == StorageType_Tree {
+		for i := 0; i < PRODOSIndexBlocks(len(needed)); i++ {
+			if prodosAnyNeeded(needed, i) {
+				count++
+			}
+		}
+	}
+
+	return count
+
+}
+
+// PRODOSStorageForSize picks the storage type for a file of size bytes,
+// and the total blocks it needs including index blocks
+func PRODOSStorageForSize(size int) (ProDOSStorageType, int, error) {
+
+	if size > PRODOS_MAX_FILE_BYTES {
+		return StorageType_Inactive, 0, errors.New("File too large")
+	}
+
+	dataBlocks := PRODOSDataBlocks(size)
+
+	switch {
+	case dataBlocks == 1:
+		return StorageType_Seedling, 1, nil
+	case dataBlocks <= 256:
+		return StorageType_Sapling, dataBlocks + 1, nil
+	}
+
+	return StorageType_Tree, dataBlocks + PRODOSIndexBlocks(dataBlocks) + 1, nil
+
+}
+
+// PRODOSGetForks reads the extended key block of a GS/OS forked file, giving
+// a descriptor for each of the data and resource forks
+func (dsk *DSKWrapper) PRODOSGetForks(fd ProDOSFileDescriptor) (ProDOSFileDescriptor, ProDOSFileDescriptor, error) {
+
+	var dfd, rfd ProDOSFileDescriptor
+
+	if fd.GetStorageType() != StorageType_Extended {
+		return dfd, rfd, errors.New("Not a forked file")
+	}
+
+	kb, err := dsk.PRODOSGetBlock(fd.IndexBlock())
+	if err != nil {
+		return dfd, rfd, err
+	}
+
+	dfd = prodosForkDescriptor(fd, kb[0:PRODOS_FORK_ENTRY_SIZE])
+	rfd = prodosForkDescriptor(fd, kb[PRODOS_FORK_ENTRY_OFFSET:PRODOS_FORK_ENTRY_OFFSET+PRODOS_FORK_ENTRY_SIZE])
+
+	return dfd, rfd, nil
+
+}
+
+// prodosForkDescriptor makes a stand in directory entry for a fork from its
+// mini entry, so the fork can be read like any other file
+func prodosForkDescriptor(fd ProDOSFileDescriptor, mini []byte) ProDOSFileDescriptor {
+
+	f := ProDOSFileDescriptor{Data: make([]byte, PRODOS_ENTRY_SIZE)}
+	copy(f.Data, fd.Data)
+
+	f.SetStorageType(ProDOSStorageType(mini[0] & 0x0f))
+	f.SetIndexBlock(int(mini[1]) + 256*int(mini[2]))
+	f.SetTotalBlocks(int(mini[3]) + 256*int(mini[4]))
+	f.SetSize(int(mini[5]) + 256*int(mini[6]) + 65536*int(mini[7]))
+
+	return f
+
+}
+
+// PRODOSReadResourceFork reads the resource fork of a forked file, plain
+// files have none
+func (dsk *DSKWrapper) PRODOSReadResourceFork(fd ProDOSFileDescriptor) ([]byte, error) {
+
+	if fd.GetStorageType() != StorageType_Extended {
+		return []byte(nil), nil
+	}
+
+	_, rfd, err := dsk.PRODOSGetForks(fd)
+	if err != nil {
+		return []byte(nil), err
+	}
+	if rfd.GetStorageType() == StorageType_Inactive {
+		return []byte{}, nil
+	}
+
+	return dsk.PRODOSReadFileSectors(rfd, -1)
+
+}
+
+// PRODOSFileBlocks lists every block used by a file, index blocks included
+func (dsk *DSKWrapper) PRODOSFileBlocks(fd *ProDOSFileDescriptor) ([]int, error) {
+
+	blocks := []int{fd.IndexBlock()}
+
+	var indexes []int
+	switch fd.GetStorageType() {
+	case StorageType_Seedling:
+		return blocks, nil
+	case StorageType_Sapling:
+		indexes = append(indexes, fd.IndexBlock())
+	case StorageType_Tree:
+		mb, err := dsk.PRODOSGetBlock(fd.IndexBlock())
+		if err != nil {
+			return blocks, err
+		}
+		for i := 0; i < PRODOS_MAX_INDEX_BLOCKS; i++ {
+			b := int(mb[i]) + 256*int(mb[i+256])
+			if b != 0 {
+				indexes = append(indexes, b)
+				blocks = append(blocks, b)
+			}
+		}
+	case StorageType_Extended:
+		dfd, rfd, err := dsk.PRODOSGetForks(*fd)
+		if err != nil {
+			return blocks, err
+		}
+		for _, f := range []ProDOSFileDescriptor{dfd, rfd} {
+			if f.GetStorageType() == StorageType_Inactive {
+				continue
+			}
+			fb, err := dsk.PRODOSFileBlocks(&f)
+			if err != nil {
+				return blocks, err
+			}
+			blocks = append(blocks, fb...)
+		}
+		return blocks, nil
+	default:
+		return blocks, errors.New("Unsupported storage type")
+	}
+
+	for _, indexnum := range indexes {
+		ib, err := dsk.PRODOSGetBlock(indexnum)
+		if err != nil {
+			return blocks, err
+		}
+		// a zero pointer is a hole in a sparse file, it has no block
+		for i := 0; i < 256; i++ {
+			b := int(ib[i]) + 256*int(ib[i+256])
+			if b != 0 {
+				blocks = append(blocks, b)
+			}
+		}
+	}
+
+	return blocks, nil
+
+}
+
 // PRODOSCreateDirectory tries to create a subdirectory...
 func (dsk *DSKWrapper) PRODOSCreateDirectory(path string, name string) error {
 
@@ -1659,8 +2166,8 @@ func (dsk *DSKWrapper) PRODOSCreateDirectory(path string, name string) error {
 		return err
 	}
 
-	dvdh.SetFileCount(vdh.GetFileCount() + 1)
-	dvdh.Publish(dsk)
+	dvdh.SetFileCount(dvdh.GetFileCount() + 1)
+	err = dvdh.Publish(dsk)
 	if err != nil {
 		return err
 	}
@@ -1698,6 +2205,131 @@ func (dsk *DSKWrapper) PRODOSInitDirectoryBlock(targetBlock int, parentBlock int
 
 }
 
+// PRODOS_VOLUME_DIR_BLOCKS is the length of the volume directory that starts
+// at block 2, the volume bitmap comes straight after it
+const PRODOS_VOLUME_DIR_BLOCKS = 4
+
+var prodosVolumeName = regexp.MustCompile("^[A-Za-z][A-Za-z0-9.]{0,14}$")
+
+// NewProDOSVolume makes an empty ProDOS volume of the given number of blocks.
+// A 140K disk can be in DOS or ProDOS order, other sizes are kept in block
+// order. If boot is given its boot blocks (0 and 1) are copied so the new
+// volume will start up.
+func NewProDOSVolume(name string, blocks int, layout SectorOrder, boot *DSKWrapper) (*DSKWrapper, error) {
+
+	if !prodosVolumeName.MatchString(name) {
+		return nil, errors.New("Bad volume name: " + name)
+	}
+
+	bitmapStart := 2 + PRODOS_VOLUME_DIR_BLOCKS
+	bitmapBlocks := PRODOSBitmapBlocks(blocks)
+
+	if blocks < bitmapStart+2 || blocks > PRODOS_MAX_BLOCKS {
+		return nil, fmt.Errorf("Volume must be %d to %d blocks", bitmapStart+2, PRODOS_MAX_BLOCKS)
+	}
+
+	var format DiskFormat
+	switch blocks {
+	case PRODOS_BLOCKS_PER_DISK:
+		format = GetDiskFormat(DF_PRODOS)
+	case PRODOS_400KB_BLOCKS:
+		format = GetDiskFormat(DF_PRODOS_400KB)
+	case PRODOS_800KB_BLOCKS:
+		format = GetDiskFormat(DF_PRODOS_800KB)
+	default:
+		format = GetPDDiskFormat(DF_PRODOS_CUSTOM, blocks)
+	}
+
+	dsk := &DSKWrapper{
+		Data:               make([]byte, blocks*PRODOS_BLOCK_BYTES),
+		Format:             format,
+		Layout:             layout,
+		CurrentSectorOrder: PRODOS_SECTOR_ORDER,
+	}
+
+	switch {
+	case layout == SectorOrderProDOSLinear:
+	case layout == SectorOrderDOS33 && format.ID == DF_PRODOS:
+		dsk.CurrentSectorOrder = DOS_33_SECTOR_ORDER
+	default:
+		return nil, errors.New("Can't make " + format.String() + " volume with " + layout.String() + " sectors")
+	}
+
+	if boot != nil {
+		switch boot.Format.ID {
+		case DF_PRODOS, DF_PRODOS_400KB, DF_PRODOS_800KB, DF_PRODOS_CUSTOM:
+		default:
+			return nil, errors.New("Boot disk is not ProDOS")
+		}
+		for b := 0; b < 2; b++ {
+			data, err := boot.PRODOSGetBlock(b)
+			if err != nil {
+				return nil, err
+			}
+			err = dsk.PRODOSWrite(b, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// volume directory blocks are linked both ways, the header goes first
+	for i := 0; i < PRODOS_VOLUME_DIR_BLOCKS; i++ {
+
+		b := 2 + i
+		block := make([]byte, PRODOS_BLOCK_BYTES)
+
+		if i > 0 {
+			block[0x00] = byte(b - 1)
+		}
+		if i < PRODOS_VOLUME_DIR_BLOCKS-1 {
+			block[0x02] = byte(b + 1)
+		}
+
+		if i == 0 {
+			vdh := &VDH{
+				Data:        block[4 : 4+PRODOS_ENTRY_SIZE],
+				blockid:     b,
+				blockoffset: 4,
+			}
+			vdh.SetStorageType(StorageType_Volume_Header)
+			vdh.SetName(name)
+			vdh.SetCreateTime(time.Now())
+			vdh.SetVersion(0x00)
+			vdh.SetMinVersion(0x00)
+			vdh.SetAccess(AccessType_Default)
+			vdh.SetEntryLength(PRODOS_ENTRY_SIZE)
+			vdh.SetEntriesPerBlock((PRODOS_BLOCK_BYTES - 4) / PRODOS_ENTRY_SIZE)
+			vdh.SetFileCount(0)
+			vdh.SetBitmapPointer(bitmapStart)
+			vdh.SetTotalBlocks(blocks)
+		}
+
+		err := dsk.PRODOSWrite(b, block)
+		if err != nil {
+			return nil, err
+		}
+
+	}
+
+	// everything after the bitmap is free, bits past the end stay clear
+	vbm := ProDOSVolumeBitmap{
+		Data:    make([]byte, bitmapBlocks*PRODOS_BLOCK_BYTES),
+		blockid: bitmapStart,
+	}
+	for b := bitmapStart + bitmapBlocks; b < blocks; b++ {
+		vbm.SetBlockFree(b, true)
+	}
+
+	err := dsk.PRODOSWriteVolumeBitmap(vbm)
+	if err != nil {
+		return nil, err
+	}
+
+	return dsk, nil
+
+}
+
 func (dsk *DSKWrapper) PRODOSDeleteDirectory(path string, name string) error {
 
 	fd, err := dsk.PRODOSGetNamedEntry(path, name)
@@ -1728,9 +2360,6 @@ func (dsk *DSKWrapper) PRODOSDeleteDirectory(path string, name string) error {
 			if err != nil {
 				return err
 			}
-		} else if subfile.GetStorageType() == StorageType_Tree {
-			// TREE FILE -- UNSUPPORTED FOR NOW
-			return errors.New("Tree file handling not supported currently")
 		} else {
 			err = dsk.PRODOSDeleteFile(path+"/"+name, subfile.NameUnadorned())
 			if err != nil {
@@ -1791,6 +2420,26 @@ func (dsk *DSKWrapper) PRODOSSetLocked(path, name string, lock bool) error {
 
 }
 
+// PRODOSSetFileInfo restores the access bits and timestamps of a file,
+// zero times are left as they are.
+func (dsk *DSKWrapper) PRODOSSetFileInfo(path, name string, access ProDOSAccessMode, created, modified time.Time) error {
+
+	fd, err := dsk.PRODOSGetNamedEntry(path, name)
+	if err != nil {
+		return err
+	}
+
+	fd.SetAccessMode(access)
+	if !created.IsZero() {
+		fd.SetCreateTime(created)
+	}
+	if !modified.IsZero() {
+		fd.SetModTime(modified)
+	}
+	return fd.Publish(dsk)
+
+}
+
 // PRODOSGetNamedEntry for a given path, will find the file descriptor with name
 func (dsk *DSKWrapper) PRODOSGetNamedEntry(path string, name string) (*ProDOSFileDescriptor, error) {
 
@@ -1850,3 +2499,181 @@ func (dsk *DSKWrapper) PRODOSRenameFile(path, name, newname string) error {
 	return fd.Publish(dsk)
 
 }
+
+// proDOSDriver exposes ProDOS volumes of any size through the Driver
+// interface.
+type proDOSDriver struct{}
+
+func init() {
+	RegisterDriver(proDOSDriver{}, DF_PRODOS, DF_PRODOS_800KB, DF_PRODOS_400KB, DF_PRODOS_CUSTOM)
+}
+
+func (proDOSDriver) Name() string {
+	return "ProDOS"
+}
+
+func (proDOSDriver) Caps() DriverCaps {
+	return CapWrite | CapDirectories
+}
+
+func (proDOSDriver) BlockSize() int {
+	return PRODOS_BLOCK_BYTES
+}
+
+func (proDOSDriver) MaxNameLength() int {
+	return 15
+}
+
+func (proDOSDriver) Catalog(dsk *DSKWrapper, path string, pattern string) ([]*CatalogEntry, error) {
+
+	path = strings.Trim(path, "/")
+
+	_, files, err := dsk.PRODOSGetCatalogPathed(2, path, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*CatalogEntry, 0, len(files))
+	for _, fd := range files {
+		out = append(out, proDOSEntry(path, fd))
+	}
+
+	return out, nil
+
+}
+
+func proDOSEntry(path string, fd ProDOSFileDescriptor) *CatalogEntry {
+	return &CatalogEntry{
+		Path:        path,
+		Name:        fd.NameUnadorned(),
+		Kind:        int(fd.Type()),
+		Type:        fd.Type().String(),
+		Ext:         fd.Type().Ext(),
+		Class:       proDOSDriver{}.Class(int(fd.Type())),
+		LoadAddress: fd.AuxType(),
+		Locked:      fd.IsLocked(),
+		Access:      fd.AccessMode(),
+		Dir:         fd.Type() == FileType_PD_Directory,
+		Sparse:      fd.IsSparse(),
+		Forked:      fd.GetStorageType() == StorageType_Extended,
+		Created:     fd.CreateTime(),
+		Modified:    fd.ModTime(),
+		fd:          fd,
+	}
+}
+
+func (proDOSDriver) ReadFile(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error) {
+	fd, ok := e.fd.(ProDOSFileDescriptor)
+	if !ok {
+		return nil, errors.New("Not a ProDOS catalog entry")
+	}
+	if e.Dir {
+		return nil, errors.New("Is a directory")
+	}
+	_, _, data, err := dsk.PRODOSReadFileRaw(fd)
+	return data, err
+}
+
+func (proDOSDriver) WriteFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error {
+	return dsk.PRODOSWriteFile(path, name, ProDOSFileType(kind), data, loadAddr)
+}
+
+func (proDOSDriver) WriteFileSparse(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error {
+	return dsk.PRODOSWriteFileSparse(path, name, ProDOSFileType(kind), data, loadAddr)
+}
+
+func (proDOSDriver) ReadResourceFork(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error) {
+	fd, ok := e.fd.(ProDOSFileDescriptor)
+	if !ok {
+		return nil, errors.New("Not a ProDOS catalog entry")
+	}
+	return dsk.PRODOSReadResourceFork(fd)
+}
+
+func (proDOSDriver) WriteForkedFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, rsrc []byte, loadAddr int) error {
+	return dsk.PRODOSWriteForkedFile(path, name, ProDOSFileType(kind), data, rsrc, loadAddr)
+}
+
+func (proDOSDriver) DeleteFile(dsk *DSKWrapper, path string, name string) error {
+	return dsk.PRODOSDeleteFile(path, name)
+}
+
+func (proDOSDriver) RenameFile(dsk *DSKWrapper, path string, name string, newname string) error {
+	return dsk.PRODOSRenameFile(path, name, newname)
+}
+
+func (proDOSDriver) SetLocked(dsk *DSKWrapper, path string, name string, lock bool) error {
+	return dsk.PRODOSSetLocked(path, name, lock)
+}
+
+func (proDOSDriver) SetFileInfo(dsk *DSKWrapper, path string, name string, access ProDOSAccessMode, created, modified time.Time) error {
+	return dsk.PRODOSSetFileInfo(path, name, access, created, modified)
+}
+
+func (proDOSDriver) Mkdir(dsk *DSKWrapper, path string, name string) error {
+	return dsk.PRODOSCreateDirectory(path, name)
+}
+
+func (proDOSDriver) UsedBitmap(dsk *DSKWrapper) ([]bool, error) {
+
+	var vdh *VDH
+	var vbm ProDOSVolumeBitmap
+	var err error
+
+	if dsk.Format.ID == DF_PRODOS_800KB || dsk.Format.ID == DF_PRODOS_400KB {
+		vdh, err = dsk.PRODOS800GetVDH(2)
+		if err == nil {
+			vbm, err = dsk.PRODOS800GetVolumeBitmap()
+		}
+	} else {
+		vdh, err = dsk.PRODOSGetVDH(2)
+		if err == nil {
+			vbm, err = dsk.PRODOSGetVolumeBitmap()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]bool, vdh.GetTotalBlocks())
+	for b := range out {
+		out[b] = !vbm.IsBlockFree(b)
+	}
+
+	return out, nil
+
+}
+
+func (proDOSDriver) Check(dsk *DSKWrapper, repair bool) ([]*CheckProblem, error) {
+	return dsk.PRODOSCheck(repair)
+}
+
+func (proDOSDriver) Deleted(dsk *DSKWrapper) ([]*DeletedEntry, error) {
+	return dsk.PRODOSGetDeleted()
+}
+
+func (proDOSDriver) Undelete(dsk *DSKWrapper, d *DeletedEntry) error {
+	return dsk.PRODOSUndelete(d)
+}
+
+func (proDOSDriver) TypeFromExt(ext string) int {
+	return int(ProDOSFileTypeFromExt(ext))
+}
+
+func (proDOSDriver) TypeFromProDOS(t ProDOSFileType) int {
+	return int(t)
+}
+
+func (proDOSDriver) Class(kind int) CatalogEntryType {
+	switch ProDOSFileType(kind) {
+	case FileType_PD_TXT:
+		return CETText
+	case FileType_PD_INT:
+		return CETBasicInteger
+	case FileType_PD_APP:
+		return CETBasicApplesoft
+	case FileType_PD_BIN, FileType_PD_SYS:
+		return CETBinary
+	}
+	return CETUnknown
+}
@@ -0,0 +1,248 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testFreeBlocks counts the blocks the volume bitmap has free
+func testFreeBlocks(t *testing.T, dsk *DSKWrapper) int {
+
+	t.Helper()
+
+	vdh, err := dsk.PRODOSGetVDH(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbm, err := dsk.PRODOSGetVolumeBitmap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	free := 0
+	for b := 0; b < vdh.GetTotalBlocks(); b++ {
+		if vbm.IsBlockFree(b) {
+			free++
+		}
+	}
+	return free
+
+}
+
+// testSparseData is mostly zeros, with data at the start, in the middle
+// and at the end
+func testSparseData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	copy(data, testData(seed, 700))
+	copy(data[size/2:], testData(seed+1, 300))
+	copy(data[size-100:], testData(seed+2, 100))
+	return data
+}
+
+func TestProDOSWriteStorageTypes(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		sparse  bool
+		data    []byte
+		rsrc    []byte
+		storage ProDOSStorageType
+		big     bool // only fits on volumes over 140K
+	}{
+		{"SEEDLING", false, testData(110, 400), nil, StorageType_Seedling, false},
+		{"SAPLING", false, testData(111, 40000), nil, StorageType_Sapling, false},
+		{"TREE", false, testData(112, 200000), nil, StorageType_Tree, true},
+		{"SPARSE.SAP", true, testSparseData(113, 100000), nil, StorageType_Sapling, false},
+		{"SPARSE.TREE", true, testSparseData(116, 1000000), nil, StorageType_Tree, false},
+		{"FORKED", false, testData(119, 3000), testData(120, 20000), StorageType_Extended, false},
+		{"FORKED.TREE", false, testData(121, 150000), testData(122, 600), StorageType_Extended, true},
+	}
+
+	for _, blocks := range []int{PRODOS_BLOCKS_PER_DISK, PRODOS_800KB_BLOCKS, PRODOS_MAX_BLOCKS} {
+
+		dsk := testProDOSDisk(t, blocks, SectorOrderProDOSLinear, testData(109, 100))
+
+		for _, tt := range tests {
+
+			if tt.big && blocks == PRODOS_BLOCKS_PER_DISK {
+				continue
+			}
+
+			before := testFreeBlocks(t, dsk)
+
+			var err error
+			switch {
+			case tt.rsrc != nil:
+				err = dsk.PRODOSWriteForkedFile("", tt.name, FileType_PD_BIN, tt.data, tt.rsrc, 0x2000)
+			case tt.sparse:
+				err = dsk.PRODOSWriteFileSparse("", tt.name, FileType_PD_BIN, tt.data, 0x2000)
+			default:
+				err = dsk.PRODOSWriteFile("", tt.name, FileType_PD_BIN, tt.data, 0x2000)
+			}
+			if err != nil {
+				t.Fatalf("%d blocks, %s: %v", blocks, tt.name, err)
+			}
+
+			fd, err := dsk.PRODOSGetNamedEntry("", tt.name)
+			if err != nil {
+				t.Fatalf("%d blocks, %s: %v", blocks, tt.name, err)
+			}
+			if fd.GetStorageType() != tt.storage {
+				t.Errorf("%d blocks, %s: storage type %d, want %d", blocks, tt.name, fd.GetStorageType(), tt.storage)
+			}
+			if fd.IsSparse() != tt.sparse {
+				t.Errorf("%d blocks, %s: sparse %v", blocks, tt.name, fd.IsSparse())
+			}
+
+			// every block the file claims is counted and marked in use
+			used, err := dsk.PRODOSFileBlocks(fd)
+			if err != nil {
+				t.Fatalf("%d blocks, %s: %v", blocks, tt.name, err)
+			}
+			if fd.TotalBlocks() != len(used) {
+				t.Errorf("%d blocks, %s: TotalBlocks is %d, the file has %d", blocks, tt.name, fd.TotalBlocks(), len(used))
+			}
+			if after := testFreeBlocks(t, dsk); before-after != len(used) {
+				t.Errorf("%d blocks, %s: bitmap lost %d blocks, the file has %d", blocks, tt.name, before-after, len(used))
+			}
+			vbm, err := dsk.PRODOSGetVolumeBitmap()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range used {
+				if vbm.IsBlockFree(b) {
+					t.Errorf("%d blocks, %s: block %d is free in the bitmap", blocks, tt.name, b)
+				}
+			}
+
+			testReadBack(t, dsk, tt.name, tt.data)
+			if tt.rsrc != nil {
+				rsrc, err := dsk.PRODOSReadResourceFork(*fd)
+				if err != nil || !bytes.Equal(rsrc, tt.rsrc) {
+					t.Errorf("%d blocks, %s: resource fork %d bytes, %v", blocks, tt.name, len(rsrc), err)
+				}
+			}
+
+		}
+
+	}
+
+}
+
+func TestNewProDOSVolume(t *testing.T) {
+
+	tests := []struct {
+		blocks int
+		layout SectorOrder
+		want   DiskFormatID
+	}{
+		{PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, DF_PRODOS},
+		{PRODOS_BLOCKS_PER_DISK, SectorOrderDOS33, DF_PRODOS},
+		{PRODOS_400KB_BLOCKS, SectorOrderProDOSLinear, DF_PRODOS_400KB},
+		{PRODOS_800KB_BLOCKS, SectorOrderProDOSLinear, DF_PRODOS_800KB},
+		{5000, SectorOrderProDOSLinear, DF_PRODOS_CUSTOM},
+		{PRODOS_MAX_BLOCKS, SectorOrderProDOSLinear, DF_PRODOS_CUSTOM},
+	}
+
+	file := testData(125, 3000)
+
+	for _, tt := range tests {
+
+		dsk, err := NewProDOSVolume("NEW.DISK", tt.blocks, tt.layout, nil)
+		if err != nil {
+			t.Fatalf("%d blocks in %s order: %v", tt.blocks, tt.layout, err)
+		}
+		if dsk.Format.ID != tt.want || len(dsk.Data) != tt.blocks*PRODOS_BLOCK_BYTES {
+			t.Errorf("%d blocks in %s order: made %s of %d bytes", tt.blocks, tt.layout, dsk.Format, len(dsk.Data))
+		}
+
+		vdh, err := dsk.PRODOSGetVDH(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vdh.GetTotalBlocks() != tt.blocks || vdh.GetVolumeName() != "NEW.DISK" {
+			t.Errorf("%d blocks in %s order: header says %s, %d blocks", tt.blocks, tt.layout, vdh.GetVolumeName(), vdh.GetTotalBlocks())
+		}
+
+		// boot blocks, the volume directory and the bitmap are in use
+		used := 2 + PRODOS_VOLUME_DIR_BLOCKS + PRODOSBitmapBlocks(tt.blocks)
+		if free := testFreeBlocks(t, dsk); free != tt.blocks-used {
+			t.Errorf("%d blocks in %s order: %d free, want %d", tt.blocks, tt.layout, free, tt.blocks-used)
+		}
+
+		if err := dsk.PRODOSWriteFile("", "HELLO", FileType_PD_BIN, file, 0x2000); err != nil {
+			t.Fatalf("%d blocks in %s order: %v", tt.blocks, tt.layout, err)
+		}
+		testReadBack(t, dsk, "HELLO", file)
+
+	}
+
+	if _, err := NewProDOSVolume("1BAD", PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, nil); err == nil {
+		t.Error("made a volume with a bad name")
+	}
+	if _, err := NewProDOSVolume("BIG", PRODOS_800KB_BLOCKS, SectorOrderDOS33, nil); err == nil {
+		t.Error("made an 800K volume in DOS order")
+	}
+
+}
+
+func TestProDOSNewVolumeReload(t *testing.T) {
+
+	tests := []struct {
+		blocks int
+		want   DiskFormatID
+		names  []string
+	}{
+		{PRODOS_BLOCKS_PER_DISK, DF_PRODOS, []string{"new.po", "new.do", "new.2mg", "new.hdv"}},
+		{PRODOS_400KB_BLOCKS, DF_PRODOS_400KB, []string{"new.po", "new.2mg", "new.hdv"}},
+		{PRODOS_800KB_BLOCKS, DF_PRODOS_800KB, []string{"new.po", "new.2mg", "new.hdv"}},
+		{5000, DF_PRODOS_CUSTOM, []string{"new.po", "new.2mg", "new.hdv"}},
+		{PRODOS_MAX_BLOCKS, DF_PRODOS_CUSTOM, []string{"new.po", "new.2mg", "new.hdv"}},
+	}
+
+	file := testData(130, 30000)
+
+	for _, tt := range tests {
+		for _, name := range tt.names {
+
+			// as init makes it
+			layout := SectorOrderProDOSLinear
+			if name == "new.do" {
+				layout = SectorOrderDOS33
+			}
+			dsk, err := NewProDOSVolume("NEW", tt.blocks, layout, nil)
+			if err != nil {
+				t.Fatalf("%d blocks as %s: %v", tt.blocks, name, err)
+			}
+			if name == "new.2mg" {
+				dsk.Header2MG = NewHeader2MG(FORMAT_2MG_PRODOS, tt.blocks)
+			}
+
+			// then mounted, and a file put on it
+			w, err := NewDSKWrapperBin(nil, dsk.Bytes(), name)
+			if err != nil {
+				t.Fatalf("%d blocks as %s: %v", tt.blocks, name, err)
+			}
+			if w.Format.ID != tt.want || w.Format.BPD() != tt.blocks {
+				t.Fatalf("%d blocks as %s: blank volume loaded as %s", tt.blocks, name, w.Format)
+			}
+			if err := w.PRODOSWriteFile("", "HELLO", FileType_PD_BIN, file, 0x2000); err != nil {
+				t.Fatalf("%d blocks as %s: %v", tt.blocks, name, err)
+			}
+
+			w, err = NewDSKWrapperBin(nil, w.Bytes(), name)
+			if err != nil {
+				t.Fatalf("%d blocks as %s: %v", tt.blocks, name, err)
+			}
+			if w.Format.ID != tt.want || w.Format.BPD() != tt.blocks {
+				t.Fatalf("%d blocks as %s: reloaded as %s", tt.blocks, name, w.Format)
+			}
+			if (name == "new.2mg") != (w.Header2MG != nil) {
+				t.Errorf("%d blocks as %s: 2MG header %v", tt.blocks, name, w.Header2MG != nil)
+			}
+			testReadBack(t, w, "HELLO", file)
+
+		}
+	}
+
+}
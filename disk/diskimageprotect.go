@@ -0,0 +1,473 @@
+package disk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+	Copy protection analysis...
+
+	Walks the nibble level data for each track (from a WOZ or NIB image, or
+	synthesized from the sectors) looking for the things protection schemes
+	and nonstandard formats do to a disk: changed address and data marks,
+	bad checksums, sync tricks, data on half tracks, long or short tracks
+	and missing or extra sectors.
+
+	The findings are kept by track, while Scheme() boils them down to a set
+	of track independent tags so that disks can be grouped by scheme.
+*/
+
+type ProtectionKind int
+
+const (
+	ProtectAddressPrologue ProtectionKind = iota
+	ProtectAddressEpilogue
+	ProtectDataPrologue
+	ProtectDataEpilogue
+	ProtectAddressChecksum
+	ProtectDataChecksum
+	ProtectTrackNumber
+	ProtectSync
+	ProtectHalfTrack
+	ProtectLongTrack
+	ProtectShortTrack
+	ProtectMissingSectors
+	ProtectExtraSectors
+)
+
+func (pk ProtectionKind) String() string {
+	switch pk {
+	case ProtectAddressPrologue:
+		return "address prologue"
+	case ProtectAddressEpilogue:
+		return "address epilogue"
+	case ProtectDataPrologue:
+		return "data prologue"
+	case ProtectDataEpilogue:
+		return "data epilogue"
+	case ProtectAddressChecksum:
+		return "bad address checksum"
+	case ProtectDataChecksum:
+		return "bad data checksum"
+	case ProtectTrackNumber:
+		return "wrong track number"
+	case ProtectSync:
+		return "short sync"
+	case ProtectHalfTrack:
+		return "half track"
+	case ProtectLongTrack:
+		return "long track"
+	case ProtectShortTrack:
+		return "short track"
+	case ProtectMissingSectors:
+		return "missing sectors"
+	case ProtectExtraSectors:
+		return "extra sectors"
+	}
+	return "Unknown"
+}
+
+// ProtectionFinding is one oddity seen on a track. Count is the number of
+// sectors it was seen in (or sectors missing, nibbles long etc), Value the
+// marks found where they differ from the standard ones.
+type ProtectionFinding struct {
+	Kind  ProtectionKind
+	Track int
+	Count int
+	Value string
+}
+
+func (f ProtectionFinding) String() string {
+	s := fmt.Sprintf("T%02d %s", f.Track, f.Kind)
+	if f.Value != "" {
+		s += " " + f.Value
+	}
+	return s + fmt.Sprintf(" (%d)", f.Count)
+}
+
+// tag is the finding without the track, used to name the scheme
+func (f ProtectionFinding) tag() string {
+	switch f.Kind {
+	case ProtectAddressPrologue, ProtectAddressEpilogue, ProtectDataPrologue, ProtectDataEpilogue:
+		return f.Kind.String() + " " + f.Value
+	}
+	return f.Kind.String()
+}
+
+// ProtectionProfile holds everything found on a disk, an empty profile is
+// a perfectly standard disk.
+type ProtectionProfile struct {
+	Source   string // WOZ, NIB or synthesized
+	Findings []ProtectionFinding
+}
+
+// Standard is true if nothing out of the ordinary was found
+func (p *ProtectionProfile) Standard() bool {
+	return len(p.Findings) == 0
+}
+
+// Scheme sums up the findings without track numbers, so disks protected
+// the same way get the same scheme.
+func (p *ProtectionProfile) Scheme() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, f := range p.Findings {
+		t := f.tag()
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Match is true if any finding contains the text, ignoring case
+func (p *ProtectionProfile) Match(text string) bool {
+	text = strings.ToLower(text)
+	for _, f := range p.Findings {
+		if strings.Contains(strings.ToLower(f.String()), text) {
+			return true
+		}
+	}
+	return false
+}
+
+func hexMarks(b []byte) string {
+	s := make([]string, len(b))
+	for i, v := range b {
+		s[i] = fmt.Sprintf("%.2X", v)
+	}
+	return strings.Join(s, " ")
+}
+
+// trackFindings counts findings for one track by kind and value
+type trackFindings struct {
+	track  int
+	counts map[ProtectionKind]map[string]int
+}
+
+func (tf *trackFindings) add(kind ProtectionKind, value string, n int) {
+	if tf.counts == nil {
+		tf.counts = make(map[ProtectionKind]map[string]int)
+	}
+	if tf.counts[kind] == nil {
+		tf.counts[kind] = make(map[string]int)
+	}
+	tf.counts[kind][value] += n
+}
+
+func (tf *trackFindings) list() []ProtectionFinding {
+	var out []ProtectionFinding
+	for kind, values := range tf.counts {
+		for value, n := range values {
+			out = append(out, ProtectionFinding{Kind: kind, Track: tf.track, Count: n, Value: value})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out
+}
+
+func is44(b byte) bool {
+	return b&0xaa == 0xaa
+}
+
+// addressFieldAt checks for a plausible address field of any prologue at
+// pos, giving the decoded fields. Standard prologues are accepted with a
+// bad checksum so it can be reported.
+func addressFieldAt(nibbles []byte, pos int) (vol, trk, sec int, std, ok, found bool) {
+
+	if pos+11 > len(nibbles) {
+		return
+	}
+
+	for i := pos + 3; i < pos+11; i++ {
+		if !is44(nibbles[i]) {
+			return
+		}
+	}
+
+	std = matchAt(nibbles, pos, ADDRESS_PROLOGUE_62) || matchAt(nibbles, pos, ADDRESS_PROLOGUE_53)
+	vol, trk, sec, ok = readAddressField(nibbles, pos+3)
+	if trk >= 0x50 || sec >= 0x20 {
+		return
+	}
+
+	if !std {
+		// Anything can look like a prologue, so insist on a good checksum
+		// and bytes that could really have been written as marks.
+		for _, b := range nibbles[pos : pos+3] {
+			if b < 0x96 || b == 0xff {
+				return
+			}
+		}
+		if !ok {
+			return
+		}
+	}
+
+	found = true
+	return
+}
+
+// dataFieldAfter finds the data field following the address field at pos,
+// giving where its prologue starts. Standard marks are searched for first,
+// otherwise whatever follows the sync gap is taken as the prologue.
+func dataFieldAfter(nibbles []byte, pos int) int {
+
+	if d := findData(nibbles, pos+11, nibbles[pos:pos+3]); d != -1 {
+		return d - len(DATA_PROLOGUE)
+	}
+
+	i := pos + 13
+	for i < pos+16 && i < len(nibbles) && nibbles[i] != 0xff {
+		i++
+	}
+	for i < pos+64 && i < len(nibbles) && nibbles[i] == 0xff {
+		i++
+	}
+	if i+3 > len(nibbles) || i >= pos+64 {
+		return -1
+	}
+	if _, _, _, _, _, found := addressFieldAt(nibbles, i); found {
+		return -1
+	}
+	return i
+}
+
+// syncBefore counts the sync bytes in front of pos
+func syncBefore(nibbles []byte, pos int) int {
+	n := 0
+	for i := pos - 1; i >= 0 && nibbles[i] == 0xff; i-- {
+		n++
+	}
+	return n
+}
+
+// analyzeTrack looks at one revolution of a track. The nibbles should run
+// on past the end of the revolution so the last sector can be read whole.
+func analyzeTrack(nibbles []byte, revolution int, track int, encoding NibbleEncoding, tf *trackFindings) int {
+
+	const lookBehind = 16
+
+	spt := STD_SECTORS_PER_TRACK
+	if encoding == NibbleEncoding53 {
+		spt = STD_SECTORS_PER_TRACK_OLD
+	}
+
+	sectors := make(map[int]bool)
+	fields := 0
+	end := lookBehind + revolution
+	if end > len(nibbles) {
+		end = len(nibbles)
+	}
+
+	for pos := lookBehind; pos < end; pos++ {
+
+		_, trk, sec, std, ok, found := addressFieldAt(nibbles, pos)
+		if !found {
+			continue
+		}
+
+		prologue := nibbles[pos : pos+3]
+		if !std {
+			tf.add(ProtectAddressPrologue, hexMarks(prologue), 1)
+		}
+		if !ok {
+			tf.add(ProtectAddressChecksum, "", 1)
+		}
+
+		if pos+13 <= len(nibbles) && !matchAt(nibbles, pos+11, []byte{0xde, 0xaa}) {
+			tf.add(ProtectAddressEpilogue, hexMarks(nibbles[pos+11:pos+13]), 1)
+		}
+
+		if syncBefore(nibbles, pos) < 4 {
+			tf.add(ProtectSync, "", 1)
+		}
+
+		if ok {
+			fields++
+			if sec < spt {
+				sectors[sec] = true
+			}
+			if trk != track {
+				tf.add(ProtectTrackNumber, fmt.Sprintf("says %d", trk), 1)
+			}
+		}
+
+		next := pos + 10
+
+		dataLength := NIBBLE_62_DATA_LENGTH
+		decoder := denibblize62
+		if prologue[2] == ADDRESS_PROLOGUE_53[2] || (!std && encoding == NibbleEncoding53) {
+			dataLength = NIBBLE_53_DATA_LENGTH
+			decoder = denibblize53
+		}
+
+		if dpos := dataFieldAfter(nibbles, pos); dpos != -1 && dpos+3+dataLength+1 <= len(nibbles) {
+			if !matchAt(nibbles, dpos, DATA_PROLOGUE) {
+				tf.add(ProtectDataPrologue, hexMarks(nibbles[dpos:dpos+3]), 1)
+			}
+			dpos += 3
+			if _, err := decoder(nibbles[dpos : dpos+dataLength+1]); err != nil {
+				tf.add(ProtectDataChecksum, "", 1)
+			}
+			epos := dpos + dataLength + 1
+			if epos+2 <= len(nibbles) && !matchAt(nibbles, epos, []byte{0xde, 0xaa}) {
+				tf.add(ProtectDataEpilogue, hexMarks(nibbles[epos:epos+2]), 1)
+			}
+			next = epos
+		}
+
+		pos = next
+	}
+
+	if track < STD_TRACKS_PER_DISK || fields > 0 {
+		if missing := spt - len(sectors); missing > 0 {
+			tf.add(ProtectMissingSectors, "", missing)
+		}
+		if extra := fields - len(sectors); extra > 0 {
+			tf.add(ProtectExtraSectors, "", extra)
+		}
+	}
+
+	return fields
+}
+
+// doubleTrack repeats a single revolution so sectors crossing the index
+// can be read
+func doubleTrack(nibbles []byte) []byte {
+	out := make([]byte, 0, 2*len(nibbles))
+	out = append(out, nibbles...)
+	return append(out, nibbles...)
+}
+
+// AnalyzeNibbleTracks builds a protection profile from per track nibble
+// streams, each holding two revolutions of the track.
+func AnalyzeNibbleTracks(tracks [][]byte, source string) *ProtectionProfile {
+
+	p := &ProtectionProfile{Source: source}
+
+	var all []byte
+	for _, t := range tracks {
+		all = append(all, t...)
+	}
+	encoding := DetectNibbleEncoding(all)
+
+	for track, nibbles := range tracks {
+		if len(nibbles) == 0 {
+			continue
+		}
+		tf := &trackFindings{track: track}
+		analyzeTrack(nibbles, len(nibbles)/2, track, encoding, tf)
+		p.Findings = append(p.Findings, tf.list()...)
+	}
+
+	return p
+}
+
+// analyzeProtection adds the half tracks and track lengths only a WOZ image can tell
+// us about
+func (woz *WOZImage) analyzeProtection() *ProtectionProfile {
+
+	tracks := make([][]byte, 0, 40)
+	for t := 0; t < 40; t++ {
+		tracks = append(tracks, woz.TrackNibbles(t))
+	}
+	for len(tracks) > STD_TRACKS_PER_DISK && tracks[len(tracks)-1] == nil {
+		tracks = tracks[:len(tracks)-1]
+	}
+
+	p := AnalyzeNibbleTracks(tracks, "WOZ")
+
+	// Copiers map the quarter tracks either side onto the whole track, so
+	// only a separate stream at the half track position counts.
+	encoding := DetectNibbleEncoding(woz.TrackNibbles(0))
+	for t := 0; t < 40; t++ {
+		qt := t*4 + 2
+		if qt+2 >= len(woz.TMap) {
+			break
+		}
+		idx := woz.TMap[qt]
+		if idx == WOZ_NO_TRACK || idx == woz.TMap[qt-2] || idx == woz.TMap[qt+2] || int(idx) >= len(woz.Tracks) {
+			continue
+		}
+		nibbles := woz.Tracks[idx]
+		if len(nibbles) == 0 {
+			continue
+		}
+		tf := &trackFindings{track: t}
+		n := analyzeTrack(nibbles, len(nibbles)/2, -1, encoding, &trackFindings{})
+		tf.add(ProtectHalfTrack, "", n)
+		p.Findings = append(p.Findings, tf.list()...)
+	}
+
+	var lengths []int
+	for _, t := range tracks {
+		if len(t) > 0 {
+			lengths = append(lengths, len(t)/2)
+		}
+	}
+	if len(lengths) > 0 {
+		sorted := append([]int(nil), lengths...)
+		sort.Ints(sorted)
+		median := sorted[len(sorted)/2]
+		for track, t := range tracks {
+			if len(t) == 0 {
+				continue
+			}
+			n := len(t) / 2
+			tf := &trackFindings{track: track}
+			switch {
+			case n*100 > median*103:
+				tf.add(ProtectLongTrack, "", n)
+			case n*100 < median*97:
+				tf.add(ProtectShortTrack, "", n)
+			}
+			p.Findings = append(p.Findings, tf.list()...)
+		}
+	}
+
+	sort.SliceStable(p.Findings, func(i, j int) bool {
+		return p.Findings[i].Track < p.Findings[j].Track
+	})
+
+	return p
+}
+
+// AnalyzeProtection scans the disk at the nibble level for copy protection
+// and nonstandard formatting. Sector images are nibblized first, which
+// tells us little beyond the disk being standard. Nil is returned for
+// disks that never had a nibble level form we know of.
+func (dsk *DSKWrapper) AnalyzeProtection() *ProtectionProfile {
+
+	if dsk.WOZ != nil {
+		return dsk.WOZ.analyzeProtection()
+	}
+
+	if dsk.nibData != nil {
+		return AnalyzeNibbleTracks(NibbleTracks(dsk.nibData), "NIB")
+	}
+
+	switch len(dsk.Data) {
+	case DISK_NIBBLE_LENGTH:
+		return AnalyzeNibbleTracks(NibbleTracks(dsk.Data), "NIB")
+	case STD_DISK_BYTES, STD_DISK_BYTES_OLD:
+		nibbles := dsk.Nibblize()
+		var tracks [][]byte
+		size := len(nibbles) / STD_TRACKS_PER_DISK
+		for off := 0; off+size <= len(nibbles); off += size {
+			tracks = append(tracks, doubleTrack(nibbles[off:off+size]))
+		}
+		return AnalyzeNibbleTracks(tracks, "synthesized")
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package disk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeProtection(t *testing.T) {
+
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(160, 5000))
+
+	tests := []struct {
+		name     string
+		alter    func(track []byte) // changes one revolution of track 5
+		findings []ProtectionFinding
+		scheme   []string
+		match    string
+	}{
+		{"standard", func(track []byte) {}, nil, nil, ""},
+		{"address prologue", func(track []byte) {
+			copy(track, bytes.Replace(track, ADDRESS_PROLOGUE_62, []byte{0xd4, 0xaa, 0x96}, -1))
+		}, []ProtectionFinding{
+			{ProtectAddressPrologue, 5, 16, "D4 AA 96"},
+		}, []string{"address prologue D4 AA 96"}, "d4 aa 96"},
+		{"data prologue", func(track []byte) {
+			copy(track, bytes.Replace(track, DATA_PROLOGUE, []byte{0xd5, 0xaa, 0xab}, -1))
+		}, []ProtectionFinding{
+			{ProtectDataPrologue, 5, 16, "D5 AA AB"},
+		}, []string{"data prologue D5 AA AB"}, "T05 data prologue"},
+		{"missing sector", func(track []byte) {
+			copy(track[bytes.Index(track, ADDRESS_PROLOGUE_62):], []byte{0xff, 0xff, 0xff})
+		}, []ProtectionFinding{
+			{ProtectMissingSectors, 5, 1, ""},
+		}, []string{"missing sectors"}, "missing"},
+	}
+
+	for _, tt := range tests {
+
+		nib := dsk.Nibblize()
+		tt.alter(nib[5*TRACK_NIBBLE_LENGTH : 6*TRACK_NIBBLE_LENGTH])
+
+		p := AnalyzeNibbleTracks(NibbleTracks(nib), "NIB")
+		if p.Standard() != (tt.findings == nil) {
+			t.Errorf("%s: standard is %v", tt.name, p.Standard())
+		}
+		if !reflect.DeepEqual(p.Findings, tt.findings) {
+			t.Errorf("%s: found %v, want %v", tt.name, p.Findings, tt.findings)
+		}
+		if !reflect.DeepEqual(p.Scheme(), tt.scheme) {
+			t.Errorf("%s: scheme %q, want %q", tt.name, p.Scheme(), tt.scheme)
+		}
+		if tt.match != "" && !p.Match(tt.match) {
+			t.Errorf("%s: %q doesn't match %v", tt.name, tt.match, p.Findings)
+		}
+		if p.Match("half track") {
+			t.Errorf("%s: half track found in %v", tt.name, p.Findings)
+		}
+
+		// a loaded image is analyzed from the nibbles as they were
+		w, err := NewDSKWrapperBin(nil, nib, "test.nib")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if lp := w.AnalyzeProtection(); lp == nil || lp.Source != "NIB" || !reflect.DeepEqual(lp.Findings, p.Findings) {
+			t.Errorf("%s: loaded image analyzed as %v", tt.name, lp)
+		}
+
+	}
+
+	// sector images are nibblized as standard
+	if p := dsk.AnalyzeProtection(); p == nil || p.Source != "synthesized" || !p.Standard() {
+		t.Errorf("sector image analyzed as %v", p)
+	}
+
+	// a single track analyzed alone
+	tf := &trackFindings{track: 5}
+	track := dsk.Nibblize()[5*TRACK_NIBBLE_LENGTH : 6*TRACK_NIBBLE_LENGTH]
+	if n := analyzeTrack(doubleTrack(track), TRACK_NIBBLE_LENGTH, 5, NibbleEncoding62, tf); n != STD_SECTORS_PER_TRACK || len(tf.list()) != 0 {
+		t.Errorf("standard track gave %d sectors and %v", n, tf.list())
+	}
+	tf = &trackFindings{track: 6}
+	if analyzeTrack(doubleTrack(track), TRACK_NIBBLE_LENGTH, 6, NibbleEncoding62, tf); !reflect.DeepEqual(tf.list(), []ProtectionFinding{{ProtectTrackNumber, 6, 16, "says 5"}}) {
+		t.Errorf("track 5 read as track 6 gave %v", tf.list())
+	}
+
+}
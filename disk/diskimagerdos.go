@@ -2,6 +2,7 @@ package disk
 
 import (
 	"bytes"
+	"errors"
 	"regexp"
 	"strings"
 )
@@ -380,3 +381,78 @@ func (dsk *DSKWrapper) RDOSReadFile(file *RDOSFileDescriptor) ([]byte, error) {
 	return data, nil
 
 }
+
+// rdosDriver exposes RDOS volumes through the Driver interface, they are
+// read only.
+type rdosDriver struct {
+	readOnlyDriver
+}
+
+func init() {
+	RegisterDriver(rdosDriver{}, DF_RDOS_3, DF_RDOS_32, DF_RDOS_33)
+}
+
+func (rdosDriver) Name() string {
+	return "RDOS"
+}
+
+func (rdosDriver) BlockSize() int {
+	return 256
+}
+
+func (rdosDriver) MaxNameLength() int {
+	return RDOS_NAME_LENGTH
+}
+
+func (rdosDriver) Catalog(dsk *DSKWrapper, path string, pattern string) ([]*CatalogEntry, error) {
+
+	files, err := dsk.RDOSGetCatalog(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*CatalogEntry, 0, len(files))
+	for _, fd := range files {
+		out = append(out, &CatalogEntry{
+			Name:        fd.NameUnadorned(),
+			Kind:        int(fd.Type()),
+			Type:        fd.Type().String(),
+			Ext:         fd.Type().Ext(),
+			Class:       rdosDriver{}.Class(int(fd.Type())),
+			LoadAddress: fd.LoadAddress(),
+			Locked:      fd.IsLocked(),
+			fd:          fd,
+		})
+	}
+
+	return out, nil
+
+}
+
+func (rdosDriver) ReadFile(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error) {
+	fd, ok := e.fd.(*RDOSFileDescriptor)
+	if !ok {
+		return nil, errors.New("Not an RDOS catalog entry")
+	}
+	return dsk.RDOSReadFile(fd)
+}
+
+func (rdosDriver) UsedBitmap(dsk *DSKWrapper) ([]bool, error) {
+	return dsk.RDOSUsedBitmap()
+}
+
+func (rdosDriver) TypeFromExt(ext string) int {
+	return int(RDOSFileTypeFromExt(ext))
+}
+
+func (rdosDriver) Class(kind int) CatalogEntryType {
+	switch RDOSFileType(kind) {
+	case FileType_RDOS_AppleSoft:
+		return CETBasicApplesoft
+	case FileType_RDOS_Binary:
+		return CETBinary
+	case FileType_RDOS_Text:
+		return CETText
+	}
+	return CETUnknown
+}
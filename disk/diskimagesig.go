@@ -0,0 +1,392 @@
+package disk
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+	Boot signature database...
+
+	Identifies the operating system on a disk, down to the variant, from
+	fingerprints of the boot code and system files. Each signature names a
+	region of the disk, how to match it and the variant it means:
+
+	    # region        match   value                  format  variant
+	    boot0           prefix  01a527c909d018a5...    dos16   DOS 3.3
+	    dos             sha256  <sha256 of tracks 0-2> -       DOS 3.3 System Master
+	    file:PRODOS     text    "PRODOS 8 V2"          -       ProDOS 8 2.x
+
+	Regions are boot0 (track 0 sector 0), boot1 (the rest of the boot code,
+	track 0 sectors 1-9 or blocks 0-1), dos (all of tracks 0-2 on DOS disks)
+	and file:NAME for a file in the root directory. A value is matched against
+	the start of the region in hex (prefix), the SHA256 of the whole region
+	(sha256) or as text anywhere in it, ignoring case and high bits (text).
+	Signatures with a format are also used to recognize the filesystem.
+
+	The built in signatures can be added to from a file without rebuilding,
+	the most specific match wins, then the one loaded last.
+
+	Only signatures that hold for every copy are built in: the boot sector
+	of each family, the names third party DOSes carry, and the version
+	banners of the ProDOS kernel and the Pascal system. Telling a System
+	Master from a patched DOS, or one kernel release from another, takes a
+	sha256 of the dos or file:PRODOS region of a known good image, which
+	has to come from a signature file made with the shell's signature
+	command.
+*/
+
+type SignatureMatch int
+
+const (
+	SigPrefix SignatureMatch = iota
+	SigText
+	SigSHA256
+)
+
+func (sm SignatureMatch) String() string {
+	switch sm {
+	case SigPrefix:
+		return "prefix"
+	case SigText:
+		return "text"
+	case SigSHA256:
+		return "sha256"
+	}
+	return "Unknown"
+}
+
+// BootSignature is one entry in the signature database
+type BootSignature struct {
+	Region  string
+	Match   SignatureMatch
+	Value   string
+	Format  DiskFormatID // DF_NONE when it says nothing about the filesystem
+	Variant string
+}
+
+var signatureFormats = map[string]DiskFormatID{
+	"-":      DF_NONE,
+	"dos13":  DF_DOS_SECTORS_13,
+	"dos16":  DF_DOS_SECTORS_16,
+	"prodos": DF_PRODOS,
+	"pascal": DF_PASCAL,
+}
+
+const defaultSignatures = `
+# region           match   value                                                             format  variant
+boot0              prefix  99b900080a0a0a990008c8d0f4a62ba9098527adcc03854184408a4a4a4a4a09  dos13   DOS 3.2
+boot0              prefix  01a527c909d018a52b4a4a4a4a09c0853fa95c853e18adfe086dff088dfe08ae  dos16   DOS 3.3
+boot0              prefix  0138b0034c32a18643c903088a29704a4a4a4a09c08549a0ff844828c8b148d0  prodos  ProDOS
+
+# Third party DOS 3.3 replacements put their name in the DOS image
+dos                text    DIVERSI-DOS                                                       -       Diversi-DOS
+dos                text    PRONTO-DOS                                                        -       ProntoDOS
+dos                text    DAVID-DOS                                                         -       David-DOS
+
+# The kernel carries its version banner
+file:PRODOS        text    "PRODOS 1."                                                       -       ProDOS 1.x
+file:PRODOS        text    "PRODOS 8 V1."                                                    -       ProDOS 8 1.x
+file:PRODOS        text    "PRODOS 8 V2."                                                    -       ProDOS 8 2.x
+
+file:SYSTEM.PASCAL text    "PASCAL 1.0"                                                      -       Apple Pascal 1.0
+file:SYSTEM.PASCAL text    "PASCAL 1.1"                                                      -       Apple Pascal 1.1
+file:SYSTEM.PASCAL text    "PASCAL 1.2"                                                      -       Apple Pascal 1.2
+file:SYSTEM.PASCAL text    "PASCAL 1.3"                                                      -       Apple Pascal 1.3
+`
+
+var signatures []*BootSignature
+
+func init() {
+	sigs, err := ParseSignatures(strings.NewReader(defaultSignatures))
+	if err != nil {
+		panic(err)
+	}
+	signatures = sigs
+}
+
+// Signatures returns the signature database
+func Signatures() []*BootSignature {
+	return signatures
+}
+
+// AddSignatures adds to the signature database, later signatures win over
+// earlier ones that are just as specific.
+func AddSignatures(sigs ...*BootSignature) {
+	signatures = append(signatures, sigs...)
+}
+
+// LoadSignatures adds the signatures in a file to the database
+func LoadSignatures(filename string) error {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sigs, err := ParseSignatures(f)
+	if err != nil {
+		return errors.New(filename + ": " + err.Error())
+	}
+
+	AddSignatures(sigs...)
+
+	return nil
+}
+
+// ParseSignatures reads signatures, one per line. Blank lines and lines
+// starting with # are skipped. Text values with spaces are quoted.
+func ParseSignatures(r io.Reader) ([]*BootSignature, error) {
+
+	var out []*BootSignature
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+
+		sig := &BootSignature{}
+		var err error
+
+		fields := strings.Fields(s)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("Line %d: Expected region, match, value, format and variant", line)
+		}
+		sig.Region = fields[0]
+		s = strings.TrimSpace(s[len(fields[0]):])
+		s = strings.TrimSpace(s[len(fields[1]):])
+
+		switch strings.ToLower(fields[1]) {
+		case "prefix":
+			sig.Match = SigPrefix
+		case "text":
+			sig.Match = SigText
+		case "sha256":
+			sig.Match = SigSHA256
+		default:
+			return nil, fmt.Errorf("Line %d: Unknown match %s", line, fields[1])
+		}
+
+		if strings.HasPrefix(s, "\"") {
+			end := strings.Index(s[1:], "\"")
+			if end == -1 {
+				return nil, fmt.Errorf("Line %d: Unterminated quote", line)
+			}
+			sig.Value, err = strconv.Unquote(s[:end+2])
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", line, err)
+			}
+			s = s[end+2:]
+		} else {
+			sig.Value = strings.Fields(s)[0]
+			s = s[len(sig.Value):]
+		}
+
+		rest := strings.Fields(s)
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("Line %d: Expected format and variant", line)
+		}
+
+		id, ok := signatureFormats[strings.ToLower(rest[0])]
+		if !ok {
+			return nil, fmt.Errorf("Line %d: Unknown format %s", line, rest[0])
+		}
+		sig.Format = id
+		sig.Variant = strings.Join(rest[1:], " ")
+
+		if sig.Match != SigText {
+			sig.Value = strings.ToLower(sig.Value)
+			if _, err := hex.DecodeString(sig.Value); err != nil {
+				return nil, fmt.Errorf("Line %d: Bad hex value", line)
+			}
+		}
+
+		out = append(out, sig)
+	}
+
+	return out, scanner.Err()
+}
+
+// Matches checks the signature against the bytes of its region
+func (sig *BootSignature) Matches(region []byte) bool {
+
+	if len(region) == 0 {
+		return false
+	}
+
+	switch sig.Match {
+	case SigPrefix:
+		n := len(sig.Value) / 2
+		if n > len(region) {
+			return false
+		}
+		return hex.EncodeToString(region[:n]) == sig.Value
+	case SigSHA256:
+		sum := sha256.Sum256(region)
+		return hex.EncodeToString(sum[:]) == sig.Value
+	case SigText:
+		text := make([]byte, len(region))
+		for i, v := range region {
+			text[i] = v & 0x7f
+		}
+		return strings.Contains(strings.ToUpper(string(text)), strings.ToUpper(sig.Value))
+	}
+
+	return false
+}
+
+// bootFormat recognizes the filesystem from the boot sector alone. Sector 0
+// is at the start of the image whatever the sector order.
+func bootFormat(data []byte) (DiskFormat, bool) {
+
+	boot0 := data
+	if len(boot0) > STD_BYTES_PER_SECTOR {
+		boot0 = boot0[:STD_BYTES_PER_SECTOR]
+	}
+
+	for i := len(signatures) - 1; i >= 0; i-- {
+		sig := signatures[i]
+		if sig.Region == "boot0" && sig.Format != DF_NONE && sig.Matches(boot0) {
+			return GetDiskFormat(sig.Format), true
+		}
+	}
+
+	return GetDiskFormat(DF_NONE), false
+}
+
+// readSectors gives a copy of the sectors in logical order
+func (dsk *DSKWrapper) readSectors(tracks int, first, last int) []byte {
+
+	var out []byte
+	for t := 0; t < tracks; t++ {
+		for s := first; s <= last; s++ {
+			if dsk.Seek(t, s) != nil {
+				return nil
+			}
+			out = append(out, dsk.Read()...)
+		}
+	}
+
+	return out
+}
+
+// SignatureRegion gives the bytes of a region of the disk, or nil if the
+// disk doesn't have it.
+func (dsk *DSKWrapper) SignatureRegion(region string) []byte {
+
+	spt := STD_SECTORS_PER_TRACK
+	if len(dsk.Data) == STD_DISK_BYTES_OLD {
+		spt = STD_SECTORS_PER_TRACK_OLD
+	}
+
+	switch {
+	case region == "boot0":
+		if len(dsk.Data) < STD_BYTES_PER_SECTOR {
+			return nil
+		}
+		return append([]byte(nil), dsk.Data[:STD_BYTES_PER_SECTOR]...)
+
+	case region == "boot1":
+		switch dsk.Format.ID {
+		case DF_DOS_SECTORS_13, DF_DOS_SECTORS_16:
+			return dsk.readSectors(1, 1, 9)
+		}
+		var out []byte
+		for block := 0; block < 2; block++ {
+			data, err := dsk.PRODOSGetBlock(block)
+			if err != nil {
+				return nil
+			}
+			out = append(out, data...)
+		}
+		return out
+
+	case region == "dos":
+		switch dsk.Format.ID {
+		case DF_DOS_SECTORS_13, DF_DOS_SECTORS_16:
+			return dsk.readSectors(3, 0, spt-1)
+		}
+		return nil
+
+	case strings.HasPrefix(region, "file:"):
+		drv, err := dsk.Driver()
+		if err != nil {
+			return nil
+		}
+		name := region[len("file:"):]
+		files, err := drv.Catalog(dsk, "", "*")
+		if err != nil {
+			return nil
+		}
+		for _, e := range files {
+			if !e.Dir && strings.EqualFold(e.Name, name) {
+				data, err := drv.ReadFile(dsk, e)
+				if err != nil {
+					return nil
+				}
+				return data
+			}
+		}
+	}
+
+	return nil
+}
+
+// SignatureRegions lists the regions on the disk the database looks at
+func (dsk *DSKWrapper) SignatureRegions() []string {
+
+	out := []string{"boot0", "boot1", "dos"}
+	seen := map[string]bool{"boot0": true, "boot1": true, "dos": true}
+	for _, sig := range signatures {
+		if !seen[sig.Region] {
+			seen[sig.Region] = true
+			out = append(out, sig.Region)
+		}
+	}
+
+	return out
+}
+
+// MatchSignature finds the best signature for the disk, nil if none match
+func (dsk *DSKWrapper) MatchSignature() *BootSignature {
+
+	regions := make(map[string][]byte)
+
+	var best *BootSignature
+	for _, sig := range signatures {
+		if best != nil && sig.Match < best.Match {
+			continue
+		}
+		data, ok := regions[sig.Region]
+		if !ok {
+			data = dsk.SignatureRegion(sig.Region)
+			regions[sig.Region] = data
+		}
+		if sig.Matches(data) {
+			best = sig
+		}
+	}
+
+	return best
+}
+
+// OSVariant names the operating system on the disk, "" if it isn't known
+func (dsk *DSKWrapper) OSVariant() string {
+	if sig := dsk.MatchSignature(); sig != nil {
+		return sig.Variant
+	}
+	return ""
+}
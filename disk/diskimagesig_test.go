@@ -0,0 +1,81 @@
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testAddSignature adds a sha256 signature for the region as it is now,
+// the database is put back when the test ends
+func testAddSignature(t *testing.T, dsk *DSKWrapper, region string, variant string) {
+
+	t.Helper()
+
+	saved := signatures
+	t.Cleanup(func() { signatures = saved })
+
+	sum := sha256.Sum256(dsk.SignatureRegion(region))
+	sigs, err := ParseSignatures(strings.NewReader(region + " sha256 " + hex.EncodeToString(sum[:]) + " - " + variant))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures = append(append([]*BootSignature(nil), signatures...), sigs...)
+
+}
+
+const testTrackBytes = STD_SECTORS_PER_TRACK * STD_BYTES_PER_SECTOR
+
+func TestSignatureDOSImage(t *testing.T) {
+
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(140, 100))
+
+	boot0, _ := hex.DecodeString("01a527c909d018a52b4a4a4a4a09c0853fa95c853e18adfe086dff088dfe08ae")
+	copy(dsk.Data, boot0)
+	copy(dsk.Data[STD_BYTES_PER_SECTOR:3*testTrackBytes], testData(141, 3*testTrackBytes))
+
+	if v := dsk.OSVariant(); v != "DOS 3.3" {
+		t.Fatalf("variant %q before the signature is added", v)
+	}
+
+	testAddSignature(t, dsk, "dos", "DOS 3.3 System Master")
+	if v := dsk.OSVariant(); v != "DOS 3.3 System Master" {
+		t.Errorf("variant %q with the signature", v)
+	}
+
+	// a patched DOS is still DOS 3.3, but not the System Master
+	dsk.Data[2*testTrackBytes] ^= 0xff
+	if v := dsk.OSVariant(); v != "DOS 3.3" {
+		t.Errorf("patched DOS is %q", v)
+	}
+
+}
+
+func TestSignatureProDOSKernel(t *testing.T) {
+
+	dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, testData(142, 100))
+
+	kernel := append([]byte("PRODOS 8 V2.0.3"), testData(143, 15000)...)
+	if err := dsk.PRODOSWriteFile("", "PRODOS", FileType_PD_SYS, kernel, 0x2000); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := dsk.OSVariant(); v != "ProDOS 8 2.x" {
+		t.Fatalf("variant %q from the banner", v)
+	}
+
+	testAddSignature(t, dsk, "file:PRODOS", "ProDOS 8 2.0.3")
+	if v := dsk.OSVariant(); v != "ProDOS 8 2.0.3" {
+		t.Errorf("variant %q with the signature", v)
+	}
+
+	kernel[100] ^= 0xff
+	if err := dsk.PRODOSWriteFile("", "PRODOS", FileType_PD_SYS, kernel, 0x2000); err != nil {
+		t.Fatal(err)
+	}
+	if v := dsk.OSVariant(); v != "ProDOS 8 2.x" {
+		t.Errorf("changed kernel is %q", v)
+	}
+
+}
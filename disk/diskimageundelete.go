@@ -0,0 +1,464 @@
+package disk
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+	Deleted file recovery...
+
+	DOS 3.3 deletes a file by moving the track of its first T/S list into
+	the last byte of the name and putting $FF in its place, ProDOS just
+	zeroes the storage type and leaves the rest of the entry alone. Either
+	way the sectors or blocks are only marked free, so until they are used
+	again the file is still there.
+
+	Each deleted entry is scored by how much of what it used is still free,
+	and whether its T/S list or index blocks still make sense. Restoring an
+	entry puts the catalog entry back and marks its allocation in use again.
+*/
+
+// DeletedEntry is a deleted file found in a catalog. The CatalogEntry is
+// the file as it was, and can be read with the driver's ReadFile.
+type DeletedEntry struct {
+	CatalogEntry
+	Total  int  // sectors or blocks the file used
+	Free   int  // how many of those are still free
+	Intact bool // the T/S list or index blocks still make sense
+	units  []int
+}
+
+// Score rates the chance of getting the file back, from 0 to 100
+func (d *DeletedEntry) Score() int {
+	if !d.Intact || d.Total == 0 {
+		return 0
+	}
+	return d.Free * 100 / d.Total
+}
+
+// Recoverable is true if nothing the file used has been given out again
+func (d *DeletedEntry) Recoverable() bool {
+	return d.Intact && d.Total > 0 && d.Free == d.Total
+}
+
+// AppleDOSGetDeleted lists the deleted entries in the catalog
+func (dsk *DSKWrapper) AppleDOSGetDeleted() ([]*DeletedEntry, error) {
+
+	vtoc, err := dsk.AppleDOSGetVTOC()
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, spt := dsk.Format.TPD(), dsk.Format.SPT()
+
+	var out []*DeletedEntry
+
+	seen := make(map[int]bool)
+	ct, cs := vtoc.GetCatalogStart()
+	for ct != 0 && ct < tracks && cs < spt && !seen[ct*spt+cs] {
+
+		seen[ct*spt+cs] = true
+
+		err = dsk.Seek(ct, cs)
+		if err != nil {
+			return out, err
+		}
+		data := append([]byte(nil), dsk.Read()...)
+
+		for slot := 0; slot < 7; slot++ {
+
+			pos := 0x0b + 35*slot
+
+			fd := FileDescriptor{}
+			fd.SetData(data[pos:pos+35], ct, cs, pos)
+
+			if fd.Data[0] != 0xff || strings.Trim(string(fd.Data[0x03:0x20]), "\xa0 ") == "" {
+				continue
+			}
+
+			// the entry as it was, the last letter of the name is lost
+			fd.Data[0x00] = fd.Data[0x20]
+			fd.Data[0x20] = 0xa0
+
+			d := &DeletedEntry{CatalogEntry: *appleDOSEntry(fd)}
+			d.units, d.Intact = dsk.appleDOSDeletedSectors(fd)
+			d.Total = len(d.units)
+			for _, u := range d.units {
+				if vtoc.IsTSFree(u/spt, u%spt) {
+					d.Free++
+				}
+			}
+
+			out = append(out, d)
+
+		}
+
+		ct, cs = int(data[1]), int(data[2])
+
+	}
+
+	return out, nil
+
+}
+
+// appleDOSDeletedSectors lists the sectors of a deleted file as track*spt+sector,
+// T/S lists first. It is not intact if the T/S list points anywhere DOS
+// would not have put the file.
+func (dsk *DSKWrapper) appleDOSDeletedSectors(fd FileDescriptor) ([]int, bool) {
+
+	tracks, spt := dsk.Format.TPD(), dsk.Format.SPT()
+
+	ok := func(t, s int) bool {
+		return t > 0 && t < tracks && t != APPLEDOS_CATALOG_TRACK && s < spt
+	}
+
+	var units []int
+	seen := make(map[int]bool)
+
+	lt, ls := fd.GetTrackSectorListStart()
+	if !ok(lt, ls) {
+		return units, false
+	}
+
+	for lt != 0 {
+
+		if !ok(lt, ls) || seen[lt*spt+ls] {
+			return units, false
+		}
+		seen[lt*spt+ls] = true
+		units = append(units, lt*spt+ls)
+
+		if dsk.Seek(lt, ls) != nil {
+			return units, false
+		}
+		list := append([]byte(nil), dsk.Read()...)
+
+		for i := 0; i < APPLEDOS_TS_PAIRS; i++ {
+			t, s := int(list[0x0c+i*2]), int(list[0x0d+i*2])
+			if t == 0 {
+				continue // not allocated
+			}
+			if !ok(t, s) {
+				return units, false
+			}
+			units = append(units, t*spt+s)
+		}
+
+		lt, ls = int(list[1]), int(list[2])
+
+	}
+
+	return units, true
+
+}
+
+// AppleDOSUndelete puts a deleted file back in the catalog
+func (dsk *DSKWrapper) AppleDOSUndelete(d *DeletedEntry) error {
+
+	fd, ok := d.fd.(FileDescriptor)
+	if !ok {
+		return errors.New("Not an AppleDOS catalog entry")
+	}
+
+	if !d.Recoverable() {
+		return errors.New("File has been overwritten")
+	}
+
+	// make sure the entry hasn't been reused since it was listed
+	err := dsk.Seek(fd.trackid, fd.sectorid)
+	if err != nil {
+		return err
+	}
+	entry := dsk.Read()[fd.sectoroffset : fd.sectoroffset+35]
+	if entry[0] != 0xff || entry[0x20] != fd.Data[0] || string(entry[1:0x20]) != string(fd.Data[1:0x20]) {
+		return errors.New("Catalog entry has been reused")
+	}
+
+	if _, err := dsk.AppleDOSNamedCatalogEntry(fd.NameUnadorned()); err == nil {
+		return errors.New("File exists")
+	}
+
+	vtoc, err := dsk.AppleDOSGetVTOC()
+	if err != nil {
+		return err
+	}
+
+	spt := dsk.Format.SPT()
+	for _, u := range d.units {
+		vtoc.SetTSFree(u/spt, u%spt, false)
+	}
+
+	err = vtoc.Publish(dsk)
+	if err != nil {
+		return err
+	}
+
+	return fd.Publish(dsk)
+
+}
+
+// PRODOSGetDeleted lists the deleted entries in every directory, including
+// those in deleted directories
+func (dsk *DSKWrapper) PRODOSGetDeleted() ([]*DeletedEntry, error) {
+
+	vdh, err := dsk.PRODOSGetVDH(2)
+	if err != nil {
+		return nil, err
+	}
+
+	vbm, err := dsk.PRODOSGetVolumeBitmap()
+	if err != nil {
+		return nil, err
+	}
+
+	total := vdh.GetTotalBlocks()
+
+	var out []*DeletedEntry
+
+	seen := make(map[int]bool)
+
+	var dir func(path string, key int)
+	dir = func(path string, key int) {
+
+		for b := key; b > 1 && b < total && !seen[b]; {
+
+			seen[b] = true
+
+			data, err := dsk.PRODOSGetBlock(b)
+			if err != nil {
+				return
+			}
+
+			first := 4
+			if b == key {
+				first += PRODOS_ENTRY_SIZE
+			}
+
+			for pos := first; pos+PRODOS_ENTRY_SIZE <= PRODOS_BLOCK_BYTES; pos += PRODOS_ENTRY_SIZE {
+
+				fd := ProDOSFileDescriptor{}
+				fd.SetData(data[pos:pos+PRODOS_ENTRY_SIZE], b, pos)
+
+				st := fd.GetStorageType()
+				if st == StorageType_SubDir_File {
+					dir(strings.TrimPrefix(path+"/"+fd.NameUnadorned(), "/"), fd.IndexBlock())
+				}
+				if st != StorageType_Inactive || fd.GetNameLength() == 0 {
+					continue
+				}
+
+				fd.SetStorageType(dsk.prodosDeletedStorage(fd))
+
+				d := &DeletedEntry{CatalogEntry: *proDOSEntry(path, fd)}
+				d.units, d.Intact = dsk.prodosDeletedBlocks(fd, total)
+				d.Total = len(d.units)
+				for _, u := range d.units {
+					if vbm.IsBlockFree(u) {
+						d.Free++
+					}
+				}
+
+				out = append(out, d)
+
+				if d.Dir && d.Intact {
+					dir(d.FullName(), fd.IndexBlock())
+				}
+
+			}
+
+			b = int(data[2]) + 256*int(data[3])
+
+		}
+
+	}
+
+	dir("", 2)
+
+	return out, nil
+
+}
+
+// prodosDeletedStorage works out the storage type a deleted entry had, from
+// its file type and size
+func (dsk *DSKWrapper) prodosDeletedStorage(fd ProDOSFileDescriptor) ProDOSStorageType {
+
+	if fd.Type() == FileType_PD_Directory {
+		return StorageType_SubDir_File
+	}
+
+	// a forked file's entry covers just the extended key block, which
+	// holds a mini entry for each fork
+	if fd.Size() == PRODOS_BLOCK_BYTES && fd.TotalBlocks() > 1 {
+		kb, err := dsk.PRODOSGetBlock(fd.IndexBlock())
+		if err == nil {
+			d, r := kb[0]&0x0f, kb[PRODOS_FORK_ENTRY_OFFSET]&0x0f
+			if d >= 1 && d <= 3 && r >= 1 && r <= 3 {
+				return StorageType_Extended
+			}
+		}
+	}
+
+	st, _, err := PRODOSStorageForSize(fd.Size())
+	if err != nil {
+		return StorageType_Inactive
+	}
+
+	return st
+
+}
+
+// prodosDeletedBlocks lists the blocks of a deleted file, it is not intact
+// if an index block points off the volume or past the end of the file, or
+// a directory's blocks no longer link up.
+func (dsk *DSKWrapper) prodosDeletedBlocks(fd ProDOSFileDescriptor, total int) ([]int, bool) {
+
+	var blocks []int
+
+	ok := func(b int) bool {
+		return b > 1 && b < total
+	}
+
+	// index walks an index block, depth 1 for a sapling and 2 for a tree,
+	// needed is the number of data blocks it covers
+	var index func(b int, depth int, needed int) bool
+	index = func(b int, depth int, needed int) bool {
+		if !ok(b) {
+			return false
+		}
+		blocks = append(blocks, b)
+		data, err := dsk.PRODOSGetBlock(b)
+		if err != nil {
+			return false
+		}
+		per := 1
+		if depth > 1 {
+			per = 256
+		}
+		for i := 0; i < 256; i++ {
+			p := int(data[i]) + 256*int(data[i+256])
+			switch {
+			case p == 0:
+				// a hole in a sparse file
+			case i*per >= needed:
+				return false
+			case depth > 1:
+				left := needed - i*per
+				if left > 256 {
+					left = 256
+				}
+				if !index(p, depth-1, left) {
+					return false
+				}
+			case !ok(p):
+				return false
+			default:
+				blocks = append(blocks, p)
+			}
+		}
+		return true
+	}
+
+	var fork func(st ProDOSStorageType, key int, size int) bool
+	fork = func(st ProDOSStorageType, key int, size int) bool {
+		switch st {
+		case StorageType_Seedling:
+			if !ok(key) {
+				return false
+			}
+			blocks = append(blocks, key)
+			return true
+		case StorageType_Sapling:
+			return index(key, 1, PRODOSDataBlocks(size))
+		case StorageType_Tree:
+			return index(key, 2, PRODOSDataBlocks(size))
+		}
+		return false
+	}
+
+	key := fd.IndexBlock()
+
+	switch fd.GetStorageType() {
+	case StorageType_SubDir_File:
+		for b, prev := key, 0; b != 0; {
+			if !ok(b) || len(blocks) >= total {
+				return blocks, false
+			}
+			data, err := dsk.PRODOSGetBlock(b)
+			if err != nil || int(data[0])+256*int(data[1]) != prev {
+				return blocks, false
+			}
+			if b == key && ProDOSStorageType(data[4]>>4) != StorageType_SubDir_Header {
+				return blocks, false
+			}
+			blocks = append(blocks, b)
+			prev, b = b, int(data[2])+256*int(data[3])
+		}
+		return blocks, true
+	case StorageType_Extended:
+		if !ok(key) {
+			return blocks, false
+		}
+		blocks = append(blocks, key)
+		kb, err := dsk.PRODOSGetBlock(key)
+		if err != nil {
+			return blocks, false
+		}
+		for _, off := range []int{0, PRODOS_FORK_ENTRY_OFFSET} {
+			f := prodosForkDescriptor(fd, kb[off:off+PRODOS_FORK_ENTRY_SIZE])
+			if !fork(f.GetStorageType(), f.IndexBlock(), f.Size()) {
+				return blocks, false
+			}
+		}
+		return blocks, true
+	}
+
+	return blocks, fork(fd.GetStorageType(), key, fd.Size())
+
+}
+
+// PRODOSUndelete puts a deleted file back in its directory
+func (dsk *DSKWrapper) PRODOSUndelete(d *DeletedEntry) error {
+
+	fd, ok := d.fd.(ProDOSFileDescriptor)
+	if !ok {
+		return errors.New("Not a ProDOS catalog entry")
+	}
+
+	if !d.Recoverable() {
+		return errors.New("File has been overwritten")
+	}
+
+	vdh, _, _, err := dsk.PRODOSFindDirBlocks(2, d.Path)
+	if err != nil {
+		return errors.New("Directory " + d.Path + " is deleted, undelete it first")
+	}
+
+	// make sure the entry hasn't been reused since it was listed
+	data, err := dsk.PRODOSGetBlock(fd.blockid)
+	if err != nil {
+		return err
+	}
+	entry := data[fd.blockoffset : fd.blockoffset+PRODOS_ENTRY_SIZE]
+	if entry[0] != fd.Data[0]&0x0f || string(entry[1:]) != string(fd.Data[1:]) {
+		return errors.New("Directory entry has been reused")
+	}
+
+	if _, err := dsk.PRODOSGetNamedEntry(d.Path, d.Name); err == nil {
+		return errors.New("File exists")
+	}
+
+	err = dsk.PRODOSMarkBlocks(d.units, false)
+	if err != nil {
+		return err
+	}
+
+	err = fd.Publish(dsk)
+	if err != nil {
+		return err
+	}
+
+	vdh.SetFileCount(vdh.GetFileCount() + 1)
+	return vdh.Publish(dsk)
+
+}
@@ -0,0 +1,170 @@
+package disk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testDeleted finds a deleted file by name
+func testDeleted(t *testing.T, dsk *DSKWrapper, name string) *DeletedEntry {
+
+	t.Helper()
+
+	d, err := dsk.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := d.(Undeleter).Deleted(dsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range list {
+		if strings.EqualFold(e.Name, name) {
+			return e
+		}
+	}
+	return nil
+
+}
+
+func TestUndelete(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		fresh  func(t *testing.T) *DSKWrapper
+		kind   int
+		reused string
+	}{
+		{"DOS 3.3", func(t *testing.T) *DSKWrapper {
+			return testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(150, 1000))
+		}, int(FileTypeBIN), "Catalog entry has been reused"},
+		{"ProDOS", func(t *testing.T) *DSKWrapper {
+			return testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, testData(150, 1000))
+		}, int(FileType_PD_BIN), "Directory entry has been reused"},
+	}
+
+	first, second := testData(151, 5000), testData(152, 7000)
+
+	for _, tt := range tests {
+
+		// setup writes FIRST then SECOND and deletes both
+		setup := func(t *testing.T) (*DSKWrapper, Driver) {
+			dsk := tt.fresh(t)
+			d, err := dsk.Driver()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range []struct {
+				name string
+				data []byte
+			}{{"FIRST", first}, {"SECOND", second}} {
+				if err := d.WriteFile(dsk, "", f.name, tt.kind, f.data, 0x2000); err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+			}
+			for _, name := range []string{"FIRST", "SECOND"} {
+				if err := d.DeleteFile(dsk, "", name); err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+			}
+			return dsk, d
+		}
+
+		// put back and read back byte for byte
+		dsk, d := setup(t)
+		for _, f := range []struct {
+			name string
+			data []byte
+		}{{"FIRST", first}, {"SECOND", second}} {
+			e := testDeleted(t, dsk, f.name)
+			if e == nil {
+				t.Fatalf("%s: %s isn't listed as deleted", tt.name, f.name)
+			}
+			if !e.Recoverable() || e.Score() != 100 {
+				t.Errorf("%s: %s listed with score %d", tt.name, f.name, e.Score())
+			}
+			if err := d.(Undeleter).Undelete(dsk, e); err != nil {
+				t.Fatalf("%s: %s: %v", tt.name, f.name, err)
+			}
+			back := testEntry(t, dsk, "", f.name)
+			if back == nil {
+				t.Fatalf("%s: %s isn't back in the catalog", tt.name, f.name)
+			}
+			data, err := d.ReadFile(dsk, back)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", tt.name, f.name, err)
+			}
+			if !bytes.Equal(data, f.data) {
+				t.Errorf("%s: %s read back %d bytes, wrote %d", tt.name, f.name, len(data), len(f.data))
+			}
+		}
+		if problems, err := d.(Checker).Check(dsk, false); err != nil || len(problems) != 0 {
+			t.Errorf("%s: undeleted volume has problems: %v %v", tt.name, problems, err)
+		}
+
+		// a new file takes FIRST's entry, all of its space and some of
+		// SECOND's
+		dsk, d = setup(t)
+		stale := testDeleted(t, dsk, "FIRST")
+		if err := d.WriteFile(dsk, "", "NEW", tt.kind, testData(153, 9000), 0x2000); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if e := testDeleted(t, dsk, "FIRST"); e != nil {
+			t.Errorf("%s: FIRST is still listed after its entry was reused", tt.name)
+		}
+		if err := d.(Undeleter).Undelete(dsk, stale); err == nil || err.Error() != tt.reused {
+			t.Errorf("%s: undeleting a reused entry gave %v", tt.name, err)
+		}
+		e := testDeleted(t, dsk, "SECOND")
+		if e == nil {
+			t.Fatalf("%s: SECOND isn't listed as deleted", tt.name)
+		}
+		if e.Recoverable() || e.Score() == 100 {
+			t.Errorf("%s: partly overwritten SECOND listed with score %d, recoverable %v", tt.name, e.Score(), e.Recoverable())
+		}
+		if err := d.(Undeleter).Undelete(dsk, e); err == nil {
+			t.Errorf("%s: undeleted a partly overwritten file", tt.name)
+		}
+
+		// a live file has taken the name
+		dsk, d = setup(t)
+		if err := d.RenameFile(dsk, "", "HELLO", "FIRST"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := d.(Undeleter).Undelete(dsk, testDeleted(t, dsk, "FIRST")); err == nil || err.Error() != "File exists" {
+			t.Errorf("%s: undeleting over a live file gave %v", tt.name, err)
+		}
+
+	}
+
+}
+
+func TestAppleDOSUndeleteLongName(t *testing.T) {
+
+	// DOS keeps the T/S list track in the last byte of the name
+	name := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123"
+	file := testData(154, 3000)
+
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(155, 1000))
+	if err := dsk.AppleDOSWriteFile(name, FileTypeBIN, file, 0x2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsk.AppleDOSDeleteFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := dsk.AppleDOSGetDeleted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !strings.EqualFold(list[0].Name, name[:len(name)-1]) {
+		t.Fatalf("deleted files listed as %v", list)
+	}
+	if err := dsk.AppleDOSUndelete(list[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	testReadBack(t, dsk, name[:len(name)-1], file)
+
+}
@@ -0,0 +1,254 @@
+package disk
+
+import (
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+/*
+	WOZ format loader...
+
+	WOZ images hold the raw bitstream of each track. We decode the bits
+	into disk bytes, then denibblize those into sectors so the rest of the
+	package can work with a plain sector image.
+*/
+
+const WOZ_HEADER_SIZE = 12
+const WOZ1_TRACK_SIZE = 6656
+const WOZ1_TRACK_BITSTREAM = 6646
+const WOZ2_TRK_ENTRY_SIZE = 8
+const WOZ_TMAP_SIZE = 160
+const WOZ_NO_TRACK = 0xff
+
+var MAGIC_WOZ1 = []byte{'W', 'O', 'Z', '1', 0xff, 0x0a, 0x0d, 0x0a}
+var MAGIC_WOZ2 = []byte{'W', 'O', 'Z', '2', 0xff, 0x0a, 0x0d, 0x0a}
+
+type WOZInfo struct {
+	Data []byte
+}
+
+func (wi *WOZInfo) GetVersion() int {
+	return int(wi.Data[0x00])
+}
+
+// GetDiskType returns 1 for 5.25" and 2 for 3.5" media
+func (wi *WOZInfo) GetDiskType() int {
+	return int(wi.Data[0x01])
+}
+
+func (wi *WOZInfo) IsWriteProtected() bool {
+	return wi.Data[0x02] == 1
+}
+
+func (wi *WOZInfo) IsSynchronized() bool {
+	return wi.Data[0x03] == 1
+}
+
+func (wi *WOZInfo) GetCreator() string {
+	return strings.TrimRight(string(wi.Data[0x05:0x25]), " \x00")
+}
+
+// GetBootSectorFormat (WOZ2 only) returns 1 for 16 sector, 2 for 13 sector
+// and 3 for both
+func (wi *WOZInfo) GetBootSectorFormat() int {
+	if wi.GetVersion() < 2 {
+		return 0
+	}
+	return int(wi.Data[0x26])
+}
+
+type WOZImage struct {
+	Version int
+	Info    *WOZInfo
+	TMap    []byte
+	Meta    map[string]string
+	Tracks  [][]byte // disk bytes for each quarter track in TMAP, by TRKS index
+}
+
+func IsWOZ(data []byte) (bool, int) {
+	if len(data) < WOZ_HEADER_SIZE {
+		return false, 0
+	}
+	switch {
+	case matchAt(data, 0, MAGIC_WOZ1):
+		return true, 1
+	case matchAt(data, 0, MAGIC_WOZ2):
+		return true, 2
+	}
+	return false, 0
+}
+
+func wozUint16(b []byte) int {
+	return int(b[0]) + 256*int(b[1])
+}
+
+func wozUint32(b []byte) int {
+	return int(b[0]) + 256*int(b[1]) + 65536*int(b[2]) + 16777216*int(b[3])
+}
+
+// wozBitsToNibbles turns a track bitstream into disk bytes the same way the
+// disk controller latches them. Two revolutions are read so that a sector
+// crossing the end of the stream is still seen whole.
+func wozBitsToNibbles(bits []byte, bitCount int) []byte {
+
+	if bitCount == 0 || bitCount > len(bits)*8 {
+		bitCount = len(bits) * 8
+	}
+
+	out := make([]byte, 0, (bitCount*2)/8)
+	var latch byte
+
+	for i := 0; i < bitCount*2; i++ {
+		pos := i % bitCount
+		bit := (bits[pos/8] >> uint(7-pos%8)) & 0x01
+		latch = (latch << 1) | bit
+		if latch&0x80 != 0 {
+			out = append(out, latch)
+			latch = 0
+		}
+	}
+
+	return out
+}
+
+func parseWOZMeta(chunk []byte) map[string]string {
+	meta := make(map[string]string)
+	for _, line := range strings.Split(string(chunk), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+		meta[parts[0]] = parts[1]
+	}
+	return meta
+}
+
+func ParseWOZ(data []byte) (*WOZImage, error) {
+
+	ok, version := IsWOZ(data)
+	if !ok {
+		return nil, errors.New("Not a WOZ image")
+	}
+
+	crc := uint32(wozUint32(data[8:12]))
+	if crc != 0 && crc != crc32.ChecksumIEEE(data[WOZ_HEADER_SIZE:]) {
+		return nil, errors.New("WOZ checksum mismatch")
+	}
+
+	woz := &WOZImage{
+		Version: version,
+		Meta:    make(map[string]string),
+	}
+
+	var trks []byte
+
+	ptr := WOZ_HEADER_SIZE
+	for ptr+8 <= len(data) {
+		id := string(data[ptr : ptr+4])
+		size := wozUint32(data[ptr+4 : ptr+8])
+		ptr += 8
+		if ptr+size > len(data) {
+			return nil, errors.New("WOZ chunk " + id + " is truncated")
+		}
+		chunk := data[ptr : ptr+size]
+		switch id {
+		case "INFO":
+			woz.Info = &WOZInfo{Data: chunk}
+		case "TMAP":
+			woz.TMap = chunk
+		case "TRKS":
+			trks = chunk
+		case "META":
+			woz.Meta = parseWOZMeta(chunk)
+		}
+		ptr += size
+	}
+
+	if woz.Info == nil || len(woz.Info.Data) < 0x25 || len(woz.TMap) < WOZ_TMAP_SIZE || trks == nil {
+		return nil, errors.New("WOZ image is missing INFO, TMAP or TRKS")
+	}
+
+	if woz.Info.GetDiskType() != 1 {
+		return nil, errors.New("Only 5.25 inch WOZ images are supported")
+	}
+
+	if version == 1 {
+		for off := 0; off+WOZ1_TRACK_SIZE <= len(trks); off += WOZ1_TRACK_SIZE {
+			trk := trks[off : off+WOZ1_TRACK_SIZE]
+			bitCount := wozUint16(trk[WOZ1_TRACK_BITSTREAM+2:])
+			woz.Tracks = append(woz.Tracks, wozBitsToNibbles(trk[:WOZ1_TRACK_BITSTREAM], bitCount))
+		}
+	} else {
+		// WOZ2 track entries point at 512 byte blocks from the start of the file
+		for i := 0; i < WOZ_TMAP_SIZE && (i+1)*WOZ2_TRK_ENTRY_SIZE <= len(trks); i++ {
+			entry := trks[i*WOZ2_TRK_ENTRY_SIZE : (i+1)*WOZ2_TRK_ENTRY_SIZE]
+			start := wozUint16(entry[0:]) * 512
+			blocks := wozUint16(entry[2:])
+			bitCount := wozUint32(entry[4:])
+			if blocks == 0 || start+blocks*512 > len(data) {
+				woz.Tracks = append(woz.Tracks, nil)
+				continue
+			}
+			woz.Tracks = append(woz.Tracks, wozBitsToNibbles(data[start:start+blocks*512], bitCount))
+		}
+	}
+
+	return woz, nil
+}
+
+// TrackNibbles returns the disk bytes for a whole track (quarter track 0 of it)
+func (woz *WOZImage) TrackNibbles(track int) []byte {
+	qt := track * 4
+	if qt >= len(woz.TMap) {
+		return nil
+	}
+	idx := int(woz.TMap[qt])
+	if idx == WOZ_NO_TRACK || idx >= len(woz.Tracks) {
+		return nil
+	}
+	return woz.Tracks[idx]
+}
+
+// Sectors decodes the whole disk into a sector image
+func (woz *WOZImage) Sectors() ([]byte, NibbleEncoding, int) {
+	tracks := make([][]byte, STD_TRACKS_PER_DISK)
+	for t := range tracks {
+		tracks[t] = woz.TrackNibbles(t)
+	}
+	return SectorsFromNibbleTracks(tracks)
+}
+
+// GetMeta returns a META field, or an empty string
+func (woz *WOZImage) GetMeta(key string) string {
+	return woz.Meta[key]
+}
+
+func NewDSKWrapperWOZ(nibbler Nibbler, data []byte, filename string) (*DSKWrapper, error) {
+
+	woz, err := ParseWOZ(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sectors, _, missing := woz.Sectors()
+	if missing == len(sectors)/STD_BYTES_PER_SECTOR {
+		return nil, errors.New("No readable sectors in WOZ image")
+	}
+
+	this := &DSKWrapper{}
+
+	this.SetData(sectors)
+	this.Filename = filename
+	this.Layout = SectorOrderDOS33
+	this.CurrentSectorOrder = DOS_33_SECTOR_ORDER
+	this.Nibbles = nibbler
+	this.WOZ = woz
+	// We can't rebuild the bitstream, so changes cannot be written back
+	this.WriteProtected = true
+
+	this.Identify()
+
+	return this, nil
+
+}
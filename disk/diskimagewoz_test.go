@@ -0,0 +1,149 @@
+package disk
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+)
+
+// wozChunk frames a WOZ chunk
+func wozChunk(id string, data []byte) []byte {
+	size := len(data)
+	out := append([]byte(id), byte(size), byte(size>>8), byte(size>>16), byte(size>>24))
+	return append(out, data...)
+}
+
+// testWOZ wraps one stream of disk bytes per track in a WOZ image, each
+// byte written as eight bits with no sync padding.
+func testWOZ(version int, tracks [][]byte) []byte {
+
+	info := make([]byte, 60)
+	info[0x00] = byte(version)
+	info[0x01] = 1 // 5.25"
+	copy(info[0x05:0x25], "dskalyzer test                  ")
+
+	tmap := make([]byte, WOZ_TMAP_SIZE)
+	for i := range tmap {
+		tmap[i] = WOZ_NO_TRACK
+	}
+	for t := range tracks {
+		tmap[t*4] = byte(t)
+	}
+
+	var trks []byte
+	if version == 1 {
+		for _, trk := range tracks {
+			entry := make([]byte, WOZ1_TRACK_SIZE)
+			copy(entry, trk)
+			bits := len(trk) * 8
+			entry[WOZ1_TRACK_BITSTREAM] = byte(len(trk))
+			entry[WOZ1_TRACK_BITSTREAM+1] = byte(len(trk) >> 8)
+			entry[WOZ1_TRACK_BITSTREAM+2] = byte(bits)
+			entry[WOZ1_TRACK_BITSTREAM+3] = byte(bits >> 8)
+			trks = append(trks, entry...)
+		}
+	} else {
+		// header, INFO, TMAP and the TRKS entries fill the first three blocks
+		entries := make([]byte, WOZ_TMAP_SIZE*WOZ2_TRK_ENTRY_SIZE)
+		var bits []byte
+		block := 3
+		for t, trk := range tracks {
+			blocks := (len(trk) + 511) / 512
+			e := entries[t*WOZ2_TRK_ENTRY_SIZE:]
+			e[0], e[1] = byte(block), byte(block>>8)
+			e[2], e[3] = byte(blocks), byte(blocks>>8)
+			n := len(trk) * 8
+			e[4], e[5], e[6], e[7] = byte(n), byte(n>>8), byte(n>>16), byte(n>>24)
+			padded := make([]byte, blocks*512)
+			copy(padded, trk)
+			bits = append(bits, padded...)
+			block += blocks
+		}
+		trks = append(entries, bits...)
+	}
+
+	body := wozChunk("INFO", info)
+	body = append(body, wozChunk("TMAP", tmap)...)
+	body = append(body, wozChunk("TRKS", trks)...)
+	body = append(body, wozChunk("META", []byte("title\tTest Disk\n"))...)
+
+	magic := MAGIC_WOZ1
+	if version == 2 {
+		magic = MAGIC_WOZ2
+	}
+	crc := crc32.ChecksumIEEE(body)
+
+	out := append([]byte{}, magic...)
+	out = append(out, byte(crc), byte(crc>>8), byte(crc>>16), byte(crc>>24))
+	return append(out, body...)
+
+}
+
+// testTracks splits nibblized sectors into tracks, trimmed of enough
+// trailing sync to fit a WOZ1 track
+func testTracks(nibbles []byte) [][]byte {
+	var tracks [][]byte
+	for off := 0; off+TRACK_NIBBLE_LENGTH <= len(nibbles); off += TRACK_NIBBLE_LENGTH {
+		tracks = append(tracks, nibbles[off:off+WOZ1_TRACK_BITSTREAM])
+	}
+	return tracks
+}
+
+func TestWOZRoundTrip(t *testing.T) {
+
+	file := testData(1, 9000)
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, file)
+	tracks := testTracks(dsk.Nibblize())
+
+	for _, version := range []int{1, 2} {
+
+		data := testWOZ(version, tracks)
+
+		woz, err := ParseWOZ(data)
+		if err != nil {
+			t.Fatalf("WOZ%d: %v", version, err)
+		}
+		if woz.Version != version || woz.GetMeta("title") != "Test Disk" || woz.Info.GetCreator() != "dskalyzer test" {
+			t.Errorf("WOZ%d: header read as version %d, title %q, creator %q", version, woz.Version, woz.GetMeta("title"), woz.Info.GetCreator())
+		}
+
+		sectors, encoding, missing := woz.Sectors()
+		if encoding != NibbleEncoding62 || missing != 0 {
+			t.Fatalf("WOZ%d: %s encoding with %d sectors missing", version, encoding, missing)
+		}
+		if !bytes.Equal(sectors, dsk.Data) {
+			t.Fatalf("WOZ%d: sectors don't match the disk", version)
+		}
+
+		w, err := NewDSKWrapperBin(nil, data, "test.woz")
+		if err != nil {
+			t.Fatalf("WOZ%d: %v", version, err)
+		}
+		if w.Format.ID != DF_DOS_SECTORS_16 || !w.WriteProtected {
+			t.Errorf("WOZ%d: loaded as %s, write protected %v", version, w.Format, w.WriteProtected)
+		}
+		if !bytes.Equal(w.Data, dsk.Data) {
+			t.Errorf("WOZ%d: loaded sectors don't match the disk", version)
+		}
+
+	}
+
+}
+
+func TestWOZChecksum(t *testing.T) {
+
+	dsk := testDOSDisk(t, GetDiskFormat(DF_DOS_SECTORS_16), SectorOrderDOS33, testData(2, 100))
+	data := testWOZ(2, testTracks(dsk.Nibblize()))
+
+	data[len(data)-1] ^= 0xff
+	if _, err := ParseWOZ(data); err == nil {
+		t.Error("corrupt WOZ image was accepted")
+	}
+
+	// a zero CRC means it wasn't worked out
+	data[8], data[9], data[10], data[11] = 0, 0, 0, 0
+	if _, err := ParseWOZ(data); err != nil {
+		t.Errorf("WOZ image without CRC: %v", err)
+	}
+
+}
@@ -1,6 +1,9 @@
 package disk
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type CatalogEntryType int
 
@@ -15,19 +18,155 @@ const (
 	CETGraphics
 )
 
-type CatalogEntry interface {
-	Size() int // file size in bytes
+// ErrNotSupported is returned by drivers for operations the filesystem
+// can't do.
+var ErrNotSupported = errors.New("Not supported by this filesystem")
+
+type DriverCaps int
+
+const (
+	CapWrite DriverCaps = 1 << iota
+	CapDirectories
+)
+
+// CatalogEntry is a file as seen through a Driver, whatever filesystem it
+// came from.
+type CatalogEntry struct {
+	Path        string // containing directory, "" for the root
+	Name        string
+	Kind        int    // filesystem specific file type
+	Type        string // file type description
+	Ext         string
+	Class       CatalogEntryType
+	LoadAddress int // load address, or aux type on ProDOS
+	Locked      bool
+	Access      ProDOSAccessMode
+	Dir         bool
+	Sparse      bool // some blocks are unallocated holes
+	Forked      bool // has a resource fork as well as the data
+	Created     time.Time
+	Modified    time.Time
+	fd          interface{}
+}
+
+// FullName gives the name of the entry including its directory
+func (e *CatalogEntry) FullName() string {
+	if e.Path == "" {
+		return e.Name
+	}
+	return e.Path + "/" + e.Name
+}
+
+// Descriptor gives the filesystem's own entry for the file, one of
+// FileDescriptor, ProDOSFileDescriptor, *PascalFileEntry or
+// *RDOSFileDescriptor.
+func (e *CatalogEntry) Descriptor() interface{} {
+	return e.fd
+}
+
+// Driver is implemented by each filesystem, so that callers can work with
+// files without caring which filesystem is on the disk. Paths are ignored
+// by filesystems without directories.
+type Driver interface {
 	Name() string
-	NameUnadorned() string
-	Date() time.Time
-	Type() CatalogEntryType
-}
-
-type DiskImage interface {
-	IsValid() (bool, DiskFormat, SectorOrder)
-	GetCatalog(path string, pattern string) ([]CatalogEntry, error)
-	ReadFile(fd CatalogEntry) (int, []byte, error)
-	StoreFile(fd CatalogEntry) error
-	GetUsedBitmap() ([]bool, error)
-	Nibblize() ([]byte, error)
+	Caps() DriverCaps
+	BlockSize() int
+	MaxNameLength() int
+	Catalog(dsk *DSKWrapper, path string, pattern string) ([]*CatalogEntry, error)
+	ReadFile(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error)
+	WriteFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error
+	DeleteFile(dsk *DSKWrapper, path string, name string) error
+	RenameFile(dsk *DSKWrapper, path string, name string, newname string) error
+	SetLocked(dsk *DSKWrapper, path string, name string, lock bool) error
+	SetFileInfo(dsk *DSKWrapper, path string, name string, access ProDOSAccessMode, created, modified time.Time) error
+	Mkdir(dsk *DSKWrapper, path string, name string) error
+	UsedBitmap(dsk *DSKWrapper) ([]bool, error)
+	TypeFromExt(ext string) int
+	TypeFromProDOS(t ProDOSFileType) int
+	Class(kind int) CatalogEntryType
+}
+
+// SparseWriter is implemented by drivers that can leave blocks of zeros in
+// a file unallocated.
+type SparseWriter interface {
+	WriteFileSparse(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error
+}
+
+// ForkDriver is implemented by drivers for filesystems that keep a resource
+// fork alongside the data. ReadFile always gives the data fork.
+type ForkDriver interface {
+	ReadResourceFork(dsk *DSKWrapper, e *CatalogEntry) ([]byte, error)
+	WriteForkedFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, rsrc []byte, loadAddr int) error
+}
+
+// Checker is implemented by drivers that can check a volume for damage,
+// putting right what they can when repair is set.
+type Checker interface {
+	Check(dsk *DSKWrapper, repair bool) ([]*CheckProblem, error)
+}
+
+// Undeleter is implemented by drivers that can find deleted files and put
+// them back.
+type Undeleter interface {
+	Deleted(dsk *DSKWrapper) ([]*DeletedEntry, error)
+	Undelete(dsk *DSKWrapper, d *DeletedEntry) error
+}
+
+var drivers = make(map[DiskFormatID]Driver)
+
+// RegisterDriver makes a driver available for the given disk formats
+func RegisterDriver(d Driver, ids ...DiskFormatID) {
+	for _, id := range ids {
+		drivers[id] = d
+	}
+}
+
+// GetDriver returns the driver registered for a disk format
+func GetDriver(id DiskFormatID) (Driver, bool) {
+	d, ok := drivers[id]
+	return d, ok
+}
+
+// Driver returns the filesystem driver for the disk
+func (dsk *DSKWrapper) Driver() (Driver, error) {
+	d, ok := GetDriver(dsk.Format.ID)
+	if !ok {
+		return nil, errors.New("No filesystem driver for " + dsk.Format.String())
+	}
+	return d, nil
+}
+
+// readOnlyDriver fills in the write side for filesystems we can only read
+type readOnlyDriver struct{}
+
+func (readOnlyDriver) Caps() DriverCaps {
+	return 0
+}
+
+func (readOnlyDriver) WriteFile(dsk *DSKWrapper, path string, name string, kind int, data []byte, loadAddr int) error {
+	return ErrNotSupported
+}
+
+func (readOnlyDriver) DeleteFile(dsk *DSKWrapper, path string, name string) error {
+	return ErrNotSupported
+}
+
+func (readOnlyDriver) RenameFile(dsk *DSKWrapper, path string, name string, newname string) error {
+	return ErrNotSupported
+}
+
+func (readOnlyDriver) SetLocked(dsk *DSKWrapper, path string, name string, lock bool) error {
+	return ErrNotSupported
+}
+
+func (readOnlyDriver) SetFileInfo(dsk *DSKWrapper, path string, name string, access ProDOSAccessMode, created, modified time.Time) error {
+	return ErrNotSupported
+}
+
+func (readOnlyDriver) Mkdir(dsk *DSKWrapper, path string, name string) error {
+	return ErrNotSupported
+}
+
+func (readOnlyDriver) TypeFromProDOS(t ProDOSFileType) int {
+	return 0
 }
@@ -0,0 +1,112 @@
+package disk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testEntry looks a file up through the disk's driver, ignoring case
+func testEntry(t *testing.T, dsk *DSKWrapper, path string, name string) *CatalogEntry {
+
+	t.Helper()
+
+	d, err := dsk.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := d.Catalog(dsk, path, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range files {
+		if strings.EqualFold(e.Name, name) {
+			return e
+		}
+	}
+	return nil
+
+}
+
+func TestGetDriver(t *testing.T) {
+
+	tests := []struct {
+		id   DiskFormatID
+		caps DriverCaps
+	}{
+		{DF_DOS_SECTORS_13, CapWrite},
+		{DF_DOS_SECTORS_16, CapWrite},
+		{DF_PRODOS, CapWrite | CapDirectories},
+		{DF_PRODOS_800KB, CapWrite | CapDirectories},
+		{DF_PRODOS_CUSTOM, CapWrite | CapDirectories},
+		{DF_PASCAL, CapWrite},
+		{DF_RDOS_33, 0},
+	}
+
+	for _, tt := range tests {
+		d, ok := GetDriver(tt.id)
+		if !ok {
+			t.Errorf("no driver for %s", GetDiskFormat(tt.id))
+			continue
+		}
+		if d.Caps() != tt.caps {
+			t.Errorf("%s driver has caps %d, want %d", d.Name(), d.Caps(), tt.caps)
+		}
+	}
+
+	dsk := &DSKWrapper{Format: GetDiskFormat(DF_NONE)}
+	if _, err := dsk.Driver(); err == nil {
+		t.Error("got a driver for an unrecognised disk")
+	}
+
+}
+
+func TestProDOSDriver(t *testing.T) {
+
+	dsk := testProDOSDisk(t, PRODOS_BLOCKS_PER_DISK, SectorOrderProDOSLinear, testData(50, 300))
+	d, err := dsk.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := testData(51, 400)
+	if err := d.WriteFile(dsk, "", "DATA", int(FileType_PD_BIN), file, 0x6000); err != nil {
+		t.Fatal(err)
+	}
+
+	e := testEntry(t, dsk, "", "DATA")
+	if e == nil {
+		t.Fatal("DATA isn't in the catalog")
+	}
+	if e.LoadAddress != 0x6000 || e.Class != CETBinary {
+		t.Errorf("DATA listed at %.4x as class %d", e.LoadAddress, e.Class)
+	}
+	data, err := d.ReadFile(dsk, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, file) {
+		t.Errorf("read back %d bytes, wrote %d", len(data), len(file))
+	}
+
+	if err := d.RenameFile(dsk, "", "DATA", "MORE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetLocked(dsk, "", "MORE", true); err != nil {
+		t.Fatal(err)
+	}
+	if e := testEntry(t, dsk, "", "MORE"); e == nil || !e.Locked {
+		t.Fatal("MORE isn't listed locked after the rename")
+	}
+
+	if err := d.SetLocked(dsk, "", "MORE", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteFile(dsk, "", "MORE"); err != nil {
+		t.Fatal(err)
+	}
+	if testEntry(t, dsk, "", "MORE") != nil {
+		t.Error("MORE is still listed after the delete")
+	}
+
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"time"
 
 	"github.com/paleotronic/dskalyzer/disk"
 	"github.com/paleotronic/dskalyzer/loggy"
@@ -116,63 +115,7 @@ func analyzeDOS16(id int, dsk *disk.DSKWrapper, info *Disk) {
 	// f.Write(dsk.Data)
 	// f.Close()
 
-	vtoc, files, err := dsk.AppleDOSGetCatalog("*")
-	if err != nil {
-		l.Errorf("Problem reading directory: %s", err.Error())
-		return
-	}
-
-	info.Files = make([]*DiskFile, 0)
-	for _, fd := range files {
-		l.Logf("- Name=%s, Type=%s", fd.NameUnadorned(), fd.Type())
-
-		file := DiskFile{
-			Filename: fd.NameUnadorned(),
-			Type:     fd.Type().String(),
-			Locked:   fd.IsLocked(),
-			Ext:      fd.Type().Ext(),
-			Created:  time.Now(),
-			Modified: time.Now(),
-		}
-
-		//l.Log("start read")
-		_, _, data, err := dsk.AppleDOSReadFileRaw(fd)
-		if err == nil {
-			sum := sha256.Sum256(data)
-			file.SHA256 = hex.EncodeToString(sum[:])
-			file.Size = len(data)
-			if *ingestMode&1 == 1 {
-				if fd.Type() == disk.FileTypeAPP {
-					file.Text = disk.ApplesoftDetoks(data)
-					file.TypeCode = TypeMask_AppleDOS | TypeCode(fd.Type())
-					file.Data = data
-					file.LoadAddress = 0x801
-				} else if fd.Type() == disk.FileTypeINT {
-					file.Text = disk.IntegerDetoks(data)
-					file.TypeCode = TypeMask_AppleDOS | TypeCode(fd.Type())
-					file.LoadAddress = 0x1000
-					file.Data = data
-				} else if fd.Type() == disk.FileTypeTXT {
-					file.Text = disk.StripText(data)
-					file.Data = data
-					file.TypeCode = TypeMask_AppleDOS | TypeCode(fd.Type())
-					file.LoadAddress = 0x0000
-				} else if fd.Type() == disk.FileTypeBIN && len(data) >= 2 {
-					file.LoadAddress = int(data[0]) + 256*int(data[1])
-					file.Data = data[2:]
-					file.TypeCode = TypeMask_AppleDOS | TypeCode(fd.Type())
-				} else {
-					file.LoadAddress = 0x0000
-					file.Data = data
-					file.TypeCode = TypeMask_AppleDOS | TypeCode(fd.Type())
-				}
-			}
-		}
-		//l.Log("end read")
-
-		info.Files = append(info.Files, &file)
-
-	}
+	analyzeFiles(id, dsk, info)
 
 	exists := exists(*baseName + "/" + info.GetFilename())
 
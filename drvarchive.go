@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/paleotronic/dskalyzer/disk"
+	"github.com/paleotronic/dskalyzer/loggy"
+)
+
+// archiveFile is a file pulled out of a file archive (NuFX, Binary II)
+type archiveFile struct {
+	Name     string
+	Type     disk.ProDOSFileType
+	AuxType  int
+	Access   disk.ProDOSAccessMode
+	Created  time.Time
+	Modified time.Time
+	Data     []byte
+}
+
+// analyzeArchive works out what kind of archive we have and hands it off.
+// The archive is read from filename unless data is passed in.
+func analyzeArchive(id int, filename string, data []byte) (*Disk, error) {
+
+	l := loggy.Get(id)
+
+	l.Logf("Reading archive from file source %s", filename)
+
+	if data == nil {
+		var err error
+		data, err = readDiskData(filename)
+		if err != nil {
+			l.Errorf("Archive read failed: %s", err)
+			return nil, err
+		}
+	}
+
+	if isNuFX, _ := disk.IsNuFX(data); isNuFX {
+		return analyzeNuFX(id, filename, data)
+	}
+
+	if disk.IsBinaryII(data) {
+		return analyzeBinaryII(id, filename, data)
+	}
+
+	l.Errorf("Unrecognized archive format")
+	return nil, errors.New("Unrecognized archive format")
+
+}
+
+// analyzeArchiveFiles gathers the files from an archive into a single
+// fingerprint for the archive itself.
+func analyzeArchiveFiles(id int, filename string, format string, data []byte, files []*archiveFile) (*Disk, error) {
+
+	l := loggy.Get(id)
+
+	info := &Disk{
+		Filename:   path.Base(filename),
+		FullPath:   path.Clean(filename),
+		Format:     format,
+		FormatID:   disk.GetDiskFormat(disk.DF_NONE),
+		IngestMode: *ingestMode,
+		Files:      make(DiskCatalog, 0),
+	}
+
+	info.SHA256 = disk.Checksum(data)
+	info.SHA256Canonical = info.SHA256
+
+	activeData := make([]byte, 0)
+
+	for _, f := range files {
+
+		l.Logf("- Name=%s, Type=%s", f.Name, f.Type)
+
+		file := &DiskFile{
+			Filename: f.Name,
+			Type:     f.Type.String(),
+			Ext:      f.Type.Ext(),
+			Locked:   f.Access&(disk.AccessType_Destroy|disk.AccessType_Rename|disk.AccessType_Writable) == 0,
+			Access:   f.Access,
+			Created:  f.Created,
+			Modified: f.Modified,
+		}
+
+		sum := sha256.Sum256(f.Data)
+		file.SHA256 = hex.EncodeToString(sum[:])
+		file.Size = len(f.Data)
+		activeData = append(activeData, f.Data...)
+
+		if *ingestMode&1 == 1 {
+			file.TypeCode = TypeMask_ProDOS | TypeCode(f.Type)
+			file.LoadAddress = f.AuxType
+			file.Data = f.Data
+			switch f.Type {
+			case disk.FileType_PD_APP:
+				file.Text = disk.ApplesoftDetoks(f.Data)
+			case disk.FileType_PD_INT:
+				file.Text = disk.IntegerDetoks(f.Data)
+			case disk.FileType_PD_TXT:
+				file.Text = disk.StripText(f.Data)
+			}
+		}
+
+		info.Files = append(info.Files, file)
+	}
+
+	sum := sha256.Sum256(activeData)
+	info.SHA256Active = hex.EncodeToString(sum[:])
+
+	in(info.FormatID)
+
+	if !exists(*baseName+"/"+info.GetFilename()) || *forceIngest {
+		if e := info.WriteToFile(*baseName + "/" + info.GetFilename()); e != nil {
+			l.Errorf("Error writing fingerprint: %v", e)
+			return info, e
+		}
+	} else {
+		l.Log("Not writing as it already exists")
+	}
+
+	out(info.FormatID)
+
+	return info, nil
+
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/paleotronic/dskalyzer/disk"
+	"github.com/paleotronic/dskalyzer/loggy"
+)
+
+// analyzeBinaryII ingests the files in a Binary II archive
+func analyzeBinaryII(id int, filename string, data []byte) (*Disk, error) {
+
+	l := loggy.Get(id)
+
+	entries, err := disk.ParseBinaryII(data)
+	if err != nil {
+		l.Errorf("Archive read failed: %s", err)
+		if len(entries) == 0 {
+			return nil, err
+		}
+	}
+
+	var files []*archiveFile
+	for _, e := range entries {
+		if e.IsDirectory() {
+			continue
+		}
+		files = append(files, &archiveFile{
+			Name:     e.Header.GetFilename(),
+			Type:     e.Header.GetFileType(),
+			AuxType:  e.Header.GetAuxType(),
+			Access:   e.Header.GetAccess(),
+			Created:  e.Header.CreateTime(),
+			Modified: e.Header.ModTime(),
+			Data:     e.Data,
+		})
+	}
+
+	return analyzeArchiveFiles(id, filename, "Binary II Archive", data, files)
+
+}
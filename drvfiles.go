@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/paleotronic/dskalyzer/disk"
+	"github.com/paleotronic/dskalyzer/loggy"
+)
+
+// typeMasks keeps the file type codes of each filesystem apart
+var typeMasks = map[string]TypeCode{
+	"AppleDOS": TypeMask_AppleDOS,
+	"ProDOS":   TypeMask_ProDOS,
+	"Pascal":   TypeMask_Pascal,
+	"RDOS":     TypeMask_RDOS,
+}
+
+// analyzeFiles catalogs the files on a disk through its filesystem driver,
+// descending into any directories.
+func analyzeFiles(id int, dsk *disk.DSKWrapper, info *Disk) {
+
+	l := loggy.Get(id)
+
+	info.Files = make([]*DiskFile, 0)
+
+	drv, err := dsk.Driver()
+	if err != nil {
+		l.Errorf("Problem reading directory: %s", err.Error())
+		return
+	}
+
+	analyzeDir(id, drv, dsk, "", info)
+
+	analyzeDeleted(id, drv, dsk, info)
+
+}
+
+// analyzeDeleted fingerprints the deleted files that can still be read back
+// in full, they are kept apart from the files on the disk.
+func analyzeDeleted(id int, drv disk.Driver, dsk *disk.DSKWrapper, info *Disk) {
+
+	l := loggy.Get(id)
+
+	u, ok := drv.(disk.Undeleter)
+	if !ok {
+		return
+	}
+
+	deleted, err := u.Deleted(dsk)
+	if err != nil {
+		l.Errorf("Problem reading deleted files: %s", err.Error())
+		return
+	}
+
+	info.DeletedFiles = make([]*DiskFile, 0)
+
+	for _, d := range deleted {
+		if d.Dir || !d.Recoverable() {
+			continue
+		}
+		l.Logf("- Deleted Path=%s, Name=%s, Type=%s", d.Path, d.Name, d.Type)
+
+		info.DeletedFiles = append(info.DeletedFiles, diskFileFromEntry(drv, dsk, &d.CatalogEntry, *ingestMode&1 == 1))
+	}
+
+}
+
+func analyzeDir(id int, drv disk.Driver, dsk *disk.DSKWrapper, path string, info *Disk) {
+
+	l := loggy.Get(id)
+
+	entries, err := drv.Catalog(dsk, path, "*")
+	if err != nil {
+		l.Errorf("Problem reading directory: %s", err.Error())
+		return
+	}
+
+	for _, e := range entries {
+		l.Logf("- Path=%s, Name=%s, Type=%s", path, e.Name, e.Type)
+
+		info.Files = append(info.Files, diskFileFromEntry(drv, dsk, e, *ingestMode&1 == 1))
+
+		if e.Dir {
+			analyzeDir(id, drv, dsk, e.FullName(), info)
+		}
+	}
+
+}
+
+// diskFileFromEntry reads a file through the driver, text and data are only
+// kept if asked for.
+func diskFileFromEntry(drv disk.Driver, dsk *disk.DSKWrapper, e *disk.CatalogEntry, keepData bool) *DiskFile {
+
+	file := &DiskFile{
+		Filename: e.FullName(),
+		Type:     e.Type,
+		Ext:      e.Ext,
+		Locked:   e.Locked,
+		Access:   e.Access,
+		Sparse:   e.Sparse,
+		Forked:   e.Forked,
+		Created:  e.Created,
+		Modified: e.Modified,
+	}
+
+	// not every filesystem keeps dates
+	if file.Created.IsZero() {
+		file.Created = time.Now()
+	}
+	if file.Modified.IsZero() {
+		file.Modified = time.Now()
+	}
+
+	if e.Dir {
+		return file
+	}
+
+	data, err := drv.ReadFile(dsk, e)
+	if err != nil {
+		return file
+	}
+
+	sum := sha256.Sum256(data)
+	file.SHA256 = hex.EncodeToString(sum[:])
+	file.Size = len(data)
+
+	if !keepData {
+		return file
+	}
+
+	file.Data = data
+	file.LoadAddress = e.LoadAddress
+	if fdrv, ok := drv.(disk.ForkDriver); ok && e.Forked {
+		file.Resource, _ = fdrv.ReadResourceFork(dsk, e)
+	}
+	file.TypeCode = typeMasks[drv.Name()] | TypeCode(e.Kind)
+
+	switch e.Class {
+	case disk.CETBasicApplesoft:
+		file.Text = disk.ApplesoftDetoks(data)
+	case disk.CETBasicInteger:
+		file.Text = disk.IntegerDetoks(data)
+	case disk.CETText:
+		file.Text = disk.StripText(data)
+	}
+
+	return file
+
+}
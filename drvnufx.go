@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/paleotronic/dskalyzer/disk"
+	"github.com/paleotronic/dskalyzer/loggy"
+)
+
+// analyzeNuFX ingests a ShrinkIt archive. Each disk image in it is
+// fingerprinted as a disk in its own right, and any files are gathered
+// into a single entry for the archive.
+func analyzeNuFX(id int, filename string, data []byte) (*Disk, error) {
+
+	l := loggy.Get(id)
+
+	archive, err := disk.ParseNuFX(data)
+	if err != nil {
+		l.Errorf("Archive read failed: %s", err)
+		return nil, err
+	}
+
+	var last *Disk
+
+	disks := archive.DiskRecords()
+	for _, r := range disks {
+
+		info := &Disk{
+			Filename: path.Base(filename),
+			FullPath: path.Clean(filename),
+		}
+		if len(disks) > 1 {
+			info.Filename += ":" + r.GetBaseName()
+		}
+
+		img, err := r.DiskImage()
+		if err != nil {
+			l.Errorf("Disk image %s could not be expanded: %s", r.Filename, err)
+			continue
+		}
+
+		dsk, err := disk.NewDSKWrapperBin(defNibbler, img, filename)
+		if err != nil {
+			l.Errorf("Disk read failed: %s", err)
+			continue
+		}
+
+		l.Logf("Disk image %s from archive", r.Filename)
+
+		last, _ = analyzeDSK(id, dsk, info)
+	}
+
+	records := archive.FileRecords()
+	if len(records) == 0 {
+		return last, nil
+	}
+
+	var files []*archiveFile
+	for _, r := range records {
+		fork, err := r.DataFork()
+		if err != nil {
+			l.Errorf("File %s could not be expanded: %s", r.Filename, err)
+			continue
+		}
+		files = append(files, &archiveFile{
+			Name:     strings.Join(r.GetPathParts(), "/"),
+			Type:     disk.ProDOSFileType(r.FileType),
+			AuxType:  r.AuxType,
+			Access:   disk.ProDOSAccessMode(r.Access),
+			Created:  r.Created,
+			Modified: r.Modified,
+			Data:     fork,
+		})
+	}
+
+	return analyzeArchiveFiles(id, filename, "NuFX Archive", data, files)
+
+}
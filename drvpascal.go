@@ -4,7 +4,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"time"
 
 	"github.com/paleotronic/dskalyzer/disk"
 	"github.com/paleotronic/dskalyzer/loggy"
@@ -119,49 +118,7 @@ func analyzePASCAL(id int, dsk *disk.DSKWrapper, info *Disk) {
 	// Analyzing files
 	l.Log("Starting Analysis of files")
 
-	files, err := dsk.PascalGetCatalog("*")
-	if err != nil {
-		l.Errorf("Problem reading directory: %s", err.Error())
-		return
-	}
-
-	info.Files = make([]*DiskFile, 0)
-	for _, fd := range files {
-		l.Logf("- Name=%s, Type=%d, Len=%d", fd.GetName(), fd.GetType(), fd.GetFileSize())
-
-		file := DiskFile{
-			Filename: fd.GetName(),
-			Type:     fd.GetType().String(),
-			Locked:   fd.IsLocked(),
-			Ext:      fd.GetType().Ext(),
-			Created:  time.Now(),
-			Modified: time.Now(),
-		}
-
-		//l.Log("start read")
-		data, err := dsk.PascalReadFile(fd)
-		if err == nil {
-			sum := sha256.Sum256(data)
-			file.SHA256 = hex.EncodeToString(sum[:])
-			file.Size = len(data)
-			if *ingestMode&1 == 1 {
-				// text ingestion
-				if fd.GetType() == disk.FileType_PAS_TEXT {
-					file.Text = disk.StripText(data)
-					file.Data = data
-					file.TypeCode = TypeMask_Pascal | TypeCode(fd.GetType())
-				} else {
-					file.Data = data
-					file.TypeCode = TypeMask_Pascal | TypeCode(fd.GetType())
-				}
-			}
-		}
-
-		//l.Log("end read")
-
-		info.Files = append(info.Files, &file)
-
-	}
+	analyzeFiles(id, dsk, info)
 
 	exists := exists(*baseName + "/" + info.GetFilename())
 
@@ -117,7 +117,7 @@ func analyzePRODOS16(id int, dsk *disk.DSKWrapper, info *Disk) {
 	// // Analyzing files
 	l.Log("Starting Analysis of files")
 
-	prodosDir(id, 2, "", dsk, info)
+	analyzeFiles(id, dsk, info)
 
 	exists := exists(*baseName + "/" + info.GetFilename())
 
@@ -134,91 +134,3 @@ func analyzePRODOS16(id int, dsk *disk.DSKWrapper, info *Disk) {
 	out(dsk.Format)
 
 }
-
-func prodosDir(id int, start int, path string, dsk *disk.DSKWrapper, info *Disk) {
-
-	l := loggy.Get(id)
-
-	_, files, err := dsk.PRODOSGetCatalog(start, "*")
-	if err != nil {
-		l.Errorf("Problem reading directory: %s", err.Error())
-		return
-	}
-	if info.Files == nil {
-		info.Files = make([]*DiskFile, 0)
-	}
-	for _, fd := range files {
-		l.Logf("- Path=%s, Name=%s, Type=%s", path, fd.NameUnadorned(), fd.Type())
-
-		var file DiskFile
-
-		if path == "" {
-
-			file = DiskFile{
-				Filename: fd.NameUnadorned(),
-				Type:     fd.Type().String(),
-				Locked:   fd.IsLocked(),
-				Ext:      fd.Type().Ext(),
-				Created:  fd.CreateTime(),
-				Modified: fd.ModTime(),
-			}
-
-		} else {
-
-			file = DiskFile{
-				Filename: path + "/" + fd.NameUnadorned(),
-				Type:     fd.Type().String(),
-				Locked:   fd.IsLocked(),
-				Ext:      fd.Type().Ext(),
-				Created:  fd.CreateTime(),
-				Modified: fd.ModTime(),
-			}
-
-		}
-
-		if fd.Type() != disk.FileType_PD_Directory {
-			_, _, data, err := dsk.PRODOSReadFileRaw(fd)
-			if err == nil {
-				sum := sha256.Sum256(data)
-				file.SHA256 = hex.EncodeToString(sum[:])
-				file.Size = len(data)
-				if *ingestMode&1 == 1 {
-					if fd.Type() == disk.FileType_PD_APP {
-						file.Text = disk.ApplesoftDetoks(data)
-						file.TypeCode = TypeMask_ProDOS | TypeCode(fd.Type())
-						file.Data = data
-						file.LoadAddress = fd.AuxType()
-					} else if fd.Type() == disk.FileType_PD_INT {
-						file.Text = disk.IntegerDetoks(data)
-						file.TypeCode = TypeMask_ProDOS | TypeCode(fd.Type())
-						file.Data = data
-						file.LoadAddress = fd.AuxType()
-					} else if fd.Type() == disk.FileType_PD_TXT {
-						file.Text = disk.StripText(data)
-						file.Data = data
-						file.TypeCode = TypeMask_ProDOS | TypeCode(fd.Type())
-						file.LoadAddress = fd.AuxType()
-					} else {
-						file.LoadAddress = fd.AuxType()
-						file.Data = data
-						file.TypeCode = TypeMask_ProDOS | TypeCode(fd.Type())
-					}
-				}
-			}
-		}
-
-		info.Files = append(info.Files, &file)
-
-		if fd.Type() == disk.FileType_PD_Directory {
-			newpath := path
-			if path != "" {
-				newpath += "/" + fd.NameUnadorned()
-			} else {
-				newpath = fd.NameUnadorned()
-			}
-			prodosDir(id, fd.IndexBlock(), newpath, dsk, info)
-		}
-
-	}
-
-}
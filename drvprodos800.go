@@ -109,7 +109,7 @@ func analyzePRODOS800(id int, dsk *disk.DSKWrapper, info *Disk) {
 	l.Log("Starting Analysis of files")
 
 	info.Files = make([]*DiskFile, 0)
-	prodosDir(id, 2, "", dsk, info)
+	analyzeFiles(id, dsk, info)
 
 	exists := exists(*baseName + "/" + info.GetFilename())
 
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/paleotronic/dskalyzer/disk"
+	"github.com/paleotronic/dskalyzer/loggy"
+)
+
+// analyzePRODOSHDV handles ProDOS volumes of any size. These can run to
+// 65535 blocks, so they are fingerprinted by block rather than by sector
+// and empty unused blocks are left out.
+func analyzePRODOSHDV(id int, dsk *disk.DSKWrapper, info *Disk) {
+
+	l := loggy.Get(id)
+
+	l.Logf("Reading Disk VTOC...")
+	vtoc, err := dsk.PRODOSGetVDH(2)
+	if err != nil {
+		l.Errorf("Error reading VTOC: %s", err.Error())
+		return
+	}
+
+	info.Blocks = vtoc.GetTotalBlocks()
+
+	l.Logf("Filecount: %d", vtoc.GetFileCount())
+
+	l.Logf("Blocks: %d", info.Blocks)
+
+	l.Logf("Reading volume bitmap and SHA256'ing blocks")
+
+	info.Bitmap = make([]bool, info.Blocks)
+
+	info.ActiveBlocks = make(DiskBlocks, 0)
+	info.InactiveBlocks = make(DiskBlocks, 0)
+
+	vbitmap, err := dsk.PRODOSGetVolumeBitmap()
+	if err != nil {
+		l.Errorf("Error reading volume bitmap: %s", err.Error())
+		return
+	}
+
+	empty := disk.Checksum(make([]byte, disk.PRODOS_BLOCK_BYTES))
+	active := sha256.New()
+
+	for b := 0; b < info.Blocks; b++ {
+		info.Bitmap[b] = !vbitmap.IsBlockFree(b)
+
+		data, err := dsk.PRODOSGetBlock(b)
+		if err != nil {
+			l.Errorf("Error reading block %d: %s", b, err.Error())
+			return
+		}
+
+		block := &DiskBlock{
+			Block:  b,
+			SHA256: disk.Checksum(data),
+		}
+
+		if *ingestMode&2 == 2 {
+			block.Data = data
+		}
+
+		if info.Bitmap[b] {
+			info.ActiveBlocks = append(info.ActiveBlocks, block)
+			active.Write(data)
+		} else if block.SHA256 != empty {
+			info.InactiveBlocks = append(info.InactiveBlocks, block)
+		}
+
+	}
+
+	info.SHA256Active = hex.EncodeToString(active.Sum(nil))
+
+	l.Logf("Active blocks: %d", len(info.ActiveBlocks))
+
+	// // Analyzing files
+	l.Log("Starting Analysis of files")
+
+	analyzeFiles(id, dsk, info)
+
+	exists := exists(*baseName + "/" + info.GetFilename())
+
+	if !exists || *forceIngest {
+		e := info.WriteToFile(*baseName + "/" + info.GetFilename())
+		if e != nil {
+			l.Errorf("Error writing fingerprint: %v", e)
+			panic(e)
+		}
+	} else {
+		l.Log("Not writing as it already exists")
+	}
+
+	out(dsk.Format)
+
+}
@@ -83,51 +83,7 @@ func analyzeRDOS(id int, dsk *disk.DSKWrapper, info *Disk) {
 	// Analyzing files
 	l.Log("Starting Analysis of files")
 
-	files, err := dsk.RDOSGetCatalog("*")
-	if err != nil {
-		l.Errorf("Problem reading directory: %s", err.Error())
-		return
-	}
-
-	info.Files = make([]*DiskFile, 0)
-	for _, fd := range files {
-		l.Logf("- Name=%s, Type=%s", fd.NameUnadorned(), fd.Type())
-
-		file := DiskFile{
-			Filename: fd.NameUnadorned(),
-			Type:     fd.Type().String(),
-			Ext:      fd.Type().Ext(),
-		}
-
-		//l.Log("start read")
-		data, err := dsk.RDOSReadFile(fd)
-		if err == nil {
-			sum := sha256.Sum256(data)
-			file.SHA256 = hex.EncodeToString(sum[:])
-			file.Size = len(data)
-			if *ingestMode&1 == 1 {
-				if fd.Type() == disk.FileType_RDOS_AppleSoft {
-					file.Text = disk.ApplesoftDetoks(data)
-					file.TypeCode = TypeMask_RDOS | TypeCode(fd.Type())
-					file.Data = data
-				} else if fd.Type() == disk.FileType_RDOS_Text {
-					file.Text = disk.StripText(data)
-					file.TypeCode = TypeMask_RDOS | TypeCode(fd.Type())
-					file.Data = data
-				} else {
-					file.Data = data
-					file.LoadAddress = fd.LoadAddress()
-					file.TypeCode = TypeMask_RDOS | TypeCode(fd.Type())
-				}
-			}
-		}
-		//l.Log("end read")
-
-		l.Logf("FILETEXT=\n%s", dump(data))
-
-		info.Files = append(info.Files, &file)
-
-	}
+	analyzeFiles(id, dsk, info)
 
 	exists := exists(*baseName + "/" + info.GetFilename())
 
@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -21,7 +24,11 @@ import (
 	"github.com/paleotronic/dskalyzer/panic"
 )
 
-var diskRegex = regexp.MustCompile("(?i)[.](po|do|dsk)$")
+var diskRegex = regexp.MustCompile("(?i)[.](po|do|dsk|d13|nib|woz|2mg|dc|dc42|image|img|hdv)$")
+var archiveRegex = regexp.MustCompile("(?i)[.](shk|sdk|bxy|bny|bqy)$")
+
+// files without a known extension have to look at least this convincing
+const sniffConfidence = 0.5
 
 func processFile(path string, info os.FileInfo, err error) error {
 	if err != nil {
@@ -29,9 +36,33 @@ func processFile(path string, info os.FileInfo, err error) error {
 		return err
 	}
 
-	if diskRegex.MatchString(path) {
+	if info.IsDir() {
+		return nil
+	}
+
+	if containerRegex.MatchString(path) {
+
+		err := processContainer(path)
+		if err != nil {
+			loggy.Get(0).Errorf("Error reading container %s: %s", path, err.Error())
+		}
+		return nil
+
+	}
+
+	if info.Size() > disk.DETECT_MAX_BYTES {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		loggy.Get(0).Errorf("Error reading %s: %s", path, err.Error())
+		return nil
+	}
+
+	if sniffImage(path, data) {
 
-		incoming <- path
+		incoming <- &ingestItem{Filename: path, Data: data}
 
 		fmt.Printf("\rIngested: %d volumes ...", processed)
 
@@ -40,9 +71,58 @@ func processFile(path string, info os.FileInfo, err error) error {
 	return nil
 }
 
+// sniffImage decides from the contents whether a file is worth analyzing.
+// A known extension only needs some sign of a disk, anything else has to
+// look convincing.
+func sniffImage(name string, data []byte) bool {
+
+	best := disk.Detect(data, name).Best()
+	if best == nil {
+		return false
+	}
+
+	if diskRegex.MatchString(name) || archiveRegex.MatchString(name) {
+		return true
+	}
+
+	return best.Confidence >= sniffConfidence
+
+}
+
+// processContainer queues up the images in a zip, gz or tar.gz. Members
+// are read here so they can be sniffed, and the data is passed along.
+func processContainer(path string) error {
+
+	return eachContainerMember(path, func(name string, r io.Reader) (bool, error) {
+
+		data, err := ioutil.ReadAll(io.LimitReader(r, disk.DETECT_MAX_BYTES+1))
+		if err != nil {
+			return false, err
+		}
+
+		if len(data) > disk.DETECT_MAX_BYTES || !sniffImage(name, data) {
+			return true, nil
+		}
+
+		incoming <- &ingestItem{Filename: containerPath(path, name), Data: data}
+
+		fmt.Printf("\rIngested: %d volumes ...", processed)
+
+		return true, nil
+	})
+
+}
+
+// ingestItem is a volume waiting to be analyzed, Data is set if it has
+// already been read.
+type ingestItem struct {
+	Filename string
+	Data     []byte
+}
+
 const loaderWorkers = 8
 
-var incoming chan string
+var incoming chan *ingestItem
 var processed int
 var errorcount int
 var indisk map[disk.DiskFormat]int
@@ -70,7 +150,7 @@ func walk(dir string) {
 
 	start := time.Now()
 
-	incoming = make(chan string, 16)
+	incoming = make(chan *ingestItem, 16)
 	indisk = make(map[disk.DiskFormat]int)
 	outdisk = make(map[disk.DiskFormat]int)
 
@@ -84,11 +164,13 @@ func walk(dir string) {
 			id := 1 + i
 			l := loggy.Get(id)
 
-			for filename := range incoming {
+			for item := range incoming {
+
+				filename := item.Filename
 
 				panic.Do(
 					func() {
-						analyze(id, filename)
+						analyzeData(id, filename, item.Data)
 						s.Lock()
 						processed++
 						s.Unlock()
@@ -183,11 +265,15 @@ func resolvePathfilters(base string, pathfilter []string, pattern string) []*reg
 
 		//fmt.Printf("OK\n")
 
-		// path is okay and now absolute
-		info, e := os.Stat(p)
+		// path is okay and now absolute, container members go by the container
+		statName, _, _ := splitContainerPath(p)
+		info, e := os.Stat(statName)
 		if e != nil {
 			continue
 		}
+		if containerRegex.MatchString(p) {
+			p += "!"
+		}
 
 		if runtime.GOOS == "windows" {
 			p = strings.Replace(p, ":", "", -1)
@@ -195,7 +281,7 @@ func resolvePathfilters(base string, pathfilter []string, pattern string) []*reg
 		}
 
 		var realpath string
-		if info.IsDir() {
+		if info.IsDir() || strings.HasSuffix(p, "!") {
 			realpath = strings.Replace(base, "\\", "/", -1) + "/" + strings.Trim(p, "/") + "/" + tmp
 		} else {
 			// file
@@ -275,6 +361,12 @@ func existsPattern(base string, filters []string, pattern string) (bool, []strin
 }
 
 func analyze(id int, filename string) (*Disk, error) {
+	return analyzeData(id, filename, nil)
+}
+
+// analyzeData fingerprints a volume. The data is read from filename unless
+// it has been passed in already.
+func analyzeData(id int, filename string, data []byte) (*Disk, error) {
 
 	l := loggy.Get(id)
 
@@ -294,13 +386,46 @@ func analyze(id int, filename string) (*Disk, error) {
 	//fmt.Printf("Processing %s\n", filename)
 	//fmt.Print(".")
 
-	dsk, err = disk.NewDSKWrapper(defNibbler, filename)
+	if data == nil {
+		data, err = readDiskData(filename)
+		if err != nil {
+			l.Errorf("Disk read failed: %s", err)
+			return &dskInfo, err
+		}
+	}
+
+	candidates := disk.Detect(data, filename)
+	for _, c := range candidates {
+		l.Debugf("Candidate: %s", c)
+	}
+
+	best := candidates.Best()
+	if best == nil {
+		err = errors.New("Not a recognized disk image or archive")
+		l.Errorf("Disk read failed: %s", err)
+		return &dskInfo, err
+	}
+
+	if best.Archive {
+		return analyzeArchive(id, filename, data)
+	}
+
+	dsk, err = disk.NewDSKWrapperDetected(defNibbler, data, filename, candidates)
 
 	if err != nil {
 		l.Errorf("Disk read failed: %s", err)
 		return &dskInfo, err
 	}
 
+	return analyzeDSK(id, dsk, &dskInfo)
+
+}
+
+// analyzeDSK fingerprints a loaded disk image
+func analyzeDSK(id int, dsk *disk.DSKWrapper, dskInfo *Disk) (*Disk, error) {
+
+	l := loggy.Get(id)
+
 	if dsk.Format.ID == disk.DF_DOS_SECTORS_13 || dsk.Format.ID == disk.DF_DOS_SECTORS_16 {
 		isADOS, _, _ := dsk.IsAppleDOS()
 		if !isADOS {
@@ -312,13 +437,34 @@ func analyze(id int, filename string) (*Disk, error) {
 
 	l.Log("Load is OK.")
 
+	if dsk.WOZ != nil {
+		dskInfo.Meta = dsk.WOZ.Meta
+		l.Logf("WOZ v%d image created by %s", dsk.WOZ.Version, dsk.WOZ.Info.GetCreator())
+	}
+
+	if dsk.Header2MG != nil {
+		dskInfo.Comment = dsk.Header2MG.Comment
+		l.Logf("2MG image created by %s", dsk.Header2MG.GetCreatorID())
+	}
+
 	dskInfo.SHA256 = dsk.ChecksumDisk()
 	l.Logf("SHA256 is %s", dskInfo.SHA256)
 
+	dskInfo.SHA256Canonical = dsk.ChecksumCanonical()
+	l.Logf("Canonical SHA256 is %s", dskInfo.SHA256Canonical)
+
 	dskInfo.Format = dsk.Format.String()
 	dskInfo.FormatID = dsk.Format
 	l.Logf("Format is %s", dskInfo.Format)
 
+	if dskInfo.OSVariant = dsk.OSVariant(); dskInfo.OSVariant != "" {
+		l.Logf("Operating system is %s", dskInfo.OSVariant)
+	}
+
+	if dskInfo.Protection = dsk.AnalyzeProtection(); dskInfo.Protection != nil {
+		l.Logf("Protection scheme (%s nibbles): %v", dskInfo.Protection.Source, dskInfo.Protection.Scheme())
+	}
+
 	l.Debugf("TOSO MAGIC: %v", hex.EncodeToString(dsk.Data[:32]))
 
 	t, s := dsk.HuntVTOC(35, 13)
@@ -332,27 +478,29 @@ func analyze(id int, filename string) (*Disk, error) {
 
 	switch dsk.Format.ID {
 	case disk.DF_DOS_SECTORS_16:
-		analyzeDOS16(id, dsk, &dskInfo)
+		analyzeDOS16(id, dsk, dskInfo)
 	case disk.DF_DOS_SECTORS_13:
-		analyzeDOS13(id, dsk, &dskInfo)
+		analyzeDOS13(id, dsk, dskInfo)
 	case disk.DF_PRODOS_400KB:
-		analyzePRODOS800(id, dsk, &dskInfo)
+		analyzePRODOS800(id, dsk, dskInfo)
 	case disk.DF_PRODOS_800KB:
-		analyzePRODOS800(id, dsk, &dskInfo)
+		analyzePRODOS800(id, dsk, dskInfo)
 	case disk.DF_PRODOS:
-		analyzePRODOS16(id, dsk, &dskInfo)
+		analyzePRODOS16(id, dsk, dskInfo)
+	case disk.DF_PRODOS_CUSTOM:
+		analyzePRODOSHDV(id, dsk, dskInfo)
 	case disk.DF_RDOS_3:
-		analyzeRDOS(id, dsk, &dskInfo)
+		analyzeRDOS(id, dsk, dskInfo)
 	case disk.DF_RDOS_32:
-		analyzeRDOS(id, dsk, &dskInfo)
+		analyzeRDOS(id, dsk, dskInfo)
 	case disk.DF_RDOS_33:
-		analyzeRDOS(id, dsk, &dskInfo)
+		analyzeRDOS(id, dsk, dskInfo)
 	case disk.DF_PASCAL:
-		analyzePASCAL(id, dsk, &dskInfo)
+		analyzePASCAL(id, dsk, dskInfo)
 	default:
-		analyzeNONE(id, dsk, &dskInfo)
+		analyzeNONE(id, dsk, dskInfo)
 	}
 
-	return &dskInfo, nil
+	return dskInfo, nil
 
 }
@@ -76,6 +76,7 @@ var verbose = flag.Bool("verbose", false, "Log to stderr")
 var fileDupes = flag.Bool("file-dupes", false, "Run file dupe report")
 var wholeDupes = flag.Bool("whole-dupes", false, "Run whole disk dupe report")
 var activeDupes = flag.Bool("as-dupes", false, "Run active sectors only disk dupe report")
+var canonicalDupes = flag.Bool("canonical", false, "Match -whole-dupes and -as-dupes on the sector order independent disk checksum")
 var asPartial = flag.Bool("as-partial", false, "Run partial active sector match against single disk (-disk required)")
 var similarity = flag.Float64("similarity", 0.90, "Object match threshold for -*-partial reports")
 var minSame = flag.Int("min-same", 0, "Minimum same # files for -all-file-partial")
@@ -98,10 +99,17 @@ var catDupes = flag.Bool("cat-dupes", false, "Run duplicate catalog report")
 var searchFilename = flag.String("search-filename", "", "Search database for file with name")
 var searchSHA = flag.String("search-sha", "", "Search database for file with checksum")
 var searchTEXT = flag.String("search-text", "", "Search database for file containing text")
+var searchProtection = flag.String("search-protection", "", "Search database for disks with a protection finding (eg. 'T00 address prologue')")
+var protectionRpt = flag.Bool("protection-report", false, "Group disks by copy protection scheme")
+var osRpt = flag.Bool("os-report", false, "Group disks by operating system variant")
+var signatureFile = flag.String("signatures", binpath()+"/signatures.txt", "File of extra boot signatures for recognizing OS variants")
 var forceIngest = flag.Bool("force", false, "Force re-ingest disks that already exist")
 var ingestMode = flag.Int("ingest-mode", 1, "Ingest mode:\n\t0=Fingerprints only\n\t1=Fingerprints + text\n\t2=Fingerprints + sector data\n\t3=All")
 var extract = flag.String("extract", "", "Extract files/disks matched in searches ('#'=extract disk, '@'=extract files)")
 var adornedCP = flag.Bool("adorned", true, "Extract files named similar to CP")
+var extractDisasm = flag.Bool("extract-disasm", false, "Also extract a disassembly (.S) of binary and system files")
+var disasmCPU = flag.String("cpu", "6502", "CPU for disassembly: 6502, 65c02 or 65816")
+var extractAs = flag.String("extract-as", "", "Extract files with their file info as AppleSingle ('as') or AppleDouble ('ad')")
 var shell = flag.Bool("shell", false, "Start interactive mode")
 var shellBatch = flag.String("shell-batch", "", "Execute shell command(s) from file and exit")
 var withDisk = flag.String("with-disk", "", "Perform disk operation (-file-extract,-file-put,-file-delete)")
@@ -110,6 +118,16 @@ var filePut = flag.String("file-put", "", "File to put on disk (-with-disk)")
 var fileDelete = flag.String("file-delete", "", "File to delete (-with-disk)")
 var fileMkdir = flag.String("dir-create", "", "Directory to create (-with-disk)")
 var fileCatalog = flag.Bool("catalog", false, "List disk contents (-with-disk)")
+var initDisk = flag.String("init", "", "Create a blank DOS 3.3 disk (.dsk/.do in DOS order, .po in ProDOS order, .2mg/.hdv with -init-prodos)")
+var initBoot = flag.String("init-boot", "", "Copy DOS or the ProDOS boot blocks from this disk to make the -init disk bootable")
+var initVolume = flag.Int("init-volume", disk.APPLEDOS_DEFAULT_VOLUME, "Volume number for -init")
+var init13 = flag.Bool("init-13", false, "Make the -init disk 13 sector DOS 3.2")
+var initProDOS = flag.Bool("init-prodos", false, "Make the -init disk a ProDOS volume")
+var initName = flag.String("init-name", "BLANK", "Volume name for -init-prodos")
+var initSize = flag.String("init-size", "140k", "Size for -init-prodos, in blocks or Kb (140k, 400k, 800k...)")
+var fileCheck = flag.Bool("check", false, "Check the filesystem for damage (-with-disk)")
+var checkRepair = flag.Bool("repair", false, "Repair what -check can")
+var fileUndelete = flag.String("undelete", "", "Deleted file to recover by name or number, * to list them (-with-disk)")
 var quarantine = flag.Bool("quarantine", false, "Run -as-dupes and -whole-disk in quarantine mode")
 
 func main() {
@@ -133,8 +151,31 @@ func main() {
 	//l.SILENT = !*logToFile
 	loggy.ECHO = *verbose
 
+	if err := disk.LoadSignatures(*signatureFile); err != nil && !os.IsNotExist(err) {
+		os.Stderr.WriteString("Unable to load signatures: " + err.Error() + "\n")
+	}
+
+	if *initDisk != "" {
+		var args []string
+		if *initProDOS {
+			args = []string{"-prodos", "-name", *initName, "-size", *initSize}
+		} else {
+			args = []string{"-volume", fmt.Sprintf("%d", *initVolume)}
+			if *init13 {
+				args = append(args, "-13")
+			}
+		}
+		if *initBoot != "" {
+			args = append(args, "-boot", *initBoot)
+		}
+		if shellInit(append(args, *initDisk)) != 0 {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
 	if *withDisk != "" {
-		dsk, err := disk.NewDSKWrapper(defNibbler, *withDisk)
+		dsk, err := loadDisk(*withDisk)
 		if err != nil {
 			os.Stderr.WriteString(err.Error())
 			os.Exit(2)
@@ -152,6 +193,14 @@ func main() {
 			shellProcess("delete " + *fileDelete)
 		case *fileCatalog:
 			shellProcess("cat ")
+		case *fileCheck && *checkRepair:
+			shellProcess("check --repair")
+		case *fileCheck:
+			shellProcess("check")
+		case *fileUndelete == "*":
+			shellProcess("undelete")
+		case *fileUndelete != "":
+			shellProcess("undelete " + *fileUndelete)
 		default:
 			os.Stderr.WriteString("Additional flag required")
 			os.Exit(3)
@@ -202,7 +251,7 @@ func main() {
 		var err error
 		if len(filterpath) > 0 {
 			fmt.Printf("Trying to load %s\n", filterpath[0])
-			dsk, err = disk.NewDSKWrapper(defNibbler, filterpath[0])
+			dsk, err = loadDisk(filterpath[0])
 			if err != nil {
 				fmt.Println("Error: " + err.Error())
 				os.Exit(1)
@@ -235,6 +284,11 @@ func main() {
 		return
 	}
 
+	if *searchProtection != "" {
+		searchForProtection(*searchProtection, filterpath)
+		return
+	}
+
 	if *dir {
 		directory(filterpath, *dirFormat)
 		return
@@ -286,6 +340,16 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *protectionRpt {
+		protectionReport(filterpath)
+		os.Exit(0)
+	}
+
+	if *osRpt {
+		osVariantReport(filterpath)
+		os.Exit(0)
+	}
+
 	if *wholeDupes {
 		if *quarantine {
 			quarantineWholeDisks(filterpath)
@@ -316,7 +380,7 @@ func main() {
 		var err error
 		if len(filterpath) > 0 {
 			fmt.Printf("Trying to load %s\n", filterpath[0])
-			dsk, err = disk.NewDSKWrapper(defNibbler, filterpath[0])
+			dsk, err = loadDisk(filterpath[0])
 			if err != nil {
 				fmt.Println("Error: " + err.Error())
 				os.Exit(1)
@@ -327,12 +391,14 @@ func main() {
 
 	}
 
-	info, err := os.Stat(*dskName)
+	// a container member is checked via the container
+	statName, _, _ := splitContainerPath(*dskName)
+	info, err := os.Stat(statName)
 	if err != nil {
 		loggy.Get(0).Errorf("Error stating file: %s", err.Error())
 		os.Exit(2)
 	}
-	if info.IsDir() {
+	if info.IsDir() || containerRegex.MatchString(*dskName) {
 		walk(*dskName)
 	} else {
 		indisk = make(map[disk.DiskFormat]int)
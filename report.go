@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 )
 
 type DuplicateSource struct {
@@ -117,18 +118,34 @@ func AggregateDuplicateFiles(d *Disk, collection interface{}) {
 
 }
 
+// wholeDiskChecksum is what whole disks are matched on, with -canonical
+// the same disk in another sector order or container counts as a copy
+func wholeDiskChecksum(d *Disk) string {
+	if *canonicalDupes {
+		return d.GetCanonicalSHA256()
+	}
+	return d.SHA256
+}
+
 func AggregateDuplicateWholeDisks(d *Disk, collection interface{}) {
 
-	collection.(*DuplicateWholeDiskCollection).Add(d.SHA256, d.FullPath, d.source)
+	collection.(*DuplicateWholeDiskCollection).Add(wholeDiskChecksum(d), d.FullPath, d.source)
 
 }
 
 func AggregateDuplicateActiveSectorDisks(d *Disk, collection interface{}) {
 
-	collection.(*DuplicateActiveSectorDiskCollection).Add(d.SHA256, d.SHA256Active, d.FullPath, d.source)
+	collection.(*DuplicateActiveSectorDiskCollection).Add(wholeDiskChecksum(d), d.SHA256Active, d.FullPath, d.source)
 
 }
 
+func wholeDiskChecksumName() string {
+	if *canonicalDupes {
+		return "Canonical SHA256"
+	}
+	return "Global SHA256"
+}
+
 func (dfc *DuplicateWholeDiskCollection) Report(filename string) {
 
 	var disksWithDupes int
@@ -159,7 +176,11 @@ func (dfc *DuplicateWholeDiskCollection) Report(filename string) {
 			w.WriteString("\n")
 			w.WriteString(fmt.Sprintf("Volume %s has %d duplicate(s):\n", original.Fullpath, len(dupes)))
 			for _, v := range dupes {
-				w.WriteString(fmt.Sprintf(" %s (sha256: %s)\n", v.Fullpath, sha256))
+				if *canonicalDupes {
+					w.WriteString(fmt.Sprintf(" %s (canonical sha256: %s)\n", v.Fullpath, sha256))
+				} else {
+					w.WriteString(fmt.Sprintf(" %s (sha256: %s)\n", v.Fullpath, sha256))
+				}
 				extras++
 			}
 
@@ -215,14 +236,14 @@ func (dfc *DuplicateActiveSectorDiskCollection) Report(filename string) {
 			w.WriteString("--------------------------------------\n")
 			w.WriteString(fmt.Sprintf("Volume       : %s\n", original.Fullpath))
 			w.WriteString(fmt.Sprintf("Active SHA256: %s\n", sha256))
-			w.WriteString(fmt.Sprintf("Global SHA256: %s\n", original.GSHA))
+			w.WriteString(fmt.Sprintf("%s: %s\n", wholeDiskChecksumName(), original.GSHA))
 			w.WriteString(fmt.Sprintf("# Duplicates : %d\n", len(dupes)))
 			for i, v := range dupes {
 				w.WriteString("\n")
 				w.WriteString(fmt.Sprintf(" Duplicate #%d\n", i+1))
 				w.WriteString(fmt.Sprintf(" = Volume       : %s\n", v.Fullpath))
 				w.WriteString(fmt.Sprintf(" = Active SHA256: %s\n", sha256))
-				w.WriteString(fmt.Sprintf(" = Global SHA256: %s\n", v.GSHA))
+				w.WriteString(fmt.Sprintf(" = %s: %s\n", wholeDiskChecksumName(), v.GSHA))
 				extras++
 			}
 			w.WriteString("\n")
@@ -382,6 +403,177 @@ func activeDupeReport(filter []string) {
 
 }
 
+// ProtectionSchemeCollection groups disks by their protection scheme
+type ProtectionSchemeCollection struct {
+	data     map[string][]DuplicateSource
+	standard int
+}
+
+func (psc *ProtectionSchemeCollection) Add(scheme string, fullpath string, fgp string) {
+
+	if psc.data == nil {
+		psc.data = make(map[string][]DuplicateSource)
+	}
+
+	psc.data[scheme] = append(psc.data[scheme], DuplicateSource{Fullpath: fullpath, fingerprint: fgp})
+
+}
+
+func AggregateProtectionSchemes(d *Disk, collection interface{}) {
+
+	if d.Protection == nil {
+		return
+	}
+
+	psc := collection.(*ProtectionSchemeCollection)
+	if d.Protection.Standard() {
+		psc.standard++
+		return
+	}
+
+	psc.Add(strings.Join(d.Protection.Scheme(), ", "), d.FullPath, d.source)
+
+}
+
+func (psc *ProtectionSchemeCollection) Report(filename string) {
+
+	var w *os.File
+	var err error
+
+	if filename != "" {
+		w, err = os.Create(filename)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+	} else {
+		w = os.Stdout
+	}
+
+	schemes := make([]string, 0, len(psc.data))
+	for scheme := range psc.data {
+		schemes = append(schemes, scheme)
+	}
+	sort.Slice(schemes, func(i, j int) bool {
+		if len(psc.data[schemes[i]]) != len(psc.data[schemes[j]]) {
+			return len(psc.data[schemes[i]]) > len(psc.data[schemes[j]])
+		}
+		return schemes[i] < schemes[j]
+	})
+
+	var protected int
+	for _, scheme := range schemes {
+		list := psc.data[scheme]
+		protected += len(list)
+		w.WriteString("\n")
+		w.WriteString(fmt.Sprintf("%d disk(s) with %s:\n", len(list), scheme))
+		for _, v := range list {
+			w.WriteString(fmt.Sprintf(" %s\n", v.Fullpath))
+		}
+	}
+
+	w.WriteString("\n")
+	w.WriteString("SUMMARY\n")
+	w.WriteString("=======\n")
+	w.WriteString(fmt.Sprintf("Total schemes found         : %d\n", len(schemes)))
+	w.WriteString(fmt.Sprintf("Total nonstandard disks     : %d\n", protected))
+	w.WriteString(fmt.Sprintf("Total standard 5.25\" disks  : %d\n", psc.standard))
+
+}
+
+func protectionReport(filter []string) {
+
+	psc := &ProtectionSchemeCollection{}
+	Aggregate(AggregateProtectionSchemes, psc, filter)
+
+	fmt.Println("COPY PROTECTION SCHEME REPORT")
+	fmt.Println()
+
+	psc.Report(*reportFile)
+
+}
+
+// OSVariantCollection groups disks by the operating system on them
+type OSVariantCollection struct {
+	data    map[string][]DuplicateSource
+	unknown int
+}
+
+func (ovc *OSVariantCollection) Add(variant string, fullpath string, fgp string) {
+
+	if ovc.data == nil {
+		ovc.data = make(map[string][]DuplicateSource)
+	}
+
+	ovc.data[variant] = append(ovc.data[variant], DuplicateSource{Fullpath: fullpath, fingerprint: fgp})
+
+}
+
+func AggregateOSVariants(d *Disk, collection interface{}) {
+
+	ovc := collection.(*OSVariantCollection)
+	if d.OSVariant == "" {
+		ovc.unknown++
+		return
+	}
+
+	ovc.Add(d.OSVariant, d.FullPath, d.source)
+
+}
+
+func (ovc *OSVariantCollection) Report(filename string) {
+
+	var w *os.File
+	var err error
+
+	if filename != "" {
+		w, err = os.Create(filename)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+	} else {
+		w = os.Stdout
+	}
+
+	variants := make([]string, 0, len(ovc.data))
+	for variant := range ovc.data {
+		variants = append(variants, variant)
+	}
+	sort.Strings(variants)
+
+	var known int
+	for _, variant := range variants {
+		list := ovc.data[variant]
+		known += len(list)
+		w.WriteString("\n")
+		w.WriteString(fmt.Sprintf("%s (%d disk(s)):\n", variant, len(list)))
+		for _, v := range list {
+			w.WriteString(fmt.Sprintf(" %s\n", v.Fullpath))
+		}
+	}
+
+	w.WriteString("\n")
+	w.WriteString("SUMMARY\n")
+	w.WriteString("=======\n")
+	w.WriteString(fmt.Sprintf("Total variants found      : %d\n", len(variants)))
+	w.WriteString(fmt.Sprintf("Total disks identified    : %d\n", known))
+	w.WriteString(fmt.Sprintf("Total disks not identified: %d\n", ovc.unknown))
+
+}
+
+func osVariantReport(filter []string) {
+
+	ovc := &OSVariantCollection{}
+	Aggregate(AggregateOSVariants, ovc, filter)
+
+	fmt.Println("OPERATING SYSTEM REPORT")
+	fmt.Println()
+
+	ovc.Report(*reportFile)
+
+}
+
 func allFilesPartialReport(t float64, filter []string, oheading string) {
 
 	matches := CollectFilesOverlapsAboveThreshold(t, filter)
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/paleotronic/dskalyzer/disk"
 )
 
 type SearchResultContext int
@@ -40,7 +46,7 @@ func searchForFilename(filename string, filter []string) {
 			if strings.Contains(strings.ToLower(f.Filename), strings.ToLower(filename)) {
 				fmt.Printf("%32s:\n  %s (%s, %d bytes, sha: %s)\n\n", diskname, f.Filename, f.Type, f.Size, f.SHA256)
 				if *extract == "@" {
-					ExtractFile(diskname, f, *adornedCP, false)
+					ExtractFile(diskname, f, *adornedCP, false, ExtractFormat(*extractAs), *extractDisasm)
 				} else if *extract == "#" {
 					ExtractDisk(diskname)
 				}
@@ -66,7 +72,7 @@ func searchForSHA256(sha string, filter []string) {
 			if f.SHA256 == sha {
 				fmt.Printf("%32s:\n  %s (%s, %d bytes, sha: %s)\n\n", diskname, f.Filename, f.Type, f.Size, f.SHA256)
 				if *extract == "@" {
-					ExtractFile(diskname, f, *adornedCP, false)
+					ExtractFile(diskname, f, *adornedCP, false, ExtractFormat(*extractAs), *extractDisasm)
 				} else if *extract == "#" {
 					ExtractDisk(diskname)
 				}
@@ -92,7 +98,7 @@ func searchForTEXT(text string, filter []string) {
 			if strings.Contains(strings.ToLower(string(f.Text)), strings.ToLower(text)) {
 				fmt.Printf("%32s:\n  %s (%s, %d bytes, sha: %s)\n\n", diskname, f.Filename, f.Type, f.Size, f.SHA256)
 				if *extract == "@" {
-					ExtractFile(diskname, f, *adornedCP, false)
+					ExtractFile(diskname, f, *adornedCP, false, ExtractFormat(*extractAs), *extractDisasm)
 				} else if *extract == "#" {
 					ExtractDisk(diskname)
 				}
@@ -102,6 +108,38 @@ func searchForTEXT(text string, filter []string) {
 
 }
 
+func searchForProtection(text string, filter []string) {
+
+	var found []*Disk
+	Aggregate(func(d *Disk, collector interface{}) {
+		if d.Protection != nil && d.Protection.Match(text) {
+			list := collector.(*[]*Disk)
+			*list = append(*list, d)
+		}
+	}, &found, filter)
+
+	fmt.Println()
+	fmt.Println()
+
+	fmt.Printf("SEARCH RESULTS FOR PROTECTION '%s'\n", text)
+
+	fmt.Println()
+
+	for _, d := range found {
+		fmt.Printf("%32s:\n", d.FullPath)
+		for _, f := range d.Protection.Findings {
+			if strings.Contains(strings.ToLower(f.String()), strings.ToLower(text)) {
+				fmt.Printf("  %s\n", f.String())
+			}
+		}
+		fmt.Println()
+		if *extract == "#" {
+			ExtractDisk(d.FullPath)
+		}
+	}
+
+}
+
 func directory(filter []string, format string) {
 
 	fd := GetAllFiles("*_*_*_*.fgp", filter)
@@ -132,7 +170,7 @@ func directory(filter []string, format string) {
 			out += tmp + "\n"
 
 			if *extract == "@" {
-				ExtractFile(diskname, file, *adornedCP, false)
+				ExtractFile(diskname, file, *adornedCP, false, ExtractFormat(*extractAs), *extractDisasm)
 			} else if *extract == "#" {
 				ExtractDisk(diskname)
 			}
@@ -144,7 +182,54 @@ func directory(filter []string, format string) {
 
 var fileExtractCounter int
 
-func ExtractFile(diskname string, fd *DiskFile, adorned bool, local bool) error {
+// ExtractFormat selects how file info is kept when extracting. Plain files
+// rely on the adorned name, AppleSingle and AppleDouble carry it with them.
+type ExtractFormat string
+
+const (
+	ExtractPlain       ExtractFormat = ""
+	ExtractAppleSingle ExtractFormat = "as"
+	ExtractAppleDouble ExtractFormat = "ad"
+)
+
+// appleSingleForFile builds the AppleSingle info for a file. DOS 3.3 files
+// are given their ProDOS equivalent type.
+func appleSingleForFile(fd *DiskFile) *disk.AppleSingle {
+
+	name := filepath.Base(fd.Filename)
+
+	switch fd.TypeCode & 0xff00 {
+	case TypeMask_ProDOS:
+		as := disk.NewAppleSingle(name, fd.Access, disk.ProDOSFileType(fd.TypeCode&0xff), fd.LoadAddress, fd.Created, fd.Modified, fd.Data)
+		as.Resource = fd.Resource
+		return as
+	case TypeMask_AppleDOS:
+		access := disk.AccessType_Default
+		if fd.Locked {
+			access = disk.AccessType_Readable
+		}
+		// DOS keeps no dates
+		return disk.NewAppleSingle(name, access, disk.FileType(fd.TypeCode&0xff).ProDOSType(), fd.LoadAddress, time.Time{}, time.Time{}, fd.Data)
+	}
+
+	return &disk.AppleSingle{Name: name, Created: fd.Created, Modified: fd.Modified, Data: fd.Data}
+
+}
+
+// codeOrigin gives the address a binary file loads at, false if the file
+// isn't machine code
+func codeOrigin(fd *DiskFile) (int, bool) {
+	switch fd.TypeCode {
+	case TypeMask_AppleDOS | TypeCode(disk.FileTypeBIN), TypeMask_ProDOS | TypeCode(disk.FileType_PD_BIN):
+		return fd.LoadAddress, true
+	case TypeMask_ProDOS | TypeCode(disk.FileType_PD_SYS):
+		// system files always load at $2000, whatever the aux type says
+		return 0x2000, true
+	}
+	return 0, false
+}
+
+func ExtractFile(diskname string, fd *DiskFile, adorned bool, local bool, format ExtractFormat, disasm bool) error {
 
 	var name string
 
@@ -154,6 +239,13 @@ func ExtractFile(diskname string, fd *DiskFile, adorned bool, local bool) error
 		name = fd.GetName()
 	}
 
+	// the type lives in the header, so keep the name as it is on disk
+	if format == ExtractAppleSingle {
+		name = fd.Filename + ".as"
+	} else if format == ExtractAppleDouble {
+		name = fd.Filename
+	}
+
 	path := binpath() + "/extract" + diskname
 
 	if local {
@@ -168,15 +260,65 @@ func ExtractFile(diskname string, fd *DiskFile, adorned bool, local bool) error
 
 	//fmt.Printf("FD.EXT=%s\n", fd.Ext)
 
+	data := fd.Data
+	if format == ExtractAppleSingle {
+		data = appleSingleForFile(fd).Bytes()
+	}
+
 	f, err := os.Create(path + "/" + name)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	f.Write(fd.Data)
+	if fd.Sparse && format == ExtractPlain {
+		err = writeSparse(f, data)
+	} else {
+		_, err = f.Write(data)
+	}
+	if err != nil {
+		return err
+	}
 	os.Stderr.WriteString("Extracted file to " + path + "/" + name + "\n")
 
+	if format == ExtractAppleDouble {
+		as := appleSingleForFile(fd)
+		as.Double = true
+		sidecar := filepath.Join(path, filepath.Dir(name), "._"+filepath.Base(name))
+		if err := ioutil.WriteFile(sidecar, as.Bytes(), 0644); err != nil {
+			return err
+		}
+		os.Stderr.WriteString("Extracted file info to " + sidecar + "\n")
+	}
+
+	// the headers carry the resource fork, a plain file needs it alongside
+	if fd.Forked && format == ExtractPlain {
+		rsrc := path + "/" + name + ".rsrc"
+		if err := ioutil.WriteFile(rsrc, fd.Resource, 0644); err != nil {
+			return err
+		}
+		os.Stderr.WriteString("Extracted resource fork to " + rsrc + "\n")
+	}
+
+	if org, ok := codeOrigin(fd); ok && disasm {
+		cpu, ok := disk.ParseCPU(*disasmCPU)
+		if !ok {
+			return errors.New("Unknown CPU " + *disasmCPU)
+		}
+		if format != ExtractPlain {
+			name = fd.GetName()
+		}
+		src := disk.Disassemble(fd.Data, org, cpu).Source(fd.Filename)
+		if err := ioutil.WriteFile(path+"/"+name+".S", []byte(src), 0644); err != nil {
+			return err
+		}
+		os.Stderr.WriteString("Extracted disassembly to " + path + "/" + name + ".S\n")
+	}
+
 	if strings.ToLower(fd.Ext) == "int" || strings.ToLower(fd.Ext) == "bas" || strings.ToLower(fd.Ext) == "txt" {
+		// the text copy is a plain file, so name it by type
+		if format != ExtractPlain {
+			name = fd.GetName()
+		}
 		f, err := os.Create(path + "/" + name + ".ASC")
 		if err != nil {
 			return err
@@ -194,10 +336,38 @@ func ExtractFile(diskname string, fd *DiskFile, adorned bool, local bool) error
 
 }
 
+// writeSparse writes data skipping over blocks of zeros, so they become
+// holes on filesystems that support them
+func writeSparse(f *os.File, data []byte) error {
+
+	zero := make([]byte, disk.PRODOS_BLOCK_BYTES)
+
+	for ptr := 0; ptr < len(data); ptr += disk.PRODOS_BLOCK_BYTES {
+		end := ptr + disk.PRODOS_BLOCK_BYTES
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[ptr:end]
+		if bytes.Equal(chunk, zero[:len(chunk)]) {
+			if _, err := f.Seek(int64(len(chunk)), io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := f.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	// a trailing hole needs the length set
+	return f.Truncate(int64(len(data)))
+
+}
+
 func ExtractDisk(diskname string) error {
 	path := binpath() + "/extract" + diskname
 	os.MkdirAll(path, 0755)
-	data, err := ioutil.ReadFile(diskname)
+	data, err := readDiskData(diskname)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestExtractKeepsHoles(t *testing.T) {
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+
+	src, data := testSparseFile(t, dir)
+
+	info, err := analyze(0, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fd *DiskFile
+	for _, f := range info.Files {
+		if strings.EqualFold(f.Filename, "SPARSE") {
+			fd = f
+		}
+	}
+	if fd == nil || !fd.Sparse {
+		t.Fatalf("SPARSE not found as a sparse file: %+v", fd)
+	}
+
+	// local extracts go in a directory named after the disk
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExtractFile(src, fd, false, true, ExtractPlain, false); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "sparse", fd.GetName())
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("extracted %d bytes, want %d", len(got), len(data))
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated := st.Sys().(*syscall.Stat_t).Blocks * 512; allocated >= int64(len(data)) {
+		t.Errorf("%d bytes allocated for a %d byte file with holes", allocated, len(data))
+	}
+
+}
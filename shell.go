@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"runtime/debug"
@@ -285,9 +287,33 @@ func init() {
 			NeedsMount:  true,
 			Context:     sccDiskFile,
 			Text: []string{
-				"extract <filename|pattern>",
+				"extract [-as|-ad] [-s] <filename|pattern>",
 				"",
 				"Extracts files from current disk",
+				"",
+				"-as writes AppleSingle files, -ad writes AppleDouble (._name)",
+				"sidecars, both keep the type, aux type, access and dates.",
+				"",
+				"-s also writes a Merlin style disassembly of binary and system",
+				"files to <name>.S, for the CPU given with -cpu (default 6502).",
+				"",
+				"The resource fork of a forked file goes in the AppleSingle or",
+				"AppleDouble header, or in a <name>.rsrc file otherwise.",
+			},
+		},
+		"bundle": &shellCommand{
+			Name:        "bundle",
+			Description: "Bundle files from disk into a Binary II archive",
+			MinArgs:     2,
+			MaxArgs:     999,
+			Code:        shellBundle,
+			NeedsMount:  true,
+			Context:     sccDiskFile,
+			Text: []string{
+				"bundle <local archive> <pattern> [<pattern> ...]",
+				"",
+				"Writes matching files from the current ProDOS disk to a",
+				"Binary II (.BNY) archive.",
 			},
 		},
 		"help": &shellCommand{
@@ -373,14 +399,21 @@ func init() {
 			Name:        "put",
 			Description: "Copy local file to disk",
 			MinArgs:     1,
-			MaxArgs:     1,
+			MaxArgs:     2,
 			Code:        shellPut,
 			NeedsMount:  true,
 			Context:     sccLocal,
 			Text: []string{
-				"put <local file>",
+				"put [-sparse] <local file>",
 				"",
 				"Write local file to current disk",
+				"",
+				"AppleSingle files, or files with an AppleDouble (._name)",
+				"sidecar, are written with the file info they carry.",
+				"A resource fork from either, or from a <name>.rsrc file,",
+				"makes a forked file (ProDOS only).",
+				"",
+				"-sparse leaves blocks of zeros unallocated (ProDOS only).",
 			},
 		},
 		"delete": &shellCommand{
@@ -541,6 +574,161 @@ func init() {
 				"Rename a file on a disk.",
 			},
 		},
+		"check": &shellCommand{
+			Name:        "check",
+			Description: "Check the filesystem for damage",
+			MinArgs:     0,
+			MaxArgs:     1,
+			Code:        shellCheck,
+			NeedsMount:  true,
+			Context:     sccNone,
+			Text: []string{
+				"check [--repair]",
+				"",
+				"Walk the catalog and every file on a DOS, ProDOS or Pascal",
+				"volume, reporting cross-linked sectors or blocks, space",
+				"marked in use that no file owns, bad pointers, free space",
+				"bitmap errors, bad names and counts that disagree with the",
+				"directory.",
+				"",
+				"--repair rebuilds the bitmap and fixes the counts. Cross-links",
+				"and bad pointers are only reported, and the counts of files",
+				"that have them are left alone.",
+			},
+		},
+		"undelete": &shellCommand{
+			Name:        "undelete",
+			Description: "List or recover deleted files",
+			MinArgs:     0,
+			MaxArgs:     1,
+			Code:        shellUndelete,
+			NeedsMount:  true,
+			Context:     sccNone,
+			Text: []string{
+				"undelete [<number>|<name>]",
+				"",
+				"With no arguments, list the deleted files still in the catalog",
+				"of a DOS 3.3 or ProDOS disk. SCORE is how much of the file is",
+				"still free, it is 0 if the T/S list or index blocks have been",
+				"overwritten.",
+				"",
+				"Given the number or name of an entry, put it back in the catalog",
+				"and mark its sectors or blocks in use. Only files at 100 can be",
+				"recovered. DOS 3.3 loses the last letter of a long name.",
+			},
+		},
+		"protection": &shellCommand{
+			Name:        "protection",
+			Description: "Look for copy protection at the nibble level",
+			MinArgs:     0,
+			MaxArgs:     0,
+			Code:        shellProtection,
+			NeedsMount:  true,
+			Context:     sccNone,
+			Text: []string{
+				"protection",
+				"",
+				"Scan each track of a 5.25\" disk for nonstandard address and data",
+				"marks, bad checksums, short sync, half tracks, long or short",
+				"tracks and missing or extra sectors. WOZ and NIB images are",
+				"scanned as they are, sector images are nibblized first so will",
+				"always look standard.",
+			},
+		},
+		"disasm": &shellCommand{
+			Name:        "disasm",
+			Description: "Disassemble a binary file",
+			MinArgs:     1,
+			MaxArgs:     3,
+			Code:        shellDisasm,
+			NeedsMount:  true,
+			Context:     sccDiskFile,
+			Text: []string{
+				"disasm [-6502|-65c02|-65816] <filename> [<address>]",
+				"",
+				"List the machine code in a file, monitor style, with ROM entry",
+				"points and soft switches named. Binary files are listed from",
+				"their load address (the aux type on ProDOS), system files from",
+				"$2000. Give an address such as $300 to list any other file.",
+				"",
+				"The 65816 is followed through REP and SEP to get the width of",
+				"immediate operands, starting with 8 bit registers.",
+			},
+		},
+		"signature": &shellCommand{
+			Name:        "signature",
+			Description: "Show the boot signature regions of the disk",
+			MinArgs:     0,
+			MaxArgs:     0,
+			Code:        shellSignature,
+			NeedsMount:  true,
+			Context:     sccNone,
+			Text: []string{
+				"signature",
+				"",
+				"Show the operating system the disk was matched to and a SHA256",
+				"line for each region the signature database looks at. Lines can",
+				"be copied into the signatures file (see -signatures) with the",
+				"variant filled in to recognize this OS from now on.",
+			},
+		},
+		"krunch": &shellCommand{
+			Name:        "krunch",
+			Description: "Merge free space on a Pascal volume",
+			MinArgs:     0,
+			MaxArgs:     0,
+			Code:        shellKrunch,
+			NeedsMount:  true,
+			Context:     sccNone,
+			Text: []string{
+				"krunch",
+				"",
+				"Move files on a Pascal volume together so that all the free",
+				"space is in one area. Pascal files must be contiguous, so this",
+				"may be needed before a large file will fit.",
+			},
+		},
+		"init": &shellCommand{
+			Name:        "init",
+			Description: "Create a new blank disk",
+			MinArgs:     1,
+			MaxArgs:     8,
+			Code:        shellInit,
+			NeedsMount:  false,
+			Context:     sccLocal,
+			Text: []string{
+				"init [-13] [-volume <n>] [-boot <dos disk>] <new diskfile>",
+				"init -prodos [-name <volume>] [-size <size>] [-boot <prodos disk>] <new diskfile>",
+				"",
+				"Create a blank DOS 3.3 or ProDOS disk and mount it. A .po",
+				"file is written in ProDOS sector order, .do or .dsk in DOS",
+				"order. ProDOS disks can also be .2mg or .hdv.",
+				"",
+				"-13      make a 13 sector DOS 3.2 disk",
+				"-volume  DOS volume number (default 254)",
+				"-prodos  make a ProDOS volume",
+				"-name    ProDOS volume name (default BLANK)",
+				"-size    ProDOS volume size in blocks, or in Kb as 140k,",
+				"         400k, 800k... (default 140k, up to 65535 blocks)",
+				"-boot    copy the boot tracks (DOS) or boot blocks (ProDOS)",
+				"         of this disk so the new one will start up",
+			},
+		},
+		"new": &shellCommand{
+			Name:        "new",
+			Description: "Create a new blank disk",
+			MinArgs:     1,
+			MaxArgs:     8,
+			Code:        shellInit,
+			NeedsMount:  false,
+			Context:     sccLocal,
+			Text: []string{
+				"new [-13] [-volume <n>] [-boot <dos disk>] <new diskfile>",
+				"new -prodos [-name <volume>] [-size <size>] [-boot <prodos disk>] <new diskfile>",
+				"",
+				"Same as init.",
+			},
+		},
 		"report": &shellCommand{
 			Name:        "report",
 			Description: "Run a report",
@@ -685,7 +873,7 @@ func shellMount(args []string) int {
 		return -1
 	}
 
-	dsk, err := disk.NewDSKWrapper(defNibbler, args[0])
+	dsk, err := loadDisk(args[0])
 	if err != nil {
 		os.Stderr.WriteString("Error:" + err.Error() + "\n")
 		return -1
@@ -762,6 +950,39 @@ func shellInfo(args []string) int {
 	fmt.Printf("Sector Order: %s\n", commandVolumes[commandTarget].Layout.String())
 	fmt.Printf("Size        : %d bytes\n", len(commandVolumes[commandTarget].Data))
 
+	if variant := commandVolumes[commandTarget].OSVariant(); variant != "" {
+		fmt.Printf("OS          : %s\n", variant)
+	}
+
+	if h := commandVolumes[commandTarget].Header2MG; h != nil {
+		fmt.Printf("2MG creator : %s\n", h.GetCreatorID())
+		if vol, ok := h.GetVolume(); ok {
+			fmt.Printf("2MG volume  : %d\n", vol)
+		}
+		fmt.Printf("2MG locked  : %v\n", h.IsLocked())
+		if h.Comment != "" {
+			fmt.Printf("2MG comment : %s\n", h.Comment)
+		}
+	}
+
+	if h := commandVolumes[commandTarget].HeaderDC42; h != nil {
+		fmt.Printf("DiskCopy    : %s\n", h.GetName())
+	}
+
+	if commandVolumes[commandTarget].NIB {
+		fmt.Printf("Nibble image: %s\n", commandVolumes[commandTarget].NibbleEncoding.String())
+	}
+
+	if woz := commandVolumes[commandTarget].WOZ; woz != nil {
+		fmt.Printf("WOZ version : %d\n", woz.Version)
+		fmt.Printf("WOZ creator : %s\n", woz.Info.GetCreator())
+		for _, key := range []string{"title", "subtitle", "publisher", "developer", "copyright", "version", "requires_machine", "requires_ram"} {
+			if v := woz.GetMeta(key); v != "" {
+				fmt.Printf("%-12s: %s\n", key, v)
+			}
+		}
+	}
+
 	return 0
 }
 
@@ -780,11 +1001,15 @@ func shellCat(args []string) int {
 		return -1
 	}
 
+	dsk := commandVolumes[commandTarget]
+
 	bs := 256
-	if info.FormatID.ID == disk.DF_PASCAL || info.FormatID.ID == disk.DF_PRODOS ||
-		info.FormatID.ID == disk.DF_PRODOS_800KB || info.FormatID.ID == disk.DF_PRODOS_400KB ||
-		info.FormatID.ID == disk.DF_PRODOS_CUSTOM {
-		bs = 512
+	bitmap := info.Bitmap
+	if drv, err := dsk.Driver(); err == nil {
+		bs = drv.BlockSize()
+		if used, err := drv.UsedBitmap(dsk); err == nil {
+			bitmap = used
+		}
 	}
 
 	pattern := "*"
@@ -804,12 +1029,15 @@ func shellCat(args []string) int {
 		if f.Locked {
 			locked = "Y"
 		}
+		if f.Forked {
+			add += fmt.Sprintf(" +RSRC(%d)", len(f.Resource))
+		}
 		fmt.Printf("%-33s  %6d  %2s  %-23s  %s\n", f.Filename, (f.Size/bs)+1, locked, f.Type, add)
 	}
 
 	free := 0
 	used := 0
-	for _, v := range info.Bitmap {
+	for _, v := range bitmap {
 		if v {
 			used++
 		} else {
@@ -880,6 +1108,9 @@ func shellAnalyze(args []string) int {
 	}
 
 	fmt.Printf("Format: %s\n", info.FormatID)
+	if info.OSVariant != "" {
+		fmt.Printf("OS: %s\n", info.OSVariant)
+	}
 	fmt.Printf("Tracks: %d, Sectors: %d\n", info.Tracks, info.Sectors)
 
 	return 0
@@ -894,13 +1125,35 @@ func shellExtract(args []string) int {
 		return 1
 	}
 
+	format := ExtractPlain
+	disasm := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch strings.ToLower(args[0]) {
+		case "-as":
+			format = ExtractAppleSingle
+		case "-ad":
+			format = ExtractAppleDouble
+		case "-s":
+			disasm = true
+		default:
+			os.Stderr.WriteString("Unknown option " + args[0] + "\n")
+			return -1
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		os.Stderr.WriteString("Nothing to extract\n")
+		return -1
+	}
+
 	fmt.Println("Extract:", args[0])
 
 	files, _ := globDisk(commandTarget, args[0])
 
 	for _, f := range files {
 
-		err := ExtractFile(fullpath, f, true, true)
+		err := ExtractFile(fullpath, f, true, true, format, disasm)
 		if err == nil {
 			fmt.Println("OK")
 		} else {
@@ -914,6 +1167,102 @@ func shellExtract(args []string) int {
 
 }
 
+func shellBundle(args []string) int {
+
+	dsk := commandVolumes[commandTarget]
+
+	if !formatIn(dsk.Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
+		os.Stderr.WriteString("Bundling files not supported on " + dsk.Format.String() + "\n")
+		return -1
+	}
+
+	var files []*disk.BinaryIIFile
+	added := make(map[string]bool)
+
+	// directories need to come before the files in them
+	addDirs := func(path string) error {
+		parent := ""
+		for _, part := range strings.Split(path, "/") {
+			name := part
+			if parent != "" {
+				name = parent + "/" + part
+			}
+			if !added[strings.ToUpper(name)] {
+				fd, err := dsk.PRODOSGetNamedEntry(parent, part)
+				if err != nil {
+					return err
+				}
+				files = append(files, disk.NewBinaryIIFile(*fd, name, nil))
+				added[strings.ToUpper(name)] = true
+			}
+			parent = name
+		}
+		return nil
+	}
+
+	for _, pattern := range args[1:] {
+
+		path := strings.Trim(commandPath, "/")
+		if strings.Contains(pattern, "/") {
+			path = strings.Trim(path+"/"+filepath.Dir(pattern), "/")
+			pattern = filepath.Base(pattern)
+		}
+
+		_, fds, err := dsk.PRODOSGetCatalogPathed(2, path, pattern)
+		if err != nil {
+			os.Stderr.WriteString("Failed to read catalog: " + err.Error() + "\n")
+			return -1
+		}
+
+		for _, fd := range fds {
+
+			if fd.Type() == disk.FileType_PD_Directory {
+				continue
+			}
+
+			name := fd.NameUnadorned()
+			if path != "" {
+				name = path + "/" + name
+				if err := addDirs(path); err != nil {
+					os.Stderr.WriteString("Failed to read directory " + path + ": " + err.Error() + "\n")
+					return -1
+				}
+			}
+
+			if added[strings.ToUpper(name)] {
+				continue
+			}
+
+			data, err := dsk.PRODOSReadFileSectors(fd, -1)
+			if err != nil {
+				os.Stderr.WriteString("Failed to read " + name + ": " + err.Error() + "\n")
+				return -1
+			}
+
+			files = append(files, disk.NewBinaryIIFile(fd, name, data))
+			added[strings.ToUpper(name)] = true
+
+			fmt.Printf("Added %s (%d bytes)\n", name, len(data))
+		}
+	}
+
+	if len(files) == 0 {
+		os.Stderr.WriteString("No files matched\n")
+		return -1
+	}
+
+	err := ioutil.WriteFile(args[0], disk.BinaryIIBytes(files), 0644)
+	if err != nil {
+		os.Stderr.WriteString("Failed to write archive: " + err.Error() + "\n")
+		return -1
+	}
+
+	fmt.Println("Created archive " + args[0])
+
+	return 0
+
+}
+
 func formatIn(f disk.DiskFormatID, list []disk.DiskFormatID) bool {
 	for _, v := range list {
 		if v == f {
@@ -958,6 +1307,11 @@ func backupFile(path string) error {
 
 func saveDisk(dsk *disk.DSKWrapper, path string) error {
 
+	if dsk.WriteProtected {
+		os.Stderr.WriteString("Disk image is read-only, not saving " + path + "\n")
+		return errors.New("Write protected")
+	}
+
 	backupFile(path)
 
 	f, e := os.Create(path)
@@ -965,7 +1319,7 @@ func saveDisk(dsk *disk.DSKWrapper, path string) error {
 		return e
 	}
 	defer f.Close()
-	f.Write(dsk.Data)
+	f.Write(dsk.Bytes())
 
 	fmt.Println("Updated disk " + path)
 	return nil
@@ -980,24 +1334,21 @@ func shellMkdir(args []string) int {
 		return 1
 	}
 
-	path := ""
-	name := args[0]
-	if strings.Contains(name, "/") {
-		path = filepath.Dir(name)
-		name = filepath.Base(name)
+	dsk := commandVolumes[commandTarget]
+
+	drv := shellDriver(dsk, "Creating directories", disk.CapWrite|disk.CapDirectories)
+	if drv == nil {
+		return -1
 	}
 
-	if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
-		e := commandVolumes[commandTarget].PRODOSCreateDirectory(path, name)
-		if e != nil {
-			fmt.Println(e)
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
-	} else {
-		fmt.Println("Do not support Mkdir on " + commandVolumes[commandTarget].Format.String() + " currently.")
-		return 0
+	path, name := shellSplitPath(drv, args[0])
+
+	e := drv.Mkdir(dsk, path, name)
+	if e != nil {
+		fmt.Println(e)
+		return -1
 	}
+	saveDisk(dsk, fullpath)
 
 	return 0
 
@@ -1012,6 +1363,56 @@ func isASCII(in []byte) bool {
 	return true
 }
 
+// readAppleFileInfo picks up the file info for a local file, either from
+// the file itself (AppleSingle) or from an AppleDouble sidecar. The data
+// fork is returned along with it.
+func readAppleFileInfo(filename string, data []byte) (*disk.AppleSingle, []byte, error) {
+
+	dir, base := filepath.Dir(filename), filepath.Base(filename)
+
+	if disk.IsAppleSingle(data) {
+		info, err := disk.ParseAppleSingle(data)
+		if err != nil {
+			return nil, data, err
+		}
+		if info.Name == "" {
+			info.Name = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+		return info, info.Data, nil
+	}
+
+	// given the sidecar, so go and get the data
+	if strings.HasPrefix(base, "._") && disk.IsAppleDouble(data) {
+		info, err := disk.ParseAppleSingle(data)
+		if err != nil {
+			return nil, data, err
+		}
+		base = base[2:]
+		fork, err := ioutil.ReadFile(filepath.Join(dir, base))
+		if err != nil {
+			return nil, data, err
+		}
+		if info.Name == "" {
+			info.Name = base
+		}
+		return info, fork, nil
+	}
+
+	header, err := ioutil.ReadFile(filepath.Join(dir, "._"+base))
+	if err != nil || !disk.IsAppleDouble(header) {
+		return nil, data, nil
+	}
+	info, err := disk.ParseAppleSingle(header)
+	if err != nil {
+		return nil, data, err
+	}
+	if info.Name == "" {
+		info.Name = base
+	}
+	return info, data, nil
+
+}
+
 func shellPut(args []string) int {
 
 	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
@@ -1021,54 +1422,66 @@ func shellPut(args []string) int {
 		return 1
 	}
 
-	data, err := ioutil.ReadFile(args[0])
-	if err != nil {
+	dsk := commandVolumes[commandTarget]
+
+	drv := shellDriver(dsk, "Writing files", disk.CapWrite)
+	if drv == nil {
 		return -1
 	}
 
-	if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_DOS_SECTORS_13, disk.DF_DOS_SECTORS_16}) {
-		addr := int64(0x0801)
-		name := filepath.Base(args[0])
-		kind := disk.FileTypeAPP
-		reSpecial := regexp.MustCompile("(?i)^(.+)[#](0x[a-fA-F0-9]+)[.]([A-Za-z]+)$")
-		ext := strings.Trim(filepath.Ext(name), ".")
-		if reSpecial.MatchString(name) {
-			m := reSpecial.FindAllStringSubmatch(name, -1)
-			name = m[0][1]
-			ext = strings.ToLower(m[0][3])
-			addrStr := m[0][2]
-			addr, _ = strconv.ParseInt(addrStr, 0, 32)
-		} else {
-			name = strings.Replace(name, "."+ext, "", -1)
+	write := drv.WriteFile
+	if strings.ToLower(args[0]) == "-sparse" {
+		sw, ok := drv.(disk.SparseWriter)
+		if !ok {
+			os.Stderr.WriteString("Sparse files not supported on " + dsk.Format.String() + "\n")
+			return -1
 		}
+		write = sw.WriteFileSparse
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		os.Stderr.WriteString("Nothing to put\n")
+		return -1
+	}
 
-		kind = disk.AppleDOSFileTypeFromExt(ext)
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return -1
+	}
 
-		if strings.HasSuffix(args[0], ".INT.ASC") {
-			kind = disk.FileTypeINT
-		} else if strings.HasSuffix(args[0], ".APP.ASC") {
-			kind = disk.FileTypeAPP
-		}
+	info, data, err := readAppleFileInfo(args[0], data)
+	if err != nil {
+		os.Stderr.WriteString("Failed to read file info: " + err.Error() + "\n")
+		return -1
+	}
 
-		if kind == disk.FileTypeAPP && isASCII(data) {
-			lines := strings.Split(string(data), "\n")
-			data = disk.ApplesoftTokenize(lines)
-		} else if kind == disk.FileTypeINT && isASCII(data) {
-			lines := strings.Split(string(data), "\n")
-			data = disk.IntegerTokenize(lines)
-			os.Stderr.WriteString("WARNING: Integer retokenization from text is experimental\n")
-		}
+	// a resource fork comes in the AppleSingle/AppleDouble header, or as a
+	// .rsrc file alongside
+	var rsrc []byte
+	forked := false
+	if info != nil && info.Resource != nil {
+		rsrc, forked = info.Resource, true
+	} else if fork, err := ioutil.ReadFile(args[0] + ".rsrc"); err == nil {
+		rsrc, forked = fork, true
+	}
 
-		e := commandVolumes[commandTarget].AppleDOSWriteFile(name, kind, data, int(addr))
-		if e != nil {
-			os.Stderr.WriteString("Failed to create file: " + e.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
+	if info != nil && !info.HasInfo {
+		os.Stderr.WriteString("WARNING: No ProDOS file info in " + args[0] + ", using file name\n")
+		info = nil
+	}
+	if info != nil && info.Access == 0 {
+		info.Access = disk.AccessType_Default
+	}
 
-	} else if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
-		addr := int64(0x0801)
-		name := filepath.Base(args[0])
+	addr := int64(0x0801)
+	name := filepath.Base(args[0])
+	var kind int
+
+	if info != nil {
+		name = info.Name
+		kind = drv.TypeFromProDOS(info.FileType)
+		addr = int64(info.AuxType)
+	} else {
 		ext := strings.Trim(filepath.Ext(name), ".")
 		reSpecial := regexp.MustCompile("(?i)^(.+)[#](0x[a-fA-F0-9]+)[.]([A-Za-z]+)$")
 		if reSpecial.MatchString(name) {
@@ -1081,39 +1494,70 @@ func shellPut(args []string) int {
 			name = strings.Replace(name, "."+ext, "", -1)
 		}
 
-		kind := disk.ProDOSFileTypeFromExt(ext)
+		kind = drv.TypeFromExt(ext)
 
 		if strings.HasSuffix(args[0], ".INT.ASC") {
-			kind = disk.FileType_PD_INT
+			kind = drv.TypeFromProDOS(disk.FileType_PD_INT)
 		} else if strings.HasSuffix(args[0], ".APP.ASC") {
-			kind = disk.FileType_PD_APP
+			kind = drv.TypeFromProDOS(disk.FileType_PD_APP)
 		}
 
-		if kind == disk.FileType_PD_APP && isASCII(data) {
+		if drv.Class(kind) == disk.CETBasicApplesoft && isASCII(data) {
 			lines := strings.Split(string(data), "\n")
 			data = disk.ApplesoftTokenize(lines)
-		} else if kind == disk.FileType_PD_INT && isASCII(data) {
+		} else if drv.Class(kind) == disk.CETBasicInteger && isASCII(data) {
 			lines := strings.Split(string(data), "\n")
 			data = disk.IntegerTokenize(lines)
 			os.Stderr.WriteString("WARNING: Integer retokenization from text is experimental\n")
 		}
+	}
 
-		e := commandVolumes[commandTarget].PRODOSWriteFile(commandPath, name, kind, data, int(addr))
+	var e error
+	if fdrv, ok := drv.(disk.ForkDriver); ok && forked {
+		e = fdrv.WriteForkedFile(dsk, commandPath, name, kind, data, rsrc, int(addr))
+	} else {
+		if forked {
+			os.Stderr.WriteString("WARNING: Resource forks not supported on " + dsk.Format.String() + ", writing data fork only\n")
+		}
+		e = write(dsk, commandPath, name, kind, data, int(addr))
+	}
+	if e != nil {
+		os.Stderr.WriteString("Failed to create file: " + e.Error())
+		return -1
+	}
+	if info != nil {
+		e = drv.SetFileInfo(dsk, commandPath, strings.ToUpper(name), info.Access, info.Created, info.Modified)
 		if e != nil {
-			os.Stderr.WriteString("Failed to create file: " + e.Error())
+			os.Stderr.WriteString("Failed to set file info: " + e.Error())
 			return -1
 		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
-
-	} else {
-		os.Stderr.WriteString("Writing files not supported on " + commandVolumes[commandTarget].Format.String())
-		return -1
 	}
+	saveDisk(dsk, fullpath)
 
 	return 0
 
 }
 
+// shellDriver gets the filesystem driver for a volume, complaining if it
+// can't do what is asked of it.
+func shellDriver(dsk *disk.DSKWrapper, action string, caps disk.DriverCaps) disk.Driver {
+	drv, err := dsk.Driver()
+	if err != nil || drv.Caps()&caps != caps {
+		os.Stderr.WriteString(action + " not supported on " + dsk.Format.String() + "\n")
+		return nil
+	}
+	return drv
+}
+
+// shellSplitPath splits a name given to a command into directory and name,
+// names without a directory are taken from the current path.
+func shellSplitPath(drv disk.Driver, name string) (string, string) {
+	if drv.Caps()&disk.CapDirectories == 0 || !strings.Contains(name, "/") {
+		return commandPath, name
+	}
+	return filepath.Dir(name), filepath.Base(name)
+}
+
 func shellDelete(args []string) int {
 
 	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
@@ -1123,31 +1567,21 @@ func shellDelete(args []string) int {
 		return 1
 	}
 
-	if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_DOS_SECTORS_13, disk.DF_DOS_SECTORS_16}) {
-		err = commandVolumes[commandTarget].AppleDOSDeleteFile(args[0])
-		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
+	dsk := commandVolumes[commandTarget]
 
-	} else if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
+	drv := shellDriver(dsk, "Deleting files", disk.CapWrite)
+	if drv == nil {
+		return -1
+	}
 
-		if strings.Contains(args[0], "/") {
-			commandPath = filepath.Dir(args[0])
-			args[0] = filepath.Base(args[0])
-		}
+	path, name := shellSplitPath(drv, args[0])
 
-		err = commandVolumes[commandTarget].PRODOSDeleteFile(commandPath, args[0])
-		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
-	} else {
-		os.Stderr.WriteString("Deleting files not supported on " + commandVolumes[commandTarget].Format.String())
+	err = drv.DeleteFile(dsk, path, name)
+	if err != nil {
+		os.Stderr.WriteString(err.Error())
 		return -1
 	}
+	saveDisk(dsk, fullpath)
 
 	return 0
 
@@ -1159,12 +1593,13 @@ func shellIngest(args []string) int {
 
 	dskName := args[0]
 
-	info, err := os.Stat(dskName)
+	statName, _, _ := splitContainerPath(dskName)
+	info, err := os.Stat(statName)
 	if err != nil {
 		loggy.Get(0).Errorf("Error stating file: %s", err.Error())
 		os.Exit(2)
 	}
-	if info.IsDir() {
+	if info.IsDir() || containerRegex.MatchString(dskName) {
 		walk(dskName)
 	} else {
 		indisk = make(map[disk.DiskFormat]int)
@@ -1190,44 +1625,14 @@ func shellIngest(args []string) int {
 }
 
 func shellLock(args []string) int {
-
-	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
-
-	_, err := analyze(0, fullpath)
-	if err != nil {
-		return 1
-	}
-
-	if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_DOS_SECTORS_13, disk.DF_DOS_SECTORS_16}) {
-		err = commandVolumes[commandTarget].AppleDOSSetLocked(args[0], true)
-		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
-
-	} else if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
-
-		if strings.Contains(args[0], "/") {
-			commandPath = filepath.Dir(args[0])
-			args[0] = filepath.Base(args[0])
-		}
-
-		err = commandVolumes[commandTarget].PRODOSSetLocked(commandPath, args[0], true)
-		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
-	} else {
-		os.Stderr.WriteString("Locking files not supported on " + commandVolumes[commandTarget].Format.String())
-		return -1
-	}
-
-	return 0
+	return shellSetLocked(args, true)
 }
 
 func shellUnlock(args []string) int {
+	return shellSetLocked(args, false)
+}
+
+func shellSetLocked(args []string, lock bool) int {
 
 	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
 
@@ -1236,31 +1641,21 @@ func shellUnlock(args []string) int {
 		return 1
 	}
 
-	if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_DOS_SECTORS_13, disk.DF_DOS_SECTORS_16}) {
-		err = commandVolumes[commandTarget].AppleDOSSetLocked(args[0], false)
-		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
+	dsk := commandVolumes[commandTarget]
 
-	} else if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
+	drv := shellDriver(dsk, "Locking files", disk.CapWrite)
+	if drv == nil {
+		return -1
+	}
 
-		if strings.Contains(args[0], "/") {
-			commandPath = filepath.Dir(args[0])
-			args[0] = filepath.Base(args[0])
-		}
+	path, name := shellSplitPath(drv, args[0])
 
-		err = commandVolumes[commandTarget].PRODOSSetLocked(commandPath, args[0], false)
-		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			return -1
-		}
-		saveDisk(commandVolumes[commandTarget], fullpath)
-	} else {
-		os.Stderr.WriteString("Locking files not supported on " + commandVolumes[commandTarget].Format.String())
+	err = drv.SetLocked(dsk, path, name, lock)
+	if err != nil {
+		os.Stderr.WriteString(err.Error())
 		return -1
 	}
+	saveDisk(dsk, fullpath)
 
 	return 0
 }
@@ -1349,89 +1744,51 @@ func shellD2DCopy(args []string) int {
 			os.Stderr.WriteString("Invalid slot number: " + m[0][2] + "\n")
 			return -1
 		}
-		if !formatIn(
-			v.Format.ID,
-			[]disk.DiskFormatID{
-				disk.DF_DOS_SECTORS_13,
-				disk.DF_DOS_SECTORS_16,
-				disk.DF_PRODOS,
-				disk.DF_PRODOS_800KB,
-				disk.DF_PRODOS_400KB,
-				disk.DF_PRODOS_CUSTOM,
-			}) {
+		drv, err := v.Driver()
+		if err != nil || drv.Caps()&disk.CapWrite == 0 {
 			os.Stderr.WriteString("Target volume does not support write.\n")
 			return -1
 		}
+		dir := ""
 		if path != "" && len(allfiles) > 1 {
 			// copy to path
-			if !formatIn(
-				v.Format.ID,
-				[]disk.DiskFormatID{
-					disk.DF_PRODOS,
-					disk.DF_PRODOS_800KB,
-					disk.DF_PRODOS_400KB,
-					disk.DF_PRODOS_CUSTOM,
-				}) {
-				os.Stderr.WriteString("Only prodos supports copy to directory")
+			if drv.Caps()&disk.CapDirectories == 0 {
+				os.Stderr.WriteString("Target volume does not support directories.\n")
 				return -1
 			}
-			for _, f := range allfiles {
-				// must be prodos
-				name := f.Filename
-				if len(name) > 15 {
-					name = name[:15]
-				}
-				kind := disk.ProDOSFileTypeFromExt(f.Ext)
-				auxtype := f.LoadAddress
-				data := f.Data
-				e := v.PRODOSWriteFile(path, name, kind, data, auxtype)
-				if e != nil {
-					os.Stderr.WriteString(fmt.Sprintf("Failed to copy %s: %s\n", name, e.Error()))
-					return -1
-				}
-				os.Stderr.WriteString(fmt.Sprintf("Copied %s (%d bytes)\n", name, len(data)))
+			dir = path
+		}
+		for _, f := range allfiles {
+			name := f.Filename
+			if path != "" && len(allfiles) == 1 {
+				name = path
 			}
-		} else {
-			for _, f := range allfiles {
-				name := f.Filename
-				if path != "" && len(allfiles) == 1 {
-					name = path
-				}
-
-				if formatIn(
-					v.Format.ID,
-					[]disk.DiskFormatID{
-						disk.DF_PRODOS,
-						disk.DF_PRODOS_800KB,
-						disk.DF_PRODOS_400KB,
-						disk.DF_PRODOS_CUSTOM,
-					}) {
-
-					if len(name) > 15 {
-						name = name[:15]
-					}
-					kind := disk.ProDOSFileTypeFromExt(f.Ext)
-					auxtype := f.LoadAddress
-					data := f.Data
-					e := v.PRODOSWriteFile("", name, kind, data, auxtype)
-					if e != nil {
-						os.Stderr.WriteString(fmt.Sprintf("Failed to copy %s: %s\n", name, e.Error()))
-						return -1
-					}
-					os.Stderr.WriteString(fmt.Sprintf("Copied %s (%d bytes)\n", name, len(data)))
-				} else {
-					// DOS
-					kind := disk.AppleDOSFileTypeFromExt(f.Ext)
-					auxtype := f.LoadAddress
-					data := f.Data
-					e := v.AppleDOSWriteFile(name, kind, data, auxtype)
-					if e != nil {
-						os.Stderr.WriteString(fmt.Sprintf("Failed to copy %s: %s\n", name, e.Error()))
-						return -1
-					}
-					os.Stderr.WriteString(fmt.Sprintf("Copied %s (%d bytes)\n", name, len(data)))
+			if len(name) > drv.MaxNameLength() {
+				name = name[:drv.MaxNameLength()]
+			}
+			kind := drv.TypeFromExt(f.Ext)
+			auxtype := f.LoadAddress
+			data := f.Data
+			var e error
+			fdrv, canFork := drv.(disk.ForkDriver)
+			sw, canSparse := drv.(disk.SparseWriter)
+			switch {
+			case f.Forked && canFork:
+				e = fdrv.WriteForkedFile(v, dir, name, kind, data, f.Resource, auxtype)
+			case f.Sparse && canSparse:
+				// keep the holes, random access files rely on them
+				e = sw.WriteFileSparse(v, dir, name, kind, data, auxtype)
+			default:
+				if f.Forked {
+					os.Stderr.WriteString(fmt.Sprintf("WARNING: Resource fork of %s dropped, not supported on %s\n", name, v.Format.String()))
 				}
+				e = drv.WriteFile(v, dir, name, kind, data, auxtype)
+			}
+			if e != nil {
+				os.Stderr.WriteString(fmt.Sprintf("Failed to copy %s: %s\n", name, e.Error()))
+				return -1
 			}
+			os.Stderr.WriteString(fmt.Sprintf("Copied %s (%d bytes)\n", name, len(data)))
 		}
 
 		// here need to publish disk
@@ -1450,41 +1807,418 @@ func shellRename(args []string) int {
 
 	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
 
-	if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_PRODOS, disk.DF_PRODOS_800KB, disk.DF_PRODOS_400KB, disk.DF_PRODOS_CUSTOM}) {
+	dsk := commandVolumes[commandTarget]
+
+	drv := shellDriver(dsk, "Rename", disk.CapWrite)
+	if drv == nil {
+		return -1
+	}
+
+	path, oldname := shellSplitPath(drv, args[0])
+	newname := filepath.Base(args[1])
+
+	e := drv.RenameFile(dsk, path, oldname, newname)
+	if e != nil {
+		os.Stderr.WriteString("Unable to rename file: " + e.Error())
+		return -1
+	}
+
+	saveDisk(dsk, fullpath)
+
+	return 0
+}
+
+func shellCheck(args []string) int {
+
+	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
+
+	dsk := commandVolumes[commandTarget]
+
+	repair := false
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "--repair", "-repair":
+			repair = true
+		default:
+			os.Stderr.WriteString("Unknown option " + args[0] + "\n")
+			return -1
+		}
+	}
+
+	drv, err := dsk.Driver()
+	checker, ok := drv.(disk.Checker)
+	if err != nil || !ok {
+		os.Stderr.WriteString("Checking not supported on " + dsk.Format.String() + "\n")
+		return -1
+	}
 
-		oldname := filepath.Base(args[0])
-		oldpath := filepath.Dir(args[0])
-		newname := filepath.Base(args[1])
+	problems, err := checker.Check(dsk, repair)
+	for _, p := range problems {
+		fmt.Println(p.String())
+	}
+	if err != nil {
+		os.Stderr.WriteString("Unable to check volume: " + err.Error() + "\n")
+		return -1
+	}
 
-		if oldpath == "." {
-			oldpath = ""
+	repaired := 0
+	for _, p := range problems {
+		if p.Repaired {
+			repaired++
 		}
+	}
 
-		fmt.Println(oldname, newname, oldpath)
+	switch {
+	case len(problems) == 0:
+		fmt.Println("No problems found")
+	case repair:
+		fmt.Printf("%d problems found, %d repaired\n", len(problems), repaired)
+	default:
+		fmt.Printf("%d problems found\n", len(problems))
+	}
 
-		e := commandVolumes[commandTarget].PRODOSRenameFile(oldpath, oldname, newname)
-		if e != nil {
-			os.Stderr.WriteString("Unable to rename file: " + e.Error())
+	if repaired > 0 {
+		saveDisk(dsk, fullpath)
+	}
+
+	return 0
+}
+
+func shellSignature(args []string) int {
+
+	dsk := commandVolumes[commandTarget]
+
+	if sig := dsk.MatchSignature(); sig != nil {
+		fmt.Printf("OS: %s (%s %s)\n", sig.Variant, sig.Region, sig.Match)
+	} else {
+		fmt.Println("OS: not recognized")
+	}
+	fmt.Println()
+
+	for _, region := range dsk.SignatureRegions() {
+		data := dsk.SignatureRegion(region)
+		if data == nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		fmt.Printf("%-18s sha256 %s - ?\n", region, hex.EncodeToString(sum[:]))
+	}
+
+	return 0
+}
+
+func shellDisasm(args []string) int {
+
+	cpu, ok := disk.ParseCPU(*disasmCPU)
+	if !ok {
+		os.Stderr.WriteString("Unknown CPU " + *disasmCPU + "\n")
+		return -1
+	}
+	if strings.HasPrefix(args[0], "-") {
+		cpu, ok = disk.ParseCPU(args[0])
+		if !ok {
+			os.Stderr.WriteString("Unknown CPU " + args[0] + "\n")
 			return -1
 		}
+		args = args[1:]
+	}
 
-	} else if formatIn(commandVolumes[commandTarget].Format.ID, []disk.DiskFormatID{disk.DF_DOS_SECTORS_13, disk.DF_DOS_SECTORS_16}) {
-		oldname := filepath.Base(args[0])
-		newname := filepath.Base(args[1])
+	if len(args) == 0 {
+		os.Stderr.WriteString("Nothing to disassemble\n")
+		return -1
+	}
 
-		e := commandVolumes[commandTarget].AppleDOSRenameFile(oldname, newname)
-		if e != nil {
-			os.Stderr.WriteString("Unable to rename file: " + e.Error())
+	files, err := globDisk(commandTarget, args[0])
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return -1
+	}
+	if len(files) == 0 {
+		os.Stderr.WriteString("File not found: " + args[0] + "\n")
+		return -1
+	}
+	f := files[0]
+
+	org, ok := codeOrigin(f)
+	if len(args) > 1 {
+		addr, err := strconv.ParseInt(strings.Replace(args[1], "$", "0x", 1), 0, 32)
+		if err != nil || addr < 0 || addr > 0xffffff {
+			os.Stderr.WriteString("Bad address " + args[1] + "\n")
 			return -1
 		}
+		org, ok = int(addr), true
+	}
+	if !ok {
+		os.Stderr.WriteString(f.Filename + " is not a binary file, give an address to list it from\n")
+		return -1
+	}
+
+	fmt.Printf("%s, %d bytes at $%.4X (%s)\n\n", f.Filename, len(f.Data), org, cpu)
+	fmt.Print(disk.Disassemble(f.Data, org, cpu).Listing())
+
+	return 0
+}
+
+func shellProtection(args []string) int {
+
+	dsk := commandVolumes[commandTarget]
+
+	p := dsk.AnalyzeProtection()
+	if p == nil {
+		os.Stderr.WriteString("No nibble level data for " + dsk.Format.String() + "\n")
+		return -1
+	}
+
+	fmt.Printf("Nibbles from %s image\n", p.Source)
+
+	if p.Standard() {
+		fmt.Println("No protection found, disk is standard")
+		return 0
+	}
+
+	for _, f := range p.Findings {
+		fmt.Println(f.String())
+	}
+	fmt.Println()
+	fmt.Printf("Scheme: %s\n", strings.Join(p.Scheme(), ", "))
+
+	return 0
+}
+
+func shellUndelete(args []string) int {
+
+	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
+
+	dsk := commandVolumes[commandTarget]
+
+	drv, err := dsk.Driver()
+	u, ok := drv.(disk.Undeleter)
+	if err != nil || !ok {
+		os.Stderr.WriteString("Undelete not supported on " + dsk.Format.String() + "\n")
+		return -1
+	}
+
+	deleted, err := u.Deleted(dsk)
+	if err != nil {
+		os.Stderr.WriteString("Unable to read catalog: " + err.Error() + "\n")
+		return -1
+	}
+
+	if len(args) == 0 {
+		if len(deleted) == 0 {
+			fmt.Println("No deleted files found")
+			return 0
+		}
+		fmt.Printf("%4s  %-33s  %6s  %5s  %-23s\n", "#", "NAME", "BLOCKS", "SCORE", "KIND")
+		for i, d := range deleted {
+			fmt.Printf("%4d  %-33s  %6d  %5d  %-23s\n", i+1, d.FullName(), d.Total, d.Score(), d.Type)
+		}
+		return 0
+	}
+
+	// pick by number, or by name taking the best of any with the same name
+	var pick *disk.DeletedEntry
+	if n, err := strconv.Atoi(args[0]); err == nil {
+		if n < 1 || n > len(deleted) {
+			os.Stderr.WriteString("No deleted file " + args[0] + "\n")
+			return -1
+		}
+		pick = deleted[n-1]
 	} else {
-		os.Stderr.WriteString("Rename currently unsupported on " + commandVolumes[commandTarget].Format.String() + "\n")
+		name := strings.Trim(args[0], "/")
+		for _, d := range deleted {
+			if strings.EqualFold(d.FullName(), name) && (pick == nil || d.Score() > pick.Score()) {
+				pick = d
+			}
+		}
+		if pick == nil {
+			os.Stderr.WriteString("No deleted file named " + args[0] + "\n")
+			return -1
+		}
+	}
+
+	err = u.Undelete(dsk, pick)
+	if err != nil {
+		os.Stderr.WriteString("Unable to undelete " + pick.FullName() + ": " + err.Error() + "\n")
 		return -1
 	}
 
-	saveDisk(commandVolumes[commandTarget], fullpath)
+	fmt.Println("Recovered " + pick.FullName())
+
+	saveDisk(dsk, fullpath)
 
 	return 0
+
+}
+
+func shellKrunch(args []string) int {
+
+	fullpath, _ := filepath.Abs(commandVolumes[commandTarget].Filename)
+
+	dsk := commandVolumes[commandTarget]
+
+	if !formatIn(dsk.Format.ID, []disk.DiskFormatID{disk.DF_PASCAL}) {
+		os.Stderr.WriteString("Krunch not supported on " + dsk.Format.String() + "\n")
+		return -1
+	}
+
+	moved, err := dsk.PascalKrunch()
+	if err != nil {
+		os.Stderr.WriteString("Unable to krunch volume: " + err.Error() + "\n")
+		return -1
+	}
+
+	fmt.Printf("Moved %d files\n", moved)
+
+	saveDisk(dsk, fullpath)
+
+	return 0
+}
+
+func shellInit(args []string) int {
+
+	format := disk.GetDiskFormat(disk.DF_DOS_SECTORS_16)
+	prodos := false
+	opts := map[string]string{}
+	filename := ""
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch opt {
+		case "-13":
+			format = disk.GetDiskFormat(disk.DF_DOS_SECTORS_13)
+		case "-prodos":
+			prodos = true
+		case "-volume", "-boot", "-name", "-size":
+			if i+1 >= len(args) {
+				os.Stderr.WriteString(args[i] + " needs a value\n")
+				return -1
+			}
+			opts[opt] = args[i+1]
+			i++
+		default:
+			if filename != "" {
+				os.Stderr.WriteString("Only one disk can be created at a time\n")
+				return -1
+			}
+			filename = args[i]
+		}
+	}
+
+	if filename == "" {
+		os.Stderr.WriteString("No disk file given\n")
+		return -1
+	}
+
+	if _, err := os.Stat(filename); err == nil {
+		os.Stderr.WriteString(filename + " already exists\n")
+		return -1
+	}
+
+	var boot *disk.DSKWrapper
+	if opts["-boot"] != "" {
+		var err error
+		boot, err = loadDisk(opts["-boot"])
+		if err != nil {
+			os.Stderr.WriteString("Unable to read boot disk: " + err.Error() + "\n")
+			return -1
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	var dsk *disk.DSKWrapper
+	var err error
+
+	if prodos {
+
+		if format.ID == disk.DF_DOS_SECTORS_13 || opts["-volume"] != "" {
+			os.Stderr.WriteString("-13 and -volume are only for DOS disks\n")
+			return -1
+		}
+
+		name := "BLANK"
+		if opts["-name"] != "" {
+			name = opts["-name"]
+		}
+
+		blocks := disk.PRODOS_BLOCKS_PER_DISK
+		if opts["-size"] != "" {
+			blocks, err = parseBlocks(opts["-size"])
+			if err != nil {
+				os.Stderr.WriteString("Bad size: " + opts["-size"] + "\n")
+				return -1
+			}
+		}
+
+		layout := disk.SectorOrderProDOSLinear
+		switch ext {
+		case ".do", ".dsk":
+			layout = disk.SectorOrderDOS33
+		case ".po", ".2mg", ".hdv":
+		default:
+			os.Stderr.WriteString("ProDOS disks can be .po, .do, .2mg or .hdv\n")
+			return -1
+		}
+
+		dsk, err = disk.NewProDOSVolume(name, blocks, layout, boot)
+		if err == nil && ext == ".2mg" {
+			dsk.Header2MG = disk.NewHeader2MG(disk.FORMAT_2MG_PRODOS, blocks)
+		}
+
+	} else {
+
+		if opts["-name"] != "" || opts["-size"] != "" {
+			os.Stderr.WriteString("-name and -size are only for ProDOS disks\n")
+			return -1
+		}
+
+		volume := disk.APPLEDOS_DEFAULT_VOLUME
+		if opts["-volume"] != "" {
+			v, err := strconv.ParseInt(opts["-volume"], 0, 32)
+			if err != nil {
+				os.Stderr.WriteString("Bad volume number: " + opts["-volume"] + "\n")
+				return -1
+			}
+			volume = int(v)
+		}
+
+		layout := disk.SectorOrderDOS33
+		switch {
+		case ext == ".po":
+			layout = disk.SectorOrderDOS33ProDOS
+		case format.ID == disk.DF_DOS_SECTORS_13:
+			layout = disk.SectorOrderDOS32
+		}
+
+		dsk, err = disk.NewAppleDOSVolume(format, layout, volume, boot)
+
+	}
+
+	if err != nil {
+		os.Stderr.WriteString("Unable to create disk: " + err.Error() + "\n")
+		return -1
+	}
+
+	err = ioutil.WriteFile(filename, dsk.Bytes(), 0644)
+	if err != nil {
+		os.Stderr.WriteString("Unable to write disk: " + err.Error() + "\n")
+		return -1
+	}
+	fmt.Println("Created disk " + filename)
+
+	return shellMount([]string{filename})
+}
+
+// parseBlocks reads a volume size, either in blocks or in kilobytes with a
+// trailing k
+func parseBlocks(size string) (int, error) {
+	size = strings.ToLower(size)
+	if strings.HasSuffix(size, "k") {
+		kb, err := strconv.Atoi(strings.TrimSuffix(size, "k"))
+		return kb * 1024 / disk.PRODOS_BLOCK_BYTES, err
+	}
+	return strconv.Atoi(size)
 }
 
 func globDisk(slotid int, pattern string) ([]*DiskFile, error) {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paleotronic/dskalyzer/disk"
+)
+
+// testProDOSVolume writes a blank 140K ProDOS volume to dir and returns
+// its path
+func testProDOSVolume(t *testing.T, dir string, name string) string {
+
+	t.Helper()
+
+	dsk, err := disk.NewProDOSVolume("TEST", disk.PRODOS_BLOCKS_PER_DISK, disk.SectorOrderProDOSLinear, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, dsk.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+
+}
+
+// testSparseFile is a ProDOS volume holding SPARSE, a file that is mostly
+// holes
+func testSparseFile(t *testing.T, dir string) (string, []byte) {
+
+	t.Helper()
+
+	data := make([]byte, 100000)
+	copy(data, "START")
+	copy(data[50000:], "MIDDLE")
+	copy(data[len(data)-3:], "END")
+
+	path := testProDOSVolume(t, dir, "sparse.po")
+	dsk, err := loadDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dsk.PRODOSWriteFileSparse("", "SPARSE", disk.FileType_PD_BIN, data, 0x2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, dsk.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, data
+
+}
+
+// testSparseEntry looks up SPARSE and the blocks it takes
+func testSparseEntry(t *testing.T, dsk *disk.DSKWrapper) (*disk.CatalogEntry, int) {
+
+	t.Helper()
+
+	vfs, err := dsk.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := vfs.Stat("SPARSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := info.Sys().(*disk.CatalogEntry)
+	fd := e.Descriptor().(disk.ProDOSFileDescriptor)
+	return e, fd.TotalBlocks()
+
+}
+
+func TestCopyKeepsHoles(t *testing.T) {
+
+	// the volumes are read again by name, and loading lowercases it, so
+	// stay out of the test's own mixed case temp dir
+	dir, err := ioutil.TempDir("", "dskalyzer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv("HOME", dir)
+
+	src, data := testSparseFile(t, dir)
+	dst := testProDOSVolume(t, dir, "copy.po")
+
+	savedVolumes, savedTarget := commandVolumes, commandTarget
+	defer func() { commandVolumes, commandTarget = savedVolumes, savedTarget }()
+
+	for i, path := range []string{src, dst} {
+		dsk, err := loadDisk(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commandVolumes[i] = dsk
+	}
+	commandTarget = 0
+
+	if shellD2DCopy([]string{"0:SPARSE", "1:"}) != 0 {
+		t.Fatal("copy failed")
+	}
+
+	sparse, blocks := testSparseEntry(t, commandVolumes[0])
+	copied, copiedBlocks := testSparseEntry(t, commandVolumes[1])
+	if !copied.Sparse || copiedBlocks != blocks {
+		t.Errorf("copy has %d blocks, sparse %v, the original %d, sparse %v", copiedBlocks, copied.Sparse, blocks, sparse.Sparse)
+	}
+
+	vfs, err := commandVolumes[1].FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := vfs.ReadFile("SPARSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("copy reads back %d bytes, want %d", len(got), len(data))
+	}
+
+}